@@ -1,12 +1,23 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 
+	"github.com/appejv/appejv-api/internal/accounts"
+	"github.com/appejv/appejv-api/internal/audit"
+	"github.com/appejv/appejv-api/internal/auth"
 	"github.com/appejv/appejv-api/internal/config"
+	"github.com/appejv/appejv-api/internal/documents"
 	"github.com/appejv/appejv-api/internal/fiber/handlers"
 	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/appejv/appejv-api/internal/mailer"
+	"github.com/appejv/appejv-api/internal/policy"
+	"github.com/appejv/appejv-api/internal/ratelimit"
+	"github.com/appejv/appejv-api/internal/sms"
+	"github.com/appejv/appejv-api/internal/storage"
 	"github.com/appejv/appejv-api/pkg/database"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -25,13 +36,122 @@ func main() {
 	cfg := config.Load()
 
 	// Initialize Supabase client
-	db := database.NewSupabaseClient(cfg)
+	db, err := database.NewSupabaseClient(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize Supabase client:", err)
+	}
 	log.Println("✓ Connected to Supabase")
 
+	// Handlers query as the caller (middleware.DB); the service-role handle
+	// is reserved for auth lookups, background jobs and the inventory
+	// services, which check warehouse scope themselves
+	service := db.Service()
+	if !db.HasServiceRole() {
+		log.Println("⚠ SUPABASE_SERVICE_KEY not set: privileged operations run with the anon key")
+	}
+
+	// Initialize file storage
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize storage:", err)
+	}
+	log.Printf("✓ Storage backend: %s", cfg.StorageBackend)
+
+	// Access tokens are verified locally with the JWT secret or JWKS
+	verifier := auth.New(cfg)
+	if cfg.JWTSecret == "" && cfg.JWKSURL == "" && !cfg.AuthRemoteFallback {
+		log.Println("⚠ No SUPABASE_JWT_SECRET or JWKS URL configured: protected endpoints will reject every token")
+	}
+	profiles := middleware.NewProfileCache(service, cfg.ProfileCacheTTL)
+	// Integrations authenticate with an API key instead
+	apiKeys := auth.NewAPIKeys(service)
+	if cfg.JWTSecret == "" {
+		log.Println("⚠ No SUPABASE_JWT_SECRET configured: API keys will be refused")
+	}
+	// Tokens of sessions ended through the API are refused until they expire
+	sessions := auth.NewSessions(service, cfg.AccessTokenTTL, cfg.SessionSync)
+	go sessions.Run(context.Background())
+	requireAuth := middleware.AuthRequired(profiles, verifier, apiKeys, sessions)
+
+	// Relational permission checks (who owns which customer and order,
+	// who is on whose team), mirroring the RLS policies
+	policies := policy.NewEngine(policy.NewDirectory(service))
+
+	// Login, refresh and logout go through GoTrue
+	gotrue := auth.NewGoTrue(cfg.AuthURL, cfg.SupabaseAnonKey)
+	lockout := auth.NewLockout(cfg.LoginMaxFailures, cfg.LoginFailureWindow, cfg.LoginLockout)
+
+	// Email
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize mailer:", err)
+	}
+	log.Printf("✓ Mail backend: %s", cfg.MailBackend)
+	emailTemplates, err := mailer.NewTemplates()
+	if err != nil {
+		log.Fatal("Failed to load email templates:", err)
+	}
+	outbox := mailer.NewOutbox(service, mail, emailTemplates, cfg.MailOutboxInterval)
+	go outbox.Run(context.Background())
+
+	// Password reset links
+	authAdmin := auth.NewAdmin(cfg.AuthURL, cfg.SupabaseServiceKey)
+	resets := auth.NewPasswordResets(service, authAdmin, sessions, outbox, cfg.PasswordResetTTL, cfg.PasswordResetURL)
+
+	// Phone sign-in with texted codes
+	texts, err := sms.New(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize SMS provider:", err)
+	}
+	log.Printf("✓ SMS provider: %s", cfg.SMSProvider)
+	phoneOTP := auth.NewPhoneOTP(service, authAdmin, gotrue, texts, cfg.OTPSecret, cfg.OTPTTL, auth.OTPLimits{
+		MaxAttempts: cfg.OTPMaxAttempts,
+		PerPhone:    cfg.OTPPhoneLimit,
+		PerIP:       cfg.OTPIPLimit,
+		Cooldown:    cfg.OTPCooldown,
+	})
+
+	// User administration
+	users := accounts.New(service, authAdmin)
+	invites := accounts.NewInvitations(service, users, outbox, cfg.InviteTTL, cfg.InviteURL)
+
+	// Audit log of every change, written in batches in the background
+	auditLog := audit.NewLog(service)
+	go auditLog.Run(context.Background())
+
+	// Stock ledger and warehouses
+	ledger := inventory.NewLedger(service)
+	warehouses := inventory.NewWarehouses(service, ledger)
+	stocktakes := inventory.NewStocktakes(service, ledger)
+	picking := inventory.NewPicking(service, warehouses)
+	scanner := inventory.NewScanner(service, ledger, picking)
+
+	// Printed documents
+	docs, err := documents.New(cfg.PDFFontPath)
+	if err != nil {
+		log.Fatal("Failed to load document templates:", err)
+	}
+
+	// Low-stock alerts after stock changes and on a schedule
+	lowStock := inventory.NewLowStockMonitor(service, cfg.LowStockInterval)
+	ledger.OnChange(lowStock.Check)
+	go lowStock.Run(context.Background())
+
+	if cfg.ProxyHeader != "" && len(cfg.TrustedProxies) == 0 {
+		log.Printf("⚠ PROXY_HEADER is set but TRUSTED_PROXIES is empty: %s is ignored and clients are identified by the connection address", cfg.ProxyHeader)
+	}
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
 		AppName:      "APPE JV API",
 		ServerHeader: "Fiber",
+		BodyLimit:    cfg.BodyLimit,
+		// Client IPs, which the per-IP rate limits key on, come from
+		// ProxyHeader only when the request is from a trusted proxy
+		ProxyHeader:             cfg.ProxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          cfg.TrustedProxies,
+		EnableIPValidation:      true,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -58,7 +178,7 @@ func main() {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     corsOrigins,
 		AllowMethods:     "GET,POST,PUT,DELETE,PATCH,OPTIONS",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,X-API-Key",
 		AllowCredentials: true,
 	}))
 
@@ -74,54 +194,168 @@ func main() {
 		})
 	})
 
+	// Serve uploaded files when using the local storage backend
+	if local, ok := store.(*storage.LocalStorage); ok {
+		app.Static(cfg.StorageBaseURL, local.Dir())
+	}
+
+	// Rate limits, counted in memory on each instance
+	limits := ratelimit.NewMemoryStore()
+	publicLimit := middleware.RateLimit(limits, "public", ratelimit.PerMinute(cfg.RateLimitPublic), middleware.ByIP)
+	authLimit := middleware.RateLimit(limits, "auth", ratelimit.PerMinute(cfg.RateLimitAuth), middleware.ByIP)
+	resetLimit := middleware.RateLimit(limits, "password_reset", ratelimit.PerHour(cfg.RateLimitReset), middleware.ByIP)
+	apiLimit := middleware.RateLimit(limits, "api", ratelimit.PerMinute(cfg.RateLimitAPI), middleware.ByCaller)
+
 	// API v1 routes
 	v1 := app.Group("/api/v1")
+	v1.Use(middleware.Databases(db, service))
 
-	// Public endpoints (no authentication)
+	// Public endpoints (no authentication). The limit is attached per
+	// route, as Use() on a "/" group would apply to every later route.
 	public := v1.Group("/")
 	{
-		public.Get("/products", handlers.GetProducts(db))
-		public.Get("/products/:id", handlers.GetProduct(db))
+		public.Get("/products", publicLimit, handlers.GetProducts())
+		public.Get("/products/compare", publicLimit, handlers.CompareProducts())
+		public.Get("/products/spec-schemas", publicLimit, handlers.GetSpecSchemas())
+		public.Get("/products/:id", publicLimit, handlers.GetProduct())
+		public.Get("/products/:id/images", publicLimit, handlers.GetProductImages())
+		public.Get("/products/:id/units", publicLimit, handlers.GetProductUnits())
 	}
 
 	// Auth endpoints (public)
-	auth := v1.Group("/auth")
+	authRoutes := v1.Group("/auth", authLimit)
 	{
-		auth.Post("/forgot-password", handlers.RequestPasswordReset(db))
+		authRoutes.Post("/login", handlers.Login(gotrue, lockout))
+		authRoutes.Post("/refresh", handlers.RefreshSession(gotrue))
+		authRoutes.Post("/logout", requireAuth, handlers.Logout(gotrue))
+		authRoutes.Post("/forgot-password", resetLimit, handlers.RequestPasswordReset(resets))
+		authRoutes.Post("/reset-password", handlers.ResetPassword(resets))
+		authRoutes.Post("/otp/request", handlers.RequestPhoneCode(phoneOTP))
+		authRoutes.Post("/otp/verify", handlers.VerifyPhoneCode(phoneOTP, gotrue))
+		authRoutes.Post("/accept-invite", handlers.AcceptInvite(invites, auditLog))
 	}
 
 	// Protected endpoints (authentication required)
 	protected := v1.Group("/")
-	protected.Use(middleware.AuthRequired(db))
+	protected.Use(requireAuth, apiLimit, middleware.Audit(auditLog))
 	{
-		// Profile endpoint (all authenticated users)
-		protected.Get("/profile", handlers.GetProfile())
-
-		// Sales endpoints (sale, admin, sale_admin)
-		sales := protected.Group("/")
-		sales.Use(middleware.RoleRequired("sale", "admin", "sale_admin"))
-		{
-			// Customers
-			sales.Get("/customers", handlers.GetCustomers(db))
-			sales.Get("/customers/:id", handlers.GetCustomer(db))
-			sales.Post("/customers", handlers.CreateCustomer(db))
-			sales.Put("/customers/:id", handlers.UpdateCustomer(db))
-
-			// Orders
-			sales.Get("/orders", handlers.GetOrders(db))
-			sales.Get("/orders/:id", handlers.GetOrder(db))
-			sales.Post("/orders", handlers.CreateOrder(db))
-			sales.Put("/orders/:id", handlers.UpdateOrder(db))
+		// Every route is checked against the permission matrix in
+		// internal/policy. Checks are attached per route: Use() on a "/"
+		// group would apply to every route registered after it under the
+		// same prefix.
+		allow := func(action policy.Action, kind policy.Kind, param ...string) fiber.Handler {
+			return middleware.Allow(policies, action, kind, param...)
 		}
 
-		// Admin endpoints (admin, sale_admin only)
-		admin := protected.Group("/")
-		admin.Use(middleware.RoleRequired("admin", "sale_admin"))
-		{
-			admin.Post("/products", handlers.CreateProduct(db))
-			admin.Put("/products/:id", handlers.UpdateProduct(db))
-			admin.Delete("/products/:id", handlers.DeleteProduct(db))
-		}
+		// Own profile and sessions
+		protected.Get("/profile", allow(policy.Read, policy.Profile), handlers.GetProfile(users))
+		protected.Get("/me/sessions", allow(policy.Read, policy.Profile), handlers.GetMySessions(sessions))
+		protected.Delete("/me/sessions/:id", allow(policy.Update, policy.Profile), handlers.RevokeMySession(sessions, auditLog))
+		protected.Patch("/profile", allow(policy.Update, policy.Profile), handlers.UpdateProfile(users, profiles, auditLog))
+		protected.Post("/profile/avatar", allow(policy.Update, policy.Profile), handlers.UploadAvatar(users, store, cfg.MaxUploadSize, auditLog))
+		protected.Delete("/profile/avatar", allow(policy.Update, policy.Profile), handlers.DeleteAvatar(users, store, auditLog))
+
+		// Customers
+		protected.Get("/customers", allow(policy.Read, policy.Customer), handlers.GetCustomers())
+		protected.Get("/customers/:id", allow(policy.Read, policy.Customer, "id"), handlers.GetCustomer())
+		protected.Post("/customers", allow(policy.Create, policy.Customer), handlers.CreateCustomer())
+		protected.Put("/customers/:id", allow(policy.Update, policy.Customer, "id"), handlers.UpdateCustomer())
+
+		// Orders; creating and changing status are checked again by the
+		// handlers, which know the customer and the new status
+		protected.Get("/orders", allow(policy.Read, policy.Order), handlers.GetOrders())
+		protected.Get("/orders/:id", allow(policy.Read, policy.Order, "id"), handlers.GetOrder())
+		protected.Post("/orders", allow(policy.Create, policy.Order), handlers.CreateOrder(policies))
+		protected.Put("/orders/:id", allow(policy.Read, policy.Order, "id"), handlers.UpdateOrder(policies))
+
+		// Stock movements and lots
+		protected.Get("/products/:id/stock-movements", allow(policy.Read, policy.Inventory), handlers.GetStockMovements(ledger, warehouses))
+		protected.Get("/inventory/adjustment-reasons", allow(policy.Read, policy.Inventory), handlers.GetAdjustmentReasons())
+		protected.Post("/inventory/adjustments", allow(policy.Create, policy.Inventory), handlers.CreateStockAdjustment(ledger, warehouses))
+		protected.Post("/inventory/receipts", allow(policy.Create, policy.Inventory), handlers.CreateStockReceipt(ledger, warehouses))
+		protected.Post("/inventory/returns", allow(policy.Create, policy.Inventory), handlers.CreateStockReturn(ledger, warehouses))
+		protected.Get("/inventory/lots", allow(policy.Read, policy.Inventory), handlers.GetStockLots(ledger, warehouses))
+		protected.Get("/inventory/lots/near-expiry", allow(policy.Read, policy.Inventory), handlers.GetNearExpiryLots(ledger, warehouses))
+		protected.Get("/inventory/low-stock", allow(policy.Read, policy.Inventory), handlers.GetLowStock(lowStock, warehouses))
+		protected.Get("/inventory/recall", allow(policy.Read, policy.Report), handlers.GetLotRecall(ledger))
+
+		// Warehouses and transfers
+		protected.Get("/warehouses", allow(policy.Read, policy.Warehouse), handlers.GetWarehouses(warehouses))
+		protected.Post("/warehouses", allow(policy.Create, policy.Warehouse), handlers.CreateWarehouse())
+		protected.Put("/warehouses/:id", allow(policy.Update, policy.Warehouse), handlers.UpdateWarehouse(warehouses))
+		protected.Put("/warehouses/:id/users", allow(policy.Assign, policy.Warehouse), handlers.AssignWarehouseUsers(warehouses))
+		protected.Get("/warehouses/:id/stock", allow(policy.Read, policy.Inventory), handlers.GetWarehouseStock(warehouses))
+		protected.Get("/transfers", allow(policy.Read, policy.Inventory), handlers.GetStockTransfers(warehouses))
+		protected.Get("/transfers/:id", allow(policy.Read, policy.Inventory), handlers.GetStockTransfer(warehouses))
+		protected.Post("/transfers", allow(policy.Create, policy.Inventory), handlers.CreateStockTransfer(warehouses))
+		protected.Post("/transfers/:id/ship", allow(policy.Update, policy.Inventory), handlers.ShipStockTransfer(warehouses))
+		protected.Post("/transfers/:id/receive", allow(policy.Update, policy.Inventory), handlers.ReceiveStockTransfer(warehouses))
+		protected.Post("/transfers/:id/cancel", allow(policy.Update, policy.Inventory), handlers.CancelStockTransfer(warehouses))
+
+		// Stocktakes
+		protected.Get("/stocktakes", allow(policy.Read, policy.Inventory), handlers.GetStocktakes(stocktakes, warehouses))
+		protected.Post("/stocktakes", allow(policy.Create, policy.Inventory), handlers.CreateStocktake(stocktakes, warehouses))
+		protected.Get("/stocktakes/:id", allow(policy.Read, policy.Inventory), handlers.GetStocktake(stocktakes, warehouses))
+		protected.Post("/stocktakes/:id/counts", allow(policy.Update, policy.Inventory), handlers.SubmitStocktakeCounts(stocktakes, warehouses))
+		protected.Post("/stocktakes/:id/cancel", allow(policy.Update, policy.Inventory), handlers.CancelStocktake(stocktakes, warehouses))
+		protected.Post("/stocktakes/:id/approve", allow(policy.Approve, policy.Inventory), handlers.ApproveStocktake(stocktakes, warehouses))
+		protected.Get("/inventory/shrinkage", allow(policy.Read, policy.Inventory), handlers.GetShrinkageReport(stocktakes, warehouses))
+
+		// Picking, packing and delivery
+		protected.Get("/warehouse/pick-lists", allow(policy.Fulfil, policy.Order), handlers.GetPickLists(picking, warehouses, docs))
+		protected.Get("/warehouse/packing-slips", allow(policy.Fulfil, policy.Order), handlers.GetPackingSlips(picking, warehouses, docs))
+		protected.Get("/orders/:id/packing-slip", allow(policy.Fulfil, policy.Order), handlers.GetOrderPackingSlip(picking, warehouses, docs))
+		protected.Get("/orders/:id/pick", allow(policy.Fulfil, policy.Order), handlers.GetOrderPick(picking, warehouses))
+		protected.Post("/orders/:id/pick", allow(policy.Fulfil, policy.Order), handlers.ConfirmOrderPick(picking, warehouses))
+		protected.Put("/orders/:id/delivery", allow(policy.Fulfil, policy.Order), handlers.UpdateOrderDelivery())
+		protected.Post("/orders/:id/fulfilment-warehouse", allow(policy.Fulfil, policy.Order), handlers.AssignOrderWarehouse(warehouses))
+
+		// Barcode and QR labels, scanning
+		protected.Get("/products/:id/barcode", allow(policy.Read, policy.Inventory), handlers.GetProductBarcode())
+		protected.Get("/orders/:id/barcode", allow(policy.Fulfil, policy.Order), handlers.GetOrderBarcode(picking, warehouses))
+		protected.Get("/inventory/lots/:id/barcode", allow(policy.Read, policy.Inventory), handlers.GetLotBarcode(ledger, warehouses))
+		protected.Get("/scan/:code", allow(policy.Read, policy.Inventory), handlers.Scan(scanner, warehouses))
+
+		// Catalogue
+		protected.Post("/products", allow(policy.Create, policy.Product), handlers.CreateProduct(ledger))
+		protected.Put("/products/:id", allow(policy.Update, policy.Product), handlers.UpdateProduct(ledger))
+		protected.Delete("/products/:id", allow(policy.Delete, policy.Product), handlers.DeleteProduct())
+
+		// Product images
+		protected.Post("/products/:id/images", allow(policy.Update, policy.Product), handlers.UploadProductImages(store, cfg.MaxUploadSize))
+		protected.Put("/products/:id/images/order", allow(policy.Update, policy.Product), handlers.ReorderProductImages())
+		protected.Put("/products/:id/images/:imageId/primary", allow(policy.Update, policy.Product), handlers.SetPrimaryProductImage())
+		protected.Delete("/products/:id/images/:imageId", allow(policy.Update, policy.Product), handlers.DeleteProductImage(store))
+
+		// Product units of measure
+		protected.Post("/products/:id/units", allow(policy.Update, policy.Product), handlers.CreateProductUnit())
+		protected.Put("/products/:id/units/:unitId", allow(policy.Update, policy.Product), handlers.UpdateProductUnit())
+		protected.Delete("/products/:id/units/:unitId", allow(policy.Update, policy.Product), handlers.DeleteProductUnit())
+
+		// User administration
+		protected.Get("/admin/users", allow(policy.Manage, policy.Profile), handlers.GetUsers(users))
+		protected.Post("/admin/users", allow(policy.Create, policy.Profile), handlers.CreateUser(users, auditLog))
+		protected.Get("/admin/users/:id", allow(policy.Manage, policy.Profile, "id"), handlers.GetUser(users))
+		protected.Put("/admin/users/:id", allow(policy.Manage, policy.Profile, "id"), handlers.UpdateUser(users, policies, profiles, auditLog))
+		protected.Post("/admin/users/:id/deactivate", allow(policy.Delete, policy.Profile, "id"), handlers.DeactivateUser(users, profiles, auditLog))
+		protected.Post("/admin/users/:id/reactivate", allow(policy.Delete, policy.Profile, "id"), handlers.ReactivateUser(users, profiles, auditLog))
+		protected.Delete("/admin/users/:id/sessions", allow(policy.Delete, policy.Profile, "id"), handlers.RevokeUserSessions(sessions, auditLog))
+		protected.Get("/admin/invitations", allow(policy.Manage, policy.Profile), handlers.GetInvitations(invites))
+		protected.Post("/admin/invitations", allow(policy.Create, policy.Profile), handlers.CreateInvitation(users, invites, auditLog))
+		protected.Post("/admin/invitations/:id/resend", allow(policy.Create, policy.Profile), handlers.ResendInvitation(invites, auditLog))
+		protected.Delete("/admin/invitations/:id", allow(policy.Create, policy.Profile), handlers.RevokeInvitation(invites, auditLog))
+
+		// API keys for integrations
+		protected.Get("/admin/api-keys", allow(policy.Manage, policy.System), handlers.GetAPIKeys(apiKeys))
+		protected.Post("/admin/api-keys", allow(policy.Manage, policy.System), handlers.CreateAPIKey(apiKeys, auditLog))
+		protected.Post("/admin/api-keys/:id/rotate", allow(policy.Manage, policy.System), handlers.RotateAPIKey(apiKeys, auditLog))
+		protected.Delete("/admin/api-keys/:id", allow(policy.Manage, policy.System), handlers.RevokeAPIKey(apiKeys, auditLog))
+
+		// Audit log
+		protected.Get("/admin/audit-logs", allow(policy.Read, policy.System), handlers.GetAuditLogs(auditLog))
+
+		// Runtime metrics
+		protected.Get("/admin/metrics", allow(policy.Read, policy.System), handlers.GetMetrics(profiles))
 	}
 
 	// Start server
@@ -133,7 +367,7 @@ func main() {
 	log.Printf("🚀 Server starting on port %s", port)
 	log.Printf("📊 Database: Supabase (%s)", cfg.SupabaseURL)
 	log.Printf("🔐 Auth: JWT-based (stateless)")
-	log.Printf("🛡️  Authorization: Role-based")
+	log.Printf("🛡️  Authorization: Policy-based (internal/policy)")
 	log.Printf("⚡ Framework: Fiber v2")
 	
 	if err := app.Listen(":" + port); err != nil {
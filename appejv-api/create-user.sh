@@ -0,0 +1,54 @@
+#!/bin/bash
+
+# Create a user through the admin API
+# Usage: ./create-user.sh <email> <role> "<full name>" [password]
+# Without a password the user is invited by email instead.
+# Signs in with ADMIN_EMAIL / ADMIN_PASSWORD against API_URL (default
+# http://localhost:8081).
+
+set -e
+
+if [ -f .env ]; then
+  source .env
+fi
+
+EMAIL=$1
+ROLE=$2
+FULL_NAME=$3
+PASSWORD=$4
+API_URL=${API_URL:-http://localhost:8081}
+
+if [ -z "$EMAIL" ] || [ -z "$ROLE" ] || [ -z "$FULL_NAME" ]; then
+  echo "Usage: $0 <email> <admin|sale_admin|sale|warehouse|customer> \"<full name>\" [password]"
+  exit 1
+fi
+
+if [ -z "$ADMIN_EMAIL" ] || [ -z "$ADMIN_PASSWORD" ]; then
+  echo "❌ Set ADMIN_EMAIL and ADMIN_PASSWORD"
+  exit 1
+fi
+
+TOKEN=$(curl -s -X POST "${API_URL}/api/v1/auth/login" \
+  -H "Content-Type: application/json" \
+  -d "{\"email\":\"${ADMIN_EMAIL}\",\"password\":\"${ADMIN_PASSWORD}\"}" \
+  | sed -n 's/.*"access_token":"\([^"]*\)".*/\1/p')
+
+if [ -z "$TOKEN" ]; then
+  echo "❌ Admin sign-in failed"
+  exit 1
+fi
+
+if [ -n "$PASSWORD" ]; then
+  ENDPOINT="${API_URL}/api/v1/admin/users"
+  BODY="{\"email\":\"${EMAIL}\",\"role\":\"${ROLE}\",\"full_name\":\"${FULL_NAME}\",\"password\":\"${PASSWORD}\"}"
+else
+  ENDPOINT="${API_URL}/api/v1/admin/invitations"
+  BODY="{\"email\":\"${EMAIL}\",\"role\":\"${ROLE}\",\"full_name\":\"${FULL_NAME}\"}"
+fi
+
+echo "Creating ${ROLE} user ${EMAIL}..."
+curl -s -X POST "$ENDPOINT" \
+  -H "Authorization: Bearer ${TOKEN}" \
+  -H "Content-Type: application/json" \
+  -d "$BODY"
+echo ""
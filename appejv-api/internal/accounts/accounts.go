@@ -0,0 +1,344 @@
+// Package accounts administers users: the auth account in GoTrue together
+// with the profile, manager and sales team membership in the database
+package accounts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/appejv/appejv-api/internal/auth"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/internal/policy"
+	"github.com/appejv/appejv-api/pkg/database"
+)
+
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrInvalidRole    = errors.New("role must be one of admin, sale_admin, sale, warehouse, customer")
+	ErrInvalidManager = errors.New("manager must be an active admin or sale_admin")
+	ErrInvalidTeam    = errors.New("team not found or inactive")
+	ErrTeamNotSale    = errors.New("only sales can be members of a sales team")
+	ErrInvalidEmail   = errors.New("a valid email is required")
+)
+
+// Roles a user may be given
+var Roles = []string{
+	policy.RoleAdmin,
+	policy.RoleSaleAdmin,
+	policy.RoleSale,
+	policy.RoleWarehouse,
+	policy.RoleCustomer,
+}
+
+// ValidRole reports whether role is one of Roles
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter narrows List
+type Filter struct {
+	Role   string
+	Search string
+	// ManagerID limits the list to the manager and their reports
+	ManagerID          string
+	IncludeDeactivated bool
+	Limit              int
+	Offset             int
+}
+
+// Accounts creates, changes and deactivates users. It needs the
+// service-role handle and the GoTrue admin API; callers authorize.
+type Accounts struct {
+	db    *database.Database
+	admin *auth.Admin
+}
+
+func New(db *database.Database, admin *auth.Admin) *Accounts {
+	return &Accounts{db: db, admin: admin}
+}
+
+// List returns users, newest first
+func (a *Accounts) List(ctx context.Context, filter Filter) ([]models.UserAccount, error) {
+	params := map[string]interface{}{
+		"p_include_deactivated": filter.IncludeDeactivated,
+		"p_limit":               filter.Limit,
+		"p_offset":              filter.Offset,
+	}
+	if filter.Role != "" {
+		params["p_role"] = filter.Role
+	}
+	if filter.Search != "" {
+		params["p_search"] = filter.Search
+	}
+	if filter.ManagerID != "" {
+		params["p_manager_id"] = filter.ManagerID
+	}
+
+	var users []models.UserAccount
+	if err := a.db.RPC(ctx, "admin_list_users", params, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Get returns one user, deactivated or not
+func (a *Accounts) Get(ctx context.Context, id string) (models.UserAccount, error) {
+	var users []models.UserAccount
+	err := a.db.RPC(ctx, "admin_list_users", map[string]interface{}{
+		"p_id":                  id,
+		"p_include_deactivated": true,
+		"p_limit":               1,
+	}, &users)
+	if err != nil {
+		return models.UserAccount{}, err
+	}
+	if len(users) == 0 {
+		return models.UserAccount{}, ErrUserNotFound
+	}
+	return users[0], nil
+}
+
+// Create creates a confirmed account with the request's password and sets
+// up its profile with the role, manager and team; if that fails the
+// account is removed again.
+func (a *Accounts) Create(ctx context.Context, req models.CreateUserRequest) (models.UserAccount, error) {
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" || !strings.Contains(req.Email, "@") {
+		return models.UserAccount{}, ErrInvalidEmail
+	}
+	if !ValidRole(req.Role) {
+		return models.UserAccount{}, ErrInvalidRole
+	}
+	if utf8.RuneCountInString(req.Password) < auth.MinPasswordLength {
+		return models.UserAccount{}, fmt.Errorf("%w: at least %d characters", auth.ErrWeakPassword, auth.MinPasswordLength)
+	}
+	managerID, err := a.checkManager(req.ManagerID)
+	if err != nil {
+		return models.UserAccount{}, err
+	}
+	teamID, err := a.checkTeam(req.Role, req.TeamID)
+	if err != nil {
+		return models.UserAccount{}, err
+	}
+
+	metadata := map[string]interface{}{
+		"full_name": req.FullName,
+		"role":      req.Role,
+	}
+	user, err := a.admin.CreateUser(ctx, req.Email, req.Password, metadata)
+	if err != nil {
+		return models.UserAccount{}, err
+	}
+
+	profile := map[string]interface{}{
+		"id":         user.ID,
+		"full_name":  strings.TrimSpace(req.FullName),
+		"role":       req.Role,
+		"phone":      req.Phone,
+		"manager_id": managerID,
+	}
+	// Upsert: a database trigger may already have created the profile
+	_, _, err = a.db.Client.From("profiles").
+		Insert(profile, true, "id", "minimal", "").
+		Execute()
+	if err == nil && teamID != nil {
+		err = a.setTeam(ctx, user.ID, teamID)
+	}
+	if err != nil {
+		if derr := a.admin.DeleteUser(ctx, user.ID); derr != nil {
+			log.Printf("accounts: removing %s after a failed setup: %v", user.ID, derr)
+		}
+		return models.UserAccount{}, err
+	}
+
+	return a.Get(ctx, user.ID)
+}
+
+// Update changes the profile fields set in req and returns the user before
+// and after. A user who stops being a sale leaves their team.
+func (a *Accounts) Update(ctx context.Context, id string, req models.UpdateUserRequest) (models.UserAccount, models.UserAccount, error) {
+	before, err := a.Get(ctx, id)
+	if err != nil {
+		return models.UserAccount{}, models.UserAccount{}, err
+	}
+
+	updates := map[string]interface{}{}
+	if req.FullName != nil {
+		updates["full_name"] = strings.TrimSpace(*req.FullName)
+	}
+	if req.Phone != nil {
+		updates["phone"] = emptyToNil(req.Phone)
+	}
+	role := before.Role
+	if req.Role != nil {
+		if !ValidRole(*req.Role) {
+			return models.UserAccount{}, models.UserAccount{}, ErrInvalidRole
+		}
+		role = *req.Role
+		updates["role"] = role
+	}
+	if req.ManagerID != nil {
+		if *req.ManagerID == id {
+			return models.UserAccount{}, models.UserAccount{}, ErrInvalidManager
+		}
+		managerID, err := a.checkManager(req.ManagerID)
+		if err != nil {
+			return models.UserAccount{}, models.UserAccount{}, err
+		}
+		updates["manager_id"] = managerID
+	}
+
+	teamID := before.TeamID
+	teamChanged := false
+	if req.TeamID != nil {
+		if teamID, err = a.checkTeam(role, req.TeamID); err != nil {
+			return models.UserAccount{}, models.UserAccount{}, err
+		}
+		teamChanged = true
+	} else if role != policy.RoleSale && before.TeamID != nil {
+		teamID, teamChanged = nil, true
+	}
+
+	if len(updates) > 0 {
+		_, _, err = a.db.Client.From("profiles").
+			Update(updates, "minimal", "").
+			Eq("id", id).
+			Execute()
+		if err != nil {
+			return models.UserAccount{}, models.UserAccount{}, err
+		}
+	}
+	if teamChanged {
+		if err := a.setTeam(ctx, id, teamID); err != nil {
+			return models.UserAccount{}, models.UserAccount{}, err
+		}
+	}
+
+	after, err := a.Get(ctx, id)
+	return before, after, err
+}
+
+// SetActive deactivates a user, banning their auth account, or reactivates
+// them. It returns the user before and after.
+func (a *Accounts) SetActive(ctx context.Context, id string, active bool) (models.UserAccount, models.UserAccount, error) {
+	before, err := a.Get(ctx, id)
+	if err != nil {
+		return models.UserAccount{}, models.UserAccount{}, err
+	}
+	if (before.DeactivatedAt == nil) == active {
+		return before, before, nil
+	}
+
+	if err := a.admin.SetBanned(ctx, id, !active); err != nil {
+		return models.UserAccount{}, models.UserAccount{}, err
+	}
+
+	var deactivatedAt interface{}
+	if !active {
+		deactivatedAt = time.Now().UTC()
+	}
+	_, _, err = a.db.Client.From("profiles").
+		Update(map[string]interface{}{"deactivated_at": deactivatedAt}, "minimal", "").
+		Eq("id", id).
+		Execute()
+	if err != nil {
+		return models.UserAccount{}, models.UserAccount{}, err
+	}
+
+	after, err := a.Get(ctx, id)
+	return before, after, err
+}
+
+// TeamManager returns the manager of an active sales team
+func (a *Accounts) TeamManager(teamID string) (string, error) {
+	var teams []struct {
+		ManagerID *string `json:"manager_id"`
+	}
+	_, err := a.db.Client.From("sales_teams").
+		Select("manager_id", "", false).
+		Eq("id", teamID).
+		Eq("status", "active").
+		Limit(1, "").
+		ExecuteTo(&teams)
+	if err != nil {
+		return "", err
+	}
+	if len(teams) == 0 {
+		return "", ErrInvalidTeam
+	}
+	if teams[0].ManagerID == nil {
+		return "", nil
+	}
+	return *teams[0].ManagerID, nil
+}
+
+// checkManager validates a manager id; an empty one means no manager
+func (a *Accounts) checkManager(managerID *string) (*string, error) {
+	id := emptyToNil(managerID)
+	if id == nil {
+		return nil, nil
+	}
+
+	var managers []struct {
+		Role          string     `json:"role"`
+		DeactivatedAt *time.Time `json:"deactivated_at"`
+	}
+	_, err := a.db.Client.From("profiles").
+		Select("role, deactivated_at", "", false).
+		Eq("id", *id).
+		Is("deleted_at", "null").
+		Limit(1, "").
+		ExecuteTo(&managers)
+	if err != nil {
+		return nil, err
+	}
+	if len(managers) == 0 || managers[0].DeactivatedAt != nil ||
+		(managers[0].Role != policy.RoleAdmin && managers[0].Role != policy.RoleSaleAdmin) {
+		return nil, ErrInvalidManager
+	}
+	return id, nil
+}
+
+// checkTeam validates a team id for a user with role; an empty one means no
+// team
+func (a *Accounts) checkTeam(role string, teamID *string) (*string, error) {
+	id := emptyToNil(teamID)
+	if id == nil {
+		return nil, nil
+	}
+	if role != policy.RoleSale {
+		return nil, ErrTeamNotSale
+	}
+	if _, err := a.TeamManager(*id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func (a *Accounts) setTeam(ctx context.Context, userID string, teamID *string) error {
+	return a.db.RPC(ctx, "set_team_membership", map[string]interface{}{
+		"p_sale_id": userID,
+		"p_team_id": teamID,
+	}, nil)
+}
+
+func emptyToNil(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := strings.TrimSpace(*s)
+	if v == "" {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,352 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/appejv/appejv-api/internal/auth"
+	"github.com/appejv/appejv-api/internal/mailer"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Invitation statuses. Expired is reported for pending invitations past
+// their expiry; it is not stored.
+const (
+	InvitePending   = "pending"
+	InviteAccepting = "accepting"
+	InviteAccepted  = "accepted"
+	InviteRevoked   = "revoked"
+	InviteExpired   = "expired"
+)
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationClosed   = errors.New("invitation is no longer pending")
+	ErrInvalidInvite      = errors.New("invalid or expired invitation")
+	ErrAlreadyInvited     = errors.New("an invitation for this email is already pending")
+)
+
+// invitationColumns is everything but the token hash
+const invitationColumns = "id, email, full_name, phone, role, manager_id, team_id, locale, status, invited_by, user_id, expires_at, sent_count, last_sent_at, accepted_at, revoked_at, created_at"
+
+// InviteFilter narrows List
+type InviteFilter struct {
+	// Status is pending, accepted, revoked or expired; empty for all
+	Status    string
+	InvitedBy string
+	Limit     int
+	Offset    int
+}
+
+// Invitations issues emailed, single-use links that let new users set
+// their password; accepting one creates the account with the role,
+// manager and team the invitation was made with.
+type Invitations struct {
+	db       *database.Database
+	accounts *Accounts
+	outbox   *mailer.Outbox
+	ttl      time.Duration
+	linkURL  string
+}
+
+// NewInvitations emails links to linkURL?token=..., valid for ttl
+func NewInvitations(db *database.Database, accounts *Accounts, outbox *mailer.Outbox, ttl time.Duration, linkURL string) *Invitations {
+	return &Invitations{
+		db:       db,
+		accounts: accounts,
+		outbox:   outbox,
+		ttl:      ttl,
+		linkURL:  linkURL,
+	}
+}
+
+// Create records a pending invitation from inviterID and emails the link.
+// The role, manager and team are checked now and again on acceptance.
+func (inv *Invitations) Create(ctx context.Context, inviterID string, req models.CreateInvitationRequest) (models.Invitation, error) {
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" || !strings.Contains(email, "@") {
+		return models.Invitation{}, ErrInvalidEmail
+	}
+	if !ValidRole(req.Role) {
+		return models.Invitation{}, ErrInvalidRole
+	}
+	managerID, err := inv.accounts.checkManager(req.ManagerID)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+	teamID, err := inv.accounts.checkTeam(req.Role, req.TeamID)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+
+	var exists bool
+	if err := inv.db.RPC(ctx, "email_has_account", map[string]interface{}{"p_email": email}, &exists); err != nil {
+		return models.Invitation{}, err
+	}
+	if exists {
+		return models.Invitation{}, auth.ErrEmailExists
+	}
+
+	// An expired invitation does not block a new one
+	_, _, err = inv.db.Client.From("invitations").
+		Update(map[string]interface{}{
+			"status":     InviteRevoked,
+			"revoked_at": time.Now().UTC(),
+		}, "minimal", "").
+		Eq("email", email).
+		Eq("status", InvitePending).
+		Lte("expires_at", time.Now().UTC().Format(time.RFC3339)).
+		Execute()
+	if err != nil {
+		return models.Invitation{}, err
+	}
+
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		return models.Invitation{}, err
+	}
+	row := map[string]interface{}{
+		"email":      email,
+		"full_name":  emptyToNil(&req.FullName),
+		"phone":      emptyToNil(req.Phone),
+		"role":       req.Role,
+		"manager_id": managerID,
+		"team_id":    teamID,
+		"locale":     mailer.Locale(req.Locale),
+		"token_hash": hash,
+		"invited_by": emptyToNil(&inviterID),
+		"expires_at": time.Now().Add(inv.ttl).UTC(),
+	}
+
+	var created []models.Invitation
+	_, err = inv.db.Client.From("invitations").
+		Insert(row, false, "", "representation", "").
+		ExecuteTo(&created)
+	if err != nil {
+		if strings.Contains(err.Error(), "idx_invitations_open_email") {
+			return models.Invitation{}, ErrAlreadyInvited
+		}
+		return models.Invitation{}, err
+	}
+	if len(created) == 0 {
+		return models.Invitation{}, errors.New("failed to create invitation")
+	}
+
+	invitation := withStatus(created[0])
+	return invitation, inv.send(ctx, invitation, token)
+}
+
+// List returns invitations, newest first
+func (inv *Invitations) List(filter InviteFilter) ([]models.Invitation, error) {
+	query := inv.db.Client.From("invitations").
+		Select(invitationColumns, "", false)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	switch filter.Status {
+	case "":
+	case InviteExpired:
+		query = query.Eq("status", InvitePending).Lte("expires_at", now)
+	case InvitePending:
+		query = query.Eq("status", InvitePending).Gt("expires_at", now)
+	default:
+		query = query.Eq("status", filter.Status)
+	}
+	if filter.InvitedBy != "" {
+		query = query.Eq("invited_by", filter.InvitedBy)
+	}
+
+	var invitations []models.Invitation
+	_, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Range(filter.Offset, filter.Offset+filter.Limit-1, "").
+		ExecuteTo(&invitations)
+	if err != nil {
+		return nil, err
+	}
+	for i := range invitations {
+		invitations[i] = withStatus(invitations[i])
+	}
+	return invitations, nil
+}
+
+// Get returns one invitation
+func (inv *Invitations) Get(id string) (models.Invitation, error) {
+	var invitations []models.Invitation
+	_, err := inv.db.Client.From("invitations").
+		Select(invitationColumns, "", false).
+		Eq("id", id).
+		Limit(1, "").
+		ExecuteTo(&invitations)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+	if len(invitations) == 0 {
+		return models.Invitation{}, ErrInvitationNotFound
+	}
+	return withStatus(invitations[0]), nil
+}
+
+// Resend emails a fresh link for a pending or expired invitation. The old
+// link stops working and the expiry starts over.
+func (inv *Invitations) Resend(ctx context.Context, id string) (models.Invitation, error) {
+	current, err := inv.Get(id)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		return models.Invitation{}, err
+	}
+
+	var updated []models.Invitation
+	_, err = inv.db.Client.From("invitations").
+		Update(map[string]interface{}{
+			"token_hash":   hash,
+			"expires_at":   time.Now().Add(inv.ttl).UTC(),
+			"sent_count":   current.SentCount + 1,
+			"last_sent_at": time.Now().UTC(),
+		}, "representation", "").
+		Eq("id", id).
+		Eq("status", InvitePending).
+		ExecuteTo(&updated)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+	if len(updated) == 0 {
+		return models.Invitation{}, ErrInvitationClosed
+	}
+
+	invitation := withStatus(updated[0])
+	return invitation, inv.send(ctx, invitation, token)
+}
+
+// Revoke cancels a pending invitation; its link stops working
+func (inv *Invitations) Revoke(id string) (models.Invitation, error) {
+	var updated []models.Invitation
+	_, err := inv.db.Client.From("invitations").
+		Update(map[string]interface{}{
+			"status":     InviteRevoked,
+			"revoked_at": time.Now().UTC(),
+		}, "representation", "").
+		Eq("id", id).
+		Eq("status", InvitePending).
+		ExecuteTo(&updated)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+	if len(updated) == 0 {
+		if _, err := inv.Get(id); err != nil {
+			return models.Invitation{}, err
+		}
+		return models.Invitation{}, ErrInvitationClosed
+	}
+	return withStatus(updated[0]), nil
+}
+
+// Accept creates the invitee's account with password and the invitation's
+// role, manager and team, and uses up the invitation
+func (inv *Invitations) Accept(ctx context.Context, req models.AcceptInviteRequest) (models.UserAccount, error) {
+	if utf8.RuneCountInString(req.Password) < auth.MinPasswordLength {
+		return models.UserAccount{}, fmt.Errorf("%w: at least %d characters", auth.ErrWeakPassword, auth.MinPasswordLength)
+	}
+
+	// Claim the invitation so a second request with the same link fails
+	var claimed []models.Invitation
+	_, err := inv.db.Client.From("invitations").
+		Update(map[string]interface{}{"status": InviteAccepting}, "representation", "").
+		Eq("token_hash", auth.HashToken(req.Token)).
+		Eq("status", InvitePending).
+		Gt("expires_at", time.Now().UTC().Format(time.RFC3339)).
+		ExecuteTo(&claimed)
+	if err != nil {
+		return models.UserAccount{}, err
+	}
+	if len(claimed) == 0 {
+		return models.UserAccount{}, ErrInvalidInvite
+	}
+	invitation := claimed[0]
+
+	create := models.CreateUserRequest{
+		Email:     invitation.Email,
+		Password:  req.Password,
+		Role:      invitation.Role,
+		Phone:     invitation.Phone,
+		ManagerID: invitation.ManagerID,
+		TeamID:    invitation.TeamID,
+	}
+	if invitation.FullName != nil {
+		create.FullName = *invitation.FullName
+	}
+	if req.FullName != nil && strings.TrimSpace(*req.FullName) != "" {
+		create.FullName = *req.FullName
+	}
+	if req.Phone != nil && strings.TrimSpace(*req.Phone) != "" {
+		create.Phone = req.Phone
+	}
+
+	user, err := inv.accounts.Create(ctx, create)
+	if err != nil {
+		// Let the invitee try again, e.g. with a stronger password
+		inv.setStatus(invitation.ID, map[string]interface{}{"status": InvitePending})
+		return models.UserAccount{}, err
+	}
+
+	inv.setStatus(invitation.ID, map[string]interface{}{
+		"status":      InviteAccepted,
+		"accepted_at": time.Now().UTC(),
+		"user_id":     user.ID,
+	})
+	return user, nil
+}
+
+func (inv *Invitations) setStatus(id string, fields map[string]interface{}) {
+	_, _, _ = inv.db.Client.From("invitations").
+		Update(fields, "minimal", "").
+		Eq("id", id).
+		Execute()
+}
+
+// send queues the invitation email with the link for token
+func (inv *Invitations) send(ctx context.Context, invitation models.Invitation, token string) error {
+	name := invitation.Email
+	if invitation.FullName != nil {
+		name = *invitation.FullName
+	}
+
+	inviter := ""
+	if invitation.InvitedBy != nil {
+		if profile, err := inv.accounts.Get(ctx, *invitation.InvitedBy); err == nil && profile.FullName != nil {
+			inviter = *profile.FullName
+		}
+	}
+
+	return inv.outbox.Enqueue(ctx, mailer.Email{
+		To:       invitation.Email,
+		Template: mailer.Invitation,
+		Locale:   invitation.Locale,
+		Data: map[string]interface{}{
+			"name":    name,
+			"inviter": inviter,
+			"role":    invitation.Role,
+			"link":    inv.linkURL + "?token=" + url.QueryEscape(token),
+			"hours":   int(inv.ttl.Hours()),
+		},
+		Sensitive: true,
+	})
+}
+
+// withStatus reports pending invitations past their expiry as expired
+func withStatus(invitation models.Invitation) models.Invitation {
+	if invitation.Status == InvitePending && time.Now().After(invitation.ExpiresAt) {
+		invitation.Status = InviteExpired
+	}
+	return invitation
+}
@@ -0,0 +1,164 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/appejv/appejv-api/internal/auth"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/internal/policy"
+	"github.com/supabase-community/postgrest-go"
+)
+
+var (
+	ErrInvalidName  = errors.New("full_name must be 1 to 100 characters")
+	ErrInvalidPhone = errors.New("invalid phone number")
+	ErrPhoneInUse   = errors.New("phone number is used by another account")
+)
+
+// profileColumns are the columns of models.Profile
+const profileColumns = "id, full_name, role, phone, manager_id, avatar_url, notifications_enabled, deleted_at, deactivated_at, created_at"
+
+// Profile returns a user's own profile with their team and manager. The
+// email is left for the caller, who has it from the access token.
+func (a *Accounts) Profile(id string) (models.ProfileDetails, error) {
+	profile, err := a.profile(id)
+	if err != nil {
+		return models.ProfileDetails{}, err
+	}
+	details := models.ProfileDetails{Profile: profile}
+
+	if profile.Role == policy.RoleSale {
+		var memberships []struct {
+			Team models.TeamSummary `json:"sales_teams"`
+		}
+		_, err := a.db.Client.From("team_members").
+			Select("sales_teams!inner(id, name)", "", false).
+			Eq("sale_id", id).
+			Eq("status", "active").
+			Eq("sales_teams.status", "active").
+			Limit(1, "").
+			ExecuteTo(&memberships)
+		if err != nil {
+			return models.ProfileDetails{}, err
+		}
+		if len(memberships) > 0 {
+			details.Team = &memberships[0].Team
+		}
+	}
+
+	if profile.Role == policy.RoleSaleAdmin || profile.Role == policy.RoleAdmin {
+		_, err := a.db.Client.From("sales_teams").
+			Select("id, name", "", false).
+			Eq("manager_id", id).
+			Eq("status", "active").
+			Order("name", &postgrest.OrderOpts{Ascending: true}).
+			ExecuteTo(&details.ManagedTeams)
+		if err != nil {
+			return models.ProfileDetails{}, err
+		}
+	}
+
+	if profile.ManagerID != nil {
+		var managers []models.ProfileSummary
+		_, err := a.db.Client.From("profiles").
+			Select("id, full_name, role, phone, avatar_url", "", false).
+			Eq("id", *profile.ManagerID).
+			Is("deleted_at", "null").
+			Limit(1, "").
+			ExecuteTo(&managers)
+		if err != nil {
+			return models.ProfileDetails{}, err
+		}
+		if len(managers) > 0 {
+			details.Manager = &managers[0]
+		}
+	}
+	return details, nil
+}
+
+// UpdateProfile changes the name, phone and notification setting a user
+// edits themselves. It returns the profile before and after.
+func (a *Accounts) UpdateProfile(ctx context.Context, id string, req models.UpdateProfileRequest) (models.Profile, models.Profile, error) {
+	updates := map[string]interface{}{}
+	if req.FullName != nil {
+		name := strings.TrimSpace(*req.FullName)
+		if name == "" || utf8.RuneCountInString(name) > 100 {
+			return models.Profile{}, models.Profile{}, ErrInvalidName
+		}
+		updates["full_name"] = name
+	}
+	if req.Phone != nil {
+		phone := emptyToNil(req.Phone)
+		if phone != nil {
+			if auth.NormalizePhone(*phone) == "" {
+				return models.Profile{}, models.Profile{}, ErrInvalidPhone
+			}
+			var inUse bool
+			err := a.db.RPC(ctx, "phone_in_use", map[string]interface{}{
+				"p_phone":   *phone,
+				"p_user_id": id,
+			}, &inUse)
+			if err != nil {
+				return models.Profile{}, models.Profile{}, err
+			}
+			if inUse {
+				return models.Profile{}, models.Profile{}, ErrPhoneInUse
+			}
+		}
+		updates["phone"] = phone
+	}
+	if req.NotificationsEnabled != nil {
+		updates["notifications_enabled"] = *req.NotificationsEnabled
+	}
+	return a.updateProfile(id, updates)
+}
+
+// SetAvatar sets or, with a nil url, removes a user's avatar. It returns
+// the profile before and after.
+func (a *Accounts) SetAvatar(id string, url *string) (models.Profile, models.Profile, error) {
+	return a.updateProfile(id, map[string]interface{}{"avatar_url": url})
+}
+
+func (a *Accounts) updateProfile(id string, updates map[string]interface{}) (models.Profile, models.Profile, error) {
+	before, err := a.profile(id)
+	if err != nil {
+		return models.Profile{}, models.Profile{}, err
+	}
+	if len(updates) == 0 {
+		return before, before, nil
+	}
+
+	var updated []models.Profile
+	_, err = a.db.Client.From("profiles").
+		Update(updates, "representation", "").
+		Eq("id", id).
+		Is("deleted_at", "null").
+		ExecuteTo(&updated)
+	if err != nil {
+		return models.Profile{}, models.Profile{}, err
+	}
+	if len(updated) == 0 {
+		return models.Profile{}, models.Profile{}, ErrUserNotFound
+	}
+	return before, updated[0], nil
+}
+
+func (a *Accounts) profile(id string) (models.Profile, error) {
+	var profiles []models.Profile
+	_, err := a.db.Client.From("profiles").
+		Select(profileColumns, "", false).
+		Eq("id", id).
+		Is("deleted_at", "null").
+		Limit(1, "").
+		ExecuteTo(&profiles)
+	if err != nil {
+		return models.Profile{}, err
+	}
+	if len(profiles) == 0 {
+		return models.Profile{}, ErrUserNotFound
+	}
+	return profiles[0], nil
+}
@@ -0,0 +1,276 @@
+// Package audit records who changed what in audit_logs
+package audit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/supabase-community/postgrest-go"
+)
+
+const (
+	// bufferSize is how many entries wait for the writer before Record
+	// starts dropping them
+	bufferSize = 10000
+	// batchSize is the most entries written in one insert
+	batchSize = 200
+	// flushInterval is the longest an entry waits to be written
+	flushInterval = 2 * time.Second
+)
+
+// Entity types
+const (
+	EntityUser       = "user"
+	EntityInvitation = "invitation"
+	EntityAPIKey     = "api_key"
+	EntitySession    = "session"
+)
+
+// ErrBufferFull means entries arrive faster than they can be written
+var ErrBufferFull = errors.New("audit buffer is full")
+
+// Entry is one recorded change
+type Entry struct {
+	ActorID    string            `json:"actor_id,omitempty"`
+	ActorRole  string            `json:"actor_role,omitempty"`
+	Action     string            `json:"action"`
+	EntityType string            `json:"entity_type"`
+	EntityID   string            `json:"entity_id,omitempty"`
+	Changes    map[string]Change `json:"changes,omitempty"`
+	IPAddress  string            `json:"ip_address,omitempty"`
+	// Method and Route are the request's, e.g. PUT /api/v1/orders/:id
+	Method     string `json:"method,omitempty"`
+	Route      string `json:"route,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+	// APIKeyID is set when an integration made the change
+	APIKeyID string `json:"api_key_id,omitempty"`
+}
+
+// Change is a field's value before and after
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Log writes entries to audit_logs. Record only queues an entry; Run
+// writes the queue in batches, so auditing adds no latency to requests.
+// Entries still queued when the process exits are lost. It needs the
+// service-role handle.
+type Log struct {
+	db      *database.Database
+	entries chan Entry
+	dropped atomic.Int64
+}
+
+func NewLog(db *database.Database) *Log {
+	return &Log{
+		db:      db,
+		entries: make(chan Entry, bufferSize),
+	}
+}
+
+// Record queues the entry for writing
+func (l *Log) Record(entry Entry) error {
+	if entry.Action == "" || entry.EntityType == "" {
+		return errors.New("audit entry needs an action and an entity type")
+	}
+	select {
+	case l.entries <- entry:
+		return nil
+	default:
+		l.dropped.Add(1)
+		return ErrBufferFull
+	}
+}
+
+// Run writes queued entries until ctx is done, then writes what is left
+func (l *Log) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Entry, 0, batchSize)
+	for {
+		select {
+		case entry := <-l.entries:
+			batch = append(batch, entry)
+			if len(batch) >= batchSize {
+				batch = l.flush(batch)
+			}
+		case <-ticker.C:
+			batch = l.flush(batch)
+		case <-ctx.Done():
+			for {
+				select {
+				case entry := <-l.entries:
+					batch = append(batch, entry)
+				default:
+					l.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes batch and returns it emptied. A failed write is retried
+// once, then given up on: the change it records has already happened.
+func (l *Log) flush(batch []Entry) []Entry {
+	if len(batch) == 0 {
+		return batch
+	}
+	err := l.insert(batch)
+	if err != nil {
+		time.Sleep(time.Second)
+		err = l.insert(batch)
+	}
+	if err != nil {
+		log.Printf("audit: writing %d entries: %v", len(batch), err)
+	}
+	if dropped := l.dropped.Swap(0); dropped > 0 {
+		log.Printf("audit: %d entries dropped, buffer full", dropped)
+	}
+	return batch[:0]
+}
+
+// insert writes batch in one request. A bulk insert needs the same columns
+// in every row, so unset fields are written as NULL rather than left out.
+func (l *Log) insert(batch []Entry) error {
+	rows := make([]map[string]interface{}, len(batch))
+	for i, e := range batch {
+		rows[i] = map[string]interface{}{
+			"actor_id":    nullable(e.ActorID),
+			"actor_role":  nullable(e.ActorRole),
+			"action":      e.Action,
+			"entity_type": e.EntityType,
+			"entity_id":   nullable(e.EntityID),
+			"changes":     e.Changes,
+			"ip_address":  nullable(e.IPAddress),
+			"method":      nullable(e.Method),
+			"route":       nullable(e.Route),
+			"status_code": e.StatusCode,
+			"api_key_id":  nullable(e.APIKeyID),
+		}
+		if len(e.Changes) == 0 {
+			rows[i]["changes"] = nil
+		}
+		if e.StatusCode == 0 {
+			rows[i]["status_code"] = nil
+		}
+	}
+	_, _, err := l.db.Client.From("audit_logs").
+		Insert(rows, false, "", "minimal", "").
+		Execute()
+	return err
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Filter narrows Search. From and To bound created_at; zero values are
+// open.
+type Filter struct {
+	ActorID    string
+	EntityType string
+	EntityID   string
+	Action     string
+	Method     string
+	// Route matches a substring of the route
+	Route  string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// StoredEntry is an entry as written
+type StoredEntry struct {
+	ID string `json:"id"`
+	Entry
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// recordColumns are the columns the API writes; older rows of the table
+// may have others
+const recordColumns = "id, actor_id, actor_role, action, entity_type, entity_id, changes, ip_address, method, route, status_code, api_key_id, created_at"
+
+// Search returns entries, newest first
+func (l *Log) Search(filter Filter) ([]StoredEntry, error) {
+	query := l.db.Client.From("audit_logs").
+		Select(recordColumns, "", false)
+	if filter.ActorID != "" {
+		query = query.Eq("actor_id", filter.ActorID)
+	}
+	if filter.EntityType != "" {
+		query = query.Eq("entity_type", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		query = query.Eq("entity_id", filter.EntityID)
+	}
+	if filter.Action != "" {
+		query = query.Eq("action", filter.Action)
+	}
+	if filter.Method != "" {
+		query = query.Eq("method", strings.ToUpper(filter.Method))
+	}
+	if filter.Route != "" {
+		query = query.Ilike("route", "*"+filter.Route+"*")
+	}
+	if !filter.From.IsZero() {
+		query = query.Gte("created_at", filter.From.UTC().Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		query = query.Lt("created_at", filter.To.UTC().Format(time.RFC3339))
+	}
+
+	records := []StoredEntry{}
+	_, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Range(filter.Offset, filter.Offset+filter.Limit-1, "").
+		ExecuteTo(&records)
+	return records, err
+}
+
+// Diff returns the fields whose value differs between before and after.
+// Both map field names to values; fields missing from one side count as
+// nil there.
+func Diff(before, after map[string]interface{}) map[string]Change {
+	changes := make(map[string]Change)
+	for field, to := range after {
+		from := before[field]
+		if !reflect.DeepEqual(deref(from), deref(to)) {
+			changes[field] = Change{From: deref(from), To: deref(to)}
+		}
+	}
+	for field, from := range before {
+		if _, ok := after[field]; !ok && deref(from) != nil {
+			changes[field] = Change{From: deref(from), To: nil}
+		}
+	}
+	return changes
+}
+
+// deref turns typed nil pointers into nil and other pointers into their
+// value, so *string("a") and "a" compare equal
+func deref(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.IsValid() && rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface()
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/internal/policy"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// apiKeyPrefix starts every key, so leaked keys are easy to recognise
+const apiKeyPrefix = "appe_"
+
+var (
+	// ErrInvalidAPIKey covers unknown, expired and revoked keys
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrAPIKeyRevoked  = errors.New("API key is revoked")
+	ErrInvalidScope   = errors.New("scopes must be kind:action pairs, e.g. order:read")
+	ErrAPIKeyName     = errors.New("name is required")
+	ErrAPIKeyTarget   = errors.New("customer or warehouse not found")
+)
+
+// apiKeyColumns is everything but the hashes. models.APIKey has no hash
+// fields, so rows returned by writes decode without them too.
+const apiKeyColumns = "id, name, prefix, scopes, customer_id, warehouse_id, created_by, expires_at, previous_expires_at, last_used_at, last_used_ip, rotated_at, revoked_at, created_at"
+
+// APIKeys issues and checks the keys integrations authenticate with. Only a
+// SHA-256 hash of each key is stored; the key itself is returned once.
+type APIKeys struct {
+	db *database.Database
+}
+
+// NewAPIKeys needs the service-role handle
+func NewAPIKeys(db *database.Database) *APIKeys {
+	return &APIKeys{db: db}
+}
+
+// Create issues a key for createdBy and returns it with the secret key
+func (k *APIKeys) Create(req models.CreateAPIKeyRequest, createdBy string) (models.APIKeyWithSecret, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return models.APIKeyWithSecret{}, ErrAPIKeyName
+	}
+	if len(req.Scopes) == 0 {
+		return models.APIKeyWithSecret{}, ErrInvalidScope
+	}
+	for _, scope := range req.Scopes {
+		if !policy.ValidScope(scope) {
+			return models.APIKeyWithSecret{}, ErrInvalidScope
+		}
+	}
+
+	secret, hash, err := newAPIKey()
+	if err != nil {
+		return models.APIKeyWithSecret{}, err
+	}
+	row := map[string]interface{}{
+		"name":         req.Name,
+		"prefix":       secret[:len(apiKeyPrefix)+6],
+		"key_hash":     hash,
+		"scopes":       req.Scopes,
+		"customer_id":  emptyToNil(req.CustomerID),
+		"warehouse_id": emptyToNil(req.WarehouseID),
+		"created_by":   createdBy,
+		"expires_at":   req.ExpiresAt,
+	}
+
+	var created []models.APIKey
+	_, err = k.db.Client.From("api_keys").
+		Insert(row, false, "", "representation", "").
+		ExecuteTo(&created)
+	if err != nil {
+		if strings.Contains(err.Error(), "_fkey") || strings.Contains(err.Error(), "uuid") {
+			return models.APIKeyWithSecret{}, ErrAPIKeyTarget
+		}
+		return models.APIKeyWithSecret{}, err
+	}
+	if len(created) == 0 {
+		return models.APIKeyWithSecret{}, errors.New("failed to create API key")
+	}
+	return models.APIKeyWithSecret{APIKey: created[0], Key: secret}, nil
+}
+
+// List returns every key, newest first
+func (k *APIKeys) List() ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	_, err := k.db.Client.From("api_keys").
+		Select(apiKeyColumns, "", false).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&keys)
+	return keys, err
+}
+
+// Get returns one key
+func (k *APIKeys) Get(id string) (models.APIKey, error) {
+	var keys []models.APIKey
+	_, err := k.db.Client.From("api_keys").
+		Select(apiKeyColumns, "", false).
+		Eq("id", id).
+		Limit(1, "").
+		ExecuteTo(&keys)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if len(keys) == 0 {
+		return models.APIKey{}, ErrAPIKeyNotFound
+	}
+	return keys[0], nil
+}
+
+// Rotate replaces a key's secret and returns the new one. The old secret
+// keeps working for grace, so the integration can switch over; a zero
+// grace stops it at once.
+func (k *APIKeys) Rotate(id string, grace time.Duration) (models.APIKeyWithSecret, error) {
+	var current []struct {
+		KeyHash   string     `json:"key_hash"`
+		RevokedAt *time.Time `json:"revoked_at"`
+	}
+	_, err := k.db.Client.From("api_keys").
+		Select("key_hash, revoked_at", "", false).
+		Eq("id", id).
+		Limit(1, "").
+		ExecuteTo(&current)
+	if err != nil {
+		return models.APIKeyWithSecret{}, err
+	}
+	if len(current) == 0 {
+		return models.APIKeyWithSecret{}, ErrAPIKeyNotFound
+	}
+	if current[0].RevokedAt != nil {
+		return models.APIKeyWithSecret{}, ErrAPIKeyRevoked
+	}
+
+	secret, hash, err := newAPIKey()
+	if err != nil {
+		return models.APIKeyWithSecret{}, err
+	}
+	now := time.Now().UTC()
+	updates := map[string]interface{}{
+		"prefix":              secret[:len(apiKeyPrefix)+6],
+		"key_hash":            hash,
+		"previous_key_hash":   nil,
+		"previous_expires_at": nil,
+		"rotated_at":          now,
+	}
+	if grace > 0 {
+		updates["previous_key_hash"] = current[0].KeyHash
+		updates["previous_expires_at"] = now.Add(grace)
+	}
+
+	var updated []models.APIKey
+	_, err = k.db.Client.From("api_keys").
+		Update(updates, "representation", "").
+		Eq("id", id).
+		Eq("key_hash", current[0].KeyHash).
+		ExecuteTo(&updated)
+	if err != nil {
+		return models.APIKeyWithSecret{}, err
+	}
+	if len(updated) == 0 {
+		// Rotated or revoked meanwhile
+		return models.APIKeyWithSecret{}, ErrAPIKeyRevoked
+	}
+	return models.APIKeyWithSecret{APIKey: updated[0], Key: secret}, nil
+}
+
+// Revoke stops a key, and any previous secret still in its grace period,
+// from working
+func (k *APIKeys) Revoke(id string) (models.APIKey, error) {
+	var updated []models.APIKey
+	_, err := k.db.Client.From("api_keys").
+		Update(map[string]interface{}{
+			"revoked_at":          time.Now().UTC(),
+			"previous_key_hash":   nil,
+			"previous_expires_at": nil,
+		}, "representation", "").
+		Eq("id", id).
+		Is("revoked_at", "null").
+		ExecuteTo(&updated)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if len(updated) == 0 {
+		// Unknown, or already revoked
+		return k.Get(id)
+	}
+	return updated[0], nil
+}
+
+// Authenticate returns the live key for secret and records its use from ip
+func (k *APIKeys) Authenticate(ctx context.Context, secret, ip string) (models.APIKey, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+
+	var keys []models.APIKey
+	err := k.db.RPC(ctx, "authenticate_api_key", map[string]interface{}{
+		"p_key_hash": HashToken(secret),
+		"p_ip":       ip,
+	}, &keys)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if len(keys) == 0 {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+	return keys[0], nil
+}
+
+// newAPIKey returns a random key and its hash
+func newAPIKey() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return secret, HashToken(secret), nil
+}
+
+func emptyToNil(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	v := strings.TrimSpace(*s)
+	if v == "" {
+		return nil
+	}
+	return &v
+}
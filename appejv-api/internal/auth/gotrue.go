@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidCredentials means a wrong email or password
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrEmailNotConfirmed means the account has not confirmed its email
+	ErrEmailNotConfirmed = errors.New("email not confirmed")
+	// ErrInvalidRefreshToken covers unknown, revoked and reused refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+// GoTrueError is an unexpected error response from the auth server
+type GoTrueError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *GoTrueError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("auth server returned %d", e.Status)
+	}
+	return fmt.Sprintf("auth server returned %d: %s", e.Status, e.Message)
+}
+
+// User is the auth server's view of an account
+type User struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+// Session is a token pair issued by the auth server
+type Session struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	ExpiresAt    int64  `json:"expires_at"`
+	RefreshToken string `json:"refresh_token"`
+	User         User   `json:"user"`
+}
+
+// Logout scopes: the current session, every session of the user, or every
+// session but the current one
+const (
+	LogoutLocal  = "local"
+	LogoutGlobal = "global"
+	LogoutOthers = "others"
+)
+
+// GoTrue calls the Supabase Auth (GoTrue) REST API at url, e.g.
+// https://<project>.supabase.co/auth/v1
+type GoTrue struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewGoTrue talks to the auth server at url with the project's anon key
+func NewGoTrue(url, apiKey string) *GoTrue {
+	return &GoTrue{
+		url:    strings.TrimRight(url, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SignIn exchanges an email and password for a session
+func (g *GoTrue) SignIn(ctx context.Context, email, password string) (Session, error) {
+	var session Session
+	err := g.do(ctx, http.MethodPost, "/token?grant_type=password", "", map[string]string{
+		"email":    email,
+		"password": password,
+	}, &session)
+
+	var gerr *GoTrueError
+	if errors.As(err, &gerr) && gerr.Status == http.StatusBadRequest {
+		switch gerr.Code {
+		case "invalid_grant", "invalid_credentials":
+			return Session{}, ErrInvalidCredentials
+		case "email_not_confirmed":
+			return Session{}, ErrEmailNotConfirmed
+		}
+	}
+	return session, err
+}
+
+// Refresh exchanges a refresh token for a new session. Refresh tokens are
+// single use: the old one stops working.
+func (g *GoTrue) Refresh(ctx context.Context, refreshToken string) (Session, error) {
+	var session Session
+	err := g.do(ctx, http.MethodPost, "/token?grant_type=refresh_token", "", map[string]string{
+		"refresh_token": refreshToken,
+	}, &session)
+
+	var gerr *GoTrueError
+	if errors.As(err, &gerr) && (gerr.Status == http.StatusBadRequest || gerr.Status == http.StatusUnauthorized) {
+		return Session{}, ErrInvalidRefreshToken
+	}
+	return session, err
+}
+
+// VerifyTokenHash exchanges a token hash from Admin.SignInToken for a
+// session
+func (g *GoTrue) VerifyTokenHash(ctx context.Context, tokenHash string) (Session, error) {
+	var session Session
+	err := g.do(ctx, http.MethodPost, "/verify", "", map[string]string{
+		"type":       "magiclink",
+		"token_hash": tokenHash,
+	}, &session)
+	return session, err
+}
+
+// SignOut revokes the refresh tokens of the access token's session, or of
+// more sessions depending on scope
+func (g *GoTrue) SignOut(ctx context.Context, accessToken, scope string) error {
+	if scope == "" {
+		scope = LogoutLocal
+	}
+	err := g.do(ctx, http.MethodPost, "/logout?scope="+scope, accessToken, nil, nil)
+
+	// The session is already gone
+	var gerr *GoTrueError
+	if errors.As(err, &gerr) && (gerr.Status == http.StatusUnauthorized || gerr.Status == http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (g *GoTrue) do(ctx context.Context, method, path, bearer string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, g.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("apikey", g.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		return goTrueError(resp.StatusCode, data)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// goTrueError decodes both the legacy OAuth-style error body and the newer
+// error_code/msg body
+func goTrueError(status int, data []byte) *GoTrueError {
+	var body struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+		ErrorCode        string `json:"error_code"`
+		Msg              string `json:"msg"`
+		Message          string `json:"message"`
+	}
+	_ = json.Unmarshal(data, &body)
+
+	gerr := &GoTrueError{Status: status, Code: body.ErrorCode, Message: body.Msg}
+	if gerr.Code == "" {
+		gerr.Code = body.Error
+	}
+	for _, m := range []string{body.ErrorDescription, body.Message} {
+		if gerr.Message == "" {
+			gerr.Message = m
+		}
+	}
+	return gerr
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeGoTrue serves the token and logout endpoints of GoTrue for one
+// confirmed account, a@example.com / secret, and one unconfirmed one,
+// b@example.com / secret. Refresh tokens are single use.
+type fakeGoTrue struct {
+	*httptest.Server
+	refreshTokens map[string]bool
+	// logouts are the scopes logged out, by access token
+	logouts map[string]string
+	// fail makes every request answer with this status
+	fail int
+}
+
+func newFakeGoTrue(t *testing.T) *fakeGoTrue {
+	f := &fakeGoTrue{
+		refreshTokens: map[string]bool{"refresh-1": true},
+		logouts:       make(map[string]string),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeGoTrue) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("apikey") != "anon" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Invalid API key"})
+		return
+	}
+	if f.fail != 0 {
+		writeJSON(w, f.fail, map[string]string{"msg": "unavailable"})
+		return
+	}
+
+	var body map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	switch {
+	case r.URL.Path == "/auth/v1/token" && r.URL.Query().Get("grant_type") == "password":
+		switch {
+		case body["password"] != "secret" || (body["email"] != "a@example.com" && body["email"] != "b@example.com"):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error_code": "invalid_credentials", "msg": "Invalid login credentials"})
+		case body["email"] == "b@example.com":
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error_code": "email_not_confirmed", "msg": "Email not confirmed"})
+		default:
+			f.refreshTokens["refresh-2"] = true
+			writeJSON(w, http.StatusOK, testSession("access-1", "refresh-2"))
+		}
+
+	case r.URL.Path == "/auth/v1/token" && r.URL.Query().Get("grant_type") == "refresh_token":
+		if !f.refreshTokens[body["refresh_token"]] {
+			// Older GoTrue versions answer in the OAuth style
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "Invalid Refresh Token"})
+			return
+		}
+		delete(f.refreshTokens, body["refresh_token"])
+		f.refreshTokens["refresh-3"] = true
+		writeJSON(w, http.StatusOK, testSession("access-2", "refresh-3"))
+
+	case r.URL.Path == "/auth/v1/logout":
+		token := r.Header.Get("Authorization")
+		if token != "Bearer access-1" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"msg": "invalid JWT"})
+			return
+		}
+		f.logouts[token] = r.URL.Query().Get("scope")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"msg": "not found"})
+	}
+}
+
+func testSession(access, refresh string) Session {
+	return Session{
+		AccessToken:  access,
+		TokenType:    "bearer",
+		ExpiresIn:    3600,
+		RefreshToken: refresh,
+		User:         User{ID: "user-1", Email: "a@example.com"},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestGoTrueSignIn(t *testing.T) {
+	f := newFakeGoTrue(t)
+	g := NewGoTrue(f.URL+"/auth/v1/", "anon")
+	ctx := context.Background()
+
+	session, err := g.SignIn(ctx, "a@example.com", "secret")
+	if err != nil {
+		t.Fatalf("SignIn: %v", err)
+	}
+	if session.AccessToken != "access-1" || session.RefreshToken != "refresh-2" || session.User.ID != "user-1" {
+		t.Errorf("SignIn = %+v", session)
+	}
+
+	if _, err := g.SignIn(ctx, "a@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("SignIn with a wrong password = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := g.SignIn(ctx, "b@example.com", "secret"); !errors.Is(err, ErrEmailNotConfirmed) {
+		t.Errorf("SignIn unconfirmed = %v, want ErrEmailNotConfirmed", err)
+	}
+}
+
+func TestGoTrueRefresh(t *testing.T) {
+	f := newFakeGoTrue(t)
+	g := NewGoTrue(f.URL+"/auth/v1", "anon")
+	ctx := context.Background()
+
+	session, err := g.Refresh(ctx, "refresh-1")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if session.AccessToken != "access-2" || session.RefreshToken != "refresh-3" {
+		t.Errorf("Refresh = %+v", session)
+	}
+
+	// The old refresh token is spent
+	if _, err := g.Refresh(ctx, "refresh-1"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Refresh with a used token = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestGoTrueSignOut(t *testing.T) {
+	f := newFakeGoTrue(t)
+	g := NewGoTrue(f.URL+"/auth/v1", "anon")
+	ctx := context.Background()
+
+	if err := g.SignOut(ctx, "access-1", ""); err != nil {
+		t.Fatalf("SignOut: %v", err)
+	}
+	if scope := f.logouts["Bearer access-1"]; scope != LogoutLocal {
+		t.Errorf("logged out with scope %q, want %q", scope, LogoutLocal)
+	}
+	if err := g.SignOut(ctx, "access-1", LogoutGlobal); err != nil {
+		t.Fatalf("SignOut global: %v", err)
+	}
+	if scope := f.logouts["Bearer access-1"]; scope != LogoutGlobal {
+		t.Errorf("logged out with scope %q, want %q", scope, LogoutGlobal)
+	}
+
+	// A session that is already gone counts as logged out
+	if err := g.SignOut(ctx, "expired", ""); err != nil {
+		t.Errorf("SignOut of an ended session = %v, want nil", err)
+	}
+}
+
+func TestGoTrueError(t *testing.T) {
+	f := newFakeGoTrue(t)
+	f.fail = http.StatusServiceUnavailable
+	g := NewGoTrue(f.URL+"/auth/v1", "anon")
+
+	_, err := g.SignIn(context.Background(), "a@example.com", "secret")
+	var gerr *GoTrueError
+	if !errors.As(err, &gerr) {
+		t.Fatalf("SignIn against a failing server = %v, want *GoTrueError", err)
+	}
+	if gerr.Status != http.StatusServiceUnavailable || gerr.Message != "unavailable" {
+		t.Errorf("GoTrueError = %+v", gerr)
+	}
+}
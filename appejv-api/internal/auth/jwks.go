@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minRefresh limits how often an unknown kid can trigger a JWKS fetch, so
+// tokens with made-up kids cannot hammer the auth server
+const minRefresh = time.Minute
+
+// JWKS caches the public keys served at a JWKS endpoint. Keys are fetched
+// lazily, refreshed after the TTL and refetched early when a token names a
+// kid the cache does not know, which picks up key rotation. Fetches run
+// outside the lock: callers holding a cached key keep verifying during a
+// refresh, and only callers without one wait for it.
+type JWKS struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]jwk
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// fetching is closed when the fetch in progress, if any, is done
+	fetching chan struct{}
+}
+
+type jwk struct {
+	alg string
+	key crypto.PublicKey
+}
+
+func NewJWKS(url string, ttl time.Duration) *JWKS {
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	return &JWKS{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Key returns the public key with kid for alg
+func (j *JWKS) Key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	k, ok := j.keys[kid]
+	stale := time.Since(j.fetchedAt) > j.ttl
+	done := j.fetching
+	if done == nil && (stale || !ok) && time.Since(j.attemptedAt) > minRefresh {
+		j.attemptedAt = time.Now()
+		done = make(chan struct{})
+		j.fetching = done
+		// The fetch serves every waiting caller, so it must not end with
+		// the request that started it
+		go j.refresh(context.WithoutCancel(ctx), done)
+	}
+	j.mu.Unlock()
+
+	if !ok && done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		j.mu.Lock()
+		k, ok = j.keys[kid]
+		j.mu.Unlock()
+	}
+
+	if !ok {
+		return nil, ErrNoKey
+	}
+	if k.alg != "" && k.alg != alg {
+		return nil, fmt.Errorf("key %s is for %s, token uses %s", kid, k.alg, alg)
+	}
+	return k.key, nil
+}
+
+// refresh replaces the cached keys and closes done. On failure the cached
+// keys keep being served while the endpoint is down.
+func (j *JWKS) refresh(ctx context.Context, done chan struct{}) {
+	keys, err := j.fetch(ctx)
+
+	j.mu.Lock()
+	if err != nil {
+		log.Printf("jwks refresh failed: %v", err)
+	} else {
+		j.keys = keys
+		j.fetchedAt = time.Now()
+	}
+	j.fetching = nil
+	j.mu.Unlock()
+	close(done)
+}
+
+func (j *JWKS) fetch(ctx context.Context) (map[string]jwk, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]jwk, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		switch k.Kty {
+		case "RSA":
+			key, err = rsaKey(k.N, k.E)
+		case "EC":
+			key, err = ecKey(k.Crv, k.X, k.Y)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("jwks: skipping key %s: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = jwk{alg: k.Alg, key: key}
+	}
+	return keys, nil
+}
+
+func rsaKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(eb)
+	if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent too large")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(exp.Int64())}, nil
+}
+
+func ecKey(crv, x, y string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch crv {
+	case "P-256":
+		curve = elliptic.P256()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	yb, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}
+	if !curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("point is not on the curve")
+	}
+	return key, nil
+}
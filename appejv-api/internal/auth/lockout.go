@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// pruneAt is the number of tracked accounts above which stale entries are
+// dropped
+const pruneAt = 1024
+
+// Lockout locks an account after repeated failed sign-ins. Failures are
+// counted per account within a window; reaching the limit locks the account
+// for a fixed duration whatever password is tried. State is kept in memory,
+// so each API instance counts on its own.
+type Lockout struct {
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+	// now is the clock, replaced in tests
+	now func() time.Time
+
+	mu       sync.Mutex
+	accounts map[string]*failures
+}
+
+type failures struct {
+	count       int
+	firstAt     time.Time
+	lockedUntil time.Time
+}
+
+// NewLockout locks an account for duration after maxFailures failures
+// within window. A maxFailures of zero disables the lockout.
+func NewLockout(maxFailures int, window, duration time.Duration) *Lockout {
+	return &Lockout{
+		maxFailures: maxFailures,
+		window:      window,
+		duration:    duration,
+		now:         time.Now,
+		accounts:    make(map[string]*failures),
+	}
+}
+
+// Locked reports whether account is locked and for how much longer
+func (l *Lockout) Locked(account string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.accounts[lockoutKey(account)]
+	if !ok {
+		return 0, false
+	}
+	if left := f.lockedUntil.Sub(l.now()); left > 0 {
+		return left, true
+	}
+	return 0, false
+}
+
+// Fail records a failed sign-in and reports whether it locked the account
+func (l *Lockout) Fail(account string) bool {
+	if l.maxFailures <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.accounts) >= pruneAt {
+		l.prune(now)
+	}
+
+	key := lockoutKey(account)
+	f, ok := l.accounts[key]
+	if !ok {
+		f = &failures{firstAt: now}
+		l.accounts[key] = f
+	} else if now.Sub(f.firstAt) > l.window {
+		f.count, f.firstAt = 0, now
+	}
+
+	f.count++
+	if f.count >= l.maxFailures {
+		f.lockedUntil = now.Add(l.duration)
+		f.count = 0
+		f.firstAt = now
+		return true
+	}
+	return false
+}
+
+// Reset forgets the account's failures after a successful sign-in
+func (l *Lockout) Reset(account string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.accounts, lockoutKey(account))
+}
+
+// prune drops accounts whose window and lock have both passed. Callers hold
+// the lock.
+func (l *Lockout) prune(now time.Time) {
+	for key, f := range l.accounts {
+		if now.Sub(f.firstAt) > l.window && now.After(f.lockedUntil) {
+			delete(l.accounts, key)
+		}
+	}
+}
+
+func lockoutKey(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
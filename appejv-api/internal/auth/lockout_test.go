@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a settable time source for Lockout
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLockout(maxFailures int, window, duration time.Duration) (*Lockout, *clock) {
+	clk := &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := NewLockout(maxFailures, window, duration)
+	l.now = clk.now
+	return l, clk
+}
+
+func TestLockoutThreshold(t *testing.T) {
+	l, _ := newTestLockout(3, 15*time.Minute, 10*time.Minute)
+
+	for i := 1; i < 3; i++ {
+		if l.Fail("a@example.com") {
+			t.Fatalf("failure %d locked the account", i)
+		}
+		if _, locked := l.Locked("a@example.com"); locked {
+			t.Fatalf("locked after %d failures", i)
+		}
+	}
+	if !l.Fail("a@example.com") {
+		t.Fatal("third failure did not lock the account")
+	}
+	left, locked := l.Locked("a@example.com")
+	if !locked || left != 10*time.Minute {
+		t.Errorf("Locked = %v, %v; want 10m, true", left, locked)
+	}
+
+	// Accounts are matched case-insensitively and other accounts are
+	// unaffected
+	if _, locked := l.Locked("  A@Example.com "); !locked {
+		t.Error("lock does not apply to the same email in another case")
+	}
+	if _, locked := l.Locked("b@example.com"); locked {
+		t.Error("another account is locked")
+	}
+}
+
+func TestLockoutExpiry(t *testing.T) {
+	l, clk := newTestLockout(2, 15*time.Minute, 10*time.Minute)
+	l.Fail("a@example.com")
+	l.Fail("a@example.com")
+
+	clk.advance(9 * time.Minute)
+	left, locked := l.Locked("a@example.com")
+	if !locked || left != time.Minute {
+		t.Errorf("Locked after 9m = %v, %v; want 1m, true", left, locked)
+	}
+
+	clk.advance(time.Minute)
+	if _, locked := l.Locked("a@example.com"); locked {
+		t.Error("still locked after the lock expired")
+	}
+
+	// The count starts again after a lock
+	if l.Fail("a@example.com") {
+		t.Error("first failure after a lock locked the account again")
+	}
+}
+
+func TestLockoutWindow(t *testing.T) {
+	l, clk := newTestLockout(2, 15*time.Minute, 10*time.Minute)
+	l.Fail("a@example.com")
+
+	// A failure outside the window starts a new count
+	clk.advance(16 * time.Minute)
+	if l.Fail("a@example.com") {
+		t.Error("failures in different windows locked the account")
+	}
+	clk.advance(time.Minute)
+	if !l.Fail("a@example.com") {
+		t.Error("two failures within the window did not lock the account")
+	}
+}
+
+func TestLockoutReset(t *testing.T) {
+	l, _ := newTestLockout(2, 15*time.Minute, 10*time.Minute)
+	l.Fail("a@example.com")
+	l.Reset("a@example.com")
+	if l.Fail("a@example.com") {
+		t.Error("failures before a successful sign-in still counted")
+	}
+}
+
+func TestLockoutDisabled(t *testing.T) {
+	l, _ := newTestLockout(0, 15*time.Minute, 10*time.Minute)
+	for i := 0; i < 10; i++ {
+		if l.Fail("a@example.com") {
+			t.Fatal("a disabled lockout locked the account")
+		}
+	}
+	if _, locked := l.Locked("a@example.com"); locked {
+		t.Error("a disabled lockout locked the account")
+	}
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/sms"
+	"github.com/appejv/appejv-api/pkg/database"
+)
+
+// otpDigits is the length of a sign-in code
+const otpDigits = 6
+
+var (
+	// ErrInvalidPhone means the phone number cannot be a real one
+	ErrInvalidPhone = errors.New("invalid phone number")
+	// ErrInvalidOTP covers wrong, expired, used up and voided codes
+	ErrInvalidOTP = errors.New("invalid or expired code")
+	// ErrNoEmailLogin means the account has no email to open a session with
+	ErrNoEmailLogin = errors.New("account cannot sign in by phone")
+)
+
+// OTPThrottledError means a code was refused by a rate limit
+type OTPThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OTPThrottledError) Error() string {
+	return fmt.Sprintf("too many code requests, retry in %s", e.RetryAfter)
+}
+
+// OTPLimits bound how codes are requested and guessed. PerPhone and PerIP
+// count requests within an hour.
+type OTPLimits struct {
+	MaxAttempts int
+	PerPhone    int
+	PerIP       int
+	Cooldown    time.Duration
+}
+
+// PhoneOTP signs users in with a code texted to the phone on their profile
+// or customer record. Codes are stored as an HMAC keyed with secret, so a
+// leaked table does not give them away; limits are counted in the
+// database and hold across API instances.
+type PhoneOTP struct {
+	db     *database.Database
+	admin  *Admin
+	gotrue *GoTrue
+	sender sms.Sender
+	secret []byte
+	ttl    time.Duration
+	limits OTPLimits
+}
+
+// NewPhoneOTP sends codes valid for ttl through sender
+func NewPhoneOTP(db *database.Database, admin *Admin, gotrue *GoTrue, sender sms.Sender, secret string, ttl time.Duration, limits OTPLimits) *PhoneOTP {
+	return &PhoneOTP{
+		db:     db,
+		admin:  admin,
+		gotrue: gotrue,
+		sender: sender,
+		secret: []byte(secret),
+		ttl:    ttl,
+		limits: limits,
+	}
+}
+
+type otpRequest struct {
+	UserID     *string `json:"user_id"`
+	RetryAfter int     `json:"retry_after"`
+}
+
+// Request texts a new code to phone if an active account has it. It
+// returns nil whether or not one does, and an *OTPThrottledError when a
+// limit is hit.
+func (o *PhoneOTP) Request(ctx context.Context, phone, ip string) error {
+	phone = NormalizePhone(phone)
+	if phone == "" {
+		return ErrInvalidPhone
+	}
+
+	code, err := newCode()
+	if err != nil {
+		return err
+	}
+
+	var rows []otpRequest
+	err = o.db.RPC(ctx, "create_phone_otp", map[string]interface{}{
+		"p_phone":            phone,
+		"p_code_hash":        o.hash(phone, code),
+		"p_ttl_seconds":      int(o.ttl.Seconds()),
+		"p_ip":               ip,
+		"p_phone_limit":      o.limits.PerPhone,
+		"p_ip_limit":         o.limits.PerIP,
+		"p_cooldown_seconds": int(o.limits.Cooldown.Seconds()),
+	}, &rows)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	if rows[0].RetryAfter > 0 {
+		return &OTPThrottledError{RetryAfter: time.Duration(rows[0].RetryAfter) * time.Second}
+	}
+	if rows[0].UserID == nil {
+		return nil
+	}
+
+	// Without diacritics, so the message fits a single GSM-7 SMS
+	text := fmt.Sprintf("Ma dang nhap APPE JV cua ban la %s, hieu luc %d phut. Khong chia se ma nay cho bat ky ai.", code, int(o.ttl.Minutes()))
+	if err := o.sender.Send(ctx, "+"+phone, text); err != nil {
+		// Answer as usual; the user can ask again after the cooldown
+		log.Printf("sign-in code to %s not sent: %v", phone, err)
+	}
+	return nil
+}
+
+// Verify uses up the phone's code and opens a session for its account
+func (o *PhoneOTP) Verify(ctx context.Context, phone, code string) (Session, error) {
+	phone = NormalizePhone(phone)
+	code = strings.TrimSpace(code)
+	if phone == "" || len(code) != otpDigits {
+		return Session{}, ErrInvalidOTP
+	}
+
+	var userID *string
+	err := o.db.RPC(ctx, "consume_phone_otp", map[string]interface{}{
+		"p_phone":        phone,
+		"p_code_hash":    o.hash(phone, code),
+		"p_max_attempts": o.limits.MaxAttempts,
+	}, &userID)
+	if err != nil {
+		return Session{}, err
+	}
+	if userID == nil {
+		return Session{}, ErrInvalidOTP
+	}
+
+	// Sessions are opened through the account's email
+	user, err := o.admin.GetUser(ctx, *userID)
+	if err != nil {
+		return Session{}, err
+	}
+	if user.Email == "" {
+		return Session{}, ErrNoEmailLogin
+	}
+	tokenHash, err := o.admin.SignInToken(ctx, user.Email)
+	if err != nil {
+		return Session{}, err
+	}
+	return o.gotrue.VerifyTokenHash(ctx, tokenHash)
+}
+
+func (o *PhoneOTP) hash(phone, code string) string {
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write([]byte(phone + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NormalizePhone returns a Vietnamese phone number as digits with the 84
+// country code, e.g. "0912 345 678" as "84912345678", or "" if it is too
+// short or too long to be a phone number. Keep it in step with the
+// normalize_phone SQL function.
+func NormalizePhone(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+	if strings.HasPrefix(digits, "0") {
+		digits = "84" + digits[1:]
+	}
+	if len(digits) < 9 || len(digits) > 15 {
+		return ""
+	}
+	return digits
+}
+
+// newCode returns a random numeric code of otpDigits digits
+func newCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < otpDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n), nil
+}
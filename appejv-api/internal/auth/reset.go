@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"github.com/appejv/appejv-api/internal/mailer"
+	"github.com/appejv/appejv-api/pkg/database"
+)
+
+// MinPasswordLength is the shortest password a reset accepts
+const MinPasswordLength = 8
+
+// ErrInvalidResetToken covers unknown, expired and already used reset tokens
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// PasswordResets issues emailed reset links and consumes them. Tokens are
+// random, stored only as a SHA-256 hash, expire after the TTL and work once.
+// A reset signs the account out everywhere.
+type PasswordResets struct {
+	db       *database.Database
+	admin    *Admin
+	sessions *Sessions
+	outbox   *mailer.Outbox
+	ttl      time.Duration
+	linkURL  string
+}
+
+// NewPasswordResets emails links to linkURL?token=..., valid for ttl
+func NewPasswordResets(db *database.Database, admin *Admin, sessions *Sessions, outbox *mailer.Outbox, ttl time.Duration, linkURL string) *PasswordResets {
+	return &PasswordResets{
+		db:       db,
+		admin:    admin,
+		sessions: sessions,
+		outbox:   outbox,
+		ttl:      ttl,
+		linkURL:  linkURL,
+	}
+}
+
+type resetRecipient struct {
+	UserID   string  `json:"user_id"`
+	Email    string  `json:"email"`
+	FullName *string `json:"full_name"`
+}
+
+// Request issues a token for the account with email and queues the link in
+// the given locale. It returns nil whether or not the account exists.
+func (r *PasswordResets) Request(ctx context.Context, email, ip, locale string) error {
+	token, hash, err := NewToken()
+	if err != nil {
+		return err
+	}
+
+	var recipients []resetRecipient
+	err = r.db.RPC(ctx, "create_password_reset", map[string]interface{}{
+		"p_email":       email,
+		"p_token_hash":  hash,
+		"p_ttl_seconds": int(r.ttl.Seconds()),
+		"p_ip":          ip,
+	}, &recipients)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	to := recipients[0]
+	name := to.Email
+	if to.FullName != nil && *to.FullName != "" {
+		name = *to.FullName
+	}
+	err = r.outbox.Enqueue(ctx, mailer.Email{
+		To:       to.Email,
+		Template: mailer.PasswordReset,
+		Locale:   locale,
+		Data: map[string]interface{}{
+			"name":    name,
+			"link":    r.linkURL + "?token=" + url.QueryEscape(token),
+			"minutes": int(r.ttl.Minutes()),
+		},
+		Sensitive: true,
+	})
+	if err != nil {
+		// Answer as usual; the user can ask again
+		log.Printf("password reset email to %s not queued: %v", to.Email, err)
+	}
+	return nil
+}
+
+// Reset sets a new password for the token's account, ends its sessions and
+// uses up the token. The token is only used up once the rest succeeded, so
+// a failed reset can be retried with the same link.
+func (r *PasswordResets) Reset(ctx context.Context, token, password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return fmt.Errorf("%w: at least %d characters", ErrWeakPassword, MinPasswordLength)
+	}
+
+	hash := HashToken(token)
+	var userID *string
+	err := r.db.RPC(ctx, "check_password_reset", map[string]interface{}{
+		"p_token_hash": hash,
+	}, &userID)
+	if err != nil {
+		return err
+	}
+	if userID == nil {
+		return ErrInvalidResetToken
+	}
+
+	_, err = r.admin.UpdateUser(ctx, *userID, map[string]interface{}{
+		"password": password,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Whoever knew the old password is signed out
+	if _, err := r.sessions.RevokeAll(ctx, *userID, *userID); err != nil {
+		return err
+	}
+
+	var consumed *string
+	return r.db.RPC(ctx, "consume_password_reset", map[string]interface{}{
+		"p_token_hash": hash,
+	}, &consumed)
+}
+
+// NewToken returns a random single-use token, for emailed links, and the
+// hash to store in its place
+func NewToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the stored form of a token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/config"
+	"github.com/appejv/appejv-api/pkg/database"
+)
+
+// fakeResets serves the reset, session and admin endpoints for one valid
+// token of user-1 and records the calls made, in order
+type fakeResets struct {
+	*httptest.Server
+	token string
+	used  bool
+	calls []string
+	// failUpdate makes the password update fail
+	failUpdate bool
+}
+
+func newFakeResets(t *testing.T, token string) *fakeResets {
+	f := &fakeResets{token: HashToken(token)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/rest/v1/rpc/"), "/auth/v1")
+		f.calls = append(f.calls, name)
+
+		switch name {
+		case "check_password_reset", "consume_password_reset":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["p_token_hash"] != f.token || f.used {
+				writeJSON(w, http.StatusOK, nil)
+				return
+			}
+			f.used = name == "consume_password_reset"
+			writeJSON(w, http.StatusOK, "user-1")
+		case "/admin/users/user-1":
+			if f.failUpdate {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"msg": "unavailable"})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"id": "user-1"})
+		case "revoke_user_sessions":
+			writeJSON(w, http.StatusOK, []string{"session-1", "session-2"})
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]string{"msg": "not found"})
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newTestResets(f *fakeResets) (*PasswordResets, *Sessions) {
+	db, _ := database.NewSupabaseClient(&config.Config{SupabaseURL: f.URL, SupabaseAnonKey: "service"})
+	sessions := NewSessions(db, time.Hour, time.Minute)
+	return NewPasswordResets(db, NewAdmin(f.URL+"/auth/v1", "service"), sessions, nil, 30*time.Minute, ""), sessions
+}
+
+func TestReset(t *testing.T) {
+	f := newFakeResets(t, "token-1")
+	resets, sessions := newTestResets(f)
+
+	if err := resets.Reset(context.Background(), "token-1", "new-password"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	want := []string{"check_password_reset", "/admin/users/user-1", "revoke_user_sessions", "consume_password_reset"}
+	if strings.Join(f.calls, ",") != strings.Join(want, ",") {
+		t.Errorf("calls = %v, want %v", f.calls, want)
+	}
+	if !sessions.Revoked("session-1") || !sessions.Revoked("session-2") {
+		t.Error("the user's sessions were not revoked")
+	}
+
+	// The token works once
+	if err := resets.Reset(context.Background(), "token-1", "new-password"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("second Reset = %v, want ErrInvalidResetToken", err)
+	}
+}
+
+// TestResetFailedUpdate checks that a failed password update leaves the
+// link usable
+func TestResetFailedUpdate(t *testing.T) {
+	f := newFakeResets(t, "token-1")
+	f.failUpdate = true
+	resets, _ := newTestResets(f)
+
+	if err := resets.Reset(context.Background(), "token-1", "new-password"); err == nil {
+		t.Fatal("Reset succeeded though the update failed")
+	}
+	if f.used {
+		t.Error("the token was used up by a failed reset")
+	}
+
+	f.failUpdate = false
+	if err := resets.Reset(context.Background(), "token-1", "new-password"); err != nil {
+		t.Errorf("retried Reset: %v", err)
+	}
+}
+
+func TestResetRejects(t *testing.T) {
+	f := newFakeResets(t, "token-1")
+	resets, _ := newTestResets(f)
+
+	if err := resets.Reset(context.Background(), "token-1", "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("Reset with a short password = %v, want ErrWeakPassword", err)
+	}
+	if err := resets.Reset(context.Background(), "other", "new-password"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("Reset with an unknown token = %v, want ErrInvalidResetToken", err)
+	}
+	if len(f.calls) != 1 {
+		t.Errorf("calls = %v, want only the token check", f.calls)
+	}
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/pkg/database"
+)
+
+// syncOverlap re-reads revocations this far back on every sync, so one
+// committed after a later one was read is not missed
+const syncOverlap = time.Minute
+
+// ErrSessionNotFound means the user has no such session
+var ErrSessionNotFound = errors.New("session not found")
+
+// Sessions lists and ends users' sessions. Ending a session voids its
+// refresh tokens at once, but its access tokens would stay valid until they
+// expire, so revoked sessions are also kept in memory for AuthRequired to
+// refuse. Revocations made on other API instances are picked up on the
+// next sync, every interval.
+type Sessions struct {
+	db       *database.Database
+	tokenTTL time.Duration
+	interval time.Duration
+
+	mu sync.RWMutex
+	// revoked maps a session to when its last access token expires
+	revoked map[string]time.Time
+	// synced is the revocation time of the newest entry read
+	synced time.Time
+}
+
+// NewSessions needs the service-role handle. tokenTTL is the longest an
+// access token lives, set by the auth server's JWT expiry.
+func NewSessions(db *database.Database, tokenTTL, interval time.Duration) *Sessions {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &Sessions{
+		db:       db,
+		tokenTTL: tokenTTL,
+		interval: interval,
+		revoked:  make(map[string]time.Time),
+	}
+}
+
+// List returns the user's live sessions, most recently used first
+func (s *Sessions) List(ctx context.Context, userID string) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := s.db.RPC(ctx, "list_user_sessions", map[string]interface{}{
+		"p_user_id": userID,
+	}, &sessions)
+	return sessions, err
+}
+
+// Revoke ends one of the user's sessions
+func (s *Sessions) Revoke(ctx context.Context, userID, sessionID, revokedBy string) error {
+	ended, err := s.revoke(ctx, userID, sessionID, revokedBy)
+	if err != nil {
+		if strings.Contains(err.Error(), "uuid") {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	if len(ended) == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAll ends every session of the user and returns how many there were
+func (s *Sessions) RevokeAll(ctx context.Context, userID, revokedBy string) (int, error) {
+	ended, err := s.revoke(ctx, userID, "", revokedBy)
+	return len(ended), err
+}
+
+func (s *Sessions) revoke(ctx context.Context, userID, sessionID, revokedBy string) ([]string, error) {
+	var ended []string
+	err := s.db.RPC(ctx, "revoke_user_sessions", map[string]interface{}{
+		"p_user_id":           userID,
+		"p_session_id":        nilIfEmpty(sessionID),
+		"p_revoked_by":        nilIfEmpty(revokedBy),
+		"p_token_ttl_seconds": int(s.tokenTTL.Seconds()),
+	}, &ended)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(s.tokenTTL)
+	s.mu.Lock()
+	for _, id := range ended {
+		s.revoked[id] = expires
+	}
+	s.mu.Unlock()
+	return ended, nil
+}
+
+// Revoked reports whether tokens of the session must be refused
+func (s *Sessions) Revoked(sessionID string) bool {
+	if sessionID == "" {
+		return false
+	}
+	s.mu.RLock()
+	expires, ok := s.revoked[sessionID]
+	s.mu.RUnlock()
+	return ok && time.Now().Before(expires)
+}
+
+// Run syncs revocations every interval until ctx is done
+func (s *Sessions) Run(ctx context.Context) {
+	if err := s.sync(); err != nil {
+		log.Printf("sessions: loading revocations: %v", err)
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.sync(); err != nil {
+				log.Printf("sessions: syncing revocations: %v", err)
+			}
+		}
+	}
+}
+
+// sync reads revocations made since the last sync and forgets the ones
+// whose tokens have all expired
+func (s *Sessions) sync() error {
+	s.mu.RLock()
+	since := s.synced
+	s.mu.RUnlock()
+
+	query := s.db.Client.From("revoked_sessions").
+		Select("session_id, revoked_at, expires_at", "", false).
+		Gt("expires_at", time.Now().UTC().Format(time.RFC3339))
+	if !since.IsZero() {
+		query = query.Gte("revoked_at", since.Add(-syncOverlap).UTC().Format(time.RFC3339))
+	}
+	var rows []struct {
+		SessionID string    `json:"session_id"`
+		RevokedAt time.Time `json:"revoked_at"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if _, err := query.ExecuteTo(&rows); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, expires := range s.revoked {
+		if !now.Before(expires) {
+			delete(s.revoked, id)
+		}
+	}
+	if s.synced.IsZero() {
+		// Later syncs only read what is new; the overlap absorbs clock skew
+		// between the API and the database
+		s.synced = now
+	}
+	for _, row := range rows {
+		s.revoked[row.SessionID] = row.ExpiresAt
+		if row.RevokedAt.After(s.synced) {
+			s.synced = row.RevokedAt
+		}
+	}
+	return nil
+}
+
+func nilIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,192 @@
+// Package auth verifies Supabase access tokens.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrInvalidToken covers malformed, expired and wrongly signed tokens
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrNoKey means the token cannot be checked locally: no secret is
+	// configured for HS256 or the JWKS has no key with its kid
+	ErrNoKey = errors.New("no key to verify token")
+)
+
+// leeway tolerates clock skew between the auth server and the API
+const leeway = 30 * time.Second
+
+// Identity is the verified caller
+type Identity struct {
+	UserID    string
+	Email     string
+	Phone     string
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// Claims are the Supabase access token claims the API reads
+type Claims struct {
+	jwt.RegisteredClaims
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	Role      string `json:"role"`
+	SessionID string `json:"session_id"`
+}
+
+// Config configures a Verifier. Secret verifies HS256 tokens; JWKSURL
+// serves the public keys of asymmetrically signed tokens. Audience and
+// Issuer are checked when set. Remote, when set, is asked about tokens that
+// cannot be verified locally.
+type Config struct {
+	Secret   string
+	JWKSURL  string
+	JWKSTTL  time.Duration
+	Audience string
+	Issuer   string
+	Remote   *Remote
+}
+
+// Verifier checks access tokens locally
+type Verifier struct {
+	secret   []byte
+	jwks     *JWKS
+	audience string
+	issuer   string
+	remote   *Remote
+}
+
+// NewVerifier builds a Verifier from cfg
+func NewVerifier(cfg Config) *Verifier {
+	v := &Verifier{
+		audience: cfg.Audience,
+		issuer:   cfg.Issuer,
+		remote:   cfg.Remote,
+	}
+	if cfg.Secret != "" {
+		v.secret = []byte(cfg.Secret)
+	}
+	if cfg.JWKSURL != "" {
+		v.jwks = NewJWKS(cfg.JWKSURL, cfg.JWKSTTL)
+	}
+	return v
+}
+
+// Verify checks the token's signature, expiry, audience and issuer and
+// returns the caller. Tokens without a local key go to the remote fallback
+// when one is configured.
+func (v *Verifier) Verify(ctx context.Context, token string) (Identity, error) {
+	identity, err := v.verifyLocal(ctx, token)
+	if errors.Is(err, ErrNoKey) && v.remote != nil {
+		return v.remote.Verify(ctx, token)
+	}
+	return identity, err
+}
+
+func (v *Verifier) verifyLocal(ctx context.Context, token string) (Identity, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return v.key(ctx, t)
+	}, opts...)
+	if err != nil {
+		if errors.Is(err, ErrNoKey) {
+			return Identity{}, ErrNoKey
+		}
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	// Only user sessions may call the API, not anon or service keys
+	if claims.Role != "" && claims.Role != "authenticated" {
+		return Identity{}, fmt.Errorf("%w: role %q is not a user session", ErrInvalidToken, claims.Role)
+	}
+
+	identity := Identity{
+		UserID:    claims.Subject,
+		Email:     claims.Email,
+		Phone:     claims.Phone,
+		SessionID: claims.SessionID,
+	}
+	if claims.ExpiresAt != nil {
+		identity.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return identity, nil
+}
+
+func (v *Verifier) key(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	if t.Method.Alg() == "HS256" {
+		if v.secret == nil {
+			return nil, ErrNoKey
+		}
+		return v.secret, nil
+	}
+
+	if v.jwks == nil {
+		return nil, ErrNoKey
+	}
+	kid, _ := t.Header["kid"].(string)
+	return v.jwks.Key(ctx, kid, t.Method.Alg())
+}
+
+// ErrCannotSign means no HS256 secret is configured to issue tokens with
+var ErrCannotSign = errors.New("no secret to sign tokens")
+
+// Sign issues a short-lived access token for userID. Requests made on a
+// user's behalf without one of their tokens, such as API key calls, run
+// their queries under it so that row level security still applies.
+func (v *Verifier) Sign(userID string, ttl time.Duration) (string, error) {
+	if v.secret == nil {
+		return "", ErrCannotSign
+	}
+	audience := v.audience
+	if audience == "" {
+		audience = "authenticated"
+	}
+	now := time.Now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{audience},
+			Issuer:    v.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Role: "authenticated",
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(v.secret)
+}
+
+// New builds the Verifier described by the application config
+func New(cfg *config.Config) *Verifier {
+	c := Config{
+		Secret:   cfg.JWTSecret,
+		JWKSURL:  cfg.JWKSURL,
+		JWKSTTL:  cfg.JWKSCacheTTL,
+		Audience: cfg.JWTAudience,
+		Issuer:   cfg.JWTIssuer,
+	}
+	if cfg.AuthRemoteFallback && cfg.SupabaseURL != "" {
+		c.Remote = NewRemote(cfg.SupabaseURL, cfg.SupabaseAnonKey)
+	}
+	return NewVerifier(c)
+}
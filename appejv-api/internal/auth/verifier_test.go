@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret-with-at-least-32-characters"
+
+func testClaims() Claims {
+	now := time.Now()
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user-1",
+			Audience:  jwt.ClaimStrings{"authenticated"},
+			Issuer:    "https://example.supabase.co/auth/v1",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Email:     "a@example.com",
+		Role:      "authenticated",
+		SessionID: "session-1",
+	}
+}
+
+func signHS256(t *testing.T, claims Claims, secret string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func signRS256(t *testing.T, claims Claims, key *rsa.PrivateKey, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// jwksServer serves the public halves of keys, by kid, and counts fetches
+type jwksServer struct {
+	*httptest.Server
+	keys    map[string]*rsa.PrivateKey
+	fetches atomic.Int32
+	// block, when set, holds fetches until it is closed
+	block chan struct{}
+}
+
+func newJWKSServer(t *testing.T, keys map[string]*rsa.PrivateKey) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		if s.block != nil {
+			<-s.block
+		}
+		var set struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerifyHS256(t *testing.T) {
+	v := NewVerifier(Config{Secret: testSecret, Audience: "authenticated", Issuer: "https://example.supabase.co/auth/v1"})
+	ctx := context.Background()
+
+	identity, err := v.Verify(ctx, signHS256(t, testClaims(), testSecret))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if identity.UserID != "user-1" || identity.Email != "a@example.com" || identity.SessionID != "session-1" {
+		t.Errorf("Verify = %+v", identity)
+	}
+
+	if _, err := v.Verify(ctx, signHS256(t, testClaims(), "another-secret-of-at-least-32-chars")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify with a wrong secret = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	v := NewVerifier(Config{Secret: testSecret, Audience: "authenticated", Issuer: "https://example.supabase.co/auth/v1"})
+
+	tests := []struct {
+		name   string
+		modify func(*Claims)
+	}{
+		{"expired", func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }},
+		{"no expiry", func(c *Claims) { c.ExpiresAt = nil }},
+		{"wrong audience", func(c *Claims) { c.Audience = jwt.ClaimStrings{"service"} }},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "https://other.supabase.co/auth/v1" }},
+		{"no subject", func(c *Claims) { c.Subject = "" }},
+		{"service role", func(c *Claims) { c.Role = "service_role" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := testClaims()
+			tt.modify(&claims)
+			if _, err := v.Verify(context.Background(), signHS256(t, claims, testSecret)); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+
+	// Clock skew within the leeway is tolerated
+	claims := testClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second))
+	if _, err := v.Verify(context.Background(), signHS256(t, claims, testSecret)); err != nil {
+		t.Errorf("Verify within the leeway = %v", err)
+	}
+}
+
+func TestVerifyNoKey(t *testing.T) {
+	v := NewVerifier(Config{})
+	if _, err := v.Verify(context.Background(), signHS256(t, testClaims(), testSecret)); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Verify without a secret = %v, want ErrNoKey", err)
+	}
+}
+
+func TestVerifyRS256(t *testing.T) {
+	key := newRSAKey(t)
+	server := newJWKSServer(t, map[string]*rsa.PrivateKey{"key-1": key})
+	v := NewVerifier(Config{JWKSURL: server.URL, Audience: "authenticated"})
+	ctx := context.Background()
+
+	identity, err := v.Verify(ctx, signRS256(t, testClaims(), key, "key-1"))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if identity.UserID != "user-1" || identity.SessionID != "session-1" {
+		t.Errorf("Verify = %+v", identity)
+	}
+
+	// Keys are cached
+	if _, err := v.Verify(ctx, signRS256(t, testClaims(), key, "key-1")); err != nil {
+		t.Fatalf("second Verify: %v", err)
+	}
+	if n := server.fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+
+	// A token signed by a key the JWKS does not serve
+	if _, err := v.Verify(ctx, signRS256(t, testClaims(), newRSAKey(t), "key-1")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify with a foreign key = %v, want ErrInvalidToken", err)
+	}
+
+	expired := testClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	if _, err := v.Verify(ctx, signRS256(t, expired, key, "key-1")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify expired = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	server := newJWKSServer(t, map[string]*rsa.PrivateKey{"old": oldKey})
+	v := NewVerifier(Config{JWKSURL: server.URL})
+	ctx := context.Background()
+
+	if _, err := v.Verify(ctx, signRS256(t, testClaims(), oldKey, "old")); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// An unknown kid refetches the keys, but not more than once a minute
+	server.keys["new"] = newKey
+	if _, err := v.Verify(ctx, signRS256(t, testClaims(), newKey, "new")); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Verify right after a fetch = %v, want ErrNoKey", err)
+	}
+	v.jwks.mu.Lock()
+	v.jwks.attemptedAt = time.Now().Add(-2 * minRefresh)
+	v.jwks.mu.Unlock()
+	if _, err := v.Verify(ctx, signRS256(t, testClaims(), newKey, "new")); err != nil {
+		t.Errorf("Verify with a rotated key = %v", err)
+	}
+}
+
+// TestJWKSRefreshDoesNotBlock checks that a slow refresh of stale keys
+// does not hold up tokens whose key is cached
+func TestJWKSRefreshDoesNotBlock(t *testing.T) {
+	key := newRSAKey(t)
+	server := newJWKSServer(t, map[string]*rsa.PrivateKey{"key-1": key})
+	v := NewVerifier(Config{JWKSURL: server.URL, JWKSTTL: time.Minute})
+	ctx := context.Background()
+	token := signRS256(t, testClaims(), key, "key-1")
+
+	if _, err := v.Verify(ctx, token); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	server.block = make(chan struct{})
+	defer close(server.block)
+	v.jwks.mu.Lock()
+	v.jwks.fetchedAt = time.Now().Add(-time.Hour)
+	v.jwks.attemptedAt = time.Now().Add(-time.Hour)
+	v.jwks.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(ctx, token)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Verify during a refresh = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Verify waited for the JWKS refresh")
+	}
+}
+
+func TestRemoteFallback(t *testing.T) {
+	revokedClaims := testClaims()
+	revokedClaims.SessionID = "session-revoked"
+	revoked := signHS256(t, revokedClaims, testSecret)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auth/v1/user" || r.Header.Get("apikey") != "anon" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") == "Bearer "+revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "user-1", "email": "a@example.com"})
+	}))
+	defer server.Close()
+
+	// No secret, so HS256 tokens go to the auth server
+	v := NewVerifier(Config{Remote: NewRemote(server.URL, "anon")})
+	ctx := context.Background()
+
+	identity, err := v.Verify(ctx, signHS256(t, testClaims(), testSecret))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if identity.UserID != "user-1" || identity.SessionID != "session-1" || identity.ExpiresAt.IsZero() {
+		t.Errorf("Verify = %+v", identity)
+	}
+
+	// Without a session the token could not be revoked
+	claims := testClaims()
+	claims.SessionID = ""
+	if _, err := v.Verify(ctx, signHS256(t, claims, testSecret)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify without a session = %v, want ErrInvalidToken", err)
+	}
+
+	claims = testClaims()
+	claims.Subject = "user-2"
+	if _, err := v.Verify(ctx, signHS256(t, claims, testSecret)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify for another user = %v, want ErrInvalidToken", err)
+	}
+
+	if _, err := v.Verify(ctx, revoked); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify of a token the auth server refuses = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	v := NewVerifier(Config{Secret: testSecret, Audience: "authenticated"})
+
+	token, err := v.Sign("user-1", time.Minute)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	identity, err := v.Verify(context.Background(), token)
+	if err != nil || identity.UserID != "user-1" {
+		t.Errorf("Verify of a signed token = %+v, %v", identity, err)
+	}
+
+	if _, err := NewVerifier(Config{}).Sign("user-1", time.Minute); !errors.Is(err, ErrCannotSign) {
+		t.Errorf("Sign without a secret = %v, want ErrCannotSign", err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package barcodes renders EAN-13, Code 128 and QR codes as PNG or SVG and
+// defines the values printed on product, order and lot labels.
+package barcodes
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/qr"
+)
+
+// Symbologies
+const (
+	EAN13   = "ean13"
+	Code128 = "code128"
+	QR      = "qr"
+)
+
+// Output formats
+const (
+	PNG = "png"
+	SVG = "svg"
+)
+
+// Label prefixes. Products are labelled with their barcode or code as is;
+// orders and lots carry a prefix so a scan resolves without guessing.
+const (
+	OrderPrefix = "ORD:"
+	LotPrefix   = "LOT:"
+)
+
+var (
+	ErrUnknownSymbology = errors.New("type must be one of ean13, code128, qr")
+	ErrUnknownFormat    = errors.New("format must be one of png, svg")
+	ErrNotEAN13         = errors.New("EAN-13 needs a 12 or 13 digit value")
+)
+
+// Options controls the rendered size in pixels (PNG) or user units (SVG).
+// Zero values use a size suited to the symbology.
+type Options struct {
+	Width  int
+	Height int
+}
+
+// OrderValue is the value printed on an order sheet
+func OrderValue(orderID string) string {
+	return OrderPrefix + orderID
+}
+
+// LotValue is the value printed on a lot label
+func LotValue(lotID string) string {
+	return LotPrefix + lotID
+}
+
+// IsEAN13 reports whether value can be printed as EAN-13
+func IsEAN13(value string) bool {
+	if len(value) != 12 && len(value) != 13 {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode builds the symbol for value
+func Encode(symbology, value string) (barcode.Barcode, error) {
+	switch symbology {
+	case EAN13:
+		if !IsEAN13(value) {
+			return nil, ErrNotEAN13
+		}
+		return ean.Encode(value)
+	case Code128:
+		return code128.Encode(value)
+	case QR:
+		return qr.Encode(value, qr.M, qr.Auto)
+	default:
+		return nil, ErrUnknownSymbology
+	}
+}
+
+// Write encodes value and writes it to w in the given format
+func Write(w io.Writer, symbology, format, value string, opts Options) error {
+	if format != PNG && format != SVG {
+		return ErrUnknownFormat
+	}
+
+	bc, err := Encode(symbology, value)
+	if err != nil {
+		return err
+	}
+
+	width, height := size(symbology, opts)
+	if format == SVG {
+		return writeSVG(w, bc, width, height)
+	}
+
+	scaled, err := barcode.Scale(bc, width, height)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, scaled)
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	if format == SVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+func size(symbology string, opts Options) (int, int) {
+	width, height := opts.Width, opts.Height
+	if symbology == QR {
+		if width == 0 {
+			width = 256
+		}
+		if height == 0 {
+			height = width
+		}
+		return width, height
+	}
+
+	if width == 0 {
+		width = 320
+	}
+	if height == 0 {
+		height = 120
+	}
+	return width, height
+}
+
+// writeSVG draws the symbol's dark modules as rectangles, one per run of
+// dark modules in a row, inside a quiet zone. 1D symbols are a single row
+// stretched to height.
+func writeSVG(w io.Writer, bc barcode.Barcode, width, height int) error {
+	bounds := bc.Bounds()
+	cols, rows := bounds.Dx(), bounds.Dy()
+	if cols == 0 || rows == 0 {
+		return errors.New("empty barcode")
+	}
+
+	qx, qy := 10, 0
+	if rows > 1 {
+		qx, qy = 4, 4
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="%d %d %d %d" preserveAspectRatio="none" shape-rendering="crispEdges">`,
+		width, height, -qx, -qy, cols+2*qx, rows+2*qy)
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="#fff"/><g fill="#000">`, -qx, -qy, cols+2*qx, rows+2*qy)
+
+	for y := 0; y < rows; y++ {
+		start := -1
+		for x := 0; x <= cols; x++ {
+			isDark := x < cols && dark(bc.At(bounds.Min.X+x, bounds.Min.Y+y))
+			switch {
+			case isDark && start < 0:
+				start = x
+			case !isDark && start >= 0:
+				fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="1"/>`, start, y, x-start)
+				start = -1
+			}
+		}
+	}
+
+	b.WriteString(`</g></svg>`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dark(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r+g+b < 3*0x8000
+}
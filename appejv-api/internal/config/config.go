@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -13,6 +15,100 @@ type Config struct {
 	GinMode            string
 	AllowedOrigins     []string
 	JWTSecret          string
+
+	// Access token verification. JWTSecret verifies HS256 tokens; JWKSURL
+	// serves the keys of asymmetrically signed tokens. AuthRemoteFallback
+	// asks Supabase Auth about tokens neither can verify.
+	JWKSURL            string
+	JWKSCacheTTL       time.Duration
+	JWTAudience        string
+	JWTIssuer          string
+	AuthRemoteFallback bool
+
+	// AuthURL is the GoTrue endpoint login, refresh and logout proxy to
+	AuthURL string
+
+	// AccessTokenTTL is the auth server's JWT expiry: how long the tokens
+	// of a revoked session must be refused. Revocations made on other
+	// instances are picked up every SessionSync.
+	AccessTokenTTL time.Duration
+	SessionSync    time.Duration
+
+	// Sign-in lockout: LoginMaxFailures failed passwords within
+	// LoginFailureWindow lock the account for LoginLockout
+	LoginMaxFailures   int
+	LoginFailureWindow time.Duration
+	LoginLockout       time.Duration
+
+	// Password reset links point at PasswordResetURL and expire after
+	// PasswordResetTTL
+	PasswordResetURL string
+	PasswordResetTTL time.Duration
+
+	// Invitation links point at InviteURL and expire after InviteTTL
+	InviteURL string
+	InviteTTL time.Duration
+
+	// Phone sign-in. Codes go out through SMSProvider ("console"), expire
+	// after OTPTTL and allow OTPMaxAttempts guesses. Within an hour a phone
+	// may ask for OTPPhoneLimit codes, at most one per OTPCooldown, and an
+	// IP for OTPIPLimit. OTPSecret keys the stored code hashes.
+	SMSProvider    string
+	OTPTTL         time.Duration
+	OTPMaxAttempts int
+	OTPPhoneLimit  int
+	OTPIPLimit     int
+	OTPCooldown    time.Duration
+	OTPSecret      string
+
+	// Rate limits, zero to disable. RateLimitPublic (per minute) applies
+	// per IP to the public catalogue, RateLimitAuth (per minute) per IP to
+	// the sign-in routes, RateLimitReset (per hour) per IP to password reset
+	// requests, and RateLimitAPI (per minute) per user or API key to
+	// authenticated routes.
+	RateLimitPublic int
+	RateLimitAuth   int
+	RateLimitReset  int
+	RateLimitAPI    int
+
+	// ProxyHeader is the header a reverse proxy puts the client IP in. It
+	// is only believed on requests from TrustedProxies (IPs or CIDRs);
+	// other requests, and all of them when ProxyHeader is empty, are keyed
+	// by the connection's address.
+	ProxyHeader    string
+	TrustedProxies []string
+
+	// Email. MailBackend selects how email is sent: "smtp", "file" (.eml
+	// files in MailDir) or "log". The outbox worker polls for due emails
+	// every MailOutboxInterval.
+	MailBackend        string
+	MailFrom           string
+	MailDir            string
+	MailOutboxInterval time.Duration
+	SMTPHost           string
+	SMTPPort           int
+	SMTPUsername       string
+	SMTPPassword       string
+
+	// ProfileCacheTTL bounds how long a changed role or a removed profile
+	// goes unnoticed by AuthRequired; zero disables the cache
+	ProfileCacheTTL time.Duration
+
+	// File storage
+	StorageBackend  string // "supabase" or "local"
+	StorageBucket   string
+	StorageLocalDir string
+	StorageBaseURL  string
+	MaxUploadSize   int64
+	BodyLimit       int
+
+	// LowStockInterval is how often every product is checked against its
+	// reorder point
+	LowStockInterval time.Duration
+
+	// PDFFontPath is a TrueType font with Vietnamese glyphs for printed
+	// documents; without it PDFs print text without diacritics
+	PDFFontPath string
 }
 
 func Load() *Config {
@@ -22,14 +118,67 @@ func Load() *Config {
 		origins = strings.Split(allowedOrigins, ",")
 	}
 
+	supabaseURL := strings.TrimRight(os.Getenv("SUPABASE_URL"), "/")
+	// GoTrue's issuer and signing keys, derived from the project URL
+	authURL, jwksURL := "", ""
+	if supabaseURL != "" {
+		authURL = supabaseURL + "/auth/v1"
+		jwksURL = authURL + "/.well-known/jwks.json"
+	}
+
 	return &Config{
-		SupabaseURL:        os.Getenv("SUPABASE_URL"),
+		SupabaseURL:        supabaseURL,
 		SupabaseAnonKey:    os.Getenv("SUPABASE_ANON_KEY"),
 		SupabaseServiceKey: os.Getenv("SUPABASE_SERVICE_KEY"),
 		Port:               getEnv("PORT", "8080"),
 		GinMode:            getEnv("GIN_MODE", "debug"),
 		AllowedOrigins:     origins,
-		JWTSecret:          getEnv("JWT_SECRET", "your-secret-key"),
+		JWTSecret:          getEnv("SUPABASE_JWT_SECRET", os.Getenv("JWT_SECRET")),
+		JWKSURL:            getEnv("SUPABASE_JWKS_URL", jwksURL),
+		JWKSCacheTTL:       time.Duration(getEnvInt("JWKS_CACHE_MINUTES", 10)) * time.Minute,
+		JWTAudience:        getEnv("JWT_AUDIENCE", "authenticated"),
+		JWTIssuer:          getEnv("JWT_ISSUER", authURL),
+		AuthRemoteFallback: getEnvBool("AUTH_REMOTE_FALLBACK", false),
+		AuthURL:            getEnv("GOTRUE_URL", authURL),
+		AccessTokenTTL:     time.Duration(getEnvInt("ACCESS_TOKEN_TTL_SECONDS", 3600)) * time.Second,
+		SessionSync:        time.Duration(getEnvInt("SESSION_SYNC_SECONDS", 5)) * time.Second,
+		LoginMaxFailures:   getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginFailureWindow: time.Duration(getEnvInt("LOGIN_FAILURE_WINDOW_MINUTES", 15)) * time.Minute,
+		LoginLockout:       time.Duration(getEnvInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+		PasswordResetURL:   getEnv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+		PasswordResetTTL:   time.Duration(getEnvInt("PASSWORD_RESET_TTL_MINUTES", 30)) * time.Minute,
+		InviteURL:          getEnv("INVITE_URL", "http://localhost:3000/accept-invite"),
+		InviteTTL:          time.Duration(getEnvInt("INVITE_TTL_HOURS", 72)) * time.Hour,
+		SMSProvider:        getEnv("SMS_PROVIDER", "console"),
+		OTPTTL:             time.Duration(getEnvInt("OTP_TTL_MINUTES", 5)) * time.Minute,
+		OTPMaxAttempts:     getEnvInt("OTP_MAX_ATTEMPTS", 5),
+		OTPPhoneLimit:      getEnvInt("OTP_PHONE_LIMIT", 5),
+		OTPIPLimit:         getEnvInt("OTP_IP_LIMIT", 20),
+		OTPCooldown:        time.Duration(getEnvInt("OTP_COOLDOWN_SECONDS", 60)) * time.Second,
+		OTPSecret:          getEnv("OTP_SECRET", os.Getenv("SUPABASE_SERVICE_KEY")),
+		RateLimitPublic:    getEnvInt("RATE_LIMIT_PUBLIC_PER_MINUTE", 120),
+		RateLimitAuth:      getEnvInt("RATE_LIMIT_AUTH_PER_MINUTE", 10),
+		RateLimitReset:     getEnvInt("RATE_LIMIT_PASSWORD_RESET_PER_HOUR", 5),
+		RateLimitAPI:       getEnvInt("RATE_LIMIT_API_PER_MINUTE", 600),
+		ProxyHeader:        os.Getenv("PROXY_HEADER"),
+		TrustedProxies:     getEnvList("TRUSTED_PROXIES"),
+		MailBackend:        getEnv("MAIL_BACKEND", "log"),
+		MailFrom:           getEnv("MAIL_FROM", "APPE JV <no-reply@appejv.app>"),
+		MailDir:            getEnv("MAIL_DIR", "./mail"),
+		MailOutboxInterval: time.Duration(getEnvInt("MAIL_OUTBOX_INTERVAL_SECONDS", 30)) * time.Second,
+		SMTPHost:           os.Getenv("SMTP_HOST"),
+		SMTPPort:           getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:       os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:       os.Getenv("SMTP_PASSWORD"),
+		ProfileCacheTTL:    time.Duration(getEnvInt("PROFILE_CACHE_TTL_SECONDS", 60)) * time.Second,
+		StorageBackend:     getEnv("STORAGE_BACKEND", "supabase"),
+		StorageBucket:      getEnv("STORAGE_BUCKET", "product-images"),
+		StorageLocalDir:    getEnv("STORAGE_LOCAL_DIR", "./uploads"),
+		StorageBaseURL:     getEnv("STORAGE_BASE_URL", "/uploads"),
+		MaxUploadSize:      int64(getEnvInt("MAX_UPLOAD_SIZE_MB", 5)) << 20,
+		BodyLimit:          getEnvInt("BODY_LIMIT_MB", 32) << 20,
+		LowStockInterval:   time.Duration(getEnvInt("LOW_STOCK_INTERVAL_MINUTES", 15)) * time.Minute,
+		PDFFontPath:        os.Getenv("PDF_FONT_PATH"),
 	}
 }
 
@@ -39,3 +188,32 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvList splits a comma-separated variable, dropping empty items
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,147 @@
+// Package documents renders printable warehouse documents (pick lists and
+// packing slips) as HTML and PDF.
+package documents
+
+import (
+	"embed"
+	"html/template"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/models"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// Renderer renders documents. PDFs use the TrueType font it was created
+// with; without one they fall back to a core font, which cannot print
+// Vietnamese diacritics, so text is folded to ASCII.
+type Renderer struct {
+	templates *template.Template
+	font      []byte
+	location  *time.Location
+}
+
+// New loads the HTML templates and, when fontPath is set, the TrueType font
+// used for PDFs (e.g. DejaVuSans.ttf)
+func New(fontPath string) (*Renderer, error) {
+	tmpl, err := template.New("documents").Funcs(funcs).ParseFS(templateFS, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Renderer{templates: tmpl, location: vietnam()}
+	if fontPath != "" {
+		r.font, err = os.ReadFile(fontPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// PickListsHTML writes printable pick lists, one page per group
+func (r *Renderer) PickListsHTML(w io.Writer, lists []models.PickList) error {
+	return r.templates.ExecuteTemplate(w, "pick_lists.html", map[string]interface{}{
+		"Lists":       lists,
+		"GeneratedAt": r.now(),
+	})
+}
+
+// PackingSlipsHTML writes one packing slip page per order
+func (r *Renderer) PackingSlipsHTML(w io.Writer, orders []models.PickOrder) error {
+	return r.templates.ExecuteTemplate(w, "packing_slip.html", map[string]interface{}{
+		"Orders": orders,
+	})
+}
+
+func (r *Renderer) now() string {
+	return time.Now().In(r.location).Format("15:04 02/01/2006")
+}
+
+var funcs = template.FuncMap{
+	"deref":        deref,
+	"shortID":      shortID,
+	"groupName":    groupName,
+	"customerName": customerName,
+	"productCode":  productCode,
+	"productName":  productName,
+	"quantity":     quantity,
+	"picked":       picked,
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// shortID is the order number printed on documents: the first block of the
+// order's UUID
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func groupName(groupBy string) string {
+	switch groupBy {
+	case "route":
+		return "Theo tuyến giao"
+	case "delivery_date":
+		return "Theo ngày giao"
+	default:
+		return "Theo kho"
+	}
+}
+
+func customerName(c *models.PickCustomer) string {
+	if c == nil {
+		return ""
+	}
+	return c.Name
+}
+
+func productCode(p *models.StocktakeProduct) string {
+	if p == nil {
+		return ""
+	}
+	return p.Code
+}
+
+func productName(p *models.StocktakeProduct) string {
+	if p == nil {
+		return ""
+	}
+	return p.Name
+}
+
+func quantity(q float64) string {
+	return strconv.FormatFloat(q, 'f', -1, 64)
+}
+
+// picked returns the confirmed base quantity of an order line, or "" when
+// the order has not been picked
+func picked(pick *models.OrderPick, orderItemID string) string {
+	if pick == nil {
+		return ""
+	}
+	for _, item := range pick.Items {
+		if item.OrderItemID == orderItemID {
+			return strconv.Itoa(item.PickedQuantity)
+		}
+	}
+	return ""
+}
+
+func vietnam() *time.Location {
+	if loc, err := time.LoadLocation("Asia/Ho_Chi_Minh"); err == nil {
+		return loc
+	}
+	return time.FixedZone("ICT", 7*60*60)
+}
@@ -0,0 +1,213 @@
+package documents
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/jung-kurt/gofpdf"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	pdfFont    = "body"
+	pageWidth  = 190.0 // A4 width less 10mm margins
+	lineHeight = 7.0
+)
+
+// column is a table column: header, width in mm and alignment
+type column struct {
+	title string
+	width float64
+	align string
+}
+
+// PickListsPDF writes pick lists as an A4 PDF, one page per group
+func (r *Renderer) PickListsPDF(w io.Writer, lists []models.PickList) error {
+	doc := r.newPDF()
+	generatedAt := r.now()
+
+	if len(lists) == 0 {
+		doc.AddPage()
+		doc.text(0, lineHeight, "Không có đơn hàng chờ soạn.", "", 1, "L")
+	}
+
+	for _, list := range lists {
+		doc.AddPage()
+		doc.heading("Phiếu soạn hàng — " + list.Label)
+		doc.meta(groupName(list.GroupBy) + " · " + strconv.Itoa(len(list.Orders)) + " đơn hàng · In lúc " + generatedAt)
+
+		lines := []column{{"Mã SP", 30, "L"}, {"Tên sản phẩm", 80, "L"}, {"Số lượng", 25, "R"}, {"ĐVT", 20, "L"}, {"Số đơn", 15, "R"}, {"Đã soạn", 20, "L"}}
+		doc.header(lines)
+		for _, line := range list.Lines {
+			doc.row(lines, line.ProductCode, line.ProductName, strconv.Itoa(line.Quantity), deref(line.Unit), strconv.Itoa(line.Orders), "")
+		}
+
+		doc.Ln(4)
+		doc.subheading("Đơn hàng")
+		orders := []column{{"Mã đơn", 25, "L"}, {"Khách hàng", 80, "L"}, {"Tuyến", 35, "L"}, {"Ngày giao", 30, "L"}, {"Số dòng", 20, "R"}}
+		doc.header(orders)
+		for _, order := range list.Orders {
+			doc.row(orders, shortID(order.ID), customerName(order.Customer), deref(order.Route), deref(order.DeliveryDate), strconv.Itoa(len(order.Items)))
+		}
+	}
+
+	return doc.Output(w)
+}
+
+// PackingSlipsPDF writes one packing slip page per order
+func (r *Renderer) PackingSlipsPDF(w io.Writer, orders []models.PickOrder) error {
+	doc := r.newPDF()
+
+	for _, order := range orders {
+		doc.AddPage()
+		doc.heading("Phiếu giao hàng #" + shortID(order.ID))
+
+		meta := "Ngày đặt " + order.CreatedAt.In(r.location).Format("02/01/2006")
+		if order.DeliveryDate != nil {
+			meta += " · Ngày giao " + *order.DeliveryDate
+		}
+		if order.Route != nil {
+			meta += " · Tuyến " + *order.Route
+		}
+		doc.meta(meta)
+
+		if c := order.Customer; c != nil {
+			doc.text(0, lineHeight-1, c.Name+" ("+c.Code+")", "", 1, "L")
+			for _, s := range []string{deref(c.Address), deref(c.Phone)} {
+				if s != "" {
+					doc.text(0, lineHeight-1, s, "", 1, "L")
+				}
+			}
+			doc.Ln(3)
+		}
+
+		items := []column{{"Mã SP", 30, "L"}, {"Tên sản phẩm", 70, "L"}, {"Số lượng", 25, "R"}, {"ĐVT", 20, "L"}, {"Quy đổi", 20, "R"}, {"Đã soạn", 25, "R"}}
+		doc.header(items)
+		for _, item := range order.Items {
+			doc.row(items, productCode(item.Product), productName(item.Product), quantity(item.Quantity),
+				deref(item.Unit), strconv.Itoa(item.BaseQuantity), picked(order.Pick, item.ID))
+		}
+
+		if order.Notes != nil {
+			doc.Ln(2)
+			doc.text(0, lineHeight-1, "Ghi chú: "+*order.Notes, "", 1, "L")
+		}
+
+		doc.Ln(12)
+		for _, s := range []string{"Người soạn hàng", "Người giao hàng", "Người nhận hàng"} {
+			doc.text(pageWidth/3, lineHeight, s, "", 0, "C")
+		}
+	}
+
+	return doc.Output(w)
+}
+
+// pdf wraps gofpdf with the font handling and table helpers documents use
+type pdf struct {
+	*gofpdf.Fpdf
+	fold bool
+}
+
+func (r *Renderer) newPDF() *pdf {
+	f := gofpdf.New("P", "mm", "A4", "")
+	f.SetMargins(10, 10, 10)
+	f.SetAutoPageBreak(true, 15)
+
+	doc := &pdf{Fpdf: f}
+	if r.font != nil {
+		f.AddUTF8FontFromBytes(pdfFont, "", r.font)
+		f.AddUTF8FontFromBytes(pdfFont, "B", r.font)
+	} else {
+		doc.fold = true
+	}
+	doc.font("", 10)
+	return doc
+}
+
+func (p *pdf) font(style string, size float64) {
+	if p.fold {
+		p.SetFont("Helvetica", style, size)
+		return
+	}
+	p.SetFont(pdfFont, style, size)
+}
+
+func (p *pdf) heading(s string) {
+	p.font("B", 15)
+	p.text(0, 9, s, "", 1, "L")
+	p.font("", 10)
+}
+
+func (p *pdf) subheading(s string) {
+	p.font("B", 12)
+	p.text(0, 8, s, "", 1, "L")
+	p.font("", 10)
+}
+
+func (p *pdf) meta(s string) {
+	p.SetTextColor(85, 85, 85)
+	p.text(0, 6, s, "", 1, "L")
+	p.SetTextColor(0, 0, 0)
+	p.Ln(2)
+}
+
+func (p *pdf) header(cols []column) {
+	p.font("B", 10)
+	p.SetFillColor(238, 238, 238)
+	for _, col := range cols {
+		p.CellFormat(col.width, lineHeight, p.clean(col.title), "1", 0, col.align, true, 0, "")
+	}
+	p.Ln(-1)
+	p.font("", 10)
+}
+
+// row writes one table row, truncating values that do not fit their column
+func (p *pdf) row(cols []column, values ...string) {
+	for i, col := range cols {
+		p.CellFormat(col.width, lineHeight, p.fit(values[i], col.width-2), "1", 0, col.align, false, 0, "")
+	}
+	p.Ln(-1)
+}
+
+func (p *pdf) text(w, h float64, s, border string, ln int, align string) {
+	p.CellFormat(w, h, p.clean(s), border, ln, align, false, 0, "")
+}
+
+func (p *pdf) fit(s string, width float64) string {
+	s = p.clean(s)
+	if p.GetStringWidth(s) <= width {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && p.GetStringWidth(string(r)+"...") > width {
+		r = r[:len(r)-1]
+	}
+	return string(r) + "..."
+}
+
+// clean folds text to ASCII when the PDF uses a core font
+func (p *pdf) clean(s string) string {
+	if !p.fold {
+		return s
+	}
+	return foldASCII(s)
+}
+
+var stripMarks = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+var asciiReplacer = strings.NewReplacer("đ", "d", "Đ", "D", "—", "-", "·", "-")
+
+// foldASCII removes diacritics ("Phiếu soạn hàng" -> "Phieu soan hang")
+func foldASCII(s string) string {
+	s = asciiReplacer.Replace(s)
+	folded, _, err := transform.String(stripMarks, s)
+	if err != nil {
+		return s
+	}
+	return folded
+}
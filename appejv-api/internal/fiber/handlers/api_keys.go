@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/audit"
+	"github.com/appejv/appejv-api/internal/auth"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetAPIKeys lists the integrations' API keys, without their secrets
+func GetAPIKeys(keys *auth.APIKeys) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		list, err := keys.List()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": list,
+		})
+	}
+}
+
+// CreateAPIKey issues a key acting for the caller within its scopes. The
+// key is in the response and cannot be retrieved again.
+func CreateAPIKey(keys *auth.APIKeys, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.CreateAPIKeyRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		key, err := keys.Create(input, middleware.Subject(c).ID)
+		if err != nil {
+			return apiKeyError(c, err)
+		}
+		recordAudit(c, auditLog, "api_key_create", audit.EntityAPIKey, key.ID, audit.Diff(nil, apiKeyFields(key.APIKey)))
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": key,
+		})
+	}
+}
+
+// RotateAPIKey replaces a key's secret. With grace_hours the old secret
+// keeps working that long.
+func RotateAPIKey(keys *auth.APIKeys, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.RotateAPIKeyRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&input); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid request body",
+				})
+			}
+		}
+		if input.GraceHours < 0 || input.GraceHours > 24*7 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "grace_hours must be between 0 and 168",
+			})
+		}
+
+		key, err := keys.Rotate(c.Params("id"), time.Duration(input.GraceHours)*time.Hour)
+		if err != nil {
+			return apiKeyError(c, err)
+		}
+		recordAudit(c, auditLog, "api_key_rotate", audit.EntityAPIKey, key.ID, nil)
+
+		return c.JSON(fiber.Map{
+			"data": key,
+		})
+	}
+}
+
+// RevokeAPIKey stops a key from working
+func RevokeAPIKey(keys *auth.APIKeys, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		key, err := keys.Revoke(id)
+		if err != nil {
+			return apiKeyError(c, err)
+		}
+		recordAudit(c, auditLog, "api_key_revoke", audit.EntityAPIKey, id, nil)
+
+		return c.JSON(fiber.Map{
+			"data": key,
+		})
+	}
+}
+
+// apiKeyFields are the audited fields of a key
+func apiKeyFields(key models.APIKey) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         key.Name,
+		"prefix":       key.Prefix,
+		"scopes":       key.Scopes,
+		"customer_id":  key.CustomerID,
+		"warehouse_id": key.WarehouseID,
+		"expires_at":   key.ExpiresAt,
+	}
+}
+
+func apiKeyError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, auth.ErrAPIKeyNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, auth.ErrAPIKeyRevoked):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, auth.ErrAPIKeyName),
+		errors.Is(err, auth.ErrInvalidScope),
+		errors.Is(err, auth.ErrAPIKeyTarget):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
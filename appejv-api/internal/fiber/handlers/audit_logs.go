@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/appejv/appejv-api/internal/audit"
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetAuditLogs searches the audit log, newest first. Query: actor_id,
+// entity_type, entity_id, action, method, route (part of the route
+// pattern), from, to (RFC 3339 times, or YYYY-MM-DD dates where to
+// includes the whole day), limit (default 50, at most 200), offset.
+func GetAuditLogs(auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+		filter := audit.Filter{
+			ActorID:    c.Query("actor_id"),
+			EntityType: c.Query("entity_type"),
+			EntityID:   c.Query("entity_id"),
+			Action:     c.Query("action"),
+			Method:     c.Query("method"),
+			Route:      c.Query("route"),
+			Limit:      limit,
+			Offset:     c.QueryInt("offset", 0),
+		}
+		if v := c.Query("from"); v != "" {
+			t, ok := parseAuditTime(v, false)
+			if !ok {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "from must be an RFC 3339 time or a date (YYYY-MM-DD)",
+				})
+			}
+			filter.From = t
+		}
+		if v := c.Query("to"); v != "" {
+			t, ok := parseAuditTime(v, true)
+			if !ok {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "to must be an RFC 3339 time or a date (YYYY-MM-DD)",
+				})
+			}
+			filter.To = t
+		}
+		if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "from must be before to",
+			})
+		}
+
+		entries, err := auditLog.Search(filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": entries,
+		})
+	}
+}
+
+// parseAuditTime reads an RFC 3339 time or a date. A date as the end of a
+// range means the end of that day.
+func parseAuditTime(v string, end bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	t, err := time.Parse(inventory.DateLayout, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, true
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/auth"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Login signs in with email and password through GoTrue and returns the
+// token pair with the caller's profile. Repeated wrong passwords lock the
+// account for a while.
+func Login(gotrue *auth.GoTrue, lockout *auth.Lockout) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req models.LoginRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		req.Email = strings.TrimSpace(req.Email)
+		if req.Email == "" || req.Password == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "email and password are required",
+			})
+		}
+
+		if left, locked := lockout.Locked(req.Email); locked {
+			return accountLocked(c, left)
+		}
+
+		session, err := gotrue.SignIn(c.Context(), req.Email, req.Password)
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			if lockout.Fail(req.Email) {
+				left, _ := lockout.Locked(req.Email)
+				return accountLocked(c, left)
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid email or password",
+			})
+		}
+		if errors.Is(err, auth.ErrEmailNotConfirmed) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Email has not been confirmed",
+			})
+		}
+		if err != nil {
+			return goTrueError(c, err)
+		}
+
+		lockout.Reset(req.Email)
+		return sendSession(c, gotrue, session)
+	}
+}
+
+// RefreshSession exchanges a refresh token for a new token pair
+func RefreshSession(gotrue *auth.GoTrue) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req models.RefreshRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if strings.TrimSpace(req.RefreshToken) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "refresh_token is required",
+			})
+		}
+
+		session, err := gotrue.Refresh(c.Context(), req.RefreshToken)
+		if errors.Is(err, auth.ErrInvalidRefreshToken) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired refresh token",
+			})
+		}
+		if err != nil {
+			return goTrueError(c, err)
+		}
+
+		return sendSession(c, gotrue, session)
+	}
+}
+
+// Logout revokes the refresh tokens of the caller's session (scope local),
+// all their sessions (global) or all but this one (others). The access
+// token itself stays valid until it expires.
+func Logout(gotrue *auth.GoTrue) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req models.LogoutRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid request body",
+				})
+			}
+		}
+		switch req.Scope {
+		case "":
+			req.Scope = auth.LogoutLocal
+		case auth.LogoutLocal, auth.LogoutGlobal, auth.LogoutOthers:
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "scope must be one of local, global, others",
+			})
+		}
+
+		token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		if err := gotrue.SignOut(c.Context(), token, req.Scope); err != nil {
+			return goTrueError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Logged out",
+		})
+	}
+}
+
+// sendSession responds with the session and the user's profile, read as
+// the new session's user. Users without a profile, or whose profile is
+// deleted or deactivated, are signed out again.
+func sendSession(c *fiber.Ctx, gotrue *auth.GoTrue, session auth.Session) error {
+	db := middleware.DB(c).ForUser(session.AccessToken)
+
+	var profiles []models.Profile
+	_, err := db.Client.From("profiles").
+		Select("id, full_name, role, phone, manager_id, avatar_url, deleted_at, deactivated_at, created_at", "", false).
+		Eq("id", session.User.ID).
+		Limit(1, "").
+		ExecuteTo(&profiles)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if len(profiles) == 0 || profiles[0].DeletedAt != nil || profiles[0].DeactivatedAt != nil {
+		_ = gotrue.SignOut(c.Context(), session.AccessToken, auth.LogoutLocal)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Account is disabled or has no profile",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"data": models.LoginResponse{
+			AccessToken:  session.AccessToken,
+			RefreshToken: session.RefreshToken,
+			TokenType:    session.TokenType,
+			ExpiresIn:    session.ExpiresIn,
+			ExpiresAt:    session.ExpiresAt,
+			User:         profiles[0],
+		},
+	})
+}
+
+func accountLocked(c *fiber.Ctx, left time.Duration) error {
+	seconds := int(math.Ceil(left.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       "Too many failed sign-in attempts, try again later",
+		"retry_after": seconds,
+	})
+}
+
+// goTrueError maps an unexpected auth server failure to a response
+func goTrueError(c *fiber.Ctx, err error) error {
+	var gerr *auth.GoTrueError
+	if errors.As(err, &gerr) && gerr.Status == http.StatusTooManyRequests {
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Too many requests, try again later",
+		})
+	}
+	return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+		"error":   "Auth server unavailable",
+		"details": err.Error(),
+	})
+}
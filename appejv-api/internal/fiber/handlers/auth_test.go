@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/auth"
+	"github.com/appejv/appejv-api/internal/config"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeSupabase serves the GoTrue token and logout endpoints and the
+// profiles table. a@example.com / secret signs in to an active profile,
+// off@example.com / secret to a deactivated one.
+type fakeSupabase struct {
+	*httptest.Server
+	signIns atomic.Int32
+	logouts atomic.Int32
+}
+
+func newFakeSupabase(t *testing.T) *fakeSupabase {
+	f := &fakeSupabase{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSupabase) serve(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	grant := r.URL.Query().Get("grant_type")
+
+	switch {
+	case r.URL.Path == "/auth/v1/token" && grant == "password":
+		f.signIns.Add(1)
+		switch {
+		case body["password"] != "secret":
+			writeTestJSON(w, http.StatusBadRequest, map[string]string{"error_code": "invalid_credentials", "msg": "Invalid login credentials"})
+		case body["email"] == "off@example.com":
+			writeTestJSON(w, http.StatusOK, testSession("access-off", "refresh-off", "user-off"))
+		default:
+			writeTestJSON(w, http.StatusOK, testSession("access-1", "refresh-1", "user-1"))
+		}
+
+	case r.URL.Path == "/auth/v1/token" && grant == "refresh_token":
+		if body["refresh_token"] != "refresh-1" {
+			writeTestJSON(w, http.StatusBadRequest, map[string]string{"error_code": "refresh_token_not_found", "msg": "Invalid Refresh Token"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, testSession("access-2", "refresh-2", "user-1"))
+
+	case r.URL.Path == "/auth/v1/logout":
+		f.logouts.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.URL.Path == "/rest/v1/profiles":
+		// Profiles are read as the signed-in user
+		switch r.Header.Get("Authorization") {
+		case "Bearer access-1", "Bearer access-2":
+			writeTestJSON(w, http.StatusOK, []map[string]interface{}{
+				{"id": "user-1", "full_name": "Nguyen Van A", "role": "sale", "created_at": "2024-01-01T00:00:00Z"},
+			})
+		case "Bearer access-off":
+			writeTestJSON(w, http.StatusOK, []map[string]interface{}{
+				{"id": "user-off", "role": "sale", "created_at": "2024-01-01T00:00:00Z", "deactivated_at": "2024-02-01T00:00:00Z"},
+			})
+		default:
+			writeTestJSON(w, http.StatusOK, []interface{}{})
+		}
+
+	default:
+		writeTestJSON(w, http.StatusNotFound, map[string]string{"msg": "not found"})
+	}
+}
+
+func testSession(access, refresh, userID string) map[string]interface{} {
+	return map[string]interface{}{
+		"access_token":  access,
+		"token_type":    "bearer",
+		"expires_in":    3600,
+		"refresh_token": refresh,
+		"user":          map[string]string{"id": userID},
+	}
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// newAuthApp mounts the auth handlers as cmd/server does, against f
+func newAuthApp(f *fakeSupabase, lockout *auth.Lockout) *fiber.App {
+	db, _ := database.NewSupabaseClient(&config.Config{SupabaseURL: f.URL, SupabaseAnonKey: "anon"})
+	gotrue := auth.NewGoTrue(f.URL+"/auth/v1", "anon")
+
+	app := fiber.New()
+	app.Use(middleware.Databases(db, db))
+	app.Post("/auth/login", Login(gotrue, lockout))
+	app.Post("/auth/refresh", RefreshSession(gotrue))
+	app.Post("/auth/logout", Logout(gotrue))
+	return app
+}
+
+func post(t *testing.T, app *fiber.App, path, body, token string) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	var out map[string]interface{}
+	_ = json.Unmarshal(data, &out)
+	return resp, out
+}
+
+func TestLogin(t *testing.T) {
+	f := newFakeSupabase(t)
+	app := newAuthApp(f, auth.NewLockout(5, 15*time.Minute, 15*time.Minute))
+
+	resp, body := post(t, app, "/auth/login", `{"email":"a@example.com","password":"secret"}`, "")
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("login = %d %v", resp.StatusCode, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	user, _ := data["user"].(map[string]interface{})
+	if data["access_token"] != "access-1" || data["refresh_token"] != "refresh-1" || user["role"] != "sale" {
+		t.Errorf("login data = %v", data)
+	}
+
+	resp, _ = post(t, app, "/auth/login", `{"email":"a@example.com","password":"wrong"}`, "")
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("wrong password = %d, want 401", resp.StatusCode)
+	}
+
+	resp, _ = post(t, app, "/auth/login", `{"email":"a@example.com"}`, "")
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("missing password = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestLoginDeactivated(t *testing.T) {
+	f := newFakeSupabase(t)
+	app := newAuthApp(f, auth.NewLockout(5, 15*time.Minute, 15*time.Minute))
+
+	resp, _ := post(t, app, "/auth/login", `{"email":"off@example.com","password":"secret"}`, "")
+	if resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("deactivated login = %d, want 403", resp.StatusCode)
+	}
+	if f.logouts.Load() != 1 {
+		t.Errorf("deactivated session was not signed out again")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	f := newFakeSupabase(t)
+	app := newAuthApp(f, auth.NewLockout(3, 15*time.Minute, 15*time.Minute))
+
+	for i := 1; i <= 2; i++ {
+		resp, _ := post(t, app, "/auth/login", `{"email":"a@example.com","password":"wrong"}`, "")
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Fatalf("failure %d = %d, want 401", i, resp.StatusCode)
+		}
+	}
+	resp, body := post(t, app, "/auth/login", `{"email":"a@example.com","password":"wrong"}`, "")
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("third failure = %d, want 429", resp.StatusCode)
+	}
+	if resp.Header.Get(fiber.HeaderRetryAfter) != "900" || body["retry_after"] != float64(900) {
+		t.Errorf("Retry-After = %q, retry_after = %v; want 900", resp.Header.Get(fiber.HeaderRetryAfter), body["retry_after"])
+	}
+
+	// The right password does not help while locked, and the auth server
+	// is not asked
+	signIns := f.signIns.Load()
+	resp, _ = post(t, app, "/auth/login", `{"email":"A@example.com","password":"secret"}`, "")
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("login while locked = %d, want 429", resp.StatusCode)
+	}
+	if f.signIns.Load() != signIns {
+		t.Error("a locked account was sent to the auth server")
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	f := newFakeSupabase(t)
+	app := newAuthApp(f, auth.NewLockout(5, 15*time.Minute, 15*time.Minute))
+
+	resp, body := post(t, app, "/auth/refresh", `{"refresh_token":"refresh-1"}`, "")
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("refresh = %d %v", resp.StatusCode, body)
+	}
+	if data, _ := body["data"].(map[string]interface{}); data["access_token"] != "access-2" {
+		t.Errorf("refresh data = %v", data)
+	}
+
+	resp, _ = post(t, app, "/auth/refresh", `{"refresh_token":"stolen"}`, "")
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("invalid refresh token = %d, want 401", resp.StatusCode)
+	}
+	resp, _ = post(t, app, "/auth/refresh", `{}`, "")
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("missing refresh token = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	f := newFakeSupabase(t)
+	app := newAuthApp(f, auth.NewLockout(5, 15*time.Minute, 15*time.Minute))
+
+	resp, _ := post(t, app, "/auth/logout", ``, "access-1")
+	if resp.StatusCode != fiber.StatusOK || f.logouts.Load() != 1 {
+		t.Errorf("logout = %d with %d calls to the auth server", resp.StatusCode, f.logouts.Load())
+	}
+
+	resp, _ = post(t, app, "/auth/logout", `{"scope":"everywhere"}`, "access-1")
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("logout with an unknown scope = %d, want 400", resp.StatusCode)
+	}
+}
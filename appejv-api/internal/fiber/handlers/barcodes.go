@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/appejv/appejv-api/internal/barcodes"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetProductBarcode renders a product label. The value is the product's
+// barcode, or its code when it has none. Query: type (ean13|code128|qr,
+// default ean13 for EAN barcodes and code128 otherwise), format (png|svg),
+// width, height.
+func GetProductBarcode() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		var products []models.Product
+		_, err := db.Client.From("products").
+			Select("id, code, barcode", "", false).
+			Eq("id", c.Params("id")).
+			Is("deleted_at", "null").
+			Limit(1, "").
+			ExecuteTo(&products)
+		if err != nil || len(products) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Product not found",
+			})
+		}
+
+		value := products[0].Code
+		if products[0].Barcode != nil {
+			value = *products[0].Barcode
+		}
+		symbology := barcodes.Code128
+		if barcodes.IsEAN13(value) {
+			symbology = barcodes.EAN13
+		}
+
+		return sendBarcode(c, symbology, value)
+	}
+}
+
+// GetOrderBarcode renders the code printed on an order sheet (default QR)
+func GetOrderBarcode(picking *inventory.Picking, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		order, err := scopedPickOrder(c, picking, warehouses, c.Params("id"))
+		if err != nil {
+			return pickingError(c, err)
+		}
+
+		return sendBarcode(c, barcodes.QR, barcodes.OrderValue(order.ID))
+	}
+}
+
+// GetLotBarcode renders the code printed on a lot label (default Code 128)
+func GetLotBarcode(ledger *inventory.Ledger, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lot, err := ledger.Lot(c.Params("id"))
+		if err != nil {
+			return ledgerError(c, err)
+		}
+		if !transferScopeAllows(c, warehouses, lot.WarehouseID) {
+			return ledgerError(c, inventory.ErrLotNotFound)
+		}
+
+		return sendBarcode(c, barcodes.Code128, barcodes.LotValue(lot.ID))
+	}
+}
+
+// Scan resolves a scanned barcode or QR value to the product, order or lot
+// it identifies, with stock and lots limited to the caller's warehouses
+func Scan(scanner *inventory.Scanner, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scope, err := warehouseScope(c, warehouses)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		result, err := scanner.Resolve(c.Params("code"), scope)
+		if errors.Is(err, inventory.ErrUnknownCode) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+				"code":  result.Code,
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": result,
+		})
+	}
+}
+
+// sendBarcode renders value with the type, format and size query parameters
+func sendBarcode(c *fiber.Ctx, defaultSymbology, value string) error {
+	width, height := c.QueryInt("width"), c.QueryInt("height")
+	if width < 0 || width > 2048 || height < 0 || height > 2048 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "width and height must be between 1 and 2048",
+		})
+	}
+
+	format := c.Query("format", barcodes.PNG)
+	var buf bytes.Buffer
+	err := barcodes.Write(&buf, c.Query("type", defaultSymbology), format, value, barcodes.Options{
+		Width:  width,
+		Height: height,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, barcodes.ContentType(format))
+	c.Set(fiber.HeaderCacheControl, "private, max-age=3600")
+	return c.Send(buf.Bytes())
+}
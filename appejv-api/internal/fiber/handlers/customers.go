@@ -1,12 +1,11 @@
 package handlers
 
 import (
-	"github.com/appejv/appejv-api/pkg/database"
 	"github.com/gofiber/fiber/v2"
 )
 
 // GetCustomers returns list of customers (sales only)
-func GetCustomers(db *database.Database) fiber.Handler {
+func GetCustomers() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// TODO: Implement get customers logic
 		return c.JSON(fiber.Map{
@@ -18,7 +17,7 @@ func GetCustomers(db *database.Database) fiber.Handler {
 }
 
 // GetCustomer returns single customer (sales only)
-func GetCustomer(db *database.Database) fiber.Handler {
+func GetCustomer() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		return c.JSON(fiber.Map{
@@ -29,7 +28,7 @@ func GetCustomer(db *database.Database) fiber.Handler {
 }
 
 // CreateCustomer creates new customer (sales only)
-func CreateCustomer(db *database.Database) fiber.Handler {
+func CreateCustomer() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
 			"message": "Create customer - TODO",
@@ -38,7 +37,7 @@ func CreateCustomer(db *database.Database) fiber.Handler {
 }
 
 // UpdateCustomer updates existing customer (sales only)
-func UpdateCustomer(db *database.Database) fiber.Handler {
+func UpdateCustomer() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetStockMovements returns the stock ledger of a product (admin, sale_admin, warehouse).
+// Warehouse staff only see movements of their own warehouses.
+func GetStockMovements(ledger *inventory.Ledger, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		productID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid product id",
+			})
+		}
+
+		page, _ := strconv.Atoi(c.Query("page", "1"))
+		limit, _ := strconv.Atoi(c.Query("limit", "50"))
+		if page < 1 {
+			page = 1
+		}
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+
+		warehouseIDs, ferr := warehouseFilter(c, warehouses, c.Query("warehouse_id"))
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+
+		filter := inventory.HistoryFilter{
+			Type:         c.Query("type"),
+			WarehouseIDs: warehouseIDs,
+			From:         c.Query("from"),
+			To:           c.Query("to"),
+			Offset:       (page - 1) * limit,
+			Limit:        limit,
+		}
+
+		movements, count, err := ledger.History(productID, filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": movements,
+			"pagination": fiber.Map{
+				"page":        page,
+				"limit":       limit,
+				"total":       count,
+				"total_pages": (int(count) + limit - 1) / limit,
+			},
+		})
+	}
+}
+
+// GetAdjustmentReasons lists the reason codes accepted for stock adjustments
+func GetAdjustmentReasons() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"data": inventory.AdjustmentReasons,
+		})
+	}
+}
+
+// CreateStockAdjustment posts a manual stock correction (admin, sale_admin, warehouse).
+// A reason code is required. Negative adjustments without a lot_id are taken
+// from the earliest-expiring lots first and return one movement per lot.
+func CreateStockAdjustment(ledger *inventory.Ledger, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.StockAdjustmentRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if input.ReasonCode == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "reason_code is required",
+			})
+		}
+
+		warehouseID, ferr := resolveWarehouse(c, warehouses, input.WarehouseID)
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		entry := inventory.Entry{
+			ProductID:   input.ProductID,
+			WarehouseID: warehouseID,
+			LotID:       input.LotID,
+			Type:        inventory.Adjustment,
+			Quantity:    input.Quantity,
+			ReasonCode:  input.ReasonCode,
+			Note:        stringValue(input.Note),
+			UserID:      userID,
+		}
+
+		if input.LotID == "" && input.Quantity < 0 {
+			// Write-offs may clear expired lots too
+			movements, err := ledger.Consume(c.Context(), entry, true)
+			if err != nil {
+				return ledgerError(c, err)
+			}
+			return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+				"data": movements,
+			})
+		}
+
+		movement, err := ledger.Post(c.Context(), entry)
+		if err != nil {
+			return ledgerError(c, err)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": movement,
+		})
+	}
+}
+
+// CreateStockReceipt records goods received into stock (admin, sale_admin, warehouse).
+// With a lot_number the goods are received into that production lot.
+func CreateStockReceipt(ledger *inventory.Ledger, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.StockReceiptRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if input.Quantity <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "quantity must be greater than zero",
+			})
+		}
+
+		warehouseID, ferr := resolveWarehouse(c, warehouses, input.WarehouseID)
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+
+		entry := inventory.Entry{
+			ProductID:   input.ProductID,
+			WarehouseID: warehouseID,
+			Type:        inventory.Receipt,
+			Quantity:    input.Quantity,
+			Note:        stringValue(input.Note),
+		}
+		if input.Reference != nil {
+			entry.ReferenceType = "purchase"
+			entry.ReferenceID = *input.Reference
+		}
+		entry.UserID, _ = c.Locals("user_id").(string)
+
+		var movement models.StockMovement
+		var err error
+		if input.LotNumber != "" {
+			lot := inventory.Lot{
+				Number:         strings.TrimSpace(input.LotNumber),
+				ManufacturedAt: stringValue(input.ManufacturedAt),
+				ExpiresAt:      stringValue(input.ExpiresAt),
+			}
+			if err := lot.Validate(); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			movement, err = ledger.ReceiveLot(c.Context(), entry, lot)
+		} else {
+			movement, err = ledger.Post(c.Context(), entry)
+		}
+		if err != nil {
+			return ledgerError(c, err)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": movement,
+		})
+	}
+}
+
+// CreateStockReturn records goods returned by a customer against an order
+// (admin, sale_admin, warehouse)
+func CreateStockReturn(ledger *inventory.Ledger, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.StockReturnRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if input.Quantity <= 0 || input.OrderID == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "quantity and order_id are required",
+			})
+		}
+
+		warehouseID, ferr := resolveWarehouse(c, warehouses, input.WarehouseID)
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		movement, err := ledger.Post(c.Context(), inventory.Entry{
+			ProductID:     input.ProductID,
+			WarehouseID:   warehouseID,
+			LotID:         input.LotID,
+			Type:          inventory.Return,
+			Quantity:      input.Quantity,
+			ReasonCode:    "customer_return",
+			ReferenceType: "order",
+			ReferenceID:   input.OrderID,
+			Note:          stringValue(input.Note),
+			UserID:        userID,
+		})
+		if err != nil {
+			return ledgerError(c, err)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": movement,
+		})
+	}
+}
+
+func ledgerError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, inventory.ErrInsufficientStock), errors.Is(err, inventory.ErrLotConflict):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, inventory.ErrProductNotFound), errors.Is(err, inventory.ErrWarehouseNotFound),
+		errors.Is(err, inventory.ErrLotNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, inventory.ErrInvalidReason), errors.Is(err, inventory.ErrZeroQuantity),
+		errors.Is(err, inventory.ErrInvalidLotDates):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// GetStockLots lists production lots in first-expiry-first-out order
+// (admin, sale_admin, warehouse). Optional query: product_id, lot_number,
+// warehouse_id, include_empty.
+func GetStockLots(ledger *inventory.Ledger, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		warehouseIDs, ferr := warehouseFilter(c, warehouses, c.Query("warehouse_id"))
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+
+		lots, err := ledger.Lots(inventory.LotFilter{
+			ProductID:    c.QueryInt("product_id"),
+			LotNumber:    c.Query("lot_number"),
+			WarehouseIDs: warehouseIDs,
+			IncludeEmpty: c.QueryBool("include_empty"),
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": lots,
+		})
+	}
+}
+
+// GetNearExpiryLots reports lots with stock expiring within `days` (default
+// 30), including expired lots (admin, sale_admin, warehouse)
+func GetNearExpiryLots(ledger *inventory.Ledger, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		days := c.QueryInt("days", 30)
+		if days < 0 || days > 365 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "days must be between 0 and 365",
+			})
+		}
+
+		warehouseIDs, ferr := warehouseFilter(c, warehouses, c.Query("warehouse_id"))
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+
+		lots, err := ledger.NearExpiry(days, warehouseIDs)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": lots,
+			"days": days,
+		})
+	}
+}
+
+// GetLotRecall lists every order and customer that received a lot
+// (admin, sale_admin). Query: product_id, lot_number.
+func GetLotRecall(ledger *inventory.Ledger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		productID := c.QueryInt("product_id")
+		lotNumber := c.Query("lot_number")
+		if productID == 0 || lotNumber == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "product_id and lot_number are required",
+			})
+		}
+
+		lots, recall, err := ledger.Recall(productID, lotNumber)
+		if err != nil {
+			return ledgerError(c, err)
+		}
+
+		customers := map[string]bool{}
+		for _, entry := range recall {
+			if entry.CustomerID != nil {
+				customers[*entry.CustomerID] = true
+			}
+		}
+
+		return c.JSON(fiber.Map{
+			"data": fiber.Map{
+				"lots":      lots,
+				"orders":    recall,
+				"customers": len(customers),
+			},
+		})
+	}
+}
+
+// GetLowStock lists products at or below their reorder point in each
+// warehouse, with the time the open alert was raised. Warehouse users only
+// see their own warehouses; warehouse_id narrows to one.
+func GetLowStock(monitor *inventory.LowStockMonitor, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		warehouseIDs, ferr := warehouseFilter(c, warehouses, c.Query("warehouse_id"))
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+
+		products, err := monitor.Products(warehouseIDs)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": products,
+		})
+	}
+}
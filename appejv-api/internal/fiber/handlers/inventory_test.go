@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/config"
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestGetLowStockScope checks that each caller only gets the low stock of
+// the warehouses they may see
+func TestGetLowStockScope(t *testing.T) {
+	var filter []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/v1/warehouse_users":
+			writeTestJSON(w, http.StatusOK, []map[string]string{{"warehouse_id": "w-1"}})
+		case "/rest/v1/low_stock_products":
+			filter = r.URL.Query()["warehouse_id"]
+			writeTestJSON(w, http.StatusOK, []interface{}{})
+		default:
+			writeTestJSON(w, http.StatusNotFound, map[string]string{"msg": "not found"})
+		}
+	}))
+	defer server.Close()
+
+	db, _ := database.NewSupabaseClient(&config.Config{SupabaseURL: server.URL, SupabaseAnonKey: "service"})
+	monitor := inventory.NewLowStockMonitor(db, time.Minute)
+	warehouses := inventory.NewWarehouses(db, inventory.NewLedger(db))
+
+	tests := []struct {
+		name   string
+		role   string
+		query  string
+		status int
+		want   []string
+	}{
+		{"admin sees every warehouse", "admin", "", fiber.StatusOK, nil},
+		{"admin narrows to one", "admin", "?warehouse_id=w-2", fiber.StatusOK, []string{"in.(w-2)"}},
+		{"warehouse sees their own", "warehouse", "", fiber.StatusOK, []string{"in.(w-1)"}},
+		{"warehouse asks for another", "warehouse", "?warehouse_id=w-2", fiber.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter = nil
+			app := fiber.New()
+			app.Get("/inventory/low-stock", func(c *fiber.Ctx) error {
+				c.Locals("user_id", "user-1")
+				c.Locals("user_role", tt.role)
+				return c.Next()
+			}, GetLowStock(monitor, warehouses))
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/inventory/low-stock"+tt.query, nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("GET = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if len(filter) != len(tt.want) || (len(tt.want) > 0 && filter[0] != tt.want[0]) {
+				t.Errorf("warehouse_id filter = %v, want %v", filter, tt.want)
+			}
+		})
+	}
+}
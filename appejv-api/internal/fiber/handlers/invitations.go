@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/appejv/appejv-api/internal/accounts"
+	"github.com/appejv/appejv-api/internal/audit"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/internal/policy"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetInvitations lists invitations. Filters: status (pending, accepted,
+// revoked, expired), limit (default 50, max 200), offset. A sale_admin only
+// sees the invitations they sent.
+func GetInvitations(invites *accounts.Invitations) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+		filter := accounts.InviteFilter{
+			Status: c.Query("status"),
+			Limit:  limit,
+			Offset: c.QueryInt("offset", 0),
+		}
+		if subject := middleware.Subject(c); subject.Role != policy.RoleAdmin {
+			filter.InvitedBy = subject.ID
+		}
+
+		list, err := invites.List(filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": list,
+		})
+	}
+}
+
+// CreateInvitation emails a single-use link with which the invitee sets
+// their password; the account gets the invitation's role, manager and team
+func CreateInvitation(users *accounts.Accounts, invites *accounts.Invitations, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.CreateInvitationRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if ok, err := limitNewUser(c, users, input.Role, &input.ManagerID, input.TeamID); !ok {
+			return err
+		}
+
+		invitation, err := invites.Create(c.Context(), middleware.Subject(c).ID, input)
+		if err != nil {
+			return invitationError(c, err)
+		}
+		recordAudit(c, auditLog, "invitation_create", audit.EntityInvitation, invitation.ID, audit.Diff(nil, invitationFields(invitation)))
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": invitation,
+		})
+	}
+}
+
+// ResendInvitation emails a fresh link; the previous one stops working
+func ResendInvitation(invites *accounts.Invitations, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if _, err := ownInvitation(c, invites, id); err != nil {
+			return invitationError(c, err)
+		}
+
+		invitation, err := invites.Resend(c.Context(), id)
+		if err != nil {
+			return invitationError(c, err)
+		}
+		recordAudit(c, auditLog, "invitation_resend", audit.EntityInvitation, id, nil)
+
+		return c.JSON(fiber.Map{
+			"data": invitation,
+		})
+	}
+}
+
+// RevokeInvitation cancels a pending invitation
+func RevokeInvitation(invites *accounts.Invitations, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		before, err := ownInvitation(c, invites, id)
+		if err != nil {
+			return invitationError(c, err)
+		}
+
+		after, err := invites.Revoke(id)
+		if err != nil {
+			return invitationError(c, err)
+		}
+		recordAudit(c, auditLog, "invitation_revoke", audit.EntityInvitation, id, audit.Diff(invitationFields(before), invitationFields(after)))
+
+		return c.JSON(fiber.Map{
+			"data": after,
+		})
+	}
+}
+
+// AcceptInvite creates the invitee's account from the token in their link
+// and the password they chose
+func AcceptInvite(invites *accounts.Invitations, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.AcceptInviteRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if input.Token == "" || input.Password == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "token and password are required",
+			})
+		}
+
+		user, err := invites.Accept(c.Context(), input)
+		if err != nil {
+			return invitationError(c, err)
+		}
+		actor := policy.Subject{ID: user.ID, Role: user.Role}
+		recordAuditAs(c, auditLog, actor, "invitation_accept", audit.EntityUser, user.ID, audit.Diff(nil, accountFields(user)))
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": user,
+		})
+	}
+}
+
+// ownInvitation returns the invitation if the caller may act on it: admins
+// on any, others on those they sent
+func ownInvitation(c *fiber.Ctx, invites *accounts.Invitations, id string) (models.Invitation, error) {
+	invitation, err := invites.Get(id)
+	if err != nil {
+		return models.Invitation{}, err
+	}
+	subject := middleware.Subject(c)
+	if subject.Role != policy.RoleAdmin &&
+		(invitation.InvitedBy == nil || *invitation.InvitedBy != subject.ID) {
+		return models.Invitation{}, accounts.ErrInvitationNotFound
+	}
+	return invitation, nil
+}
+
+// invitationFields are the audited fields of an invitation
+func invitationFields(inv models.Invitation) map[string]interface{} {
+	return map[string]interface{}{
+		"email":      inv.Email,
+		"full_name":  inv.FullName,
+		"role":       inv.Role,
+		"manager_id": inv.ManagerID,
+		"team_id":    inv.TeamID,
+		"status":     inv.Status,
+	}
+}
+
+func invitationError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, accounts.ErrInvitationNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, accounts.ErrInvitationClosed),
+		errors.Is(err, accounts.ErrAlreadyInvited):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, accounts.ErrInvalidInvite):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return accountError(c, err)
+}
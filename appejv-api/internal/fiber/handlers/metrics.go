@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetMetrics returns runtime counters: profile cache hits, misses and size
+func GetMetrics(profiles *middleware.ProfileCache) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"data": fiber.Map{
+				"profile_cache": profiles.Stats(),
+			},
+		})
+	}
+}
@@ -1,48 +1,340 @@
 package handlers
 
 import (
-	"github.com/appejv/appejv-api/pkg/database"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/internal/policy"
+	"github.com/appejv/appejv-api/internal/uom"
 	"github.com/gofiber/fiber/v2"
+	"github.com/supabase-community/postgrest-go"
 )
 
-// GetOrders returns list of orders (sales only)
-func GetOrders(db *database.Database) fiber.Handler {
+// GetOrders lists the orders the caller can see, newest first. Filters:
+// status, customer_id, page, limit (default 20, max 200). Row level security
+// decides which orders are visible; an API key limited to a customer only
+// sees that customer's orders.
+func GetOrders() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		page, _ := strconv.Atoi(c.Query("page", "1"))
+		limit, _ := strconv.Atoi(c.Query("limit", "20"))
+		if page < 1 {
+			page = 1
+		}
+		if limit < 1 || limit > 200 {
+			limit = 20
+		}
+		offset := (page - 1) * limit
+
+		query := middleware.DB(c).Client.From("orders").
+			Select("*", "exact", false).
+			Is("deleted_at", "null")
+
+		if status := c.Query("status"); status != "" {
+			if !validOrderStatus(status) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "status must be one of draft, ordered, shipping, delivered, completed, cancelled",
+				})
+			}
+			query = query.Eq("status", status)
+		}
+		if customerID := c.Query("customer_id"); customerID != "" {
+			query = query.Eq("customer_id", customerID)
+		}
+		if subject := middleware.Subject(c); subject.Limits != nil && subject.Limits.CustomerID != "" {
+			query = query.Eq("customer_id", subject.Limits.CustomerID)
+		}
+
+		var orders []models.Order
+		count, err := query.
+			Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+			Range(offset, offset+limit-1, "").
+			ExecuteTo(&orders)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
 		return c.JSON(fiber.Map{
-			"message": "Get orders - TODO",
-			"user_id": c.Locals("user_id"),
-			"role":    c.Locals("user_role"),
+			"data": orders,
+			"pagination": fiber.Map{
+				"page":        page,
+				"limit":       limit,
+				"total":       count,
+				"total_pages": (int(count) + limit - 1) / limit,
+			},
 		})
 	}
 }
 
-// GetOrder returns single order (sales only)
-func GetOrder(db *database.Database) fiber.Handler {
+// GetOrder returns an order with its lines
+func GetOrder() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
 		id := c.Params("id")
+
+		var orders []models.Order
+		_, err := db.Client.From("orders").
+			Select("*", "", false).
+			Eq("id", id).
+			Is("deleted_at", "null").
+			Limit(1, "").
+			ExecuteTo(&orders)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if len(orders) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Order not found",
+			})
+		}
+
+		var items []models.OrderItem
+		_, err = db.Client.From("order_items").
+			Select("*", "", false).
+			Eq("order_id", id).
+			ExecuteTo(&items)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
 		return c.JSON(fiber.Map{
-			"message": "Get order - TODO",
-			"id":      id,
+			"data": fiber.Map{
+				"order": orders[0],
+				"items": items,
+			},
 		})
 	}
 }
 
-// CreateOrder creates new order (sales only)
-func CreateOrder(db *database.Database) fiber.Handler {
+// CreateOrder creates a draft order (sales only). Each line may use any unit
+// allowed for the product; quantities are converted to the base unit and
+// priced per unit. The customer must be one the caller may order for.
+func CreateOrder(engine *policy.Engine) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
-			"message": "Create order - TODO",
+		db := middleware.DB(c)
+		var input models.CreateOrderRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		// An API key limited to a customer orders for that customer
+		subject := middleware.Subject(c)
+		if subject.Limits != nil && subject.Limits.CustomerID != "" && input.CustomerID == nil {
+			input.CustomerID = &subject.Limits.CustomerID
+		}
+		resource := policy.Resource{Kind: policy.Order}
+		if input.CustomerID != nil {
+			resource.CustomerID = *input.CustomerID
+		}
+		if err := engine.Authorize(subject, policy.Create, resource); err != nil {
+			return middleware.PolicyError(c, err)
+		}
+
+		if len(input.Items) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Order must have at least one item",
+			})
+		}
+
+		productIDs := make([]string, 0, len(input.Items))
+		for _, item := range input.Items {
+			productIDs = append(productIDs, strconv.Itoa(item.ProductID))
+		}
+
+		var products []models.Product
+		_, err := db.Client.From("products").
+			Select("*", "", false).
+			In("id", productIDs).
+			Is("deleted_at", "null").
+			ExecuteTo(&products)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		productsByID := make(map[int]models.Product, len(products))
+		for _, p := range products {
+			productsByID[p.ID] = p
+		}
+
+		unitsByProduct, err := listProductUnits(db, productIDs)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		items := make([]models.OrderItem, 0, len(input.Items))
+		total := 0.0
+		for i, line := range input.Items {
+			product, ok := productsByID[line.ProductID]
+			if !ok {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Product not found",
+					"line":  i,
+				})
+			}
+
+			unit, err := uom.Resolve(product, unitsByProduct[product.ID], line.Unit)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+					"line":  i,
+				})
+			}
+
+			baseQty, err := uom.BaseQuantity(unit, line.Quantity)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+					"line":  i,
+				})
+			}
+
+			unitName := unit.Unit
+			items = append(items, models.OrderItem{
+				ProductID:    product.ID,
+				Quantity:     line.Quantity,
+				Unit:         &unitName,
+				UnitFactor:   unit.Factor,
+				BaseQuantity: baseQty,
+				PriceAtOrder: unit.UnitPrice,
+			})
+			total += unit.UnitPrice * line.Quantity
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		order := map[string]interface{}{
+			"customer_id":  input.CustomerID,
+			"sale_id":      userID,
+			"created_by":   userID,
+			"status":       "draft",
+			"total_amount": total,
+			"notes":        input.Notes,
+			"route":        input.Route,
+		}
+		if input.DeliveryDate != nil && *input.DeliveryDate != "" {
+			if _, err := time.Parse(inventory.DateLayout, *input.DeliveryDate); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "delivery_date must be a date (YYYY-MM-DD)",
+				})
+			}
+			order["delivery_date"] = *input.DeliveryDate
+		}
+
+		var created []models.Order
+		_, err = db.Client.From("orders").
+			Insert(order, false, "", "representation", "").
+			ExecuteTo(&created)
+		if err != nil || len(created) == 0 {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": errorMessage(err, "Failed to create order"),
+			})
+		}
+
+		rows := make([]map[string]interface{}, 0, len(items))
+		for _, item := range items {
+			rows = append(rows, map[string]interface{}{
+				"order_id":       created[0].ID,
+				"product_id":     item.ProductID,
+				"quantity":       item.Quantity,
+				"unit":           item.Unit,
+				"unit_factor":    item.UnitFactor,
+				"base_quantity":  item.BaseQuantity,
+				"price_at_order": item.PriceAtOrder,
+			})
+		}
+
+		var createdItems []models.OrderItem
+		_, err = db.Client.From("order_items").
+			Insert(rows, false, "", "representation", "").
+			ExecuteTo(&createdItems)
+		if err != nil {
+			// Do not leave an order without lines behind
+			db.Client.From("orders").Delete("minimal", "").Eq("id", created[0].ID).Execute()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": fiber.Map{
+				"order": created[0],
+				"items": createdItems,
+			},
 		})
 	}
 }
 
-// UpdateOrder updates existing order (sales only)
-func UpdateOrder(db *database.Database) fiber.Handler {
+// UpdateOrder changes an order's status. The policy decides which changes
+// the caller may make: sales and customers place and cancel their own
+// orders, warehouse only dispatches ordered ones, and every role only moves
+// an order forward through its lifecycle or cancels it.
+func UpdateOrder(engine *policy.Engine) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		var input models.UpdateOrderRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if input.Status == nil || !validOrderStatus(*input.Status) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "status must be one of draft, ordered, shipping, delivered, completed, cancelled",
+			})
+		}
+
 		id := c.Params("id")
-		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
-			"message": "Update order - TODO",
-			"id":      id,
+		resource := policy.Resource{Kind: policy.Order, ID: id, Status: *input.Status}
+		if err := engine.Authorize(middleware.Subject(c), policy.ChangeStatus, resource); err != nil {
+			return middleware.PolicyError(c, err)
+		}
+
+		var updated []models.Order
+		_, err := middleware.DB(c).Client.From("orders").
+			Update(map[string]interface{}{"status": *input.Status}, "representation", "").
+			Eq("id", id).
+			Is("deleted_at", "null").
+			ExecuteTo(&updated)
+		if err != nil {
+			// The database refuses changes outside the order lifecycle and
+			// shipping an order that has not been picked
+			status := fiber.StatusInternalServerError
+			if msg := err.Error(); strings.Contains(msg, "invalid order status change") || strings.Contains(msg, "has not been picked") {
+				status = fiber.StatusConflict
+			}
+			return c.Status(status).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if len(updated) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Order not found",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": updated[0],
 		})
 	}
 }
+
+func validOrderStatus(status string) bool {
+	switch status {
+	case "draft", "ordered", "shipping", "delivered", "completed", "cancelled":
+		return true
+	}
+	return false
+}
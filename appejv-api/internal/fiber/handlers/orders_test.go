@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/auth"
+	"github.com/appejv/appejv-api/internal/config"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/policy"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+const testJWTSecret = "test-secret-with-at-least-32-characters"
+
+// fakeOrdersREST authenticates one API key, limited to customer c-1 and
+// acting for admin-1, and records the orders queries it is sent
+type fakeOrdersREST struct {
+	*httptest.Server
+	mu      sync.Mutex
+	queries []*http.Request
+}
+
+func newFakeOrdersREST(t *testing.T) *fakeOrdersREST {
+	f := &fakeOrdersREST{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/v1/rpc/authenticate_api_key":
+			writeTestJSON(w, http.StatusOK, []map[string]interface{}{
+				{"id": "key-1", "scopes": []string{"order:read"}, "customer_id": "c-1", "created_by": "admin-1"},
+			})
+		case "/rest/v1/profiles":
+			writeTestJSON(w, http.StatusOK, []map[string]interface{}{
+				{"id": "admin-1", "role": "admin"},
+			})
+		case "/rest/v1/orders":
+			f.mu.Lock()
+			f.queries = append(f.queries, r.Clone(context.Background()))
+			f.mu.Unlock()
+			w.Header().Set("Content-Range", "0-0/1")
+			writeTestJSON(w, http.StatusOK, []map[string]interface{}{
+				{"id": "o-1", "customer_id": "c-1", "sale_id": "s-1", "status": "ordered", "created_at": "2024-01-01T00:00:00Z"},
+			})
+		default:
+			writeTestJSON(w, http.StatusNotFound, map[string]string{"msg": "not found"})
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newOrdersApp(f *fakeOrdersREST, secret string) *fiber.App {
+	db, _ := database.NewSupabaseClient(&config.Config{SupabaseURL: f.URL, SupabaseAnonKey: "anon"})
+	service, _ := database.NewSupabaseClient(&config.Config{SupabaseURL: f.URL, SupabaseAnonKey: "service"})
+	verifier := auth.NewVerifier(auth.Config{Secret: secret, Audience: "authenticated"})
+
+	app := fiber.New()
+	app.Use(middleware.Databases(db, service))
+	app.Use(middleware.AuthRequired(middleware.NewProfileCache(service, time.Minute), verifier, auth.NewAPIKeys(service), nil))
+	app.Get("/orders", GetOrders())
+	return app
+}
+
+// TestGetOrdersWithAPIKey checks that API key requests query as the key's
+// owner, not with the service role, and only see the key's customer
+func TestGetOrdersWithAPIKey(t *testing.T) {
+	f := newFakeOrdersREST(t)
+	app := newOrdersApp(f, testJWTSecret)
+
+	req := httptest.NewRequest(http.MethodGet, "/orders?status=ordered", nil)
+	req.Header.Set(middleware.APIKeyHeader, "appe_test")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("GET /orders = %d", resp.StatusCode)
+	}
+
+	if len(f.queries) != 1 {
+		t.Fatalf("orders queried %d times, want 1", len(f.queries))
+	}
+	q := f.queries[0]
+	token := strings.TrimPrefix(q.Header.Get("Authorization"), "Bearer ")
+	identity, err := auth.NewVerifier(auth.Config{Secret: testJWTSecret, Audience: "authenticated"}).Verify(context.Background(), token)
+	if err != nil || identity.UserID != "admin-1" {
+		t.Errorf("orders queried with token for %q (%v), want a token for admin-1", identity.UserID, err)
+	}
+	if got := q.URL.Query()["customer_id"]; len(got) != 1 || got[0] != "eq.c-1" {
+		t.Errorf("customer_id filter = %v, want eq.c-1", got)
+	}
+	if got := q.URL.Query().Get("status"); got != "eq.ordered" {
+		t.Errorf("status filter = %q, want eq.ordered", got)
+	}
+}
+
+func TestGetOrdersWithAPIKeyNeedsSecret(t *testing.T) {
+	f := newFakeOrdersREST(t)
+	app := newOrdersApp(f, "")
+
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set(middleware.APIKeyHeader, "appe_test")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusServiceUnavailable {
+		t.Errorf("GET /orders without a JWT secret = %d, want 503", resp.StatusCode)
+	}
+	if len(f.queries) != 0 {
+		t.Errorf("orders were queried without a token to run under")
+	}
+}
+
+// TestUpdateOrderStatus checks that an admin cannot move an order backwards
+// or out of a final status, and that the database refusing a change is a
+// conflict
+func TestUpdateOrderStatus(t *testing.T) {
+	var current string
+	var updates int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/v1/orders" {
+			writeTestJSON(w, http.StatusNotFound, map[string]string{"msg": "not found"})
+			return
+		}
+		if r.Method == http.MethodPatch {
+			updates++
+			writeTestJSON(w, http.StatusBadRequest, map[string]string{"code": "P0001", "message": "order o-1 has not been picked in full"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, []map[string]interface{}{
+			{"id": "o-1", "sale_id": "s-1", "customer_id": "c-1", "status": current},
+		})
+	}))
+	defer server.Close()
+
+	db, _ := database.NewSupabaseClient(&config.Config{SupabaseURL: server.URL, SupabaseAnonKey: "service"})
+	app := fiber.New()
+	app.Use(middleware.Databases(db, db))
+	app.Put("/orders/:id", func(c *fiber.Ctx) error {
+		c.Locals("user_id", "admin-1")
+		c.Locals("user_role", "admin")
+		return c.Next()
+	}, UpdateOrder(policy.NewEngine(policy.NewDirectory(db))))
+
+	tests := []struct {
+		from, to string
+		status   int
+	}{
+		{"shipping", "ordered", fiber.StatusForbidden},
+		{"cancelled", "ordered", fiber.StatusForbidden},
+		{"completed", "cancelled", fiber.StatusForbidden},
+		{"draft", "shipping", fiber.StatusForbidden},
+		{"ordered", "shipping", fiber.StatusConflict},
+	}
+	for _, tt := range tests {
+		current, updates = tt.from, 0
+		req := httptest.NewRequest(http.MethodPut, "/orders/o-1", strings.NewReader(`{"status":"`+tt.to+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s -> %s = %d, want %d", tt.from, tt.to, resp.StatusCode, tt.status)
+		}
+		if tt.status == fiber.StatusForbidden && updates != 0 {
+			t.Errorf("%s -> %s was sent to the database", tt.from, tt.to)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/appejv/appejv-api/internal/auth"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// phoneCodeSent is the answer to every accepted code request, so it does
+// not reveal whether the phone has an account
+const phoneCodeSent = "Nếu số điện thoại đã được đăng ký, bạn sẽ nhận được mã đăng nhập qua SMS"
+
+// RequestPhoneCode texts a one-time sign-in code to the account with the
+// given phone, if there is one
+func RequestPhoneCode(otp *auth.PhoneOTP) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req models.PhoneCodeRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		err := otp.Request(c.Context(), req.Phone, c.IP())
+		var throttled *auth.OTPThrottledError
+		switch {
+		case errors.Is(err, auth.ErrInvalidPhone):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Số điện thoại không hợp lệ",
+			})
+		case errors.As(err, &throttled):
+			seconds := int(math.Ceil(throttled.RetryAfter.Seconds()))
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":       "Too many code requests, try again later",
+				"retry_after": seconds,
+			})
+		case err != nil:
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Không thể gửi mã đăng nhập",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": phoneCodeSent,
+		})
+	}
+}
+
+// VerifyPhoneCode signs in with a phone and the code texted to it and
+// returns the same token pair and profile as Login
+func VerifyPhoneCode(otp *auth.PhoneOTP, gotrue *auth.GoTrue) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req models.PhoneCodeVerifyRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if req.Phone == "" || req.Code == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "phone and code are required",
+			})
+		}
+
+		session, err := otp.Verify(c.Context(), req.Phone, req.Code)
+		switch {
+		case errors.Is(err, auth.ErrInvalidOTP):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired code",
+			})
+		case errors.Is(err, auth.ErrNoEmailLogin):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case err != nil:
+			return goTrueError(c, err)
+		}
+
+		return sendSession(c, gotrue, session)
+	}
+}
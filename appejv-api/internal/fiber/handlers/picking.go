@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/documents"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPickLists groups the orders waiting to be picked by warehouse, route or
+// delivery date and totals what to pick per product. Query: group_by
+// (warehouse|route|delivery_date, default warehouse), warehouse_id, route,
+// delivery_date, format (json|html|pdf).
+func GetPickLists(picking *inventory.Picking, warehouses *inventory.Warehouses, docs *documents.Renderer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		groupBy := c.Query("group_by", inventory.GroupByWarehouse)
+		switch groupBy {
+		case inventory.GroupByWarehouse, inventory.GroupByRoute, inventory.GroupByDeliveryDate:
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "group_by must be one of warehouse, route, delivery_date",
+			})
+		}
+
+		orders, ferr := openOrders(c, picking, warehouses)
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+
+		lists, err := picking.PickLists(orders, groupBy)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return sendDocument(c, "pick-lists", lists,
+			func(b *bytes.Buffer) error { return docs.PickListsHTML(b, lists) },
+			func(b *bytes.Buffer) error { return docs.PickListsPDF(b, lists) })
+	}
+}
+
+// GetPackingSlips returns packing slips for the orders waiting to be picked,
+// with the same filters as GetPickLists. Query format: json|html|pdf.
+func GetPackingSlips(picking *inventory.Picking, warehouses *inventory.Warehouses, docs *documents.Renderer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orders, ferr := openOrders(c, picking, warehouses)
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+
+		return sendDocument(c, "packing-slips", orders,
+			func(b *bytes.Buffer) error { return docs.PackingSlipsHTML(b, orders) },
+			func(b *bytes.Buffer) error { return docs.PackingSlipsPDF(b, orders) })
+	}
+}
+
+// GetOrderPackingSlip returns the packing slip of one order. Query format:
+// json|html|pdf.
+func GetOrderPackingSlip(picking *inventory.Picking, warehouses *inventory.Warehouses, docs *documents.Renderer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		order, err := scopedPickOrder(c, picking, warehouses, c.Params("id"))
+		if err != nil {
+			return pickingError(c, err)
+		}
+
+		orders := []models.PickOrder{order}
+		return sendDocument(c, "packing-slip-"+order.ID, order,
+			func(b *bytes.Buffer) error { return docs.PackingSlipsHTML(b, orders) },
+			func(b *bytes.Buffer) error { return docs.PackingSlipsPDF(b, orders) })
+	}
+}
+
+// GetOrderPick returns an order's lines with its pick confirmation, if any
+func GetOrderPick(picking *inventory.Picking, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		order, err := scopedPickOrder(c, picking, warehouses, c.Params("id"))
+		if err != nil {
+			return pickingError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": order,
+		})
+	}
+}
+
+// ConfirmOrderPick records the base quantity picked for every line of an
+// ordered order. The order can only move to shipping once every line is
+// picked in full; a short pick can be confirmed again after restocking.
+func ConfirmOrderPick(picking *inventory.Picking, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.ConfirmPickRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if len(input.Lines) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "lines is required",
+			})
+		}
+		for i, line := range input.Lines {
+			if line.OrderItemID == "" || line.PickedQuantity < 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "order_item_id and a non-negative picked_quantity are required",
+					"line":  i,
+				})
+			}
+		}
+
+		order, err := scopedPickOrder(c, picking, warehouses, c.Params("id"))
+		if err != nil {
+			return pickingError(c, err)
+		}
+		scope, err := warehouseScope(c, warehouses)
+		if err != nil {
+			return pickingError(c, err)
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		pick, err := picking.ConfirmPick(c.Context(), order.ID, input, userID, scope)
+		if err != nil {
+			return pickingError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": pick,
+		})
+	}
+}
+
+// UpdateOrderDelivery sets the route and delivery date of an order that has
+// not shipped yet
+func UpdateOrderDelivery() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		var input models.UpdateOrderDeliveryRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		updates := map[string]interface{}{}
+		if input.Route != nil {
+			if route := strings.TrimSpace(*input.Route); route != "" {
+				updates["route"] = route
+			} else {
+				updates["route"] = nil
+			}
+		}
+		if input.DeliveryDate != nil {
+			if *input.DeliveryDate == "" {
+				updates["delivery_date"] = nil
+			} else if _, err := time.Parse(inventory.DateLayout, *input.DeliveryDate); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "delivery_date must be a date (YYYY-MM-DD)",
+				})
+			} else {
+				updates["delivery_date"] = *input.DeliveryDate
+			}
+		}
+		if len(updates) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "route or delivery_date is required",
+			})
+		}
+
+		var updated []models.Order
+		_, err := db.Client.From("orders").
+			Update(updates, "representation", "").
+			Eq("id", c.Params("id")).
+			In("status", []string{"draft", "ordered"}).
+			Is("deleted_at", "null").
+			ExecuteTo(&updated)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if len(updated) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Order not found or already shipped",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": updated[0],
+		})
+	}
+}
+
+// openOrders loads the ordered orders matching the query filters, limited to
+// the caller's warehouses
+func openOrders(c *fiber.Ctx, picking *inventory.Picking, warehouses *inventory.Warehouses) ([]models.PickOrder, *fiber.Error) {
+	date := c.Query("delivery_date")
+	if date != "" {
+		if _, err := time.Parse(inventory.DateLayout, date); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "delivery_date must be a date (YYYY-MM-DD)")
+		}
+	}
+
+	warehouseIDs, ferr := warehouseFilter(c, warehouses, c.Query("warehouse_id"))
+	if ferr != nil {
+		return nil, ferr
+	}
+
+	orders, err := picking.OpenOrders(inventory.PickFilter{
+		WarehouseIDs: warehouseIDs,
+		Route:        c.Query("route"),
+		DeliveryDate: date,
+	})
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	return orders, nil
+}
+
+// scopedPickOrder loads an order and checks the caller may pick for its
+// warehouse
+func scopedPickOrder(c *fiber.Ctx, picking *inventory.Picking, warehouses *inventory.Warehouses, id string) (models.PickOrder, error) {
+	order, err := picking.Order(id)
+	if err != nil {
+		return models.PickOrder{}, err
+	}
+
+	scope, err := warehouseScope(c, warehouses)
+	if err != nil {
+		return models.PickOrder{}, err
+	}
+	if !scope.All && (order.WarehouseID == nil || !scope.Allows(*order.WarehouseID)) {
+		return models.PickOrder{}, inventory.ErrOrderNotFound
+	}
+	return order, nil
+}
+
+// sendDocument responds with data as JSON, or renders it as printable HTML
+// or a PDF depending on the format query
+func sendDocument(c *fiber.Ctx, name string, data interface{}, html, pdf func(*bytes.Buffer) error) error {
+	var buf bytes.Buffer
+	switch c.Query("format", "json") {
+	case "json":
+		return c.JSON(fiber.Map{
+			"data": data,
+		})
+	case "html":
+		if err := html(&buf); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		c.Type("html", "utf-8")
+	case "pdf":
+		if err := pdf(&buf); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		c.Type("pdf")
+		c.Set(fiber.HeaderContentDisposition, `inline; filename="`+name+`.pdf"`)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "format must be one of json, html, pdf",
+		})
+	}
+	return c.Send(buf.Bytes())
+}
+
+func pickingError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, inventory.ErrOrderNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, inventory.ErrNotPickable):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, inventory.ErrInvalidPick):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	default:
+		return warehouseError(c, err)
+	}
+}
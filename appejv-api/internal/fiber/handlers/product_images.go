@@ -0,0 +1,461 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/media"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/internal/storage"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// maxImagesPerUpload limits how many files one upload request may carry
+const maxImagesPerUpload = 10
+
+// GetProductImages returns the images of a product in display order (public)
+func GetProductImages() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		images, err := listProductImages(db, c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": images,
+		})
+	}
+}
+
+// UploadProductImages uploads one or more images for a product (admin only).
+// Expects multipart/form-data with files in the "images" field. Pass
+// primary=true to make the first uploaded image the primary one.
+func UploadProductImages(store storage.Storage, maxSize int64) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		productID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid product id",
+			})
+		}
+
+		var products []models.Product
+		_, err = db.Client.From("products").
+			Select("id", "", false).
+			Eq("id", strconv.Itoa(productID)).
+			Is("deleted_at", "null").
+			Limit(1, "").
+			ExecuteTo(&products)
+		if err != nil || len(products) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Product not found",
+			})
+		}
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Expected multipart/form-data with an images field",
+			})
+		}
+
+		files := form.File["images"]
+		if len(files) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "No images uploaded",
+			})
+		}
+		if len(files) > maxImagesPerUpload {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("At most %d images per upload", maxImagesPerUpload),
+			})
+		}
+
+		// Validate every file before storing anything
+		decoded := make([]*media.Image, 0, len(files))
+		for _, fh := range files {
+			img, err := readImage(fh, maxSize)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+					"file":  fh.Filename,
+				})
+			}
+			decoded = append(decoded, img)
+		}
+
+		existing, err := listProductImages(db, strconv.Itoa(productID))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		nextOrder := 0
+		currentPrimary := ""
+		for _, img := range existing {
+			if img.SortOrder >= nextOrder {
+				nextOrder = img.SortOrder + 1
+			}
+			if img.IsPrimary {
+				currentPrimary = img.ID
+			}
+		}
+
+		// Without a primary image the first upload is inserted as primary.
+		// Otherwise the current one stays primary until the new rows exist.
+		makePrimary := c.FormValue("primary") == "true" || currentPrimary == ""
+
+		var createdBy *string
+		if userID, ok := c.Locals("user_id").(string); ok {
+			createdBy = &userID
+		}
+
+		rows := make([]models.ProductImage, 0, len(decoded))
+		for i, img := range decoded {
+			row, err := storeProductImage(store, productID, img)
+			if err != nil {
+				deleteStoredImages(store, rows)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to store image: " + err.Error(),
+				})
+			}
+
+			row.SortOrder = nextOrder + i
+			row.IsPrimary = makePrimary && currentPrimary == "" && i == 0
+			row.CreatedBy = createdBy
+			row.CreatedAt = time.Now()
+			rows = append(rows, row)
+		}
+
+		var inserted []models.ProductImage
+		_, err = db.Client.From("product_images").
+			Insert(rows, false, "", "representation", "").
+			ExecuteTo(&inserted)
+		if err != nil {
+			deleteStoredImages(store, rows)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		if makePrimary && currentPrimary != "" && len(inserted) > 0 {
+			if err := setPrimaryImage(db, productID, inserted[0].ID, currentPrimary); err != nil {
+				ids := make([]string, 0, len(inserted))
+				for _, img := range inserted {
+					ids = append(ids, img.ID)
+				}
+				db.Client.From("product_images").Delete("minimal", "").In("id", ids).Execute()
+				deleteStoredImages(store, rows)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			inserted[0].IsPrimary = true
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": inserted,
+		})
+	}
+}
+
+// ReorderProductImages sets the display order of a product's images (admin only)
+func ReorderProductImages() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		productID := c.Params("id")
+
+		var input models.ReorderProductImagesRequest
+		if err := c.BodyParser(&input); err != nil || len(input.ImageIDs) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "image_ids is required",
+			})
+		}
+
+		existing, err := listProductImages(db, productID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		known := make(map[string]bool, len(existing))
+		for _, img := range existing {
+			known[img.ID] = true
+		}
+		if len(input.ImageIDs) != len(existing) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "image_ids must list every image of the product exactly once",
+			})
+		}
+		for _, id := range input.ImageIDs {
+			if !known[id] {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "image_ids must list every image of the product exactly once",
+				})
+			}
+			delete(known, id)
+		}
+
+		for i, id := range input.ImageIDs {
+			_, _, err := db.Client.From("product_images").
+				Update(map[string]interface{}{"sort_order": i}, "minimal", "").
+				Eq("id", id).
+				Eq("product_id", productID).
+				Execute()
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+		}
+
+		images, err := listProductImages(db, productID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": images,
+		})
+	}
+}
+
+// SetPrimaryProductImage marks one image as the product's primary image (admin only)
+func SetPrimaryProductImage() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		productID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid product id",
+			})
+		}
+		imageID := c.Params("imageId")
+
+		image, err := getProductImage(db, productID, imageID)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Image not found",
+			})
+		}
+
+		if !image.IsPrimary {
+			if err := setPrimaryImage(db, productID, imageID, ""); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			image.IsPrimary = true
+		}
+
+		return c.JSON(fiber.Map{
+			"data": image,
+		})
+	}
+}
+
+// DeleteProductImage removes an image and its stored files (admin only).
+// If the primary image is deleted, the next image in order becomes primary.
+func DeleteProductImage(store storage.Storage) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		productID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid product id",
+			})
+		}
+		imageID := c.Params("imageId")
+
+		image, err := getProductImage(db, productID, imageID)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Image not found",
+			})
+		}
+
+		_, _, err = db.Client.From("product_images").
+			Delete("minimal", "").
+			Eq("id", imageID).
+			Execute()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		deleteStoredImages(store, []models.ProductImage{image})
+
+		if image.IsPrimary {
+			remaining, err := listProductImages(db, strconv.Itoa(productID))
+			if err == nil && len(remaining) > 0 {
+				db.Client.From("product_images").
+					Update(map[string]interface{}{"is_primary": true}, "minimal", "").
+					Eq("id", remaining[0].ID).
+					Execute()
+			}
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Image deleted",
+			"id":      imageID,
+		})
+	}
+}
+
+func readImage(fh *multipart.FileHeader, maxSize int64) (*media.Image, error) {
+	if maxSize > 0 && fh.Size > maxSize {
+		return nil, media.ErrTooLarge
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// A limit of zero or less means none; the body limit still applies
+	var r io.Reader = f
+	if maxSize > 0 {
+		r = io.LimitReader(f, maxSize+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return media.DecodeImage(data, maxSize)
+}
+
+// storeProductImage writes the original and its generated variants to storage
+func storeProductImage(store storage.Storage, productID int, img *media.Image) (models.ProductImage, error) {
+	id := uuid.NewString()
+	base := fmt.Sprintf("products/%d/%s", productID, id)
+
+	thumb, err := img.Render(media.Thumbnail)
+	if err != nil {
+		return models.ProductImage{}, err
+	}
+	web, err := img.Render(media.Web)
+	if err != nil {
+		return models.ProductImage{}, err
+	}
+
+	originalPath := base + img.Ext
+	thumbPath := base + "_" + media.Thumbnail.Name + ".jpg"
+	webPath := base + "_" + media.Web.Name + ".jpg"
+
+	if err := store.Put(originalPath, img.Data, img.ContentType); err != nil {
+		return models.ProductImage{}, err
+	}
+	if err := store.Put(thumbPath, thumb, "image/jpeg"); err != nil {
+		store.Delete(originalPath)
+		return models.ProductImage{}, err
+	}
+	if err := store.Put(webPath, web, "image/jpeg"); err != nil {
+		store.Delete(originalPath, thumbPath)
+		return models.ProductImage{}, err
+	}
+
+	return models.ProductImage{
+		ID:           id,
+		ProductID:    productID,
+		StoragePath:  originalPath,
+		URL:          store.PublicURL(originalPath),
+		ThumbnailURL: store.PublicURL(thumbPath),
+		WebURL:       store.PublicURL(webPath),
+		ContentType:  img.ContentType,
+		SizeBytes:    int64(len(img.Data)),
+		Width:        img.Width,
+		Height:       img.Height,
+	}, nil
+}
+
+// deleteStoredImages removes the files of the given images, ignoring errors
+func deleteStoredImages(store storage.Storage, images []models.ProductImage) {
+	var paths []string
+	for _, img := range images {
+		base := strings.TrimSuffix(img.StoragePath, path.Ext(img.StoragePath))
+		paths = append(paths,
+			img.StoragePath,
+			base+"_"+media.Thumbnail.Name+".jpg",
+			base+"_"+media.Web.Name+".jpg",
+		)
+	}
+	store.Delete(paths...)
+}
+
+func listProductImages(db *database.Database, productID string) ([]models.ProductImage, error) {
+	images := []models.ProductImage{}
+	_, err := db.Client.From("product_images").
+		Select("*", "", false).
+		Eq("product_id", productID).
+		Order("sort_order", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&images)
+	return images, err
+}
+
+func getProductImage(db *database.Database, productID int, imageID string) (models.ProductImage, error) {
+	var images []models.ProductImage
+	_, err := db.Client.From("product_images").
+		Select("*", "", false).
+		Eq("id", imageID).
+		Eq("product_id", strconv.Itoa(productID)).
+		Limit(1, "").
+		ExecuteTo(&images)
+	if err != nil {
+		return models.ProductImage{}, err
+	}
+	if len(images) == 0 {
+		return models.ProductImage{}, errors.New("image not found")
+	}
+	return images[0], nil
+}
+
+// setPrimaryImage makes imageID the primary image of the product. Only one
+// image may be primary, so the current one is unset first; with previousID
+// it is restored when the new one cannot be set.
+func setPrimaryImage(db *database.Database, productID int, imageID, previousID string) error {
+	if err := clearPrimaryImage(db, productID); err != nil {
+		return err
+	}
+
+	_, _, err := db.Client.From("product_images").
+		Update(map[string]interface{}{"is_primary": true}, "minimal", "").
+		Eq("id", imageID).
+		Execute()
+	if err != nil && previousID != "" {
+		db.Client.From("product_images").
+			Update(map[string]interface{}{"is_primary": true}, "minimal", "").
+			Eq("id", previousID).
+			Execute()
+	}
+	return err
+}
+
+func clearPrimaryImage(db *database.Database, productID int) error {
+	_, _, err := db.Client.From("product_images").
+		Update(map[string]interface{}{"is_primary": false}, "minimal", "").
+		Eq("product_id", strconv.Itoa(productID)).
+		Eq("is_primary", "true").
+		Execute()
+	return err
+}
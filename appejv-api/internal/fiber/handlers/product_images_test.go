@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/appejv/appejv-api/internal/config"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/media"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// fileHeader returns data as the field of a parsed multipart form
+func fileHeader(t *testing.T, field string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile(field, "upload.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	w.Close()
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File[field][0]
+}
+
+func TestReadImage(t *testing.T) {
+	data := testPNG(t, 64, 32)
+
+	img, err := readImage(fileHeader(t, "images", data), 1<<20)
+	if err != nil {
+		t.Fatalf("readImage: %v", err)
+	}
+	if img.Width != 64 || img.Height != 32 {
+		t.Errorf("readImage = %d×%d, want 64×32", img.Width, img.Height)
+	}
+
+	// A limit of zero is no limit rather than a limit of zero bytes
+	if _, err := readImage(fileHeader(t, "images", data), 0); err != nil {
+		t.Errorf("readImage without a limit: %v", err)
+	}
+
+	if _, err := readImage(fileHeader(t, "images", data), int64(len(data)-1)); !errors.Is(err, media.ErrTooLarge) {
+		t.Errorf("readImage over the limit = %v, want ErrTooLarge", err)
+	}
+}
+
+// memoryStore keeps stored objects in memory
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memoryStore) Put(path string, data []byte, _ string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[path] = data
+	return nil
+}
+
+func (m *memoryStore) Delete(paths ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range paths {
+		delete(m.objects, p)
+	}
+	return nil
+}
+
+func (m *memoryStore) PublicURL(path string) string { return "/media/" + path }
+
+// TestUploadPrimaryImage checks that the current primary image is only
+// unset once the new one is inserted, and kept when the insert fails
+func TestUploadPrimaryImage(t *testing.T) {
+	for _, failInsert := range []bool{false, true} {
+		var changes []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			switch {
+			case r.URL.Path == "/rest/v1/products":
+				writeTestJSON(w, http.StatusOK, []map[string]interface{}{{"id": 1}})
+			case r.Method == http.MethodGet:
+				writeTestJSON(w, http.StatusOK, []map[string]interface{}{{"id": "img-old", "product_id": 1, "is_primary": true}})
+			case r.Method == http.MethodPost:
+				var rows []map[string]interface{}
+				_ = json.Unmarshal(body, &rows)
+				changes = append(changes, "insert primary="+jsonValue(rows[0]["is_primary"]))
+				if failInsert {
+					writeTestJSON(w, http.StatusBadRequest, map[string]string{"message": "insert failed"})
+					return
+				}
+				rows[0]["id"] = "img-new"
+				writeTestJSON(w, http.StatusCreated, rows)
+			default:
+				changes = append(changes, r.Method+" "+r.URL.RawQuery+" "+string(body))
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}))
+		defer server.Close()
+
+		db, _ := database.NewSupabaseClient(&config.Config{SupabaseURL: server.URL, SupabaseAnonKey: "service"})
+		store := &memoryStore{objects: make(map[string][]byte)}
+		app := fiber.New()
+		app.Use(middleware.Databases(db, db))
+		app.Post("/products/:id/images", UploadProductImages(store, 1<<20))
+
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		part, _ := mw.CreateFormFile("images", "upload.png")
+		part.Write(testPNG(t, 64, 32))
+		mw.WriteField("primary", "true")
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/products/1/images", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if failInsert {
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Errorf("failed upload = %d, want 500", resp.StatusCode)
+			}
+			if len(changes) != 1 {
+				t.Errorf("changes after a failed insert = %v, want only the insert", changes)
+			}
+			if len(store.objects) != 0 {
+				t.Errorf("%d stored objects left behind", len(store.objects))
+			}
+			continue
+		}
+
+		if resp.StatusCode != fiber.StatusCreated {
+			t.Fatalf("upload = %d, want 201", resp.StatusCode)
+		}
+		want := []string{
+			"insert primary=false",
+			`PATCH is_primary=eq.true&product_id=eq.1 {"is_primary":false}`,
+			`PATCH id=eq.img-new {"is_primary":true}`,
+		}
+		if strings.Join(changes, "\n") != strings.Join(want, "\n") {
+			t.Errorf("changes = %q, want %q", changes, want)
+		}
+	}
+}
+
+func jsonValue(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/internal/specs"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// maxCompareProducts limits how many products can be compared at once
+const maxCompareProducts = 4
+
+var errNoSpecSchema = errors.New("category has no specification schema")
+
+// GetSpecSchemas returns the available product specification schemas (public)
+func GetSpecSchemas() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"data": specs.All(),
+		})
+	}
+}
+
+// CompareProducts returns products side by side with their specification
+// values aligned by field (public). Usage: /products/compare?ids=1,2,3
+func CompareProducts() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		var ids []string
+		for _, raw := range strings.Split(c.Query("ids"), ",") {
+			raw = strings.TrimSpace(raw)
+			if raw == "" {
+				continue
+			}
+			if _, err := strconv.Atoi(raw); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "ids must be a comma separated list of product ids",
+				})
+			}
+			ids = append(ids, raw)
+		}
+
+		if len(ids) < 2 || len(ids) > maxCompareProducts {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Provide between 2 and " + strconv.Itoa(maxCompareProducts) + " product ids",
+			})
+		}
+
+		var products []models.Product
+		_, err := db.Client.From("products").
+			Select("*", "", false).
+			In("id", ids).
+			Is("deleted_at", "null").
+			ExecuteTo(&products)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Keep the order the caller asked for
+		byID := make(map[string]models.Product, len(products))
+		for _, p := range products {
+			byID[strconv.Itoa(p.ID)] = p
+		}
+		ordered := make([]models.Product, 0, len(ids))
+		for _, id := range ids {
+			p, ok := byID[id]
+			if !ok {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Product not found",
+					"id":    id,
+				})
+			}
+			ordered = append(ordered, p)
+		}
+
+		schemas, err := categorySpecSchemas(db, ordered)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		// Union of fields in schema order, each with one value per product
+		type comparedField struct {
+			specs.Field
+			Values []interface{} `json:"values"`
+		}
+		var fields []comparedField
+		seen := make(map[string]bool)
+		for _, p := range ordered {
+			schema, ok := schemas[categoryKey(p.CategoryID)]
+			if !ok {
+				continue
+			}
+			for _, f := range schema.Fields {
+				if seen[f.Key] {
+					continue
+				}
+				seen[f.Key] = true
+
+				values := make([]interface{}, len(ordered))
+				for i, q := range ordered {
+					values[i] = q.Specs[f.Key]
+				}
+				fields = append(fields, comparedField{Field: f, Values: values})
+			}
+		}
+
+		return c.JSON(fiber.Map{
+			"data": fiber.Map{
+				"products": ordered,
+				"fields":   fields,
+			},
+		})
+	}
+}
+
+// applySpecFilters adds spec filters from the query string: <key>_min and
+// <key>_max for numeric fields, <key>=value for text and enum fields
+func applySpecFilters(c *fiber.Ctx, query *postgrest.FilterBuilder) (*postgrest.FilterBuilder, error) {
+	for key, f := range specs.FilterableFields() {
+		if f.Type == specs.Number {
+			if v := c.Query(key + "_min"); v != "" {
+				if _, err := strconv.ParseFloat(v, 64); err != nil {
+					return nil, errors.New(key + "_min must be a number")
+				}
+				query = query.Gte("specs->"+key, v)
+			}
+			if v := c.Query(key + "_max"); v != "" {
+				if _, err := strconv.ParseFloat(v, 64); err != nil {
+					return nil, errors.New(key + "_max must be a number")
+				}
+				query = query.Lte("specs->"+key, v)
+			}
+			continue
+		}
+
+		if v := c.Query(key); v != "" {
+			query = query.Eq("specs->>"+key, v)
+		}
+	}
+	return query, nil
+}
+
+// validateProductSpecs validates values against the schema of the category.
+// Empty specs are always accepted.
+func validateProductSpecs(db *database.Database, categoryID *int, values models.Specs) (models.Specs, []specs.FieldError, error) {
+	if len(values) == 0 {
+		return models.Specs{}, nil, nil
+	}
+
+	schema, err := specSchemaForCategory(db, categoryID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	normalized, errs := schema.Validate(values)
+	return normalized, errs, nil
+}
+
+func specSchemaForCategory(db *database.Database, categoryID *int) (specs.Schema, error) {
+	if categoryID == nil {
+		return specs.Schema{}, errNoSpecSchema
+	}
+
+	var categories []models.Category
+	_, err := db.Client.From("categories").
+		Select("id, name, spec_schema", "", false).
+		Eq("id", strconv.Itoa(*categoryID)).
+		Limit(1, "").
+		ExecuteTo(&categories)
+	if err != nil {
+		return specs.Schema{}, err
+	}
+	if len(categories) == 0 || categories[0].SpecSchema == nil {
+		return specs.Schema{}, errNoSpecSchema
+	}
+
+	schema, ok := specs.Lookup(*categories[0].SpecSchema)
+	if !ok {
+		return specs.Schema{}, errNoSpecSchema
+	}
+	return schema, nil
+}
+
+// categorySpecSchemas returns the spec schema of each product's category,
+// keyed by categoryKey
+func categorySpecSchemas(db *database.Database, products []models.Product) (map[string]specs.Schema, error) {
+	var ids []string
+	for _, p := range products {
+		if p.CategoryID != nil {
+			ids = append(ids, strconv.Itoa(*p.CategoryID))
+		}
+	}
+
+	out := make(map[string]specs.Schema)
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	var categories []models.Category
+	_, err := db.Client.From("categories").
+		Select("id, name, spec_schema", "", false).
+		In("id", ids).
+		ExecuteTo(&categories)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cat := range categories {
+		if cat.SpecSchema == nil {
+			continue
+		}
+		if schema, ok := specs.Lookup(*cat.SpecSchema); ok {
+			id := cat.ID
+			out[categoryKey(&id)] = schema
+		}
+	}
+	return out, nil
+}
+
+func categoryKey(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/internal/uom"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// GetProductUnits returns the units a product can be sold in with their
+// conversion factors and effective prices (public)
+func GetProductUnits() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		product, err := getActiveProduct(db, c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Product not found",
+			})
+		}
+
+		units, err := listProductUnits(db, []string{strconv.Itoa(product.ID)})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": uom.Units(product, units[product.ID]),
+		})
+	}
+}
+
+// CreateProductUnit adds a sellable unit to a product (admin only)
+func CreateProductUnit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		product, err := getActiveProduct(db, c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Product not found",
+			})
+		}
+
+		var input models.CreateProductUnitRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		input.Unit = strings.TrimSpace(input.Unit)
+		if input.Unit == "" || input.Factor <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "unit and a positive factor are required",
+			})
+		}
+		if input.Price != nil && *input.Price < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "price cannot be negative",
+			})
+		}
+		if input.Unit == uom.BaseUnit(product).Unit {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Unit is already the product's base unit",
+			})
+		}
+
+		row := map[string]interface{}{
+			"product_id": product.ID,
+			"unit":       input.Unit,
+			"factor":     input.Factor,
+			"price":      input.Price,
+			"is_base":    false,
+		}
+
+		var created []models.ProductUnit
+		_, err = db.Client.From("product_units").
+			Insert(row, false, "", "representation", "").
+			ExecuteTo(&created)
+		if err != nil || len(created) == 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": errorMessage(err, "Failed to create unit"),
+			})
+		}
+
+		unit := created[0]
+		unit.UnitPrice = uom.UnitPrice(unit, product.Price)
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": unit,
+		})
+	}
+}
+
+// UpdateProductUnit changes a unit's conversion factor or price (admin only).
+// The base unit's factor is fixed at 1.
+func UpdateProductUnit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		product, err := getActiveProduct(db, c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Product not found",
+			})
+		}
+		unitID := c.Params("unitId")
+
+		var input models.UpdateProductUnitRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		existing, err := getProductUnit(db, product.ID, unitID)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Unit not found",
+			})
+		}
+
+		updates := map[string]interface{}{}
+		if input.Factor != nil {
+			if *input.Factor <= 0 || (existing.IsBase && *input.Factor != 1) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "factor must be positive and the base unit factor must be 1",
+				})
+			}
+			updates["factor"] = *input.Factor
+		}
+		if input.Price != nil {
+			if *input.Price < 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "price cannot be negative",
+				})
+			}
+			updates["price"] = *input.Price
+		}
+		if input.ClearPrice {
+			updates["price"] = nil
+		}
+
+		if len(updates) == 0 {
+			existing.UnitPrice = uom.UnitPrice(existing, product.Price)
+			return c.JSON(fiber.Map{
+				"data": existing,
+			})
+		}
+
+		var updated []models.ProductUnit
+		_, err = db.Client.From("product_units").
+			Update(updates, "representation", "").
+			Eq("id", unitID).
+			ExecuteTo(&updated)
+		if err != nil || len(updated) == 0 {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": errorMessage(err, "Failed to update unit"),
+			})
+		}
+
+		unit := updated[0]
+		unit.UnitPrice = uom.UnitPrice(unit, product.Price)
+
+		return c.JSON(fiber.Map{
+			"data": unit,
+		})
+	}
+}
+
+// DeleteProductUnit removes a sellable unit (admin only). The base unit
+// cannot be removed.
+func DeleteProductUnit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		productID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid product id",
+			})
+		}
+		unitID := c.Params("unitId")
+
+		unit, err := getProductUnit(db, productID, unitID)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Unit not found",
+			})
+		}
+		if unit.IsBase {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "The base unit cannot be deleted",
+			})
+		}
+
+		_, _, err = db.Client.From("product_units").
+			Delete("minimal", "").
+			Eq("id", unitID).
+			Execute()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Unit deleted",
+			"id":      unitID,
+		})
+	}
+}
+
+// createBaseUnit records the base unit row of a newly created product
+func createBaseUnit(db *database.Database, p models.Product) error {
+	base := uom.BaseUnit(p)
+	_, _, err := db.Client.From("product_units").
+		Insert(map[string]interface{}{
+			"product_id": p.ID,
+			"unit":       base.Unit,
+			"factor":     1,
+			"is_base":    true,
+		}, false, "", "minimal", "").
+		Execute()
+	return err
+}
+
+func getActiveProduct(db *database.Database, id string) (models.Product, error) {
+	var products []models.Product
+	_, err := db.Client.From("products").
+		Select("*", "", false).
+		Eq("id", id).
+		Is("deleted_at", "null").
+		Limit(1, "").
+		ExecuteTo(&products)
+	if err != nil {
+		return models.Product{}, err
+	}
+	if len(products) == 0 {
+		return models.Product{}, errNotFound
+	}
+	return products[0], nil
+}
+
+// listProductUnits returns the unit rows of the given products keyed by product id
+func listProductUnits(db *database.Database, productIDs []string) (map[int][]models.ProductUnit, error) {
+	var units []models.ProductUnit
+	_, err := db.Client.From("product_units").
+		Select("*", "", false).
+		In("product_id", productIDs).
+		Order("factor", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&units)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[int][]models.ProductUnit)
+	for _, u := range units {
+		out[u.ProductID] = append(out[u.ProductID], u)
+	}
+	return out, nil
+}
+
+func getProductUnit(db *database.Database, productID int, unitID string) (models.ProductUnit, error) {
+	var units []models.ProductUnit
+	_, err := db.Client.From("product_units").
+		Select("*", "", false).
+		Eq("id", unitID).
+		Eq("product_id", strconv.Itoa(productID)).
+		Limit(1, "").
+		ExecuteTo(&units)
+	if err != nil {
+		return models.ProductUnit{}, err
+	}
+	if len(units) == 0 {
+		return models.ProductUnit{}, errNotFound
+	}
+	return units[0], nil
+}
@@ -1,17 +1,21 @@
 package handlers
 
 import (
+	"errors"
 	"strconv"
 
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/inventory"
 	"github.com/appejv/appejv-api/internal/models"
-	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/appejv/appejv-api/internal/uom"
 	"github.com/gofiber/fiber/v2"
 	"github.com/supabase-community/postgrest-go"
 )
 
 // GetProducts returns list of products (public)
-func GetProducts(db *database.Database) fiber.Handler {
+func GetProducts() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
 		category := c.Query("category")
 		search := c.Query("search")
 		page, _ := strconv.Atoi(c.Query("page", "1"))
@@ -34,6 +38,14 @@ func GetProducts(db *database.Database) fiber.Handler {
 			query = query.Or("name.ilike.%"+search+"%,code.ilike.%"+search+"%", "")
 		}
 
+		// Filter by specification values (e.g. protein_min=18&target_animal=pig)
+		query, err := applySpecFilters(c, query)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
 		// Pagination
 		query = query.Range(offset, offset+limit-1, "")
 
@@ -63,8 +75,9 @@ func GetProducts(db *database.Database) fiber.Handler {
 }
 
 // GetProduct returns single product (public)
-func GetProduct(db *database.Database) fiber.Handler {
+func GetProduct() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
 		id := c.Params("id")
 
 		var products []models.Product
@@ -81,53 +94,248 @@ func GetProduct(db *database.Database) fiber.Handler {
 			})
 		}
 
+		product := products[0]
+		if images, err := listProductImages(db, id); err == nil {
+			product.Images = images
+		}
+
 		return c.JSON(fiber.Map{
-			"data": products[0],
+			"data": product,
 		})
 	}
 }
 
-// CreateProduct creates new product (admin only)
-func CreateProduct(db *database.Database) fiber.Handler {
+// CreateProduct creates new product (admin only). A non-zero stock is
+// posted to the stock ledger as the opening receipt.
+func CreateProduct(ledger *inventory.Ledger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var product models.Product
-		if err := c.BodyParser(&product); err != nil {
+		db := middleware.DB(c)
+		var input models.CreateProductRequest
+		if err := c.BodyParser(&input); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error": "Invalid request body",
 			})
 		}
 
-		// TODO: Implement create logic
-		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
-			"message": "Create product - TODO",
-			"data":    product,
+		if input.Code == "" || input.Name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "code and name are required",
+			})
+		}
+		if input.ReorderPoint != nil && *input.ReorderPoint < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "reorder_point must not be negative",
+			})
+		}
+
+		values, fieldErrs, err := validateProductSpecs(db, input.CategoryID, input.Specs)
+		if err != nil {
+			return specsError(c, err)
+		}
+		if len(fieldErrs) > 0 {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error":   "Invalid specifications",
+				"details": fieldErrs,
+			})
+		}
+		input.Specs = values
+
+		openingStock := input.Stock
+		input.Stock = 0
+
+		var created []models.Product
+		_, err = db.Client.From("products").
+			Insert(input, false, "", "representation", "").
+			ExecuteTo(&created)
+		if err != nil || len(created) == 0 {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": errorMessage(err, "Failed to create product"),
+			})
+		}
+
+		// Do not leave a product without its base unit or opening stock
+		// behind; its unit rows go with it
+		discard := func() {
+			db.Client.From("products").Delete("minimal", "").Eq("id", strconv.Itoa(created[0].ID)).Execute()
+		}
+
+		if err := createBaseUnit(db, created[0]); err != nil {
+			discard()
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Base unit could not be recorded: " + err.Error(),
+			})
+		}
+
+		if openingStock > 0 {
+			userID, _ := c.Locals("user_id").(string)
+			movement, err := ledger.Post(c.Context(), inventory.Entry{
+				ProductID:     created[0].ID,
+				Type:          inventory.Receipt,
+				Quantity:      openingStock,
+				ReferenceType: "opening_balance",
+				UserID:        userID,
+			})
+			if err != nil {
+				discard()
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Opening stock could not be posted: " + err.Error(),
+				})
+			}
+			created[0].Stock = movement.BalanceAfter
+		} else if input.ReorderPoint != nil {
+			ledger.Changed(created[0].ID)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": created[0],
 		})
 	}
 }
 
 // UpdateProduct updates existing product (admin only)
-func UpdateProduct(db *database.Database) fiber.Handler {
+func UpdateProduct(ledger *inventory.Ledger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
 		id := c.Params("id")
 
-		var product models.Product
-		if err := c.BodyParser(&product); err != nil {
+		var input models.UpdateProductRequest
+		if err := c.BodyParser(&input); err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error": "Invalid request body",
 			})
 		}
 
-		// TODO: Implement update logic
-		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
-			"message": "Update product - TODO",
-			"id":      id,
-			"data":    product,
+		var existing []models.Product
+		_, err := db.Client.From("products").
+			Select("*", "", false).
+			Eq("id", id).
+			Is("deleted_at", "null").
+			Limit(1, "").
+			ExecuteTo(&existing)
+		if err != nil || len(existing) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Product not found",
+			})
+		}
+		product := existing[0]
+		middleware.AuditBefore(c, product)
+
+		if input.ReorderPoint != nil && *input.ReorderPoint < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "reorder_point must not be negative",
+			})
+		}
+
+		// Stock and the other units' factors are counted in the base unit, so
+		// it can only be renamed before the product has either
+		renameBase := input.Unit != nil && *input.Unit != uom.BaseUnit(product).Unit
+		if renameBase {
+			if *input.Unit == "" {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "unit must not be empty",
+				})
+			}
+			units, err := listProductUnits(db, []string{strconv.Itoa(product.ID)})
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			for _, u := range units[product.ID] {
+				if !u.IsBase {
+					return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+						"error": "The base unit cannot change while the product has other units",
+					})
+				}
+			}
+			if product.Stock != 0 {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "The base unit cannot change while the product has stock",
+				})
+			}
+		}
+
+		updates := map[string]interface{}{}
+		setIfPresent(updates, "name", input.Name)
+		setIfPresent(updates, "unit", input.Unit)
+		setIfPresent(updates, "price", input.Price)
+		setIfPresent(updates, "category", input.Category)
+		setIfPresent(updates, "category_id", input.CategoryID)
+		setIfPresent(updates, "description", input.Description)
+		setIfPresent(updates, "image_url", input.ImageURL)
+		setIfPresent(updates, "specifications", input.Specifications)
+		setIfPresent(updates, "barcode", input.Barcode)
+		setIfPresent(updates, "reorder_point", input.ReorderPoint)
+		if input.ClearReorderPoint {
+			updates["reorder_point"] = nil
+		}
+
+		// Specs are revalidated when they change or the category changes
+		categoryID := product.CategoryID
+		if input.CategoryID != nil {
+			categoryID = input.CategoryID
+		}
+		specValues := product.Specs
+		if input.Specs != nil {
+			specValues = input.Specs
+		}
+		if input.Specs != nil || input.CategoryID != nil {
+			values, fieldErrs, err := validateProductSpecs(db, categoryID, specValues)
+			if err != nil {
+				return specsError(c, err)
+			}
+			if len(fieldErrs) > 0 {
+				return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+					"error":   "Invalid specifications",
+					"details": fieldErrs,
+				})
+			}
+			updates["specs"] = values
+		}
+
+		if len(updates) == 0 {
+			return c.JSON(fiber.Map{
+				"data": product,
+			})
+		}
+
+		var updated []models.Product
+		_, err = db.Client.From("products").
+			Update(updates, "representation", "").
+			Eq("id", id).
+			ExecuteTo(&updated)
+		if err != nil || len(updated) == 0 {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": errorMessage(err, "Failed to update product"),
+			})
+		}
+
+		// Keep the base unit row named after products.unit
+		if renameBase {
+			_, _, err = db.Client.From("product_units").
+				Update(map[string]interface{}{"unit": *input.Unit}, "minimal", "").
+				Eq("product_id", id).
+				Eq("is_base", "true").
+				Execute()
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Product updated but base unit could not be renamed: " + err.Error(),
+				})
+			}
+		}
+
+		if _, ok := updates["reorder_point"]; ok {
+			ledger.Changed(product.ID)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": updated[0],
 		})
 	}
 }
 
 // DeleteProduct deletes product (admin only)
-func DeleteProduct(db *database.Database) fiber.Handler {
+func DeleteProduct() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("id")
 
@@ -138,3 +346,31 @@ func DeleteProduct(db *database.Database) fiber.Handler {
 		})
 	}
 }
+
+var errNotFound = errors.New("not found")
+
+func specsError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errNoSpecSchema) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Specifications are not supported for this category",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// setIfPresent adds a pointer field to an update map when it is set
+func setIfPresent[T any](updates map[string]interface{}, column string, value *T) {
+	if value != nil {
+		updates[column] = *value
+	}
+}
+
+// errorMessage returns err's message, or fallback when err is nil
+func errorMessage(err error, fallback string) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fallback
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/config"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeProductREST serves product 1, sold in kg, with the given stock and
+// unit rows, and records the requests that change it. Stock postings fail.
+type fakeProductREST struct {
+	*httptest.Server
+	stock   int
+	units   []map[string]interface{}
+	changes []string
+	// failUnits makes writes to product_units fail
+	failUnits bool
+}
+
+func newFakeProductREST(t *testing.T) *fakeProductREST {
+	f := &fakeProductREST{units: []map[string]interface{}{{"id": "u-kg", "product_id": 1, "unit": "kg", "factor": 1, "is_base": true}}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			f.changes = append(f.changes, r.Method+" "+r.URL.Path)
+			if f.failUnits && r.URL.Path == "/rest/v1/product_units" {
+				writeTestJSON(w, http.StatusBadRequest, map[string]string{"message": "unit rows are locked"})
+				return
+			}
+		}
+		switch r.URL.Path {
+		case "/rest/v1/products":
+			writeTestJSON(w, http.StatusOK, []map[string]interface{}{
+				{"id": 1, "code": "P1", "name": "Cám", "unit": "kg", "stock": f.stock, "created_at": time.Now()},
+			})
+		case "/rest/v1/product_units":
+			writeTestJSON(w, http.StatusOK, f.units)
+		default:
+			writeTestJSON(w, http.StatusNotFound, map[string]string{"msg": "not found"})
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeProductREST) app() *fiber.App {
+	db, _ := database.NewSupabaseClient(&config.Config{SupabaseURL: f.URL, SupabaseAnonKey: "service"})
+	app := fiber.New()
+	app.Use(middleware.Databases(db, db))
+	app.Post("/products", CreateProduct(inventory.NewLedger(db)))
+	app.Put("/products/:id", UpdateProduct(inventory.NewLedger(db)))
+	return app
+}
+
+func (f *fakeProductREST) send(t *testing.T, method, path, body string) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.app().Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestUpdateProductBaseUnit(t *testing.T) {
+	t.Run("renamed with the product", func(t *testing.T) {
+		f := newFakeProductREST(t)
+		if status := f.send(t, http.MethodPut, "/products/1", `{"unit":"bao"}`); status != fiber.StatusOK {
+			t.Fatalf("PUT = %d, want 200", status)
+		}
+		want := "PATCH /rest/v1/products,PATCH /rest/v1/product_units"
+		if got := strings.Join(f.changes, ","); got != want {
+			t.Errorf("changes = %s, want %s", got, want)
+		}
+	})
+
+	t.Run("unchanged unit", func(t *testing.T) {
+		f := newFakeProductREST(t)
+		f.stock = 10
+		if status := f.send(t, http.MethodPut, "/products/1", `{"unit":"kg","name":"Cám gà"}`); status != fiber.StatusOK {
+			t.Fatalf("PUT = %d, want 200", status)
+		}
+		if got := strings.Join(f.changes, ","); got != "PATCH /rest/v1/products" {
+			t.Errorf("changes = %s, want only the product", got)
+		}
+	})
+
+	t.Run("product has stock", func(t *testing.T) {
+		f := newFakeProductREST(t)
+		f.stock = 10
+		if status := f.send(t, http.MethodPut, "/products/1", `{"unit":"bao"}`); status != fiber.StatusConflict {
+			t.Errorf("PUT = %d, want 409", status)
+		}
+		if len(f.changes) != 0 {
+			t.Errorf("changes = %v, want none", f.changes)
+		}
+	})
+
+	t.Run("product has other units", func(t *testing.T) {
+		f := newFakeProductREST(t)
+		f.units = append(f.units, map[string]interface{}{"id": "u-bao", "product_id": 1, "unit": "bao", "factor": 25, "is_base": false})
+		if status := f.send(t, http.MethodPut, "/products/1", `{"unit":"tấn"}`); status != fiber.StatusConflict {
+			t.Errorf("PUT = %d, want 409", status)
+		}
+		if len(f.changes) != 0 {
+			t.Errorf("changes = %v, want none", f.changes)
+		}
+	})
+
+	t.Run("rename fails", func(t *testing.T) {
+		f := newFakeProductREST(t)
+		f.failUnits = true
+		if status := f.send(t, http.MethodPut, "/products/1", `{"unit":"bao"}`); status != fiber.StatusInternalServerError {
+			t.Errorf("PUT = %d, want 500", status)
+		}
+	})
+}
+
+// TestCreateProductRollback checks that a product whose base unit or
+// opening stock fails is removed again
+func TestCreateProductRollback(t *testing.T) {
+	t.Run("base unit fails", func(t *testing.T) {
+		f := newFakeProductREST(t)
+		f.failUnits = true
+		if status := f.send(t, http.MethodPost, "/products", `{"code":"P1","name":"Cám","stock":5}`); status != fiber.StatusInternalServerError {
+			t.Errorf("POST = %d, want 500", status)
+		}
+		want := "POST /rest/v1/products,POST /rest/v1/product_units,DELETE /rest/v1/products"
+		if got := strings.Join(f.changes, ","); got != want {
+			t.Errorf("changes = %s, want %s", got, want)
+		}
+	})
+
+	t.Run("opening stock fails", func(t *testing.T) {
+		f := newFakeProductREST(t)
+		if status := f.send(t, http.MethodPost, "/products", `{"code":"P1","name":"Cám","stock":5}`); status != fiber.StatusInternalServerError {
+			t.Errorf("POST = %d, want 500", status)
+		}
+		want := "POST /rest/v1/products,POST /rest/v1/product_units,POST /rest/v1/rpc/post_stock_movement,DELETE /rest/v1/products"
+		if got := strings.Join(f.changes, ","); got != want {
+			t.Errorf("changes = %s, want %s", got, want)
+		}
+	})
+}
@@ -1,21 +1,158 @@
 package handlers
 
 import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/appejv/appejv-api/internal/accounts"
+	"github.com/appejv/appejv-api/internal/audit"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/media"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/internal/storage"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
-// GetProfile returns current user profile
-func GetProfile() fiber.Handler {
+// avatarPrefix is where avatars are stored, one folder per user
+const avatarPrefix = "avatars/"
+
+// GetProfile returns the caller's profile with their email, team and
+// manager
+func GetProfile(users *accounts.Accounts) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		profile := c.Locals("user_profile")
-		if profile == nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "User profile not found",
-			})
+		profile, err := users.Profile(middleware.Subject(c).ID)
+		if err != nil {
+			return accountError(c, err)
 		}
+		profile.Email, _ = c.Locals("user_email").(string)
 
 		return c.JSON(fiber.Map{
 			"data": profile,
 		})
 	}
 }
+
+// UpdateProfile changes the caller's name, phone and notification setting
+func UpdateProfile(users *accounts.Accounts, profiles *middleware.ProfileCache, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.UpdateProfileRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		id := middleware.Subject(c).ID
+		before, after, err := users.UpdateProfile(c.Context(), id, input)
+		if err != nil {
+			return accountError(c, err)
+		}
+		profiles.Invalidate(id)
+		if changes := audit.Diff(profileFields(before), profileFields(after)); len(changes) > 0 {
+			recordAudit(c, auditLog, "profile_update", audit.EntityUser, id, changes)
+		} else {
+			// Nothing to record
+			middleware.MarkAudited(c)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": after,
+		})
+	}
+}
+
+// UploadAvatar replaces the caller's avatar. Expects multipart/form-data
+// with the image in the "avatar" field; only a square, resized copy is
+// kept.
+func UploadAvatar(users *accounts.Accounts, store storage.Storage, maxSize int64, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		fh, err := c.FormFile("avatar")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Expected multipart/form-data with an avatar field",
+			})
+		}
+		img, err := readImage(fh, maxSize)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		data, err := img.Render(media.Avatar)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		id := middleware.Subject(c).ID
+		path := fmt.Sprintf("%s%s/%s.jpg", avatarPrefix, id, uuid.NewString())
+		if err := store.Put(path, data, "image/jpeg"); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to store avatar: " + err.Error(),
+			})
+		}
+
+		url := store.PublicURL(path)
+		before, after, err := users.SetAvatar(id, &url)
+		if err != nil {
+			store.Delete(path)
+			return accountError(c, err)
+		}
+		deleteAvatar(store, id, before.AvatarURL)
+		recordAudit(c, auditLog, "profile_avatar_update", audit.EntityUser, id, audit.Diff(profileFields(before), profileFields(after)))
+
+		return c.JSON(fiber.Map{
+			"data": after,
+		})
+	}
+}
+
+// DeleteAvatar removes the caller's avatar
+func DeleteAvatar(users *accounts.Accounts, store storage.Storage, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := middleware.Subject(c).ID
+		before, after, err := users.SetAvatar(id, nil)
+		if err != nil {
+			return accountError(c, err)
+		}
+		if before.AvatarURL != nil {
+			deleteAvatar(store, id, before.AvatarURL)
+			recordAudit(c, auditLog, "profile_avatar_delete", audit.EntityUser, id, audit.Diff(profileFields(before), profileFields(after)))
+		} else {
+			middleware.MarkAudited(c)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": after,
+		})
+	}
+}
+
+// deleteAvatar removes a user's replaced avatar from storage. Avatars
+// stored elsewhere, e.g. URLs set before uploads existed, are left alone.
+func deleteAvatar(store storage.Storage, userID string, url *string) {
+	if url == nil {
+		return
+	}
+	folder := avatarPrefix + userID + "/"
+	base := store.PublicURL(folder)
+	if !strings.HasPrefix(*url, base) {
+		return
+	}
+	if err := store.Delete(folder + strings.TrimPrefix(*url, base)); err != nil {
+		log.Printf("deleting replaced avatar %s: %v", *url, err)
+	}
+}
+
+// profileFields are the audited fields a user edits themselves
+func profileFields(p models.Profile) map[string]interface{} {
+	return map[string]interface{}{
+		"full_name":             p.FullName,
+		"phone":                 p.Phone,
+		"avatar_url":            p.AvatarURL,
+		"notifications_enabled": p.NotificationsEnabled,
+	}
+}
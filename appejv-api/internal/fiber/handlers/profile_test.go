@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appejv/appejv-api/internal/media"
+	"github.com/appejv/appejv-api/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+// pngHeader is the start of a PNG claiming w×h greyscale pixels, without
+// the pixels
+func pngHeader(w, h uint32) []byte {
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:], w)
+	binary.BigEndian.PutUint32(ihdr[4:], h)
+	ihdr[8] = 8
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr[:]...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+// TestUploadAvatarRejectsBomb checks that an avatar claiming huge
+// dimensions is refused before it is decoded and nothing is stored
+func TestUploadAvatarRejectsBomb(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewLocalStorage(dir, "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/profile/avatar", func(c *fiber.Ctx) error {
+		c.Locals("user_id", "user-1")
+		c.Locals("user_role", "sale")
+		return c.Next()
+	}, UploadAvatar(nil, store, 1<<20, nil))
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("avatar", "bomb.png")
+	part.Write(pngHeader(100_000, 100_000))
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/profile/avatar", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusBadRequest || !strings.Contains(string(data), media.ErrTooManyPixels.Error()) {
+		t.Errorf("upload = %d %s, want 400 with %q", resp.StatusCode, data, media.ErrTooManyPixels)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("a rejected avatar left %d entries in storage", len(entries))
+	}
+}
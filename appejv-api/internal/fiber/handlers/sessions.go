@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/appejv/appejv-api/internal/audit"
+	"github.com/appejv/appejv-api/internal/auth"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetMySessions lists the caller's sessions, marking the current one
+func GetMySessions(sessions *auth.Sessions) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := middleware.APIKey(c); ok {
+			return noSessions(c)
+		}
+
+		list, err := sessions.List(c.Context(), middleware.Subject(c).ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		current, _ := c.Locals("session_id").(string)
+		for i := range list {
+			list[i].Current = list[i].ID == current
+		}
+
+		return c.JSON(fiber.Map{
+			"data": list,
+		})
+	}
+}
+
+// RevokeMySession signs one of the caller's sessions out; its tokens stop
+// working at once
+func RevokeMySession(sessions *auth.Sessions, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := middleware.APIKey(c); ok {
+			return noSessions(c)
+		}
+
+		id := c.Params("id")
+		userID := middleware.Subject(c).ID
+		if err := sessions.Revoke(c.Context(), userID, id, userID); err != nil {
+			if errors.Is(err, auth.ErrSessionNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		recordAudit(c, auditLog, "session_revoke", audit.EntitySession, id, nil)
+
+		return c.JSON(fiber.Map{
+			"message": "Session revoked",
+		})
+	}
+}
+
+// RevokeUserSessions signs a user out everywhere, e.g. when they leave the
+// company. It does not stop them signing in again; deactivate the account
+// for that.
+func RevokeUserSessions(sessions *auth.Sessions, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		count, err := sessions.RevokeAll(c.Context(), id, middleware.Subject(c).ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		recordAudit(c, auditLog, "user_sessions_revoke", audit.EntityUser, id, map[string]audit.Change{
+			"sessions": {From: count, To: 0},
+		})
+
+		return c.JSON(fiber.Map{
+			"data": fiber.Map{
+				"revoked": count,
+			},
+		})
+	}
+}
+
+// noSessions refuses API keys, which act for a user but are not one of
+// their sessions
+func noSessions(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "API keys have no sessions",
+	})
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetStocktakes lists stocktakes of the caller's warehouses, newest first.
+// Optional query: status.
+func GetStocktakes(stocktakes *inventory.Stocktakes, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scope, err := warehouseScope(c, warehouses)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		list, err := stocktakes.List(scope, c.Query("status"))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": list,
+		})
+	}
+}
+
+// CreateStocktake opens a stocktake for a warehouse, snapshotting the
+// quantities the ledger expects to be on the shelves
+func CreateStocktake(stocktakes *inventory.Stocktakes, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.CreateStocktakeRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		warehouseID, ferr := resolveWarehouse(c, warehouses, input.WarehouseID)
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+		if warehouseID == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "warehouse_id is required",
+			})
+		}
+
+		scope, err := warehouseScope(c, warehouses)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		stocktake, err := stocktakes.Start(c.Context(), warehouseID, stringValue(input.Note), userID, scope)
+		if err != nil {
+			return stocktakeError(c, err)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": stocktake,
+		})
+	}
+}
+
+// GetStocktake returns a stocktake with its lines. Query only_variances=true
+// keeps counted lines that differ from the snapshot.
+func GetStocktake(stocktakes *inventory.Stocktakes, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		stocktake, err := scopedStocktake(c, stocktakes, warehouses, c.Params("id"))
+		if err != nil {
+			return stocktakeError(c, err)
+		}
+
+		if c.QueryBool("only_variances") {
+			lines := make([]models.StocktakeLine, 0, len(stocktake.Lines))
+			for _, line := range stocktake.Lines {
+				if line.Variance != nil && *line.Variance != 0 {
+					lines = append(lines, line)
+				}
+			}
+			stocktake.Lines = lines
+		}
+
+		return c.JSON(fiber.Map{
+			"data": stocktake,
+		})
+	}
+}
+
+// SubmitStocktakeCounts records counts for an open stocktake. Each count
+// names the product by product_id or by a scanned barcode; several counters
+// and locations add up to the counted quantity.
+func SubmitStocktakeCounts(stocktakes *inventory.Stocktakes, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.StocktakeCountRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if len(input.Counts) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "counts is required",
+			})
+		}
+
+		stocktake, err := scopedStocktake(c, stocktakes, warehouses, c.Params("id"))
+		if err != nil {
+			return stocktakeError(c, err)
+		}
+		if stocktake.Status != inventory.StocktakeOpen {
+			return stocktakeError(c, inventory.ErrStocktakeClosed)
+		}
+
+		scope, err := warehouseScope(c, warehouses)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		lines := make([]models.StocktakeLine, 0, len(input.Counts))
+		for i, count := range input.Counts {
+			if count.Quantity < 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "quantity must not be negative",
+					"line":  i,
+				})
+			}
+
+			source := "manual"
+			if count.Barcode != "" {
+				source = "barcode"
+				count.ProductID, err = stocktakes.ResolveBarcode(count.Barcode)
+				if err != nil {
+					return stocktakeLineError(c, err, i)
+				}
+			}
+			if count.ProductID == 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "product_id or barcode is required",
+					"line":  i,
+				})
+			}
+
+			line, err := stocktakes.RecordCount(c.Context(), stocktake.ID, count, source, userID, scope)
+			if err != nil {
+				return stocktakeLineError(c, err, i)
+			}
+			lines = append(lines, line)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": lines,
+		})
+	}
+}
+
+// ApproveStocktake posts the variances as count_correction adjustments and
+// closes the stocktake (admin, sale_admin)
+func ApproveStocktake(stocktakes *inventory.Stocktakes, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		stocktake, err := scopedStocktake(c, stocktakes, warehouses, c.Params("id"))
+		if err != nil {
+			return stocktakeError(c, err)
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		role, _ := c.Locals("user_role").(string)
+		stocktake, err = stocktakes.Approve(c.Context(), stocktake.ID, userID, role)
+		if err != nil {
+			return stocktakeError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": stocktake,
+		})
+	}
+}
+
+// CancelStocktake discards an open stocktake without touching stock
+func CancelStocktake(stocktakes *inventory.Stocktakes, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		stocktake, err := scopedStocktake(c, stocktakes, warehouses, c.Params("id"))
+		if err != nil {
+			return stocktakeError(c, err)
+		}
+
+		stocktake, err = stocktakes.Cancel(stocktake.ID)
+		if err != nil {
+			return stocktakeError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": stocktake,
+		})
+	}
+}
+
+// GetShrinkageReport summarises stock lost and found through count
+// corrections, damage, expiry and loss. Query: from, to (YYYY-MM-DD,
+// default the last 12 months), period (day|week|month|quarter|year,
+// default month), warehouse_id.
+func GetShrinkageReport(stocktakes *inventory.Stocktakes, warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		to := time.Now()
+		if v := c.Query("to"); v != "" {
+			t, err := time.Parse(inventory.DateLayout, v)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "to must be a date (YYYY-MM-DD)",
+				})
+			}
+			to = t.AddDate(0, 0, 1)
+		}
+		from := to.AddDate(-1, 0, 0)
+		if v := c.Query("from"); v != "" {
+			t, err := time.Parse(inventory.DateLayout, v)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "from must be a date (YYYY-MM-DD)",
+				})
+			}
+			from = t
+		}
+		if !from.Before(to) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "from must be before to",
+			})
+		}
+
+		period := c.Query("period", "month")
+		if !inventory.ShrinkagePeriods[period] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "period must be one of day, week, month, quarter, year",
+			})
+		}
+
+		warehouseIDs, ferr := warehouseFilter(c, warehouses, c.Query("warehouse_id"))
+		if ferr != nil {
+			return c.Status(ferr.Code).JSON(fiber.Map{
+				"error": ferr.Message,
+			})
+		}
+
+		rows, err := stocktakes.Shrinkage(c.Context(), from, to, period, warehouseIDs)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		type total struct {
+			Lost  int `json:"lost"`
+			Found int `json:"found"`
+		}
+		byProduct := map[int]*total{}
+		byPeriod := map[string]*total{}
+		for _, row := range rows {
+			if byProduct[row.ProductID] == nil {
+				byProduct[row.ProductID] = &total{}
+			}
+			if byPeriod[row.Period] == nil {
+				byPeriod[row.Period] = &total{}
+			}
+			byProduct[row.ProductID].Lost += row.Lost
+			byProduct[row.ProductID].Found += row.Found
+			byPeriod[row.Period].Lost += row.Lost
+			byPeriod[row.Period].Found += row.Found
+		}
+
+		return c.JSON(fiber.Map{
+			"data": rows,
+			"totals": fiber.Map{
+				"by_product": byProduct,
+				"by_period":  byPeriod,
+			},
+			"from":   from.Format(inventory.DateLayout),
+			"to":     to.AddDate(0, 0, -1).Format(inventory.DateLayout),
+			"period": period,
+		})
+	}
+}
+
+// scopedStocktake loads a stocktake and checks the caller may act on its
+// warehouse
+func scopedStocktake(c *fiber.Ctx, stocktakes *inventory.Stocktakes, warehouses *inventory.Warehouses, id string) (models.Stocktake, error) {
+	stocktake, err := stocktakes.Get(id)
+	if err != nil {
+		return models.Stocktake{}, err
+	}
+
+	scope, err := warehouseScope(c, warehouses)
+	if err != nil {
+		return models.Stocktake{}, err
+	}
+	if !scope.Allows(stocktake.WarehouseID) {
+		return models.Stocktake{}, inventory.ErrStocktakeNotFound
+	}
+	return stocktake, nil
+}
+
+func stocktakeLineError(c *fiber.Ctx, err error, line int) error {
+	if errors.Is(err, inventory.ErrUnknownBarcode) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+			"line":  line,
+		})
+	}
+	return stocktakeError(c, err)
+}
+
+func stocktakeError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, inventory.ErrStocktakeNotFound), errors.Is(err, inventory.ErrUnknownBarcode):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, inventory.ErrNotApprover):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, inventory.ErrStocktakeOpen), errors.Is(err, inventory.ErrStocktakeClosed):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	default:
+		return warehouseError(c, err)
+	}
+}
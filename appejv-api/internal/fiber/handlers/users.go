@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+
+	"github.com/appejv/appejv-api/internal/accounts"
+	"github.com/appejv/appejv-api/internal/audit"
+	"github.com/appejv/appejv-api/internal/auth"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/internal/policy"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// GetUsers lists users. Filters: role, q (name, email or phone),
+// include_deactivated, limit (default 50, max 200), offset. A sale_admin
+// only sees themselves and the users they manage.
+func GetUsers(users *accounts.Accounts) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+		filter := accounts.Filter{
+			Role:               c.Query("role"),
+			Search:             c.Query("q"),
+			IncludeDeactivated: c.QueryBool("include_deactivated"),
+			Limit:              limit,
+			Offset:             c.QueryInt("offset", 0),
+		}
+		if subject := middleware.Subject(c); subject.Role != policy.RoleAdmin {
+			filter.ManagerID = subject.ID
+		}
+
+		list, err := users.List(c.Context(), filter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": list,
+		})
+	}
+}
+
+// GetUser returns a user with their email, team and account state
+func GetUser(users *accounts.Accounts) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := users.Get(c.Context(), c.Params("id"))
+		if err != nil {
+			return accountError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": user,
+		})
+	}
+}
+
+// CreateUser creates a user with a password, role, manager and team
+func CreateUser(users *accounts.Accounts, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.CreateUserRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+		if ok, err := limitNewUser(c, users, input.Role, &input.ManagerID, input.TeamID); !ok {
+			return err
+		}
+
+		user, err := users.Create(c.Context(), input)
+		if err != nil {
+			return accountError(c, err)
+		}
+		recordAudit(c, auditLog, "user_create", audit.EntityUser, user.ID, audit.Diff(nil, accountFields(user)))
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": user,
+		})
+	}
+}
+
+// limitNewUser keeps a sale_admin to adding sales and customers they manage,
+// in teams they manage. It answers the request and returns false when the
+// user may not be added.
+func limitNewUser(c *fiber.Ctx, users *accounts.Accounts, role string, managerID **string, teamID *string) (bool, error) {
+	subject := middleware.Subject(c)
+	if subject.Role != policy.RoleSaleAdmin {
+		return true, nil
+	}
+	if role != policy.RoleSale && role != policy.RoleCustomer {
+		return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "sale_admin can only add sale and customer users",
+		})
+	}
+	*managerID = &subject.ID
+	if teamID != nil && *teamID != "" {
+		manager, err := users.TeamManager(*teamID)
+		if err != nil {
+			return false, accountError(c, err)
+		}
+		if manager != subject.ID {
+			return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You can only add users to teams you manage",
+			})
+		}
+	}
+	return true, nil
+}
+
+// UpdateUser changes a user's name and phone, and, for admins, their role,
+// manager and team. Changes apply on the user's next request.
+func UpdateUser(users *accounts.Accounts, engine *policy.Engine, profiles *middleware.ProfileCache, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.UpdateUserRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		id := c.Params("id")
+		subject := middleware.Subject(c)
+		if input.Role != nil || input.ManagerID != nil || input.TeamID != nil {
+			if err := engine.Authorize(subject, policy.Assign, policy.Resource{Kind: policy.Profile, ID: id}); err != nil {
+				return middleware.PolicyError(c, err)
+			}
+			if input.Role != nil && id == subject.ID {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "You cannot change your own role",
+				})
+			}
+		}
+
+		before, after, err := users.Update(c.Context(), id, input)
+		if err != nil {
+			return accountError(c, err)
+		}
+		profiles.Invalidate(id)
+
+		action := "user_update"
+		if before.Role != after.Role {
+			action = "role_change"
+		}
+		if changes := audit.Diff(accountFields(before), accountFields(after)); len(changes) > 0 {
+			recordAudit(c, auditLog, action, audit.EntityUser, id, changes)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": after,
+		})
+	}
+}
+
+// DeactivateUser bans a user's account and refuses their tokens from the
+// next request on
+func DeactivateUser(users *accounts.Accounts, profiles *middleware.ProfileCache, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return setUserActive(c, users, profiles, auditLog, false)
+	}
+}
+
+// ReactivateUser lifts a deactivation
+func ReactivateUser(users *accounts.Accounts, profiles *middleware.ProfileCache, auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return setUserActive(c, users, profiles, auditLog, true)
+	}
+}
+
+func setUserActive(c *fiber.Ctx, users *accounts.Accounts, profiles *middleware.ProfileCache, auditLog *audit.Log, active bool) error {
+	id := c.Params("id")
+	if !active && id == middleware.Subject(c).ID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "You cannot deactivate your own account",
+		})
+	}
+
+	before, after, err := users.SetActive(c.Context(), id, active)
+	if err != nil {
+		return accountError(c, err)
+	}
+	profiles.Invalidate(id)
+
+	if changes := audit.Diff(accountFields(before), accountFields(after)); len(changes) > 0 {
+		action := "user_deactivate"
+		if active {
+			action = "user_reactivate"
+		}
+		recordAudit(c, auditLog, action, audit.EntityUser, id, changes)
+	}
+
+	return c.JSON(fiber.Map{
+		"data": after,
+	})
+}
+
+// accountFields are the audited fields of a user
+func accountFields(u models.UserAccount) map[string]interface{} {
+	return map[string]interface{}{
+		"email":       u.Email,
+		"full_name":   u.FullName,
+		"role":        u.Role,
+		"phone":       u.Phone,
+		"manager_id":  u.ManagerID,
+		"team_id":     u.TeamID,
+		"deactivated": u.DeactivatedAt != nil,
+	}
+}
+
+// recordAudit records a change made by the caller. The change has already
+// happened, so a failure is only logged. The entry is written after the
+// request, so the strings it takes from the request are copied.
+func recordAudit(c *fiber.Ctx, auditLog *audit.Log, action, entityType, entityID string, changes map[string]audit.Change) {
+	recordAuditAs(c, auditLog, middleware.Subject(c), action, entityType, entityID, changes)
+}
+
+// recordAuditAs records a change made by actor, for requests without a
+// signed-in caller
+func recordAuditAs(c *fiber.Ctx, auditLog *audit.Log, actor policy.Subject, action, entityType, entityID string, changes map[string]audit.Change) {
+	entry := audit.Entry{
+		ActorID:    actor.ID,
+		ActorRole:  actor.Role,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   utils.CopyString(entityID),
+		Changes:    changes,
+		IPAddress:  utils.CopyString(c.IP()),
+		Method:     utils.CopyString(c.Method()),
+		Route:      c.Route().Path,
+	}
+	if key, ok := middleware.APIKey(c); ok {
+		entry.APIKeyID = key.ID
+	}
+	middleware.MarkAudited(c)
+	if err := auditLog.Record(entry); err != nil {
+		log.Printf("audit: recording %s on %s %s: %v", action, entityType, entityID, err)
+	}
+}
+
+func accountError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, accounts.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, auth.ErrEmailExists),
+		errors.Is(err, accounts.ErrPhoneInUse):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, accounts.ErrInvalidRole),
+		errors.Is(err, accounts.ErrInvalidManager),
+		errors.Is(err, accounts.ErrInvalidTeam),
+		errors.Is(err, accounts.ErrTeamNotSale),
+		errors.Is(err, accounts.ErrInvalidEmail),
+		errors.Is(err, accounts.ErrInvalidName),
+		errors.Is(err, accounts.ErrInvalidPhone),
+		errors.Is(err, auth.ErrWeakPassword):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var gerr *auth.GoTrueError
+	if errors.As(err, &gerr) {
+		return goTrueError(c, err)
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,616 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+var warehouseStatuses = map[string]bool{"active": true, "inactive": true}
+
+// GetWarehouses lists the warehouses visible to the caller. Warehouse staff
+// only see the depots they are assigned to.
+func GetWarehouses(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scope, err := warehouseScope(c, warehouses)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		list, err := warehouses.List(scope)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": list,
+		})
+	}
+}
+
+// CreateWarehouse adds a depot (admin, sale_admin)
+func CreateWarehouse() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		var input models.CreateWarehouseRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		input.Code = strings.ToUpper(strings.TrimSpace(input.Code))
+		if input.Code == "" || strings.TrimSpace(input.Name) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "code and name are required",
+			})
+		}
+
+		var created []models.Warehouse
+		_, err := db.Client.From("warehouses").
+			Insert(input, false, "", "representation", "").
+			ExecuteTo(&created)
+		if err != nil || len(created) == 0 {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": errorMessage(err, "Failed to create warehouse"),
+			})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": created[0],
+		})
+	}
+}
+
+// UpdateWarehouse updates a depot (admin, sale_admin)
+func UpdateWarehouse(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		id := c.Params("id")
+
+		var input models.UpdateWarehouseRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if input.Status != nil && !warehouseStatuses[*input.Status] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "status must be active or inactive",
+			})
+		}
+
+		current, err := warehouses.Get(id)
+		if err != nil {
+			return warehouseError(c, err)
+		}
+		if current.IsDefault && input.Status != nil && *input.Status != "active" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "The default warehouse cannot be deactivated",
+			})
+		}
+		middleware.AuditBefore(c, current)
+
+		updates := map[string]interface{}{}
+		setIfPresent(updates, "name", input.Name)
+		setIfPresent(updates, "address", input.Address)
+		setIfPresent(updates, "latitude", input.Latitude)
+		setIfPresent(updates, "longitude", input.Longitude)
+		setIfPresent(updates, "status", input.Status)
+		if len(updates) == 0 {
+			return c.JSON(fiber.Map{
+				"data": current,
+			})
+		}
+
+		var updated []models.Warehouse
+		_, err = db.Client.From("warehouses").
+			Update(updates, "representation", "").
+			Eq("id", id).
+			ExecuteTo(&updated)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if len(updated) == 0 {
+			return warehouseError(c, inventory.ErrWarehouseNotFound)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": updated[0],
+		})
+	}
+}
+
+// AssignWarehouseUsers replaces the staff assigned to a depot (admin, sale_admin)
+func AssignWarehouseUsers(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		var input models.AssignWarehouseUsersRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if _, err := warehouses.Get(id); err != nil {
+			return warehouseError(c, err)
+		}
+
+		if err := warehouses.AssignUsers(id, input.UserIDs); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": fiber.Map{
+				"warehouse_id": id,
+				"user_ids":     input.UserIDs,
+			},
+		})
+	}
+}
+
+// GetWarehouseStock returns the on-hand stock of a depot
+func GetWarehouseStock(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		scope, err := warehouseScope(c, warehouses)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if !scope.Allows(id) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You are not assigned to this warehouse",
+			})
+		}
+
+		warehouse, err := warehouses.Get(id)
+		if err != nil {
+			return warehouseError(c, err)
+		}
+
+		stock, err := warehouses.Stock(id)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": fiber.Map{
+				"warehouse": warehouse,
+				"stock":     stock,
+			},
+		})
+	}
+}
+
+// GetStockTransfers lists transfers from or to the caller's warehouses.
+// Optional query: status.
+func GetStockTransfers(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scope, err := warehouseScope(c, warehouses)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		transfers, err := warehouses.ListTransfers(scope, c.Query("status"))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": transfers,
+		})
+	}
+}
+
+// GetStockTransfer returns a transfer with its items
+func GetStockTransfer(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		transfer, err := scopedTransfer(c, warehouses, c.Params("id"))
+		if err != nil {
+			return warehouseError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": transfer,
+		})
+	}
+}
+
+// CreateStockTransfer drafts a transfer between two warehouses. Warehouse
+// staff may only send from a depot they are assigned to.
+func CreateStockTransfer(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.CreateStockTransferRequest
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if input.FromWarehouseID == "" || input.ToWarehouseID == "" || len(input.Items) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "from_warehouse_id, to_warehouse_id and items are required",
+			})
+		}
+		if input.FromWarehouseID == input.ToWarehouseID {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Source and destination warehouses must differ",
+			})
+		}
+		for i, item := range input.Items {
+			if item.Quantity <= 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "quantity must be greater than zero",
+					"line":  i,
+				})
+			}
+		}
+
+		scope, err := warehouseScope(c, warehouses)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if !scope.Allows(input.FromWarehouseID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You are not assigned to the source warehouse",
+			})
+		}
+
+		for _, id := range []string{input.FromWarehouseID, input.ToWarehouseID} {
+			if _, err := warehouses.Get(id); err != nil {
+				return warehouseError(c, err)
+			}
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		transfer, err := warehouses.CreateTransfer(input, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"data": transfer,
+		})
+	}
+}
+
+// ShipStockTransfer dispatches a draft transfer, taking the goods out of the
+// source warehouse
+func ShipStockTransfer(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		transfer, err := scopedTransfer(c, warehouses, c.Params("id"))
+		if err != nil {
+			return warehouseError(c, err)
+		}
+		if !transferScopeAllows(c, warehouses, transfer.FromWarehouseID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the source warehouse can ship a transfer",
+			})
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		transfer, err = warehouses.ShipTransfer(c.Context(), transfer.ID, userID)
+		if err != nil {
+			return warehouseError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": transfer,
+		})
+	}
+}
+
+// ReceiveStockTransfer books an in-transit transfer into the destination warehouse
+func ReceiveStockTransfer(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		transfer, err := scopedTransfer(c, warehouses, c.Params("id"))
+		if err != nil {
+			return warehouseError(c, err)
+		}
+		if !transferScopeAllows(c, warehouses, transfer.ToWarehouseID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the destination warehouse can receive a transfer",
+			})
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		transfer, err = warehouses.ReceiveTransfer(c.Context(), transfer.ID, userID)
+		if err != nil {
+			return warehouseError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": transfer,
+		})
+	}
+}
+
+// CancelStockTransfer cancels a transfer that has not shipped yet
+func CancelStockTransfer(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		transfer, err := scopedTransfer(c, warehouses, c.Params("id"))
+		if err != nil {
+			return warehouseError(c, err)
+		}
+		if !transferScopeAllows(c, warehouses, transfer.FromWarehouseID) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Only the source warehouse can cancel a transfer",
+			})
+		}
+
+		transfer, err = warehouses.CancelTransfer(transfer.ID)
+		if err != nil {
+			return warehouseError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"data": transfer,
+		})
+	}
+}
+
+// AssignOrderWarehouse sets the warehouse that fulfils an order. Without a
+// warehouse_id the nearest active warehouse holding enough stock for every
+// line is chosen, based on the customer's location.
+func AssignOrderWarehouse(warehouses *inventory.Warehouses) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		db := middleware.DB(c)
+		id := c.Params("id")
+
+		var input models.AssignOrderWarehouseRequest
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&input); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid request body",
+				})
+			}
+		}
+
+		var orders []models.Order
+		_, err := db.Client.From("orders").
+			Select("*", "", false).
+			Eq("id", id).
+			Is("deleted_at", "null").
+			Limit(1, "").
+			ExecuteTo(&orders)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if len(orders) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Order not found",
+			})
+		}
+		order := orders[0]
+		if order.Status != "draft" && order.Status != "ordered" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "The warehouse can only change before the order ships",
+			})
+		}
+
+		var warehouse models.Warehouse
+		if input.WarehouseID != "" {
+			scope, err := warehouseScope(c, warehouses)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			if !scope.Allows(input.WarehouseID) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": "You are not assigned to this warehouse",
+				})
+			}
+
+			warehouse, err = warehouses.Get(input.WarehouseID)
+			if err == nil && warehouse.Status != "active" {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "Warehouse is inactive",
+				})
+			}
+		} else {
+			warehouse, err = nearestWarehouseForOrder(db, warehouses, order)
+		}
+		if err != nil {
+			return warehouseError(c, err)
+		}
+
+		var updated []models.Order
+		_, err = db.Client.From("orders").
+			Update(map[string]interface{}{"warehouse_id": warehouse.ID}, "representation", "").
+			Eq("id", id).
+			ExecuteTo(&updated)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		if len(updated) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Order not found",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data": fiber.Map{
+				"order":     updated[0],
+				"warehouse": warehouse,
+			},
+		})
+	}
+}
+
+func nearestWarehouseForOrder(db *database.Database, warehouses *inventory.Warehouses, order models.Order) (models.Warehouse, error) {
+	var items []models.OrderItem
+	_, err := db.Client.From("order_items").
+		Select("product_id, base_quantity", "", false).
+		Eq("order_id", order.ID).
+		ExecuteTo(&items)
+	if err != nil {
+		return models.Warehouse{}, err
+	}
+
+	needs := make(map[int]int, len(items))
+	for _, item := range items {
+		needs[item.ProductID] += item.BaseQuantity
+	}
+
+	var lat, lng *float64
+	if order.CustomerID != nil {
+		var customers []struct {
+			Latitude  *float64 `json:"latitude"`
+			Longitude *float64 `json:"longitude"`
+		}
+		_, err = db.Client.From("customers").
+			Select("latitude, longitude", "", false).
+			Eq("id", *order.CustomerID).
+			Limit(1, "").
+			ExecuteTo(&customers)
+		if err != nil {
+			return models.Warehouse{}, err
+		}
+		if len(customers) > 0 {
+			lat, lng = customers[0].Latitude, customers[0].Longitude
+		}
+	}
+
+	return warehouses.Nearest(lat, lng, needs)
+}
+
+// resolveWarehouse picks the warehouse a stock movement is posted to. An
+// explicit id must be within the caller's scope; otherwise warehouse staff
+// with a single assignment use it and everyone else gets the default
+// warehouse (empty id).
+func resolveWarehouse(c *fiber.Ctx, warehouses *inventory.Warehouses, requested string) (string, *fiber.Error) {
+	scope, err := warehouseScope(c, warehouses)
+	if err != nil {
+		return "", fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	switch {
+	case requested != "":
+		if !scope.Allows(requested) {
+			return "", fiber.NewError(fiber.StatusForbidden, "You are not assigned to this warehouse")
+		}
+		return requested, nil
+	case scope.All:
+		return "", nil
+	case len(scope.IDs) == 1:
+		return scope.IDs[0], nil
+	case len(scope.IDs) == 0:
+		return "", fiber.NewError(fiber.StatusForbidden, "You are not assigned to any warehouse")
+	default:
+		return "", fiber.NewError(fiber.StatusBadRequest, "warehouse_id is required")
+	}
+}
+
+// warehouseFilter returns the warehouses a listing is limited to: the
+// requested one if the caller may see it, else the caller's assignments (nil
+// means all warehouses)
+func warehouseFilter(c *fiber.Ctx, warehouses *inventory.Warehouses, requested string) ([]string, *fiber.Error) {
+	scope, err := warehouseScope(c, warehouses)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	switch {
+	case requested != "":
+		if !scope.Allows(requested) {
+			return nil, fiber.NewError(fiber.StatusForbidden, "You are not assigned to this warehouse")
+		}
+		return []string{requested}, nil
+	case scope.All:
+		return nil, nil
+	case len(scope.IDs) == 0:
+		return nil, fiber.NewError(fiber.StatusForbidden, "You are not assigned to any warehouse")
+	default:
+		return scope.IDs, nil
+	}
+}
+
+func warehouseScope(c *fiber.Ctx, warehouses *inventory.Warehouses) (inventory.Scope, error) {
+	userID, _ := c.Locals("user_id").(string)
+	role, _ := c.Locals("user_role").(string)
+	scope, err := warehouses.ScopeFor(userID, role)
+	if err != nil {
+		return inventory.Scope{}, err
+	}
+
+	// An API key limited to a warehouse acts on that one only
+	if limits := middleware.Subject(c).Limits; limits != nil && limits.WarehouseID != "" {
+		if !scope.Allows(limits.WarehouseID) {
+			return inventory.Scope{IDs: []string{}}, nil
+		}
+		return inventory.Scope{IDs: []string{limits.WarehouseID}}, nil
+	}
+	return scope, nil
+}
+
+// scopedTransfer loads a transfer the caller may see: one touching any of
+// their warehouses
+func scopedTransfer(c *fiber.Ctx, warehouses *inventory.Warehouses, id string) (models.StockTransfer, error) {
+	transfer, err := warehouses.GetTransfer(id)
+	if err != nil {
+		return models.StockTransfer{}, err
+	}
+
+	scope, err := warehouseScope(c, warehouses)
+	if err != nil {
+		return models.StockTransfer{}, err
+	}
+	if !scope.Allows(transfer.FromWarehouseID) && !scope.Allows(transfer.ToWarehouseID) {
+		return models.StockTransfer{}, inventory.ErrTransferNotFound
+	}
+	return transfer, nil
+}
+
+func transferScopeAllows(c *fiber.Ctx, warehouses *inventory.Warehouses, warehouseID string) bool {
+	scope, err := warehouseScope(c, warehouses)
+	return err == nil && scope.Allows(warehouseID)
+}
+
+func warehouseError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, inventory.ErrWarehouseNotFound), errors.Is(err, inventory.ErrTransferNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, inventory.ErrInvalidTransfer), errors.Is(err, inventory.ErrNoWarehouseInStock):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	default:
+		return ledgerError(c, err)
+	}
+}
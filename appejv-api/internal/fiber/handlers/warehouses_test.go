@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/appejv/appejv-api/internal/config"
+	"github.com/appejv/appejv-api/internal/fiber/middleware"
+	"github.com/appejv/appejv-api/internal/inventory"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeWarehousesREST serves warehouses w-1 and w-2, an ordered order o-1 and
+// a warehouse user assigned to w-1. Updates match no rows, as when the row
+// is deleted or hidden by RLS in between.
+func fakeWarehousesREST(t *testing.T, patched *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			*patched++
+			writeTestJSON(w, http.StatusOK, []interface{}{})
+			return
+		}
+		switch r.URL.Path {
+		case "/rest/v1/warehouse_users":
+			writeTestJSON(w, http.StatusOK, []map[string]string{{"warehouse_id": "w-1"}})
+		case "/rest/v1/warehouses":
+			id := strings.TrimPrefix(r.URL.Query().Get("id"), "eq.")
+			writeTestJSON(w, http.StatusOK, []map[string]interface{}{{"id": id, "code": id, "name": id, "status": "active"}})
+		case "/rest/v1/orders":
+			writeTestJSON(w, http.StatusOK, []map[string]interface{}{{"id": "o-1", "status": "ordered"}})
+		default:
+			writeTestJSON(w, http.StatusNotFound, map[string]string{"msg": "not found"})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newWarehousesApp(server *httptest.Server, role string) *fiber.App {
+	db, _ := database.NewSupabaseClient(&config.Config{SupabaseURL: server.URL, SupabaseAnonKey: "service"})
+	warehouses := inventory.NewWarehouses(db, inventory.NewLedger(db))
+
+	app := fiber.New()
+	app.Use(middleware.Databases(db, db), func(c *fiber.Ctx) error {
+		c.Locals("user_id", "user-1")
+		c.Locals("user_role", role)
+		return c.Next()
+	})
+	app.Put("/warehouses/:id", UpdateWarehouse(warehouses))
+	app.Post("/orders/:id/fulfilment-warehouse", AssignOrderWarehouse(warehouses))
+	return app
+}
+
+func TestAssignOrderWarehouseScope(t *testing.T) {
+	var patched int
+	app := newWarehousesApp(fakeWarehousesREST(t, &patched), "warehouse")
+
+	req := httptest.NewRequest(http.MethodPost, "/orders/o-1/fulfilment-warehouse", strings.NewReader(`{"warehouse_id":"w-2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("assigning another warehouse's order = %d, want 403", resp.StatusCode)
+	}
+	if patched != 0 {
+		t.Error("the order was updated with a warehouse outside the caller's scope")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/orders/o-1/fulfilment-warehouse", strings.NewReader(`{"warehouse_id":"w-1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound || patched != 1 {
+		t.Errorf("assigning an order that is gone = %d after %d updates, want 404 after 1", resp.StatusCode, patched)
+	}
+}
+
+func TestUpdateWarehouseGone(t *testing.T) {
+	var patched int
+	app := newWarehousesApp(fakeWarehousesREST(t, &patched), "admin")
+
+	req := httptest.NewRequest(http.MethodPut, "/warehouses/w-1", strings.NewReader(`{"name":"Kho 1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("updating a warehouse that is gone = %d, want 404", resp.StatusCode)
+	}
+}
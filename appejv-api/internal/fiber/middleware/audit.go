@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/appejv/appejv-api/internal/audit"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// auditedResource is what a route's first segment changes: the entity type
+// recorded and the table its row is read from for the diff
+type auditedResource struct {
+	entityType string
+	table      string
+}
+
+// auditedResources are the resources whose second path segment is a row
+// id. Changes to other resources are recorded with the response body as
+// their diff.
+var auditedResources = map[string]auditedResource{
+	"customers":   {"customer", "customers"},
+	"orders":      {"order", "orders"},
+	"products":    {"product", "products"},
+	"warehouses":  {"warehouse", "warehouses"},
+	"transfers":   {"stock_transfer", "stock_transfers"},
+	"stocktakes":  {"stocktake", "stocktakes"},
+	"users":       {audit.EntityUser, "profiles"},
+	"invitations": {audit.EntityInvitation, "invitations"},
+	"api-keys":    {audit.EntityAPIKey, "api_keys"},
+}
+
+// Audit records every successful POST, PUT, PATCH and DELETE in the audit
+// log: who made it, from where, on which route, and how the changed row
+// differs before and after. The row is read after the response is sent;
+// handlers that load it before changing it pass it to AuditBefore, and
+// otherwise only the row after the change is recorded. Handlers that
+// record their own, more specific entry call MarkAudited so the change is
+// not recorded twice. Failed requests changed nothing and are not
+// recorded.
+func Audit(auditLog *audit.Log) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		default:
+			return c.Next()
+		}
+
+		// Strings from the request are reused once the handler returns, so
+		// what the entry keeps is copied
+		resource, entityID := auditTarget(c.Path())
+		entityID = utils.CopyString(entityID)
+		db := ServiceDB(c)
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+		status := c.Response().StatusCode()
+		if status >= fiber.StatusBadRequest || c.Locals("audit_recorded") != nil {
+			return nil
+		}
+
+		actor := Subject(c)
+		entry := audit.Entry{
+			ActorID:    actor.ID,
+			ActorRole:  actor.Role,
+			Action:     auditAction(c.Method(), c.Route().Path, resource.entityType),
+			EntityType: resource.entityType,
+			EntityID:   entityID,
+			IPAddress:  utils.CopyString(c.IP()),
+			Method:     utils.CopyString(c.Method()),
+			Route:      c.Route().Path,
+			StatusCode: status,
+		}
+		if key, ok := APIKey(c); ok {
+			entry.APIKeyID = key.ID
+		}
+
+		// The response body is reused once the handler returns, so it is
+		// read now; the row is read after the response is sent
+		var response map[string]interface{}
+		if resource.table == "" || entityID == "" {
+			response = responseData(c.Response().Body())
+			if entry.EntityID == "" {
+				entry.EntityID = idOf(response)
+			}
+		}
+
+		before := c.Locals("audit_before")
+		go func() {
+			after := response
+			if resource.table != "" && entry.EntityID != "" {
+				after = auditRow(db, resource.table, entry.EntityID)
+			}
+			entry.Changes = redact(audit.Diff(rowOf(before), after))
+			if err := auditLog.Record(entry); err != nil {
+				log.Printf("audit: recording %s %s: %v", entry.Method, entry.Route, err)
+			}
+		}()
+		return nil
+	}
+}
+
+// MarkAudited tells Audit the handler recorded the change itself
+func MarkAudited(c *fiber.Ctx) {
+	c.Locals("audit_recorded", true)
+}
+
+// AuditBefore gives Audit the row a handler loaded before changing it, so
+// the recorded diff shows what changed rather than only the new row
+func AuditBefore(c *fiber.Ctx, row interface{}) {
+	c.Locals("audit_before", row)
+}
+
+// auditTarget returns the resource a request path changes and the id of
+// its row, if the path names one. Paths look like /api/v1/orders/:id/...
+// or /api/v1/admin/users/:id/...
+func auditTarget(path string) (auditedResource, string) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(path, "/api/v1"), "/"), "/")
+	if len(segments) > 1 && segments[0] == "admin" {
+		segments = segments[1:]
+	}
+	resource, ok := auditedResources[segments[0]]
+	if !ok {
+		return auditedResource{entityType: strings.ReplaceAll(segments[0], "-", "_")}, ""
+	}
+	if len(segments) > 1 {
+		return resource, segments[1]
+	}
+	return resource, ""
+}
+
+// auditAction names a change after its entity and route, e.g. order_create
+// for POST /orders, stock_transfer_ship for POST /transfers/:id/ship and
+// product_images_delete for DELETE /products/:id/images/:imageId
+func auditAction(method, route, entityType string) string {
+	var words []string
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(route, "/api/v1"), "/"), "/")
+	if len(segments) > 0 && segments[0] == "admin" {
+		segments = segments[1:]
+	}
+	for i, segment := range segments {
+		if i > 0 && !strings.HasPrefix(segment, ":") {
+			words = append(words, strings.ReplaceAll(segment, "-", "_"))
+		}
+	}
+
+	switch {
+	case method == fiber.MethodPost && len(words) > 0 && len(segments) > 1 && strings.HasPrefix(segments[1], ":"):
+		// An action on a row, e.g. /transfers/:id/ship
+	case method == fiber.MethodPost:
+		words = append(words, "create")
+	case method == fiber.MethodDelete:
+		words = append(words, "delete")
+	default:
+		words = append(words, "update")
+	}
+	return entityType + "_" + strings.Join(words, "_")
+}
+
+// auditRow reads a row for the diff. A row that cannot be read, or no
+// longer exists, counts as empty.
+func auditRow(db *database.Database, table, id string) map[string]interface{} {
+	var rows []map[string]interface{}
+	_, err := db.Client.From(table).
+		Select("*", "", false).
+		Eq("id", id).
+		Limit(1, "").
+		ExecuteTo(&rows)
+	if err != nil || len(rows) == 0 {
+		return nil
+	}
+	return rows[0]
+}
+
+// rowOf turns a row given to AuditBefore into its JSON fields
+func rowOf(row interface{}) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// responseData returns the "data" object of a JSON response, if it has one
+func responseData(body []byte) map[string]interface{} {
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+	return response.Data
+}
+
+func idOf(data map[string]interface{}) string {
+	switch id := data["id"].(type) {
+	case string:
+		return id
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64)
+	}
+	return ""
+}
+
+// redact leaves hashes, passwords, tokens and secrets out of a diff
+func redact(changes map[string]audit.Change) map[string]audit.Change {
+	for field := range changes {
+		name := strings.ToLower(field)
+		if name == "key" || strings.Contains(name, "hash") || strings.Contains(name, "password") ||
+			strings.Contains(name, "token") || strings.Contains(name, "secret") {
+			delete(changes, field)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Databases makes the anon and service-role handles available to handlers
+// through DB and ServiceDB
+func Databases(db, service *database.Database) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("db", db)
+		c.Locals("service_db", service)
+		return c.Next()
+	}
+}
+
+// DB returns the handle handlers should use by default: scoped to the
+// caller's access token after AuthRequired, so RLS policies apply to them,
+// and the anon handle on public routes. The scoped handle is built on
+// first use and shares the anon handle's connections.
+func DB(c *fiber.Ctx) *database.Database {
+	if db, ok := c.Locals("user_db").(*database.Database); ok {
+		return db
+	}
+
+	db, _ := c.Locals("db").(*database.Database)
+	token, _ := c.Locals("access_token").(string)
+	if token == "" {
+		return db
+	}
+
+	userDB := db.ForUser(token)
+	c.Locals("user_db", userDB)
+	return userDB
+}
+
+// ServiceDB returns the service-role handle, which bypasses RLS. Handlers
+// only use it for operations they have authorized themselves.
+func ServiceDB(c *fiber.Ctx) *database.Database {
+	db, _ := c.Locals("service_db").(*database.Database)
+	return db
+}
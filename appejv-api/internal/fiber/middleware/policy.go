@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"errors"
+
+	"github.com/appejv/appejv-api/internal/policy"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Subject returns the authenticated caller as a policy subject
+func Subject(c *fiber.Ctx) policy.Subject {
+	userID, _ := c.Locals("user_id").(string)
+	role, _ := c.Locals("user_role").(string)
+	subject := policy.Subject{ID: userID, Role: role}
+	if key, ok := APIKey(c); ok {
+		subject.Limits = keyLimits(key)
+	}
+	return subject
+}
+
+// Allow lets the request through when the policy allows the caller action
+// on kind. With param, the check is on the resource whose id is that route
+// parameter, so ownership and team membership apply.
+func Allow(engine *policy.Engine, action policy.Action, kind policy.Kind, param ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		resource := policy.Resource{Kind: kind}
+		if len(param) > 0 {
+			resource.ID = c.Params(param[0])
+		}
+		if err := engine.Authorize(Subject(c), action, resource); err != nil {
+			return PolicyError(c, err)
+		}
+		return c.Next()
+	}
+}
+
+// PolicyError answers a failed policy check: 403 when denied, 404 when the
+// resource does not exist
+func PolicyError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, policy.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error":     "Insufficient permissions",
+			"user_role": c.Locals("user_role"),
+		})
+	case errors.Is(err, policy.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appejv/appejv-api/pkg/database"
+)
+
+// ErrProfileNotFound means the token's user has no profile
+var ErrProfileNotFound = errors.New("profile not found")
+
+// maxCachedProfiles bounds the cache's memory; when it is full, expired
+// entries are dropped and, failing that, the whole cache is cleared
+const maxCachedProfiles = 10000
+
+// ProfileCache caches the profiles AuthRequired loads, keyed by user id.
+// Entries live for the TTL, so a demoted or removed user keeps their old
+// role for at most that long; handlers that change a profile's role or
+// manager call Invalidate so the change applies on the next request.
+type ProfileCache struct {
+	db  *database.Database
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]cachedProfile
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	invalidations atomic.Int64
+}
+
+type cachedProfile struct {
+	profile   Profile
+	expiresAt time.Time
+}
+
+// ProfileCacheStats are the cache's counters since startup
+type ProfileCacheStats struct {
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	HitRatio      float64 `json:"hit_ratio"`
+	Invalidations int64   `json:"invalidations"`
+	Size          int     `json:"size"`
+	TTLSeconds    float64 `json:"ttl_seconds"`
+}
+
+// NewProfileCache caches profiles for ttl. A ttl of zero disables caching:
+// every request reads the profile.
+func NewProfileCache(db *database.Database, ttl time.Duration) *ProfileCache {
+	return &ProfileCache{
+		db:      db,
+		ttl:     ttl,
+		entries: make(map[string]cachedProfile),
+	}
+}
+
+// Get returns the user's profile, from the cache while it is fresh
+func (pc *ProfileCache) Get(userID string) (Profile, error) {
+	now := time.Now()
+
+	pc.mu.RLock()
+	entry, ok := pc.entries[userID]
+	pc.mu.RUnlock()
+	if ok && now.Before(entry.expiresAt) {
+		pc.hits.Add(1)
+		return entry.profile, nil
+	}
+	pc.misses.Add(1)
+
+	var profiles []Profile
+	_, err := pc.db.Client.From("profiles").
+		Select("id, full_name, role, phone, manager_id, deactivated_at", "", false).
+		Eq("id", userID).
+		Limit(1, "").
+		ExecuteTo(&profiles)
+	if err != nil {
+		return Profile{}, err
+	}
+	if len(profiles) == 0 {
+		// Not cached: a profile created right after sign-up is picked up
+		// on the next request
+		pc.Invalidate(userID)
+		return Profile{}, ErrProfileNotFound
+	}
+
+	if pc.ttl > 0 {
+		pc.mu.Lock()
+		if len(pc.entries) >= maxCachedProfiles {
+			pc.sweep(now)
+		}
+		pc.entries[userID] = cachedProfile{profile: profiles[0], expiresAt: now.Add(pc.ttl)}
+		pc.mu.Unlock()
+	}
+
+	return profiles[0], nil
+}
+
+// Invalidate drops the cached profiles of the given users
+func (pc *ProfileCache) Invalidate(userIDs ...string) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for _, id := range userIDs {
+		if _, ok := pc.entries[id]; ok {
+			delete(pc.entries, id)
+			pc.invalidations.Add(1)
+		}
+	}
+}
+
+// Stats returns the cache's counters
+func (pc *ProfileCache) Stats() ProfileCacheStats {
+	pc.mu.RLock()
+	size := len(pc.entries)
+	pc.mu.RUnlock()
+
+	stats := ProfileCacheStats{
+		Hits:          pc.hits.Load(),
+		Misses:        pc.misses.Load(),
+		Invalidations: pc.invalidations.Load(),
+		Size:          size,
+		TTLSeconds:    pc.ttl.Seconds(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// sweep drops expired entries, or everything when none have expired.
+// Callers hold the write lock.
+func (pc *ProfileCache) sweep(now time.Time) {
+	for id, entry := range pc.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(pc.entries, id)
+		}
+	}
+	if len(pc.entries) >= maxCachedProfiles {
+		pc.entries = make(map[string]cachedProfile)
+	}
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/ratelimit"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimitKey picks whose bucket a request takes from
+type RateLimitKey func(c *fiber.Ctx) string
+
+// ByIP gives each client IP its own bucket
+func ByIP(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
+
+// ByCaller gives each API key and each signed-in user their own bucket,
+// and other requests one per IP. It belongs after AuthRequired.
+func ByCaller(c *fiber.Ctx) string {
+	if key, ok := APIKey(c); ok {
+		return "key:" + key.ID
+	}
+	if userID, _ := c.Locals("user_id").(string); userID != "" {
+		return "user:" + userID
+	}
+	return ByIP(c)
+}
+
+// RateLimit throttles requests to limit per key. name separates the
+// buckets of different limits in a shared store. Responses carry the
+// RateLimit-* headers of the IETF draft; a request over the limit gets 429
+// with Retry-After. If the store fails the request is let through, so an
+// outage of a shared store does not take the API down with it.
+func RateLimit(store ratelimit.Store, name string, limit ratelimit.Limit, key RateLimitKey) fiber.Handler {
+	if !limit.Enabled() {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+	policy := fmt.Sprintf("%d;w=%d", limit.Burst, int(limit.Per.Seconds()))
+
+	return func(c *fiber.Ctx) error {
+		result, err := store.Take(c.Context(), name+":"+key(c), limit)
+		if err != nil {
+			log.Printf("rate limit %s: %v", name, err)
+			return c.Next()
+		}
+
+		c.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		c.Set("RateLimit-Policy", policy)
+		if !result.Allowed {
+			seconds := ceilSeconds(result.RetryAfter)
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":       "Too many requests, try again later",
+				"retry_after": seconds,
+			})
+		}
+		return c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestByIPBehindProxy checks that the client IP header is only believed
+// from a trusted proxy, configured as cmd/server does
+func TestByIPBehindProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		header  string
+		want    string
+	}{
+		{"trusted proxy", []string{"0.0.0.0/8"}, "203.0.113.7", "ip:203.0.113.7"},
+		{"untrusted sender", []string{"10.0.0.1"}, "203.0.113.7", "ip:0.0.0.0"},
+		{"no trusted proxies", nil, "203.0.113.7", "ip:0.0.0.0"},
+		{"not an IP", []string{"0.0.0.0/8"}, "anything", "ip:0.0.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{
+				ProxyHeader:             "X-Real-IP",
+				EnableTrustedProxyCheck: true,
+				TrustedProxies:          tt.trusted,
+				EnableIPValidation:      true,
+			})
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString(ByIP(c))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-IP", tt.header)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.want {
+				t.Errorf("ByIP = %q, want %q", body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Movement types recorded in the stock ledger
+const (
+	Receipt          = "receipt"
+	OrderConsumption = "order_consumption"
+	Return           = "return"
+	Adjustment       = "adjustment"
+	TransferOut      = "transfer_out"
+	TransferIn       = "transfer_in"
+)
+
+// MovementTypes lists every valid movement type
+var MovementTypes = []string{Receipt, OrderConsumption, Return, Adjustment, TransferOut, TransferIn}
+
+// AdjustmentReasons are the reason codes accepted for manual adjustments
+var AdjustmentReasons = map[string]string{
+	"damaged":          "Hàng hư hỏng",
+	"expired":          "Hàng hết hạn",
+	"lost":             "Thất thoát",
+	"count_correction": "Điều chỉnh sau kiểm kê",
+	"sample":           "Xuất mẫu",
+	"internal_use":     "Sử dụng nội bộ",
+	"other":            "Lý do khác",
+}
+
+var (
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrProductNotFound   = errors.New("product not found")
+	ErrWarehouseNotFound = errors.New("warehouse not found")
+	ErrLotNotFound       = errors.New("lot not found")
+	ErrLotConflict       = errors.New("lot already exists with different dates")
+	ErrInvalidReason     = errors.New("invalid adjustment reason code")
+	ErrZeroQuantity      = errors.New("quantity must not be zero")
+)
+
+// Entry is a movement to post to the ledger
+type Entry struct {
+	ProductID     int
+	WarehouseID   string // default warehouse when empty
+	LotID         string
+	Type          string
+	Quantity      int // signed delta in base units
+	ReasonCode    string
+	ReferenceType string
+	ReferenceID   string
+	Note          string
+	UserID        string
+}
+
+// Ledger posts and reads stock movements. products.stock is kept in sync by
+// the post_stock_movement database function.
+type Ledger struct {
+	db *database.Database
+
+	mu        sync.RWMutex
+	observers []func(productIDs ...int)
+}
+
+func NewLedger(db *database.Database) *Ledger {
+	return &Ledger{db: db}
+}
+
+// OnChange registers fn to be called after stock of products changes through
+// the ledger
+func (l *Ledger) OnChange(fn func(productIDs ...int)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.observers = append(l.observers, fn)
+}
+
+// Changed notifies observers that stock-related data of the products changed.
+// Postings call it; handlers call it for changes made outside the ledger.
+func (l *Ledger) Changed(productIDs ...int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, fn := range l.observers {
+		fn(productIDs...)
+	}
+}
+
+// Post appends a movement and returns it with the resulting balance
+func (l *Ledger) Post(ctx context.Context, e Entry) (models.StockMovement, error) {
+	if e.Quantity == 0 {
+		return models.StockMovement{}, ErrZeroQuantity
+	}
+	if e.Type == Adjustment {
+		if _, ok := AdjustmentReasons[e.ReasonCode]; !ok {
+			return models.StockMovement{}, ErrInvalidReason
+		}
+	}
+
+	var movement models.StockMovement
+	err := l.db.RPC(ctx, "post_stock_movement", map[string]interface{}{
+		"p_product_id":     e.ProductID,
+		"p_movement_type":  e.Type,
+		"p_quantity":       e.Quantity,
+		"p_reason_code":    nullable(e.ReasonCode),
+		"p_reference_type": nullable(e.ReferenceType),
+		"p_reference_id":   nullable(e.ReferenceID),
+		"p_note":           nullable(e.Note),
+		"p_user_id":        nullable(e.UserID),
+		"p_warehouse_id":   nullable(e.WarehouseID),
+		"p_lot_id":         nullable(e.LotID),
+	}, &movement)
+	if err != nil {
+		return models.StockMovement{}, postingError(err)
+	}
+
+	l.Changed(e.ProductID)
+	return movement, nil
+}
+
+// postingError maps exceptions raised by the posting functions to the
+// package errors
+func postingError(err error) error {
+	var rpcErr *database.RPCError
+	if !errors.As(err, &rpcErr) {
+		return err
+	}
+
+	switch msg := rpcErr.Message; {
+	case strings.Contains(msg, "insufficient stock"):
+		return ErrInsufficientStock
+	case strings.Contains(msg, "warehouse not found"):
+		return ErrWarehouseNotFound
+	case strings.HasPrefix(msg, "lot") && strings.Contains(msg, "not found"):
+		return ErrLotNotFound
+	case strings.Contains(msg, "different dates"):
+		return ErrLotConflict
+	case strings.Contains(msg, "not found"):
+		return ErrProductNotFound
+	}
+	return err
+}
+
+// HistoryFilter narrows a movement history query
+type HistoryFilter struct {
+	Type string
+	// WarehouseIDs restricts results to these warehouses when non-empty
+	WarehouseIDs []string
+	From         string // RFC 3339 or date
+	To           string
+	Offset       int
+	Limit        int
+}
+
+// History returns the movements of a product, newest first, and the total count
+func (l *Ledger) History(productID int, f HistoryFilter) ([]models.StockMovement, int64, error) {
+	query := l.db.Client.From("stock_movements").
+		Select("*", "exact", false).
+		Eq("product_id", strconv.Itoa(productID))
+
+	if f.Type != "" {
+		query = query.Eq("movement_type", f.Type)
+	}
+	if len(f.WarehouseIDs) > 0 {
+		query = query.In("warehouse_id", f.WarehouseIDs)
+	}
+	if f.From != "" {
+		query = query.Gte("created_at", f.From)
+	}
+	if f.To != "" {
+		query = query.Lte("created_at", f.To)
+	}
+
+	movements := []models.StockMovement{}
+	count, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Range(f.Offset, f.Offset+f.Limit-1, "").
+		ExecuteTo(&movements)
+	return movements, count, err
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
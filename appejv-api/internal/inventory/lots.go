@@ -0,0 +1,304 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// DateLayout is the format of lot manufacture and expiry dates
+const DateLayout = "2006-01-02"
+
+var ErrInvalidLotDates = errors.New("expiry date must not be before the manufacture date")
+
+// Lot identifies a production batch on receipt. Dates are YYYY-MM-DD.
+type Lot struct {
+	Number         string
+	ManufacturedAt string
+	ExpiresAt      string
+}
+
+// Validate checks the lot dates
+func (l Lot) Validate() error {
+	var made, expires time.Time
+	var err error
+	if l.ManufacturedAt != "" {
+		if made, err = time.Parse(DateLayout, l.ManufacturedAt); err != nil {
+			return errors.New("manufactured_at must be a date (YYYY-MM-DD)")
+		}
+	}
+	if l.ExpiresAt != "" {
+		if expires, err = time.Parse(DateLayout, l.ExpiresAt); err != nil {
+			return errors.New("expires_at must be a date (YYYY-MM-DD)")
+		}
+	}
+	if !made.IsZero() && !expires.IsZero() && expires.Before(made) {
+		return ErrInvalidLotDates
+	}
+	return nil
+}
+
+// ReceiveLot posts a positive movement into a lot, creating the lot on its
+// first receipt
+func (l *Ledger) ReceiveLot(ctx context.Context, e Entry, lot Lot) (models.StockMovement, error) {
+	if e.Quantity <= 0 {
+		return models.StockMovement{}, ErrZeroQuantity
+	}
+	if err := lot.Validate(); err != nil {
+		return models.StockMovement{}, err
+	}
+
+	var movement models.StockMovement
+	err := l.db.RPC(ctx, "receive_stock_lot", map[string]interface{}{
+		"p_product_id":      e.ProductID,
+		"p_quantity":        e.Quantity,
+		"p_lot_number":      lot.Number,
+		"p_manufactured_at": nullable(lot.ManufacturedAt),
+		"p_expires_at":      nullable(lot.ExpiresAt),
+		"p_movement_type":   e.Type,
+		"p_reason_code":     nullable(e.ReasonCode),
+		"p_reference_type":  nullable(e.ReferenceType),
+		"p_reference_id":    nullable(e.ReferenceID),
+		"p_note":            nullable(e.Note),
+		"p_user_id":         nullable(e.UserID),
+		"p_warehouse_id":    nullable(e.WarehouseID),
+	}, &movement)
+	if err != nil {
+		return models.StockMovement{}, postingError(err)
+	}
+
+	l.Changed(e.ProductID)
+	return movement, nil
+}
+
+// Consume takes -e.Quantity out of a warehouse first-expiry-first-out across
+// lots, then from unlotted stock, and returns one movement per lot touched.
+// Expired lots are only used when includeExpired is set.
+func (l *Ledger) Consume(ctx context.Context, e Entry, includeExpired bool) ([]models.StockMovement, error) {
+	if e.Quantity >= 0 {
+		return nil, ErrZeroQuantity
+	}
+	if e.Type == Adjustment {
+		if _, ok := AdjustmentReasons[e.ReasonCode]; !ok {
+			return nil, ErrInvalidReason
+		}
+	}
+
+	movements := []models.StockMovement{}
+	err := l.db.RPC(ctx, "consume_stock_fefo", map[string]interface{}{
+		"p_product_id":      e.ProductID,
+		"p_movement_type":   e.Type,
+		"p_quantity":        -e.Quantity,
+		"p_reason_code":     nullable(e.ReasonCode),
+		"p_reference_type":  nullable(e.ReferenceType),
+		"p_reference_id":    nullable(e.ReferenceID),
+		"p_note":            nullable(e.Note),
+		"p_user_id":         nullable(e.UserID),
+		"p_warehouse_id":    nullable(e.WarehouseID),
+		"p_include_expired": includeExpired,
+	}, &movements)
+	if err != nil {
+		return nil, postingError(err)
+	}
+
+	l.Changed(e.ProductID)
+	return movements, nil
+}
+
+// LotFilter narrows a lot listing
+type LotFilter struct {
+	ProductID    int
+	LotNumber    string
+	WarehouseIDs []string
+	// IncludeEmpty also returns lots that have been used up
+	IncludeEmpty bool
+}
+
+// Lots returns lots in FEFO order
+func (l *Ledger) Lots(f LotFilter) ([]models.StockLot, error) {
+	query := l.db.Client.From("stock_lots").Select("*", "", false)
+	if f.ProductID != 0 {
+		query = query.Eq("product_id", strconv.Itoa(f.ProductID))
+	}
+	if f.LotNumber != "" {
+		query = query.Eq("lot_number", f.LotNumber)
+	}
+	if len(f.WarehouseIDs) > 0 {
+		query = query.In("warehouse_id", f.WarehouseIDs)
+	}
+	if !f.IncludeEmpty {
+		query = query.Gt("quantity", "0")
+	}
+
+	lots := []models.StockLot{}
+	_, err := query.
+		Order("expires_at", &postgrest.OrderOpts{Ascending: true, NullsFirst: false}).
+		Order("received_at", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&lots)
+	return lots, err
+}
+
+// Lot returns one lot
+func (l *Ledger) Lot(id string) (models.StockLot, error) {
+	var lots []models.StockLot
+	_, err := l.db.Client.From("stock_lots").
+		Select("*", "", false).
+		Eq("id", id).
+		Limit(1, "").
+		ExecuteTo(&lots)
+	if err != nil {
+		return models.StockLot{}, err
+	}
+	if len(lots) == 0 {
+		return models.StockLot{}, ErrLotNotFound
+	}
+	return lots[0], nil
+}
+
+// NearExpiry returns lots with stock that expire within days of today,
+// including lots that have already expired, soonest first
+func (l *Ledger) NearExpiry(days int, warehouseIDs []string) ([]models.NearExpiryLot, error) {
+	today := time.Now().Truncate(24 * time.Hour)
+	cutoff := today.AddDate(0, 0, days).Format(DateLayout)
+
+	query := l.db.Client.From("stock_lots").
+		Select("*", "", false).
+		Gt("quantity", "0").
+		Lte("expires_at", cutoff)
+	if len(warehouseIDs) > 0 {
+		query = query.In("warehouse_id", warehouseIDs)
+	}
+
+	var lots []models.StockLot
+	_, err := query.Order("expires_at", &postgrest.OrderOpts{Ascending: true}).ExecuteTo(&lots)
+	if err != nil {
+		return nil, err
+	}
+
+	report := make([]models.NearExpiryLot, 0, len(lots))
+	for _, lot := range lots {
+		entry := models.NearExpiryLot{StockLot: lot}
+		if lot.ExpiresAt != nil {
+			if expires, err := time.Parse(DateLayout, *lot.ExpiresAt); err == nil {
+				entry.DaysLeft = int(math.Round(expires.Sub(today).Hours() / 24))
+				entry.Expired = entry.DaysLeft < 0
+			}
+		}
+		report = append(report, entry)
+	}
+	return report, nil
+}
+
+// Recall lists every order, with its customer, that received goods from a
+// lot number of a product in any warehouse. Quantity is what was shipped
+// from the lot; Returned is what came back against the same order.
+func (l *Ledger) Recall(productID int, lotNumber string) ([]models.StockLot, []models.RecallEntry, error) {
+	lots, err := l.Lots(LotFilter{ProductID: productID, LotNumber: lotNumber, IncludeEmpty: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(lots) == 0 {
+		return nil, nil, ErrLotNotFound
+	}
+
+	lotIDs := make([]string, 0, len(lots))
+	for _, lot := range lots {
+		lotIDs = append(lotIDs, lot.ID)
+	}
+
+	var movements []models.StockMovement
+	_, err = l.db.Client.From("stock_movements").
+		Select("*", "", false).
+		In("lot_id", lotIDs).
+		Eq("reference_type", "order").
+		Order("created_at", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&movements)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type key struct{ order, lot string }
+	entries := map[key]*models.RecallEntry{}
+	var keys []key
+	for _, m := range movements {
+		if m.ReferenceID == nil || m.LotID == nil {
+			continue
+		}
+		k := key{*m.ReferenceID, *m.LotID}
+		entry, ok := entries[k]
+		if !ok {
+			entry = &models.RecallEntry{OrderID: k.order, LotID: k.lot, ShippedAt: m.CreatedAt}
+			if m.WarehouseID != nil {
+				entry.WarehouseID = *m.WarehouseID
+			}
+			entries[k] = entry
+			keys = append(keys, k)
+		}
+		if m.Quantity < 0 {
+			entry.Quantity -= m.Quantity
+		} else {
+			entry.Returned += m.Quantity
+		}
+	}
+
+	if len(keys) == 0 {
+		return lots, []models.RecallEntry{}, nil
+	}
+
+	orderIDs := make([]string, 0, len(keys))
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if !seen[k.order] {
+			seen[k.order] = true
+			orderIDs = append(orderIDs, k.order)
+		}
+	}
+
+	var orders []struct {
+		ID         string    `json:"id"`
+		CustomerID *string   `json:"customer_id"`
+		SaleID     *string   `json:"sale_id"`
+		Status     string    `json:"status"`
+		CreatedAt  time.Time `json:"created_at"`
+		Customer   *struct {
+			Code    *string `json:"code"`
+			Name    *string `json:"name"`
+			Phone   *string `json:"phone"`
+			Address *string `json:"address"`
+		} `json:"customer"`
+	}
+	_, err = l.db.Client.From("orders").
+		Select("id, customer_id, sale_id, status, created_at, customer:customers(code, name, phone, address)", "", false).
+		In("id", orderIDs).
+		ExecuteTo(&orders)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recall := make([]models.RecallEntry, 0, len(keys))
+	for _, k := range keys {
+		entry := entries[k]
+		for _, o := range orders {
+			if o.ID != entry.OrderID {
+				continue
+			}
+			entry.OrderStatus = o.Status
+			entry.OrderedAt = o.CreatedAt
+			entry.CustomerID = o.CustomerID
+			entry.SaleID = o.SaleID
+			if o.Customer != nil {
+				entry.CustomerCode = o.Customer.Code
+				entry.CustomerName = o.Customer.Name
+				entry.Phone = o.Customer.Phone
+				entry.Address = o.Customer.Address
+			}
+		}
+		recall = append(recall, *entry)
+	}
+	return lots, recall, nil
+}
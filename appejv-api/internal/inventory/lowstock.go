@@ -0,0 +1,136 @@
+package inventory
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// checkDelay batches stock changes that arrive close together into one
+// evaluation
+const checkDelay = 2 * time.Second
+
+// LowStockMonitor raises low-stock alerts, per warehouse. It evaluates
+// products shortly
+// after their stock changes through the API and every interval for changes
+// made elsewhere (order status triggers, direct database clients).
+// De-duplication lives in evaluate_low_stock(): one alert per dip.
+type LowStockMonitor struct {
+	db       *database.Database
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[int]struct{}
+	wake    chan struct{}
+}
+
+func NewLowStockMonitor(db *database.Database, interval time.Duration) *LowStockMonitor {
+	return &LowStockMonitor{
+		db:       db,
+		interval: interval,
+		pending:  make(map[int]struct{}),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Check queues products for evaluation. It never blocks, so it can be
+// registered with Ledger.OnChange.
+func (m *LowStockMonitor) Check(productIDs ...int) {
+	m.mu.Lock()
+	for _, id := range productIDs {
+		m.pending[id] = struct{}{}
+	}
+	m.mu.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run evaluates queued products and runs a full evaluation every interval
+// until ctx is cancelled
+func (m *LowStockMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	m.run(ctx, nil)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.run(ctx, nil)
+		case <-m.wake:
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(checkDelay):
+			}
+			if ids := m.drain(); len(ids) > 0 {
+				m.run(ctx, ids)
+			}
+		}
+	}
+}
+
+// Evaluate raises and resolves alerts for the products (all when empty) and
+// returns the alerts raised
+func (m *LowStockMonitor) Evaluate(ctx context.Context, productIDs []int) ([]models.LowStockAlert, error) {
+	var ids interface{}
+	if len(productIDs) > 0 {
+		ids = productIDs
+	}
+
+	alerts := []models.LowStockAlert{}
+	err := m.db.RPC(ctx, "evaluate_low_stock", map[string]interface{}{
+		"p_product_ids": ids,
+	}, &alerts)
+	return alerts, err
+}
+
+// Products lists products at or below their reorder point in the
+// warehouses (all when nil), largest shortfall first
+func (m *LowStockMonitor) Products(warehouseIDs []string) ([]models.LowStockProduct, error) {
+	products := []models.LowStockProduct{}
+	query := m.db.Client.From("low_stock_products").Select("*", "", false)
+	if warehouseIDs != nil {
+		query = query.In("warehouse_id", warehouseIDs)
+	}
+	_, err := query.
+		Order("shortfall", &postgrest.OrderOpts{Ascending: false}).
+		ExecuteTo(&products)
+	return products, err
+}
+
+func (m *LowStockMonitor) run(ctx context.Context, productIDs []int) {
+	alerts, err := m.Evaluate(ctx, productIDs)
+	if err != nil {
+		log.Printf("low-stock evaluation failed: %v", err)
+		return
+	}
+	for _, a := range alerts {
+		warehouse := ""
+		if a.WarehouseID != nil {
+			warehouse = *a.WarehouseID
+		}
+		log.Printf("low stock: product %d at %d in warehouse %s (reorder point %d)", a.ProductID, a.Stock, warehouse, a.ReorderPoint)
+	}
+}
+
+func (m *LowStockMonitor) drain() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]int, 0, len(m.pending))
+	for id := range m.pending {
+		ids = append(ids, id)
+	}
+	m.pending = make(map[int]struct{})
+	return ids
+}
@@ -0,0 +1,302 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Pick list groupings
+const (
+	GroupByWarehouse    = "warehouse"
+	GroupByRoute        = "route"
+	GroupByDeliveryDate = "delivery_date"
+)
+
+// Pick statuses
+const (
+	PickComplete = "picked"
+	PickShort    = "short"
+)
+
+var (
+	ErrOrderNotFound = errors.New("order not found")
+	ErrNotPickable   = errors.New("only ordered orders can be picked")
+	ErrInvalidPick   = errors.New("invalid pick")
+)
+
+const pickOrderColumns = "id, status, warehouse_id, route, delivery_date, notes, created_at, " +
+	"customer:customers(code, name, address, phone), " +
+	"items:order_items(id, product_id, quantity, unit, base_quantity, product:products(code, name, unit, barcode)), " +
+	"pick:order_picks(*, items:order_pick_items(*))"
+
+// PickFilter selects the open orders to pick
+type PickFilter struct {
+	// WarehouseIDs limits orders to these fulfilment warehouses (all when
+	// empty). Orders without a warehouse are fulfilled by the default one.
+	WarehouseIDs []string
+	Route        string
+	DeliveryDate string
+}
+
+// Picking builds pick lists and records pick confirmations
+type Picking struct {
+	db         *database.Database
+	warehouses *Warehouses
+}
+
+func NewPicking(db *database.Database, warehouses *Warehouses) *Picking {
+	return &Picking{db: db, warehouses: warehouses}
+}
+
+// OpenOrders returns the ordered orders matching the filter, oldest first.
+// Orders without a fulfilment warehouse are reported against the default
+// warehouse.
+func (p *Picking) OpenOrders(f PickFilter) ([]models.PickOrder, error) {
+	defaultID, err := p.defaultWarehouse()
+	if err != nil {
+		return nil, err
+	}
+
+	query := p.db.Client.From("orders").
+		Select(pickOrderColumns, "", false).
+		Eq("status", "ordered").
+		Is("deleted_at", "null")
+	if len(f.WarehouseIDs) > 0 {
+		ids := strings.Join(f.WarehouseIDs, ",")
+		if contains(f.WarehouseIDs, defaultID) {
+			query = query.Or("warehouse_id.in.("+ids+"),warehouse_id.is.null", "")
+		} else {
+			query = query.In("warehouse_id", f.WarehouseIDs)
+		}
+	}
+	if f.Route != "" {
+		query = query.Eq("route", f.Route)
+	}
+	if f.DeliveryDate != "" {
+		query = query.Eq("delivery_date", f.DeliveryDate)
+	}
+
+	orders := []models.PickOrder{}
+	_, err = query.Order("created_at", &postgrest.OrderOpts{Ascending: true}).ExecuteTo(&orders)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range orders {
+		if orders[i].WarehouseID == nil && defaultID != "" {
+			id := defaultID
+			orders[i].WarehouseID = &id
+		}
+	}
+	return orders, nil
+}
+
+// Order returns one order with its lines and pick confirmation
+func (p *Picking) Order(id string) (models.PickOrder, error) {
+	var orders []models.PickOrder
+	_, err := p.db.Client.From("orders").
+		Select(pickOrderColumns, "", false).
+		Eq("id", id).
+		Is("deleted_at", "null").
+		Limit(1, "").
+		ExecuteTo(&orders)
+	if err != nil {
+		return models.PickOrder{}, err
+	}
+	if len(orders) == 0 {
+		return models.PickOrder{}, ErrOrderNotFound
+	}
+
+	order := orders[0]
+	if order.WarehouseID == nil {
+		defaultID, err := p.defaultWarehouse()
+		if err != nil {
+			return models.PickOrder{}, err
+		}
+		if defaultID != "" {
+			order.WarehouseID = &defaultID
+		}
+	}
+	return order, nil
+}
+
+// PickLists groups orders by warehouse, route or delivery date and totals
+// the base quantity of every product to pick in each group
+func (p *Picking) PickLists(orders []models.PickOrder, groupBy string) ([]models.PickList, error) {
+	labels := map[string]string{}
+	if groupBy == GroupByWarehouse {
+		warehouses, err := p.warehouses.List(Scope{All: true})
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range warehouses {
+			labels[w.ID] = w.Code + " - " + w.Name
+		}
+	}
+
+	byKey := map[string]*models.PickList{}
+	var keys []string
+	for _, order := range orders {
+		key, label := pickGroup(order, groupBy, labels)
+		list, ok := byKey[key]
+		if !ok {
+			list = &models.PickList{GroupBy: groupBy, Key: key, Label: label}
+			byKey[key] = list
+			keys = append(keys, key)
+		}
+		list.Orders = append(list.Orders, order)
+	}
+
+	// Ungrouped orders ("") sort last
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i] == "" || keys[j] == "" {
+			return keys[j] == ""
+		}
+		return keys[i] < keys[j]
+	})
+
+	lists := make([]models.PickList, 0, len(keys))
+	for _, key := range keys {
+		list := byKey[key]
+		list.Lines = pickLines(list.Orders)
+		lists = append(lists, *list)
+	}
+	return lists, nil
+}
+
+// ConfirmPick records the base quantity userID picked for every line of an
+// order. Unless scope covers every warehouse, the order must ship from one
+// of its warehouses; the database checks this while it holds the order.
+func (p *Picking) ConfirmPick(ctx context.Context, orderID string, req models.ConfirmPickRequest, userID string, scope Scope) (models.OrderPick, error) {
+	params := map[string]interface{}{
+		"p_order_id": orderID,
+		"p_lines":    req.Lines,
+		"p_user_id":  nullable(userID),
+		"p_note":     req.Note,
+	}
+	if !scope.All {
+		// Never nil: a user without warehouses sends an empty list, which
+		// allows none, rather than null, which allows all
+		params["p_warehouse_ids"] = append([]string{}, scope.IDs...)
+	}
+
+	var pick models.OrderPick
+	err := p.db.RPC(ctx, "confirm_order_pick", params, &pick)
+	if err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch msg := rpcErr.Message; {
+			case strings.HasPrefix(msg, "order") && strings.Contains(msg, "not found"):
+				return models.OrderPick{}, ErrOrderNotFound
+			case strings.Contains(msg, "invalid order status"):
+				return models.OrderPick{}, ErrNotPickable
+			case strings.HasPrefix(msg, "permission denied"):
+				// The order moved out of the picker's warehouses
+				return models.OrderPick{}, ErrOrderNotFound
+			case strings.HasPrefix(msg, "invalid pick"):
+				return models.OrderPick{}, fmt.Errorf("%w: %s", ErrInvalidPick, strings.TrimPrefix(msg, "invalid pick: "))
+			}
+		}
+		return models.OrderPick{}, err
+	}
+
+	order, err := p.Order(orderID)
+	if err != nil {
+		return models.OrderPick{}, err
+	}
+	if order.Pick != nil {
+		pick = *order.Pick
+	}
+	return pick, nil
+}
+
+func (p *Picking) defaultWarehouse() (string, error) {
+	var rows []struct {
+		ID string `json:"id"`
+	}
+	_, err := p.db.Client.From("warehouses").
+		Select("id", "", false).
+		Eq("is_default", "true").
+		Limit(1, "").
+		ExecuteTo(&rows)
+	if err != nil || len(rows) == 0 {
+		return "", err
+	}
+	return rows[0].ID, nil
+}
+
+func pickGroup(order models.PickOrder, groupBy string, labels map[string]string) (string, string) {
+	switch groupBy {
+	case GroupByRoute:
+		if order.Route != nil && *order.Route != "" {
+			return *order.Route, *order.Route
+		}
+		return "", "Chưa xếp tuyến"
+	case GroupByDeliveryDate:
+		if order.DeliveryDate != nil {
+			return *order.DeliveryDate, *order.DeliveryDate
+		}
+		return "", "Chưa hẹn ngày giao"
+	default:
+		if order.WarehouseID != nil {
+			if label, ok := labels[*order.WarehouseID]; ok {
+				return *order.WarehouseID, label
+			}
+			return *order.WarehouseID, *order.WarehouseID
+		}
+		return "", "Chưa chọn kho"
+	}
+}
+
+// pickLines totals the base quantity per product, ordered by product code
+func pickLines(orders []models.PickOrder) []models.PickListLine {
+	byProduct := map[int]*models.PickListLine{}
+	for _, order := range orders {
+		seen := map[int]bool{}
+		for _, item := range order.Items {
+			line, ok := byProduct[item.ProductID]
+			if !ok {
+				line = &models.PickListLine{ProductID: item.ProductID}
+				if item.Product != nil {
+					line.ProductCode = item.Product.Code
+					line.ProductName = item.Product.Name
+					line.Unit = item.Product.Unit
+				}
+				byProduct[item.ProductID] = line
+			}
+			line.Quantity += item.BaseQuantity
+			if !seen[item.ProductID] {
+				line.Orders++
+				seen[item.ProductID] = true
+			}
+		}
+	}
+
+	lines := make([]models.PickListLine, 0, len(byProduct))
+	for _, line := range byProduct {
+		lines = append(lines, *line)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].ProductCode != lines[j].ProductCode {
+			return lines[i].ProductCode < lines[j].ProductCode
+		}
+		return lines[i].ProductID < lines[j].ProductID
+	})
+	return lines
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
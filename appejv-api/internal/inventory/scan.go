@@ -0,0 +1,166 @@
+package inventory
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/appejv/appejv-api/internal/barcodes"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/google/uuid"
+)
+
+// Scan result types
+const (
+	ScanProduct = "product"
+	ScanOrder   = "order"
+	ScanLot     = "lot"
+	ScanLots    = "lots"
+)
+
+var ErrUnknownCode = errors.New("no product, order or lot matches this code")
+
+// Scanner resolves scanned label values to products, orders and lots
+type Scanner struct {
+	db      *database.Database
+	ledger  *Ledger
+	picking *Picking
+}
+
+func NewScanner(db *database.Database, ledger *Ledger, picking *Picking) *Scanner {
+	return &Scanner{db: db, ledger: ledger, picking: picking}
+}
+
+// Resolve looks a scanned value up. Prefixed values (ORD:, LOT:) and bare
+// UUIDs are orders or lots; anything else is a product barcode or code, then
+// a lot number. Stock and lots are limited to the scope.
+func (s *Scanner) Resolve(code string, scope Scope) (models.ScanResult, error) {
+	code = strings.TrimSpace(code)
+	result := models.ScanResult{Code: code}
+
+	switch {
+	case strings.HasPrefix(code, barcodes.OrderPrefix):
+		return s.order(result, strings.TrimPrefix(code, barcodes.OrderPrefix), scope)
+	case strings.HasPrefix(code, barcodes.LotPrefix):
+		return s.lot(result, strings.TrimPrefix(code, barcodes.LotPrefix), scope)
+	case isUUID(code):
+		if r, err := s.order(result, code, scope); !errors.Is(err, ErrUnknownCode) {
+			return r, err
+		}
+		return s.lot(result, code, scope)
+	}
+
+	product, err := productByCode(s.db, code)
+	if err == nil {
+		return s.product(result, product, scope)
+	}
+	if !errors.Is(err, ErrUnknownBarcode) {
+		return result, err
+	}
+	return s.lotNumber(result, code, scope)
+}
+
+func (s *Scanner) product(result models.ScanResult, product models.Product, scope Scope) (models.ScanResult, error) {
+	query := s.db.Client.From("warehouse_stock").
+		Select("*", "", false).
+		Eq("product_id", strconv.Itoa(product.ID))
+	if !scope.All {
+		query = query.In("warehouse_id", scope.IDs)
+	}
+	stock := []models.WarehouseStock{}
+	if _, err := query.ExecuteTo(&stock); err != nil {
+		return result, err
+	}
+
+	filter := LotFilter{ProductID: product.ID}
+	if !scope.All {
+		filter.WarehouseIDs = scope.IDs
+	}
+	lots, err := s.ledger.Lots(filter)
+	if err != nil {
+		return result, err
+	}
+
+	result.Type = ScanProduct
+	result.Product = &models.ScanProduct{Product: product, WarehouseStock: stock, Lots: lots}
+	return result, nil
+}
+
+func (s *Scanner) order(result models.ScanResult, id string, scope Scope) (models.ScanResult, error) {
+	if !isUUID(id) {
+		return result, ErrUnknownCode
+	}
+
+	order, err := s.picking.Order(id)
+	if errors.Is(err, ErrOrderNotFound) {
+		return result, ErrUnknownCode
+	}
+	if err != nil {
+		return result, err
+	}
+	if !scope.All && (order.WarehouseID == nil || !scope.Allows(*order.WarehouseID)) {
+		return result, ErrUnknownCode
+	}
+
+	result.Type = ScanOrder
+	result.Order = &order
+	return result, nil
+}
+
+func (s *Scanner) lot(result models.ScanResult, id string, scope Scope) (models.ScanResult, error) {
+	if !isUUID(id) {
+		return result, ErrUnknownCode
+	}
+
+	lots, err := s.lots(map[string]string{"id": id}, scope)
+	if err != nil {
+		return result, err
+	}
+	if len(lots) == 0 {
+		return result, ErrUnknownCode
+	}
+
+	result.Type = ScanLot
+	result.Lot = &lots[0]
+	return result, nil
+}
+
+func (s *Scanner) lotNumber(result models.ScanResult, number string, scope Scope) (models.ScanResult, error) {
+	lots, err := s.lots(map[string]string{"lot_number": number}, scope)
+	if err != nil {
+		return result, err
+	}
+
+	switch len(lots) {
+	case 0:
+		return result, ErrUnknownCode
+	case 1:
+		result.Type = ScanLot
+		result.Lot = &lots[0]
+	default:
+		result.Type = ScanLots
+		result.Lots = lots
+	}
+	return result, nil
+}
+
+func (s *Scanner) lots(match map[string]string, scope Scope) ([]models.ScanLot, error) {
+	query := s.db.Client.From("stock_lots").
+		Select("*, product:products(code, name, unit, barcode)", "", false)
+	for column, value := range match {
+		query = query.Eq(column, value)
+	}
+	if !scope.All {
+		query = query.In("warehouse_id", scope.IDs)
+	}
+
+	lots := []models.ScanLot{}
+	_, err := query.ExecuteTo(&lots)
+	return lots, err
+}
+
+func isUUID(s string) bool {
+	_, err := uuid.Parse(s)
+	return err == nil && len(s) == 36
+}
@@ -0,0 +1,272 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Stocktake statuses
+const (
+	StocktakeOpen      = "open"
+	StocktakeApproved  = "approved"
+	StocktakeCancelled = "cancelled"
+)
+
+// ShrinkagePeriods are the accepted report granularities
+var ShrinkagePeriods = map[string]bool{"day": true, "week": true, "month": true, "quarter": true, "year": true}
+
+// barcodePattern limits scanned codes to characters that are safe in a
+// PostgREST filter
+var barcodePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+var (
+	ErrStocktakeNotFound = errors.New("stocktake not found")
+	ErrStocktakeOpen     = errors.New("a stocktake is already open for this warehouse")
+	ErrStocktakeClosed   = errors.New("stocktake is not open")
+	ErrUnknownBarcode    = errors.New("no product with this barcode")
+	ErrNotApprover       = errors.New("only admin or sale_admin can approve a stocktake")
+)
+
+// Stocktakes runs physical stock counts
+type Stocktakes struct {
+	db     *database.Database
+	ledger *Ledger
+}
+
+func NewStocktakes(db *database.Database, ledger *Ledger) *Stocktakes {
+	return &Stocktakes{db: db, ledger: ledger}
+}
+
+// Start opens a stocktake of a warehouse in scope by userID and snapshots
+// the expected quantities
+func (s *Stocktakes) Start(ctx context.Context, warehouseID, note, userID string, scope Scope) (models.Stocktake, error) {
+	if !scope.Allows(warehouseID) {
+		return models.Stocktake{}, ErrWarehouseNotFound
+	}
+
+	var st models.Stocktake
+	err := s.db.RPC(ctx, "start_stocktake", map[string]interface{}{
+		"p_warehouse_id": warehouseID,
+		"p_user_id":      nullable(userID),
+		"p_note":         nullable(note),
+	}, &st)
+	if err != nil {
+		return models.Stocktake{}, stocktakeError(err)
+	}
+	return st, nil
+}
+
+// List returns stocktakes of the scope's warehouses, newest first
+func (s *Stocktakes) List(scope Scope, status string) ([]models.Stocktake, error) {
+	query := s.db.Client.From("stocktakes").Select("*", "", false)
+	if status != "" {
+		query = query.Eq("status", status)
+	}
+	if !scope.All {
+		if len(scope.IDs) == 0 {
+			return []models.Stocktake{}, nil
+		}
+		query = query.In("warehouse_id", scope.IDs)
+	}
+
+	stocktakes := []models.Stocktake{}
+	_, err := query.Order("created_at", &postgrest.OrderOpts{Ascending: false}).ExecuteTo(&stocktakes)
+	return stocktakes, err
+}
+
+// Get returns a stocktake with its lines and their variances
+func (s *Stocktakes) Get(id string) (models.Stocktake, error) {
+	var stocktakes []models.Stocktake
+	_, err := s.db.Client.From("stocktakes").
+		Select("*", "", false).
+		Eq("id", id).
+		Limit(1, "").
+		ExecuteTo(&stocktakes)
+	if err != nil {
+		return models.Stocktake{}, err
+	}
+	if len(stocktakes) == 0 {
+		return models.Stocktake{}, ErrStocktakeNotFound
+	}
+	st := stocktakes[0]
+
+	_, err = s.db.Client.From("stocktake_lines").
+		Select("*, product:products(code, name, unit, barcode)", "", false).
+		Eq("stocktake_id", id).
+		Order("product_id", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&st.Lines)
+	if err != nil {
+		return models.Stocktake{}, err
+	}
+
+	for i, line := range st.Lines {
+		if line.CountedQuantity != nil {
+			v := *line.CountedQuantity - line.ExpectedQuantity
+			st.Lines[i].Variance = &v
+		}
+	}
+	return st, nil
+}
+
+// ResolveBarcode returns the product a scanned code belongs to, matching
+// products.barcode first and then products.code
+func (s *Stocktakes) ResolveBarcode(code string) (int, error) {
+	product, err := productByCode(s.db, code)
+	if err != nil {
+		return 0, err
+	}
+	return product.ID, nil
+}
+
+// productByCode finds the live product whose barcode, or failing that whose
+// code, equals a scanned value
+func productByCode(db *database.Database, code string) (models.Product, error) {
+	code = strings.TrimSpace(code)
+	if !barcodePattern.MatchString(code) {
+		return models.Product{}, ErrUnknownBarcode
+	}
+
+	var products []models.Product
+	_, err := db.Client.From("products").
+		Select("*", "", false).
+		Or("barcode.eq."+code+",code.eq."+code, "").
+		Is("deleted_at", "null").
+		ExecuteTo(&products)
+	if err != nil {
+		return models.Product{}, err
+	}
+	if len(products) == 0 {
+		return models.Product{}, ErrUnknownBarcode
+	}
+	for _, p := range products {
+		if p.Barcode != nil && *p.Barcode == code {
+			return p, nil
+		}
+	}
+	return products[0], nil
+}
+
+// RecordCount stores userID's quantity and returns the updated line. Unless
+// scope covers every warehouse, the stocktake must be of one of its
+// warehouses; the database checks this while it holds the stocktake.
+func (s *Stocktakes) RecordCount(ctx context.Context, id string, count models.StocktakeCount, source, userID string, scope Scope) (models.StocktakeLine, error) {
+	params := map[string]interface{}{
+		"p_stocktake_id": id,
+		"p_product_id":   count.ProductID,
+		"p_quantity":     count.Quantity,
+		"p_user_id":      nullable(userID),
+		"p_location":     strings.TrimSpace(count.Location),
+		"p_source":       source,
+	}
+	if !scope.All {
+		// Never nil, which would allow every warehouse
+		params["p_warehouse_ids"] = append([]string{}, scope.IDs...)
+	}
+
+	var line models.StocktakeLine
+	err := s.db.RPC(ctx, "record_stocktake_count", params, &line)
+	if err != nil {
+		return models.StocktakeLine{}, stocktakeError(err)
+	}
+
+	if line.CountedQuantity != nil {
+		v := *line.CountedQuantity - line.ExpectedQuantity
+		line.Variance = &v
+	}
+	return line, nil
+}
+
+// Approve posts the variances as count_correction adjustments and closes
+// the stocktake. Only admin and sale_admin users may approve.
+func (s *Stocktakes) Approve(ctx context.Context, id, userID, role string) (models.Stocktake, error) {
+	if role != "admin" && role != "sale_admin" {
+		return models.Stocktake{}, ErrNotApprover
+	}
+
+	var st models.Stocktake
+	err := s.db.RPC(ctx, "approve_stocktake", map[string]interface{}{
+		"p_stocktake_id": id,
+		"p_user_id":      nullable(userID),
+	}, &st)
+	if err != nil {
+		return models.Stocktake{}, stocktakeError(err)
+	}
+
+	st, err = s.Get(id)
+	if err != nil {
+		return models.Stocktake{}, err
+	}
+
+	var adjusted []int
+	for _, line := range st.Lines {
+		if line.AdjustedQuantity != nil && *line.AdjustedQuantity != 0 {
+			adjusted = append(adjusted, line.ProductID)
+		}
+	}
+	if len(adjusted) > 0 {
+		s.ledger.Changed(adjusted...)
+	}
+	return st, nil
+}
+
+// Cancel discards an open stocktake without posting anything
+func (s *Stocktakes) Cancel(id string) (models.Stocktake, error) {
+	var updated []models.Stocktake
+	_, err := s.db.Client.From("stocktakes").
+		Update(map[string]interface{}{"status": StocktakeCancelled}, "representation", "").
+		Eq("id", id).
+		Eq("status", StocktakeOpen).
+		ExecuteTo(&updated)
+	if err != nil {
+		return models.Stocktake{}, err
+	}
+	if len(updated) == 0 {
+		return models.Stocktake{}, ErrStocktakeClosed
+	}
+	return updated[0], nil
+}
+
+// Shrinkage returns losses and gains from count corrections, damage, expiry
+// and loss between from and to, grouped by period, product and reason
+func (s *Stocktakes) Shrinkage(ctx context.Context, from, to time.Time, period string, warehouseIDs []string) ([]models.ShrinkageRow, error) {
+	var ids interface{}
+	if len(warehouseIDs) > 0 {
+		ids = warehouseIDs
+	}
+
+	rows := []models.ShrinkageRow{}
+	err := s.db.RPC(ctx, "shrinkage_report", map[string]interface{}{
+		"p_from":          from.Format(time.RFC3339),
+		"p_to":            to.Format(time.RFC3339),
+		"p_period":        period,
+		"p_warehouse_ids": ids,
+	}, &rows)
+	return rows, err
+}
+
+func stocktakeError(err error) error {
+	var rpcErr *database.RPCError
+	if !errors.As(err, &rpcErr) {
+		return err
+	}
+
+	switch msg := rpcErr.Message; {
+	case strings.Contains(msg, "already open"):
+		return ErrStocktakeOpen
+	case strings.Contains(msg, "invalid stocktake status"):
+		return ErrStocktakeClosed
+	case strings.HasPrefix(msg, "stocktake") && strings.Contains(msg, "not found"):
+		return ErrStocktakeNotFound
+	case strings.HasPrefix(msg, "permission denied"):
+		// The stocktake is not of the counter's warehouses
+		return ErrStocktakeNotFound
+	}
+	return postingError(err)
+}
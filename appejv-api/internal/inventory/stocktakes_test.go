@@ -0,0 +1,130 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/appejv/appejv-api/internal/config"
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/pkg/database"
+)
+
+// fakeStocktakeDB serves stocktake st-1 of warehouse w-1 and records the
+// parameters of every function call, by name
+type fakeStocktakeDB struct {
+	*httptest.Server
+	calls map[string]map[string]interface{}
+}
+
+func newFakeStocktakeDB(t *testing.T) (*fakeStocktakeDB, *Stocktakes) {
+	f := &fakeStocktakeDB{calls: make(map[string]map[string]interface{})}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if name := strings.TrimPrefix(r.URL.Path, "/rest/v1/rpc/"); name != r.URL.Path {
+			var params map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&params)
+			f.calls[name] = params
+
+			// Like the database, refuse a count outside the caller's warehouses
+			if ids, ok := params["p_warehouse_ids"].([]interface{}); ok && !containsValue(ids, "w-1") {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"message": "permission denied: stocktake is not of the caller's warehouses"})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": "st-1", "warehouse_id": "w-1", "status": "open", "stocktake_id": "st-1", "product_id": 1, "expected_quantity": 5})
+			return
+		}
+		switch r.URL.Path {
+		case "/rest/v1/stocktakes":
+			_ = json.NewEncoder(w).Encode([]map[string]interface{}{{"id": "st-1", "warehouse_id": "w-1", "status": "approved"}})
+		default:
+			_ = json.NewEncoder(w).Encode([]interface{}{})
+		}
+	}))
+	t.Cleanup(f.Close)
+
+	db, _ := database.NewSupabaseClient(&config.Config{SupabaseURL: f.URL, SupabaseAnonKey: "service"})
+	return f, NewStocktakes(db, NewLedger(db))
+}
+
+func containsValue(list []interface{}, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestApproveStocktakeRole(t *testing.T) {
+	f, stocktakes := newFakeStocktakeDB(t)
+	ctx := context.Background()
+
+	for _, role := range []string{"warehouse", "sale", "customer", ""} {
+		if _, err := stocktakes.Approve(ctx, "st-1", "user-1", role); !errors.Is(err, ErrNotApprover) {
+			t.Errorf("Approve as %q = %v, want ErrNotApprover", role, err)
+		}
+	}
+	if _, ok := f.calls["approve_stocktake"]; ok {
+		t.Fatal("approve_stocktake was called for a user who may not approve")
+	}
+
+	if _, err := stocktakes.Approve(ctx, "st-1", "admin-1", "admin"); err != nil {
+		t.Fatalf("Approve as admin: %v", err)
+	}
+	if got := f.calls["approve_stocktake"]["p_user_id"]; got != "admin-1" {
+		t.Errorf("approved by %v, want admin-1", got)
+	}
+}
+
+func TestStartStocktakeScope(t *testing.T) {
+	f, stocktakes := newFakeStocktakeDB(t)
+	ctx := context.Background()
+
+	if _, err := stocktakes.Start(ctx, "w-2", "", "user-1", Scope{IDs: []string{"w-1"}}); !errors.Is(err, ErrWarehouseNotFound) {
+		t.Errorf("Start outside the scope = %v, want ErrWarehouseNotFound", err)
+	}
+	if _, ok := f.calls["start_stocktake"]; ok {
+		t.Fatal("start_stocktake was called for a warehouse outside the scope")
+	}
+
+	if _, err := stocktakes.Start(ctx, "w-1", "", "user-1", Scope{IDs: []string{"w-1"}}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if got := f.calls["start_stocktake"]["p_user_id"]; got != "user-1" {
+		t.Errorf("started by %v, want user-1", got)
+	}
+}
+
+func TestRecordCountScope(t *testing.T) {
+	f, stocktakes := newFakeStocktakeDB(t)
+	ctx := context.Background()
+	count := models.StocktakeCount{ProductID: 1, Quantity: 4}
+
+	if _, err := stocktakes.RecordCount(ctx, "st-1", count, "manual", "user-1", Scope{All: true}); err != nil {
+		t.Fatalf("RecordCount: %v", err)
+	}
+	if _, ok := f.calls["record_stocktake_count"]["p_warehouse_ids"]; ok {
+		t.Error("a scope of every warehouse sent p_warehouse_ids")
+	}
+
+	if _, err := stocktakes.RecordCount(ctx, "st-1", count, "manual", "user-1", Scope{IDs: []string{"w-1"}}); err != nil {
+		t.Fatalf("RecordCount in the counter's warehouse: %v", err)
+	}
+	if got := f.calls["record_stocktake_count"]["p_user_id"]; got != "user-1" {
+		t.Errorf("counted by %v, want user-1", got)
+	}
+
+	// No warehouses sends an empty list, which allows none, not null
+	if _, err := stocktakes.RecordCount(ctx, "st-1", count, "manual", "user-1", Scope{}); !errors.Is(err, ErrStocktakeNotFound) {
+		t.Errorf("RecordCount without warehouses = %v, want ErrStocktakeNotFound", err)
+	}
+	if ids, ok := f.calls["record_stocktake_count"]["p_warehouse_ids"].([]interface{}); !ok || len(ids) != 0 {
+		t.Errorf("p_warehouse_ids = %v, want an empty list", f.calls["record_stocktake_count"]["p_warehouse_ids"])
+	}
+}
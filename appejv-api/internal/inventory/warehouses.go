@@ -0,0 +1,366 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/appejv/appejv-api/internal/models"
+	"github.com/appejv/appejv-api/pkg/database"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// Transfer statuses
+const (
+	TransferDraft     = "draft"
+	TransferInTransit = "in_transit"
+	TransferReceived  = "received"
+	TransferCancelled = "cancelled"
+)
+
+var (
+	ErrTransferNotFound   = errors.New("transfer not found")
+	ErrInvalidTransfer    = errors.New("invalid transfer status")
+	ErrNoWarehouseInStock = errors.New("no warehouse has enough stock for the whole order")
+)
+
+// Scope is the set of warehouses a user may act on
+type Scope struct {
+	All bool
+	IDs []string
+}
+
+// Allows reports whether the scope includes warehouseID
+func (s Scope) Allows(warehouseID string) bool {
+	if s.All {
+		return true
+	}
+	for _, id := range s.IDs {
+		if id == warehouseID {
+			return true
+		}
+	}
+	return false
+}
+
+// Warehouses manages depots, per-warehouse stock and transfers
+type Warehouses struct {
+	db     *database.Database
+	ledger *Ledger
+}
+
+func NewWarehouses(db *database.Database, ledger *Ledger) *Warehouses {
+	return &Warehouses{db: db, ledger: ledger}
+}
+
+// List returns all warehouses, restricted to the scope
+func (w *Warehouses) List(scope Scope) ([]models.Warehouse, error) {
+	query := w.db.Client.From("warehouses").Select("*", "", false)
+	if !scope.All {
+		if len(scope.IDs) == 0 {
+			return []models.Warehouse{}, nil
+		}
+		query = query.In("id", scope.IDs)
+	}
+
+	warehouses := []models.Warehouse{}
+	_, err := query.Order("code", &postgrest.OrderOpts{Ascending: true}).ExecuteTo(&warehouses)
+	return warehouses, err
+}
+
+// Get returns one warehouse
+func (w *Warehouses) Get(id string) (models.Warehouse, error) {
+	var warehouses []models.Warehouse
+	_, err := w.db.Client.From("warehouses").
+		Select("*", "", false).
+		Eq("id", id).
+		Limit(1, "").
+		ExecuteTo(&warehouses)
+	if err != nil {
+		return models.Warehouse{}, err
+	}
+	if len(warehouses) == 0 {
+		return models.Warehouse{}, ErrWarehouseNotFound
+	}
+	return warehouses[0], nil
+}
+
+// ScopeFor returns the warehouses a user may act on. Warehouse-role users
+// are limited to the depots they are assigned to; other staff see all.
+func (w *Warehouses) ScopeFor(userID, role string) (Scope, error) {
+	if role != "warehouse" {
+		return Scope{All: true}, nil
+	}
+
+	var rows []struct {
+		WarehouseID string `json:"warehouse_id"`
+	}
+	_, err := w.db.Client.From("warehouse_users").
+		Select("warehouse_id", "", false).
+		Eq("user_id", userID).
+		ExecuteTo(&rows)
+	if err != nil {
+		return Scope{}, err
+	}
+
+	scope := Scope{IDs: make([]string, 0, len(rows))}
+	for _, r := range rows {
+		scope.IDs = append(scope.IDs, r.WarehouseID)
+	}
+	return scope, nil
+}
+
+// AssignUsers replaces the users assigned to a warehouse
+func (w *Warehouses) AssignUsers(warehouseID string, userIDs []string) error {
+	_, _, err := w.db.Client.From("warehouse_users").
+		Delete("minimal", "").
+		Eq("warehouse_id", warehouseID).
+		Execute()
+	if err != nil || len(userIDs) == 0 {
+		return err
+	}
+
+	rows := make([]map[string]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		rows = append(rows, map[string]string{"warehouse_id": warehouseID, "user_id": id})
+	}
+	_, _, err = w.db.Client.From("warehouse_users").
+		Insert(rows, false, "", "minimal", "").
+		Execute()
+	return err
+}
+
+// Stock returns the on-hand stock of a warehouse
+func (w *Warehouses) Stock(warehouseID string) ([]models.WarehouseStock, error) {
+	stock := []models.WarehouseStock{}
+	_, err := w.db.Client.From("warehouse_stock").
+		Select("*", "", false).
+		Eq("warehouse_id", warehouseID).
+		Gt("quantity", "0").
+		Order("product_id", &postgrest.OrderOpts{Ascending: true}).
+		ExecuteTo(&stock)
+	return stock, err
+}
+
+// Nearest returns the closest active warehouse that holds enough stock for
+// every product in needs (product id -> base quantity). Without a location
+// the default warehouse is preferred.
+func (w *Warehouses) Nearest(lat, lng *float64, needs map[int]int) (models.Warehouse, error) {
+	warehouses, err := w.List(Scope{All: true})
+	if err != nil {
+		return models.Warehouse{}, err
+	}
+
+	productIDs := make([]string, 0, len(needs))
+	for id := range needs {
+		productIDs = append(productIDs, strconv.Itoa(id))
+	}
+
+	var stock []models.WarehouseStock
+	if len(productIDs) > 0 {
+		_, err = w.db.Client.From("warehouse_stock").
+			Select("*", "", false).
+			In("product_id", productIDs).
+			ExecuteTo(&stock)
+		if err != nil {
+			return models.Warehouse{}, err
+		}
+	}
+
+	onHand := make(map[string]map[int]int)
+	for _, s := range stock {
+		if onHand[s.WarehouseID] == nil {
+			onHand[s.WarehouseID] = make(map[int]int)
+		}
+		onHand[s.WarehouseID][s.ProductID] = s.Quantity
+	}
+
+	var best *models.Warehouse
+	bestDistance := math.Inf(1)
+	for i, wh := range warehouses {
+		if wh.Status != "active" || !covers(onHand[wh.ID], needs) {
+			continue
+		}
+
+		d := distance(lat, lng, wh)
+		if d < bestDistance || (d == bestDistance && wh.IsDefault) {
+			best, bestDistance = &warehouses[i], d
+		}
+	}
+
+	if best == nil {
+		return models.Warehouse{}, ErrNoWarehouseInStock
+	}
+	return *best, nil
+}
+
+// CreateTransfer creates a draft transfer with its items
+func (w *Warehouses) CreateTransfer(req models.CreateStockTransferRequest, userID string) (models.StockTransfer, error) {
+	transfer := map[string]interface{}{
+		"from_warehouse_id": req.FromWarehouseID,
+		"to_warehouse_id":   req.ToWarehouseID,
+		"status":            TransferDraft,
+		"note":              req.Note,
+		"created_by":        nullable(userID),
+	}
+
+	var created []models.StockTransfer
+	_, err := w.db.Client.From("stock_transfers").
+		Insert(transfer, false, "", "representation", "").
+		ExecuteTo(&created)
+	if err != nil {
+		return models.StockTransfer{}, err
+	}
+	if len(created) == 0 {
+		return models.StockTransfer{}, errors.New("failed to create transfer")
+	}
+	t := created[0]
+
+	items := make([]models.StockTransferItem, 0, len(req.Items))
+	for _, item := range req.Items {
+		items = append(items, models.StockTransferItem{
+			TransferID: t.ID,
+			ProductID:  item.ProductID,
+			Quantity:   item.Quantity,
+		})
+	}
+
+	_, err = w.db.Client.From("stock_transfer_items").
+		Insert(items, false, "", "representation", "").
+		ExecuteTo(&t.Items)
+	if err != nil {
+		w.db.Client.From("stock_transfers").Delete("minimal", "").Eq("id", t.ID).Execute()
+		return models.StockTransfer{}, err
+	}
+
+	return t, nil
+}
+
+// GetTransfer returns a transfer with its items
+func (w *Warehouses) GetTransfer(id string) (models.StockTransfer, error) {
+	var transfers []models.StockTransfer
+	_, err := w.db.Client.From("stock_transfers").
+		Select("*, items:stock_transfer_items(*)", "", false).
+		Eq("id", id).
+		Limit(1, "").
+		ExecuteTo(&transfers)
+	if err != nil {
+		return models.StockTransfer{}, err
+	}
+	if len(transfers) == 0 {
+		return models.StockTransfer{}, ErrTransferNotFound
+	}
+	return transfers[0], nil
+}
+
+// ListTransfers returns transfers touching the scope's warehouses, newest first
+func (w *Warehouses) ListTransfers(scope Scope, status string) ([]models.StockTransfer, error) {
+	query := w.db.Client.From("stock_transfers").Select("*, items:stock_transfer_items(*)", "", false)
+	if status != "" {
+		query = query.Eq("status", status)
+	}
+	if !scope.All {
+		if len(scope.IDs) == 0 {
+			return []models.StockTransfer{}, nil
+		}
+		ids := strings.Join(scope.IDs, ",")
+		query = query.Or("from_warehouse_id.in.("+ids+"),to_warehouse_id.in.("+ids+")", "")
+	}
+
+	transfers := []models.StockTransfer{}
+	_, err := query.Order("created_at", &postgrest.OrderOpts{Ascending: false}).ExecuteTo(&transfers)
+	return transfers, err
+}
+
+// ShipTransfer moves a draft transfer in transit, taking stock out of the
+// source warehouse
+func (w *Warehouses) ShipTransfer(ctx context.Context, id, userID string) (models.StockTransfer, error) {
+	return w.transition(ctx, "ship_stock_transfer", id, userID)
+}
+
+// ReceiveTransfer completes an in-transit transfer, adding stock to the
+// destination warehouse
+func (w *Warehouses) ReceiveTransfer(ctx context.Context, id, userID string) (models.StockTransfer, error) {
+	return w.transition(ctx, "receive_stock_transfer", id, userID)
+}
+
+// CancelTransfer cancels a draft transfer. In-transit transfers must be
+// received; goods can then be transferred back.
+func (w *Warehouses) CancelTransfer(id string) (models.StockTransfer, error) {
+	var updated []models.StockTransfer
+	_, err := w.db.Client.From("stock_transfers").
+		Update(map[string]interface{}{"status": TransferCancelled}, "representation", "").
+		Eq("id", id).
+		Eq("status", TransferDraft).
+		ExecuteTo(&updated)
+	if err != nil {
+		return models.StockTransfer{}, err
+	}
+	if len(updated) == 0 {
+		return models.StockTransfer{}, ErrInvalidTransfer
+	}
+	return updated[0], nil
+}
+
+func (w *Warehouses) transition(ctx context.Context, fn, id, userID string) (models.StockTransfer, error) {
+	var t models.StockTransfer
+	err := w.db.RPC(ctx, fn, map[string]interface{}{
+		"p_transfer_id": id,
+		"p_user_id":     nullable(userID),
+	}, &t)
+	if err != nil {
+		var rpcErr *database.RPCError
+		if errors.As(err, &rpcErr) {
+			switch {
+			case strings.Contains(rpcErr.Message, "insufficient stock"):
+				return models.StockTransfer{}, ErrInsufficientStock
+			case strings.Contains(rpcErr.Message, "invalid transfer status"):
+				return models.StockTransfer{}, ErrInvalidTransfer
+			case strings.Contains(rpcErr.Message, "not found"):
+				return models.StockTransfer{}, ErrTransferNotFound
+			}
+		}
+		return models.StockTransfer{}, err
+	}
+
+	t, err = w.GetTransfer(t.ID)
+	if err != nil {
+		return models.StockTransfer{}, err
+	}
+
+	// Goods in transit are not on hand, so totals change on both steps
+	productIDs := make([]int, 0, len(t.Items))
+	for _, item := range t.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	w.ledger.Changed(productIDs...)
+	return t, nil
+}
+
+func covers(onHand map[int]int, needs map[int]int) bool {
+	for productID, qty := range needs {
+		if onHand[productID] < qty {
+			return false
+		}
+	}
+	return true
+}
+
+// distance returns the great-circle distance in km between a point and a
+// warehouse. Unknown locations sort last.
+func distance(lat, lng *float64, wh models.Warehouse) float64 {
+	if lat == nil || lng == nil || wh.Latitude == nil || wh.Longitude == nil {
+		return math.MaxFloat64
+	}
+
+	const earthRadiusKm = 6371.0
+	rad := func(d float64) float64 { return d * math.Pi / 180 }
+
+	dLat := rad(*wh.Latitude - *lat)
+	dLng := rad(*wh.Longitude - *lng)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(*lat))*math.Cos(rad(*wh.Latitude))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
@@ -0,0 +1,19 @@
+package mailer
+
+import (
+	"context"
+	"log"
+)
+
+// LogMailer writes emails to the server log instead of sending them. For
+// development only: reset links end up in the log.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("📧 To: %s | Subject: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
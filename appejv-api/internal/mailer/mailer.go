@@ -0,0 +1,44 @@
+// Package mailer sends transactional email: rendered from localized
+// templates, queued in the database outbox and delivered by a pluggable
+// backend (SMTP, files or the log).
+package mailer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/appejv/appejv-api/internal/config"
+)
+
+// Message is a single email
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the mailer selected by cfg.MailBackend
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailBackend {
+	case "log", "":
+		return NewLogMailer(), nil
+	case "file":
+		return NewFileMailer(cfg.MailDir, cfg.MailFrom)
+	case "smtp":
+		return NewSMTPMailer(SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		})
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q", cfg.MailBackend)
+	}
+}
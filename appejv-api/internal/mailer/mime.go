@@ -0,0 +1,84 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// buildMIME encodes msg as an RFC 5322 message: multipart/alternative when
+// it has both a text and an HTML body, bodies base64 encoded as UTF-8
+func buildMIME(from string, msg Message) ([]byte, error) {
+	var b bytes.Buffer
+
+	header := func(k, v string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	header("From", from)
+	header("To", msg.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(from))
+	header("MIME-Version", "1.0")
+
+	switch {
+	case msg.HTML != "" && msg.Text != "":
+		boundary, err := randomHex(12)
+		if err != nil {
+			return nil, err
+		}
+		header("Content-Type", `multipart/alternative; boundary="`+boundary+`"`)
+		b.WriteString("\r\n")
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain", msg.Text},
+			{"text/html", msg.HTML},
+		} {
+			fmt.Fprintf(&b, "--%s\r\n", boundary)
+			writePart(&b, part.contentType, part.body)
+		}
+		fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	case msg.HTML != "":
+		writePart(&b, "text/html", msg.HTML)
+	default:
+		writePart(&b, "text/plain", msg.Text)
+	}
+
+	return b.Bytes(), nil
+}
+
+func writePart(b *bytes.Buffer, contentType, body string) {
+	fmt.Fprintf(b, "Content-Type: %s; charset=utf-8\r\n", contentType)
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(addr.Address, "@"); at >= 0 {
+			domain = addr.Address[at+1:]
+		}
+	}
+	id, _ := randomHex(16)
+	return "<" + id + "@" + domain + ">"
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,174 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/appejv/appejv-api/pkg/database"
+)
+
+const (
+	// batchSize is how many due emails a worker leases at once
+	batchSize = 20
+	// lease is how long a leased email is left alone before another worker
+	// may retry it
+	lease = 5 * time.Minute
+	// Retries back off exponentially from minBackoff up to maxBackoff
+	minBackoff = time.Minute
+	maxBackoff = 6 * time.Hour
+)
+
+// Email is a queued email: a template rendered with Data in Locale.
+// Sensitive data, such as a reset link, is erased from the outbox once the
+// email has been sent or given up on.
+type Email struct {
+	To        string
+	Template  string
+	Locale    string
+	Data      map[string]interface{}
+	Sensitive bool
+}
+
+// Outbox queues emails in the email_outbox table and delivers them in the
+// background, retrying failed sends with backoff. Enqueueing only writes a
+// row, so a mail server outage never fails the request that sent the email.
+type Outbox struct {
+	db        *database.Database
+	mailer    Mailer
+	templates *Templates
+	interval  time.Duration
+	wake      chan struct{}
+}
+
+// NewOutbox delivers queued emails with m, polling every interval for
+// retries and emails queued by database triggers
+func NewOutbox(db *database.Database, m Mailer, templates *Templates, interval time.Duration) *Outbox {
+	return &Outbox{
+		db:        db,
+		mailer:    m,
+		templates: templates,
+		interval:  interval,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Enqueue queues an email and wakes the worker
+func (o *Outbox) Enqueue(ctx context.Context, email Email) error {
+	if !o.templates.Has(email.Template) {
+		return fmt.Errorf("unknown email template %q", email.Template)
+	}
+	if email.Locale == "" {
+		email.Locale = DefaultLocale
+	}
+
+	err := o.db.RPC(ctx, "enqueue_email", map[string]interface{}{
+		"p_to":        email.To,
+		"p_template":  email.Template,
+		"p_locale":    email.Locale,
+		"p_data":      email.Data,
+		"p_sensitive": email.Sensitive,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers due emails until ctx is cancelled
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+
+	o.deliver(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.deliver(ctx)
+		case <-o.wake:
+			o.deliver(ctx)
+		}
+	}
+}
+
+type outboxEmail struct {
+	ID          string                 `json:"id"`
+	ToAddress   string                 `json:"to_address"`
+	Template    string                 `json:"template"`
+	Locale      string                 `json:"locale"`
+	Data        map[string]interface{} `json:"data"`
+	Attempts    int                    `json:"attempts"`
+	MaxAttempts int                    `json:"max_attempts"`
+}
+
+// deliver sends due emails batch by batch until none are left
+func (o *Outbox) deliver(ctx context.Context) {
+	for ctx.Err() == nil {
+		var due []outboxEmail
+		err := o.db.RPC(ctx, "claim_email_outbox", map[string]interface{}{
+			"p_limit":         batchSize,
+			"p_lease_seconds": int(lease.Seconds()),
+		}, &due)
+		if err != nil {
+			log.Printf("email outbox: claim failed: %v", err)
+			return
+		}
+
+		for _, email := range due {
+			o.send(ctx, email)
+		}
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+func (o *Outbox) send(ctx context.Context, email outboxEmail) {
+	err := o.render(ctx, email)
+
+	params := map[string]interface{}{"p_id": email.ID}
+	if err != nil {
+		params["p_error"] = err.Error()
+		params["p_retry_seconds"] = int(backoff(email.Attempts).Seconds())
+		if email.Attempts >= email.MaxAttempts {
+			log.Printf("email outbox: giving up on %s to %s after %d attempts: %v", email.Template, email.ToAddress, email.Attempts, err)
+		}
+	}
+
+	if err := o.db.RPC(ctx, "complete_email", params, nil); err != nil {
+		// The lease runs out and the email is retried
+		log.Printf("email outbox: recording result of %s failed: %v", email.ID, err)
+	}
+}
+
+func (o *Outbox) render(ctx context.Context, email outboxEmail) error {
+	msg, err := o.templates.Render(email.Template, email.Locale, email.ToAddress, email.Data)
+	if err != nil {
+		return err
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	return o.mailer.Send(sendCtx, msg)
+}
+
+// backoff is the delay before retrying an email that failed its attempt-th
+// send: 1, 2, 4, ... minutes, at most maxBackoff
+func backoff(attempt int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
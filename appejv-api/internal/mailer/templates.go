@@ -0,0 +1,166 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates/*.html templates/*.txt
+var templateFS embed.FS
+
+// Template names
+const (
+	PasswordReset      = "password_reset"
+	Invitation         = "invitation"
+	OrderConfirmation  = "order_confirmation"
+	ShipmentDispatched = "shipment_dispatched"
+)
+
+// Locales. Emails in an unknown locale are sent in DefaultLocale.
+const (
+	Vietnamese    = "vi"
+	English       = "en"
+	DefaultLocale = Vietnamese
+)
+
+// Templates renders emails from <name>.<locale>.txt and .html. The first
+// line of the text template is "Subject: ..."; the HTML version is optional.
+type Templates struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+func NewTemplates() (*Templates, error) {
+	html, err := htmltemplate.New("emails").Funcs(htmltemplate.FuncMap(funcs)).ParseFS(templateFS, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	text, err := texttemplate.New("emails").Funcs(funcs).ParseFS(templateFS, "templates/*.txt")
+	if err != nil {
+		return nil, err
+	}
+	return &Templates{html: html, text: text}, nil
+}
+
+// Has reports whether the template exists in the default locale
+func (t *Templates) Has(name string) bool {
+	return t.text.Lookup(name+"."+DefaultLocale+".txt") != nil
+}
+
+// Locale picks a supported locale from an Accept-Language header value
+func Locale(acceptLanguage string) string {
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		switch {
+		case strings.HasPrefix(tag, Vietnamese):
+			return Vietnamese
+		case strings.HasPrefix(tag, English):
+			return English
+		}
+	}
+	return DefaultLocale
+}
+
+// Render builds the email to to from the template in locale
+func (t *Templates) Render(name, locale, to string, data map[string]interface{}) (Message, error) {
+	file := name + "." + locale + ".txt"
+	if t.text.Lookup(file) == nil {
+		locale = DefaultLocale
+		file = name + "." + locale + ".txt"
+	}
+	if t.text.Lookup(file) == nil {
+		return Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var text bytes.Buffer
+	if err := t.text.ExecuteTemplate(&text, file, data); err != nil {
+		return Message{}, err
+	}
+	subject, body, ok := strings.Cut(text.String(), "\n")
+	if !ok || !strings.HasPrefix(subject, "Subject:") {
+		return Message{}, fmt.Errorf("email template %s has no subject line", file)
+	}
+
+	msg := Message{
+		To:      to,
+		Subject: strings.TrimSpace(strings.TrimPrefix(subject, "Subject:")),
+		Text:    strings.TrimLeft(body, "\n"),
+	}
+
+	htmlFile := strings.TrimSuffix(file, path.Ext(file)) + ".html"
+	if t.html.Lookup(htmlFile) != nil {
+		var html bytes.Buffer
+		if err := t.html.ExecuteTemplate(&html, htmlFile, data); err != nil {
+			return Message{}, err
+		}
+		msg.HTML = html.String()
+	}
+	return msg, nil
+}
+
+var funcs = texttemplate.FuncMap{
+	"money":    money,
+	"date":     date,
+	"quantity": quantity,
+}
+
+// money formats a VND amount as 1.234.567 ₫
+func money(v interface{}) string {
+	n, ok := number(v)
+	if !ok {
+		return ""
+	}
+	s := strconv.FormatInt(int64(math.Round(n)), 10)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(r)
+	}
+	if neg {
+		return "-" + b.String() + " ₫"
+	}
+	return b.String() + " ₫"
+}
+
+// date formats a YYYY-MM-DD date as DD/MM/YYYY
+func date(v interface{}) string {
+	s, _ := v.(string)
+	if d, err := time.Parse("2006-01-02", s); err == nil {
+		return d.Format("02/01/2006")
+	}
+	return s
+}
+
+func quantity(v interface{}) string {
+	n, ok := number(v)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
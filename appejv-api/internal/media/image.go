@@ -0,0 +1,152 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Allowed upload content types and the file extension stored for each
+var AllowedImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrTooLarge        = errors.New("image exceeds maximum upload size")
+	ErrInvalidImage    = errors.New("file is not a valid image")
+	ErrTooManyPixels   = errors.New("image dimensions exceed the maximum")
+)
+
+// MaxPixels bounds the width × height of an upload. A few kilobytes of
+// compressed image can claim dimensions that take gigabytes to decode, so
+// the dimensions are checked before the pixels are.
+const MaxPixels = 25_000_000
+
+// Variant describes a generated rendition of an uploaded image
+type Variant struct {
+	Name    string
+	MaxSize int  // longest edge in pixels
+	Quality int  // JPEG quality
+	Square  bool // crop to the centred square first
+}
+
+// Variants generated for every uploaded product image
+var (
+	Thumbnail = Variant{Name: "thumb", MaxSize: 300, Quality: 80}
+	Web       = Variant{Name: "web", MaxSize: 1200, Quality: 85}
+)
+
+// Avatar is the only rendition kept of an uploaded profile picture
+var Avatar = Variant{Name: "avatar", MaxSize: 256, Quality: 85, Square: true}
+
+// Image is a validated, decoded upload
+type Image struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+
+	img image.Image
+}
+
+// DecodeImage validates data by sniffing its MIME type (the client supplied
+// Content-Type is not trusted), size and dimensions, then decodes it. A
+// maxSize of zero or less means no size limit.
+func DecodeImage(data []byte, maxSize int64) (*Image, error) {
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := AllowedImageTypes[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, fmt.Errorf("%w: %d×%d", ErrTooManyPixels, cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	bounds := img.Bounds()
+	return &Image{
+		Data:        data,
+		ContentType: contentType,
+		Ext:         ext,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		img:         img,
+	}, nil
+}
+
+// Render scales the image to fit within v.MaxSize (never upscaling) and
+// encodes it as JPEG. Transparent areas are flattened onto white.
+func (i *Image) Render(v Variant) ([]byte, error) {
+	src := i.img.Bounds()
+	if v.Square {
+		src = centredSquare(src)
+	}
+	w, h := fit(src.Dx(), src.Dy(), v.MaxSize)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), i.img, src, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: v.Quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// centredSquare returns the largest square in the middle of r
+func centredSquare(r image.Rectangle) image.Rectangle {
+	side := r.Dx()
+	if r.Dy() < side {
+		side = r.Dy()
+	}
+	min := image.Pt(r.Min.X+(r.Dx()-side)/2, r.Min.Y+(r.Dy()-side)/2)
+	return image.Rectangle{Min: min, Max: min.Add(image.Pt(side, side))}
+}
+
+// fit returns the dimensions of a w×h image scaled so its longest edge is
+// at most max, preserving aspect ratio
+func fit(w, h, max int) (int, int) {
+	if w <= max && h <= max {
+		return w, h
+	}
+	if w >= h {
+		return max, maxInt(1, h*max/w)
+	}
+	return maxInt(1, w*max/h), max
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,128 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader is the start of a PNG claiming w×h pixels: enough for its
+// type and dimensions to be read, without the pixels
+func pngHeader(w, h uint32) []byte {
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[0:], w)
+	binary.BigEndian.PutUint32(ihdr[4:], h)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 0 // greyscale
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr[:]...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestDecodeImage(t *testing.T) {
+	img, err := DecodeImage(encodePNG(t, 40, 20), 1<<20)
+	if err != nil {
+		t.Fatalf("DecodeImage: %v", err)
+	}
+	if img.ContentType != "image/png" || img.Ext != ".png" || img.Width != 40 || img.Height != 20 {
+		t.Errorf("DecodeImage = %s %s %d×%d", img.ContentType, img.Ext, img.Width, img.Height)
+	}
+
+	// No size limit
+	if _, err := DecodeImage(encodePNG(t, 40, 20), 0); err != nil {
+		t.Errorf("DecodeImage without a limit: %v", err)
+	}
+}
+
+func TestDecodeImageRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		max  int64
+		want error
+	}{
+		{"too large", encodePNG(t, 40, 20), 10, ErrTooLarge},
+		{"not an image", []byte("%PDF-1.4 not an image"), 1 << 20, ErrUnsupportedType},
+		{"truncated", encodePNG(t, 40, 20)[:60], 1 << 20, ErrInvalidImage},
+		{"decompression bomb", pngHeader(100_000, 100_000), 1 << 20, ErrTooManyPixels},
+		{"just over the pixel limit", pngHeader(5001, 5000), 1 << 20, ErrTooManyPixels},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeImage(tt.data, tt.max); !errors.Is(err, tt.want) {
+				t.Errorf("DecodeImage = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	img, err := DecodeImage(encodePNG(t, 600, 300), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		variant Variant
+		w, h    int
+	}{
+		{Thumbnail, 300, 150},
+		{Web, 600, 300}, // never upscaled
+		{Avatar, 256, 256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.variant.Name, func(t *testing.T) {
+			data, err := img.Render(tt.variant)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			out, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("rendition is not a JPEG: %v", err)
+			}
+			if b := out.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+				t.Errorf("rendition is %d×%d, want %d×%d", b.Dx(), b.Dy(), tt.w, tt.h)
+			}
+		})
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct{ w, h, max, wantW, wantH int }{
+		{100, 50, 300, 100, 50},
+		{1200, 600, 300, 300, 150},
+		{600, 1200, 300, 150, 300},
+		{3000, 1, 300, 300, 1},
+	}
+	for _, tt := range tests {
+		if w, h := fit(tt.w, tt.h, tt.max); w != tt.wantW || h != tt.wantH {
+			t.Errorf("fit(%d, %d, %d) = %d, %d; want %d, %d", tt.w, tt.h, tt.max, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
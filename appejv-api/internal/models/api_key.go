@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// APIKey is an integration's key as administrators see it; the key itself
+// is only shown when it is created or rotated
+type APIKey struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// CustomerID and WarehouseID, if set, confine the key to that customer
+	// or warehouse
+	CustomerID  *string `json:"customer_id"`
+	WarehouseID *string `json:"warehouse_id"`
+	// CreatedBy is the admin the key acts for
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	// PreviousExpiresAt is when the secret replaced by the last rotation
+	// stops working
+	PreviousExpiresAt *time.Time `json:"previous_expires_at"`
+	LastUsedAt        *time.Time `json:"last_used_at"`
+	LastUsedIP        *string    `json:"last_used_ip"`
+	RotatedAt         *time.Time `json:"rotated_at"`
+	RevokedAt         *time.Time `json:"revoked_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// APIKeyWithSecret is a new or rotated key with the secret to hand to the
+// integration
+type APIKeyWithSecret struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKeyRequest issues a key with scopes such as "order:read" and
+// "inventory:create"
+type CreateAPIKeyRequest struct {
+	Name        string     `json:"name"`
+	Scopes      []string   `json:"scopes"`
+	CustomerID  *string    `json:"customer_id"`
+	WarehouseID *string    `json:"warehouse_id"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// RotateAPIKeyRequest keeps the old secret working for GraceHours
+type RotateAPIKeyRequest struct {
+	GraceHours int `json:"grace_hours"`
+}
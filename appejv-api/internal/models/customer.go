@@ -9,21 +9,27 @@ type Customer struct {
 	Address      *string    `json:"address,omitempty"`
 	Phone        *string    `json:"phone,omitempty"`
 	AssignedSale *string    `json:"assigned_sale,omitempty"`
+	Latitude     *float64   `json:"latitude,omitempty"`
+	Longitude    *float64   `json:"longitude,omitempty"`
 	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
 	CreatedAt    time.Time  `json:"created_at"`
 }
 
 type CreateCustomerRequest struct {
-	Code         string  `json:"code" binding:"required"`
-	Name         string  `json:"name" binding:"required"`
-	Address      *string `json:"address"`
-	Phone        *string `json:"phone"`
-	AssignedSale *string `json:"assigned_sale"`
+	Code         string   `json:"code" binding:"required"`
+	Name         string   `json:"name" binding:"required"`
+	Address      *string  `json:"address"`
+	Phone        *string  `json:"phone"`
+	AssignedSale *string  `json:"assigned_sale"`
+	Latitude     *float64 `json:"latitude"`
+	Longitude    *float64 `json:"longitude"`
 }
 
 type UpdateCustomerRequest struct {
-	Name         *string `json:"name"`
-	Address      *string `json:"address"`
-	Phone        *string `json:"phone"`
-	AssignedSale *string `json:"assigned_sale"`
+	Name         *string  `json:"name"`
+	Address      *string  `json:"address"`
+	Phone        *string  `json:"phone"`
+	AssignedSale *string  `json:"assigned_sale"`
+	Latitude     *float64 `json:"latitude"`
+	Longitude    *float64 `json:"longitude"`
 }
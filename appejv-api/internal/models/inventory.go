@@ -0,0 +1,120 @@
+package models
+
+import "time"
+
+type StockMovement struct {
+	ID            string    `json:"id"`
+	ProductID     int       `json:"product_id"`
+	WarehouseID   *string   `json:"warehouse_id,omitempty"`
+	LotID         *string   `json:"lot_id,omitempty"`
+	MovementType  string    `json:"movement_type"`
+	Quantity      int       `json:"quantity"`
+	BalanceAfter  int       `json:"balance_after"`
+	ReasonCode    *string   `json:"reason_code,omitempty"`
+	ReferenceType *string   `json:"reference_type,omitempty"`
+	ReferenceID   *string   `json:"reference_id,omitempty"`
+	Note          *string   `json:"note,omitempty"`
+	CreatedBy     *string   `json:"created_by,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type StockAdjustmentRequest struct {
+	ProductID int `json:"product_id" binding:"required"`
+	// WarehouseID defaults to the caller's only assigned warehouse, or the default warehouse
+	WarehouseID string `json:"warehouse_id"`
+	// LotID targets a specific lot. Negative adjustments without a lot are
+	// allocated first-expiry-first-out.
+	LotID string `json:"lot_id"`
+	// Quantity is a signed delta in base units
+	Quantity   int     `json:"quantity" binding:"required"`
+	ReasonCode string  `json:"reason_code" binding:"required"`
+	Note       *string `json:"note"`
+}
+
+type StockReceiptRequest struct {
+	ProductID   int     `json:"product_id" binding:"required"`
+	WarehouseID string  `json:"warehouse_id"`
+	Quantity    int     `json:"quantity" binding:"required,gt=0"`
+	Reference   *string `json:"reference"`
+	Note        *string `json:"note"`
+	// LotNumber receives the goods into a production lot; dates are YYYY-MM-DD
+	LotNumber      string  `json:"lot_number"`
+	ManufacturedAt *string `json:"manufactured_at"`
+	ExpiresAt      *string `json:"expires_at"`
+}
+
+type StockReturnRequest struct {
+	ProductID   int     `json:"product_id" binding:"required"`
+	WarehouseID string  `json:"warehouse_id"`
+	Quantity    int     `json:"quantity" binding:"required,gt=0"`
+	OrderID     string  `json:"order_id" binding:"required"`
+	LotID       string  `json:"lot_id"`
+	Note        *string `json:"note"`
+}
+
+// StockLot is a production batch of a product held in one warehouse. Dates
+// are YYYY-MM-DD.
+type StockLot struct {
+	ID             string    `json:"id"`
+	ProductID      int       `json:"product_id"`
+	WarehouseID    string    `json:"warehouse_id"`
+	LotNumber      string    `json:"lot_number"`
+	ManufacturedAt *string   `json:"manufactured_at,omitempty"`
+	ExpiresAt      *string   `json:"expires_at,omitempty"`
+	Quantity       int       `json:"quantity"`
+	ReceivedAt     time.Time `json:"received_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// NearExpiryLot is a lot in the near-expiry report
+type NearExpiryLot struct {
+	StockLot
+	DaysLeft int  `json:"days_left"`
+	Expired  bool `json:"expired"`
+}
+
+// RecallEntry is an order that received goods from a recalled lot
+type RecallEntry struct {
+	OrderID      string    `json:"order_id"`
+	OrderStatus  string    `json:"order_status"`
+	OrderedAt    time.Time `json:"ordered_at"`
+	ShippedAt    time.Time `json:"shipped_at"`
+	Quantity     int       `json:"quantity"`
+	Returned     int       `json:"returned"`
+	LotID        string    `json:"lot_id"`
+	WarehouseID  string    `json:"warehouse_id"`
+	CustomerID   *string   `json:"customer_id,omitempty"`
+	CustomerCode *string   `json:"customer_code,omitempty"`
+	CustomerName *string   `json:"customer_name,omitempty"`
+	Phone        *string   `json:"phone,omitempty"`
+	Address      *string   `json:"address,omitempty"`
+	SaleID       *string   `json:"sale_id,omitempty"`
+}
+
+// LowStockAlert is one dip of a product to or below its reorder point in
+// a warehouse
+type LowStockAlert struct {
+	ID           string     `json:"id"`
+	ProductID    int        `json:"product_id"`
+	WarehouseID  *string    `json:"warehouse_id,omitempty"`
+	Stock        int        `json:"stock"`
+	ReorderPoint int        `json:"reorder_point"`
+	RaisedAt     time.Time  `json:"raised_at"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+}
+
+// LowStockProduct is a product at or below its reorder point in a
+// warehouse; Stock is the warehouse's
+type LowStockProduct struct {
+	ID            int        `json:"id"`
+	Code          string     `json:"code"`
+	Name          string     `json:"name"`
+	Unit          *string    `json:"unit,omitempty"`
+	WarehouseID   string     `json:"warehouse_id"`
+	WarehouseCode string     `json:"warehouse_code"`
+	WarehouseName string     `json:"warehouse_name"`
+	Stock         int        `json:"stock"`
+	ReorderPoint  int        `json:"reorder_point"`
+	Shortfall     int        `json:"shortfall"`
+	AlertRaisedAt *time.Time `json:"alert_raised_at,omitempty"`
+}
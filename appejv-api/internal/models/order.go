@@ -3,40 +3,60 @@ package models
 import "time"
 
 type Order struct {
-	ID          int        `json:"id"`
-	CustomerID  int        `json:"customer_id"`
-	SaleID      string     `json:"sale_id"`
-	Status      string     `json:"status"`
-	TotalAmount float64    `json:"total_amount"`
-	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
-	CreatedAt   time.Time  `json:"created_at"`
+	ID          string  `json:"id"`
+	CustomerID  *string `json:"customer_id,omitempty"`
+	SaleID      string  `json:"sale_id"`
+	WarehouseID *string `json:"warehouse_id,omitempty"`
+	Status      string  `json:"status"`
+	Route       *string `json:"route,omitempty"`
+	// DeliveryDate is a date (YYYY-MM-DD)
+	DeliveryDate *string    `json:"delivery_date,omitempty"`
+	TotalAmount  float64    `json:"total_amount"`
+	Notes        *string    `json:"notes,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
 }
 
 type OrderItem struct {
-	ID           int     `json:"id"`
-	OrderID      int     `json:"order_id"`
+	ID           string  `json:"id"`
+	OrderID      string  `json:"order_id"`
 	ProductID    int     `json:"product_id"`
-	Quantity     int     `json:"quantity"`
+	Quantity     float64 `json:"quantity"`
+	Unit         *string `json:"unit,omitempty"`
+	UnitFactor   float64 `json:"unit_factor"`
+	BaseQuantity int     `json:"base_quantity"`
 	PriceAtOrder float64 `json:"price_at_order"`
 }
 
 type CreateOrderRequest struct {
-	CustomerID int               `json:"customer_id" binding:"required"`
-	Items      []OrderItemCreate `json:"items" binding:"required,min=1"`
+	CustomerID   *string           `json:"customer_id"`
+	Notes        *string           `json:"notes"`
+	Route        *string           `json:"route"`
+	DeliveryDate *string           `json:"delivery_date"`
+	Items        []OrderItemCreate `json:"items" binding:"required,min=1"`
 }
 
 type OrderItemCreate struct {
-	ProductID int `json:"product_id" binding:"required"`
-	Quantity  int `json:"quantity" binding:"required,min=1"`
+	ProductID int     `json:"product_id" binding:"required"`
+	Quantity  float64 `json:"quantity" binding:"required,gt=0"`
+	// Unit defaults to the product's base unit
+	Unit string `json:"unit"`
 }
 
 type UpdateOrderRequest struct {
 	Status *string `json:"status"`
 }
 
+// UpdateOrderDeliveryRequest sets the route and delivery date used to group
+// pick lists
+type UpdateOrderDeliveryRequest struct {
+	Route        *string `json:"route"`
+	DeliveryDate *string `json:"delivery_date"`
+}
+
 type OrderWithDetails struct {
 	Order
-	Customer     Customer      `json:"customer"`
-	Items        []OrderItem   `json:"items"`
-	ItemProducts []Product     `json:"item_products"`
+	Customer     Customer    `json:"customer"`
+	Items        []OrderItem `json:"items"`
+	ItemProducts []Product   `json:"item_products"`
 }
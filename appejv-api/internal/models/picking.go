@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// PickOrder is an order waiting to be picked, with what the pick list and
+// packing slip print
+type PickOrder struct {
+	ID           string          `json:"id"`
+	Status       string          `json:"status"`
+	WarehouseID  *string         `json:"warehouse_id,omitempty"`
+	Route        *string         `json:"route,omitempty"`
+	DeliveryDate *string         `json:"delivery_date,omitempty"`
+	Notes        *string         `json:"notes,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Customer     *PickCustomer   `json:"customer,omitempty"`
+	Items        []PickOrderItem `json:"items"`
+	Pick         *OrderPick      `json:"pick,omitempty"`
+}
+
+type PickCustomer struct {
+	Code    string  `json:"code"`
+	Name    string  `json:"name"`
+	Address *string `json:"address,omitempty"`
+	Phone   *string `json:"phone,omitempty"`
+}
+
+type PickOrderItem struct {
+	ID           string            `json:"id"`
+	ProductID    int               `json:"product_id"`
+	Quantity     float64           `json:"quantity"`
+	Unit         *string           `json:"unit,omitempty"`
+	BaseQuantity int               `json:"base_quantity"`
+	Product      *StocktakeProduct `json:"product,omitempty"`
+}
+
+// PickList is the picking work of one group of orders (a warehouse, a route
+// or a delivery date)
+type PickList struct {
+	GroupBy string         `json:"group_by"`
+	Key     string         `json:"key"`
+	Label   string         `json:"label"`
+	Lines   []PickListLine `json:"lines"`
+	Orders  []PickOrder    `json:"orders"`
+}
+
+// PickListLine is the total base quantity of a product to pick for a group
+type PickListLine struct {
+	ProductID   int     `json:"product_id"`
+	ProductCode string  `json:"product_code"`
+	ProductName string  `json:"product_name"`
+	Unit        *string `json:"unit,omitempty"`
+	Quantity    int     `json:"quantity"`
+	Orders      int     `json:"orders"`
+}
+
+// OrderPick is the warehouse's confirmation of what was picked for an order.
+// Status is picked (every line in full) or short.
+type OrderPick struct {
+	OrderID  string          `json:"order_id"`
+	Status   string          `json:"status"`
+	Note     *string         `json:"note,omitempty"`
+	PickedBy *string         `json:"picked_by,omitempty"`
+	PickedAt time.Time       `json:"picked_at"`
+	Items    []OrderPickItem `json:"items,omitempty"`
+}
+
+type OrderPickItem struct {
+	OrderItemID     string `json:"order_item_id"`
+	ProductID       int    `json:"product_id"`
+	OrderedQuantity int    `json:"ordered_quantity"`
+	PickedQuantity  int    `json:"picked_quantity"`
+}
+
+// ConfirmPickRequest lists the base quantity picked for every order line
+type ConfirmPickRequest struct {
+	Lines []PickLine `json:"lines" binding:"required,min=1"`
+	Note  *string    `json:"note"`
+}
+
+type PickLine struct {
+	OrderItemID    string `json:"order_item_id"`
+	PickedQuantity int    `json:"picked_quantity"`
+}
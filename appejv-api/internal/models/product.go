@@ -15,14 +15,20 @@ type Product struct {
 	CategoryID     *int       `json:"category_id,omitempty"`
 	Description    *string    `json:"description,omitempty"`
 	Specifications *string    `json:"specifications,omitempty"`
+	Specs          Specs      `json:"specs,omitempty"`
+	ReorderPoint   *int       `json:"reorder_point,omitempty"`
+	Barcode        *string    `json:"barcode,omitempty"`
 	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
 	CreatedAt      time.Time  `json:"created_at"`
+
+	Images []ProductImage `json:"images,omitempty"`
 }
 
 type CreateProductRequest struct {
-	Code           string  `json:"code" binding:"required"`
-	Name           string  `json:"name" binding:"required"`
-	Unit           *string `json:"unit"`
+	Code string  `json:"code" binding:"required"`
+	Name string  `json:"name" binding:"required"`
+	Unit *string `json:"unit"`
+	// Stock is the opening balance, posted to the stock ledger as a receipt
 	Stock          int     `json:"stock"`
 	Price          float64 `json:"price"`
 	Category       *string `json:"category"`
@@ -30,16 +36,81 @@ type CreateProductRequest struct {
 	Description    *string `json:"description"`
 	ImageURL       *string `json:"image_url"`
 	Specifications *string `json:"specifications"`
+	Specs          Specs   `json:"specs"`
+	ReorderPoint   *int    `json:"reorder_point"`
+	Barcode        *string `json:"barcode"`
 }
 
 type UpdateProductRequest struct {
 	Name           *string  `json:"name"`
 	Unit           *string  `json:"unit"`
-	Stock          *int     `json:"stock"`
 	Price          *float64 `json:"price"`
 	Category       *string  `json:"category"`
 	CategoryID     *int     `json:"category_id"`
 	Description    *string  `json:"description"`
 	ImageURL       *string  `json:"image_url"`
 	Specifications *string  `json:"specifications"`
+	Specs          Specs    `json:"specs"`
+	ReorderPoint   *int     `json:"reorder_point"`
+	Barcode        *string  `json:"barcode"`
+	// ClearReorderPoint turns low-stock alerts off for the product
+	ClearReorderPoint bool `json:"clear_reorder_point"`
+}
+
+// Specs holds structured specification values keyed by field
+// (see internal/specs for the schemas)
+type Specs map[string]interface{}
+
+type Category struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	Slug         *string `json:"slug,omitempty"`
+	SpecSchema   *string `json:"spec_schema,omitempty"`
+	DisplayOrder *int    `json:"display_order,omitempty"`
+}
+
+type ProductImage struct {
+	ID           string    `json:"id"`
+	ProductID    int       `json:"product_id"`
+	StoragePath  string    `json:"storage_path"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	WebURL       string    `json:"web_url"`
+	ContentType  string    `json:"content_type"`
+	SizeBytes    int64     `json:"size_bytes"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	SortOrder    int       `json:"sort_order"`
+	IsPrimary    bool      `json:"is_primary"`
+	CreatedBy    *string   `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type ReorderProductImagesRequest struct {
+	ImageIDs []string `json:"image_ids" binding:"required,min=1"`
+}
+
+type ProductUnit struct {
+	ID        string   `json:"id"`
+	ProductID int      `json:"product_id"`
+	Unit      string   `json:"unit"`
+	Factor    float64  `json:"factor"`
+	Price     *float64 `json:"price"`
+	IsBase    bool     `json:"is_base"`
+
+	// UnitPrice is the effective price per unit (explicit or derived)
+	UnitPrice float64 `json:"unit_price"`
+}
+
+type CreateProductUnitRequest struct {
+	Unit   string   `json:"unit" binding:"required"`
+	Factor float64  `json:"factor" binding:"required,gt=0"`
+	Price  *float64 `json:"price"`
+}
+
+type UpdateProductUnitRequest struct {
+	Factor *float64 `json:"factor"`
+	Price  *float64 `json:"price"`
+	// ClearPrice switches the unit back to a derived price
+	ClearPrice bool `json:"clear_price"`
 }
@@ -0,0 +1,27 @@
+package models
+
+// ScanResult is what a scanned barcode or QR value resolved to. Type is
+// product, order, lot or lots (a lot number shared by several lots); only
+// the matching field is set.
+type ScanResult struct {
+	Type    string       `json:"type"`
+	Code    string       `json:"code"`
+	Product *ScanProduct `json:"product,omitempty"`
+	Order   *PickOrder   `json:"order,omitempty"`
+	Lot     *ScanLot     `json:"lot,omitempty"`
+	Lots    []ScanLot    `json:"lots,omitempty"`
+}
+
+// ScanProduct is a product with its stock and lots in the scanner's
+// warehouses
+type ScanProduct struct {
+	Product
+	WarehouseStock []WarehouseStock `json:"warehouse_stock"`
+	Lots           []StockLot       `json:"lots"`
+}
+
+// ScanLot is a lot with the product it belongs to
+type ScanLot struct {
+	StockLot
+	Product *StocktakeProduct `json:"product,omitempty"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Session is one of a user's sign-ins, from login until sign-out or
+// revocation
+type Session struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// RefreshedAt is when the session last got a new access token
+	RefreshedAt time.Time  `json:"refreshed_at"`
+	UserAgent   *string    `json:"user_agent"`
+	IP          *string    `json:"ip"`
+	NotAfter    *time.Time `json:"not_after"`
+	// Current marks the session the request was made with
+	Current bool `json:"current"`
+}
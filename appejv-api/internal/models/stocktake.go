@@ -0,0 +1,70 @@
+package models
+
+import "time"
+
+type Stocktake struct {
+	ID          string          `json:"id"`
+	WarehouseID string          `json:"warehouse_id"`
+	Status      string          `json:"status"`
+	Note        *string         `json:"note,omitempty"`
+	SnapshotAt  time.Time       `json:"snapshot_at"`
+	CreatedBy   *string         `json:"created_by,omitempty"`
+	ApprovedBy  *string         `json:"approved_by,omitempty"`
+	ApprovedAt  *time.Time      `json:"approved_at,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Lines       []StocktakeLine `json:"lines,omitempty"`
+}
+
+// StocktakeLine is the expected and counted quantity of one product
+type StocktakeLine struct {
+	StocktakeID      string `json:"stocktake_id"`
+	ProductID        int    `json:"product_id"`
+	ExpectedQuantity int    `json:"expected_quantity"`
+	// CountedQuantity is nil until the product has been counted
+	CountedQuantity *int `json:"counted_quantity"`
+	// Variance is counted - expected at the snapshot
+	Variance *int `json:"variance"`
+	// AdjustedQuantity is what approval posted to the ledger
+	AdjustedQuantity *int `json:"adjusted_quantity,omitempty"`
+
+	Product *StocktakeProduct `json:"product,omitempty"`
+}
+
+type StocktakeProduct struct {
+	Code    string  `json:"code"`
+	Name    string  `json:"name"`
+	Unit    *string `json:"unit,omitempty"`
+	Barcode *string `json:"barcode,omitempty"`
+}
+
+type CreateStocktakeRequest struct {
+	WarehouseID string  `json:"warehouse_id"`
+	Note        *string `json:"note"`
+}
+
+// StocktakeCountRequest submits counts, typed in or scanned
+type StocktakeCountRequest struct {
+	Counts []StocktakeCount `json:"counts" binding:"required,min=1"`
+}
+
+// StocktakeCount identifies the product by product_id or by a scanned
+// barcode (products.barcode or products.code)
+type StocktakeCount struct {
+	ProductID int    `json:"product_id"`
+	Barcode   string `json:"barcode"`
+	Quantity  int    `json:"quantity"`
+	// Location is a shelf or area; a counter's later count for the same
+	// product and location replaces the earlier one
+	Location string `json:"location"`
+}
+
+// ShrinkageRow is the loss and gain of a product in a period for one reason
+type ShrinkageRow struct {
+	Period      string `json:"period"`
+	ProductID   int    `json:"product_id"`
+	ProductCode string `json:"product_code"`
+	ProductName string `json:"product_name"`
+	ReasonCode  string `json:"reason_code"`
+	Lost        int    `json:"lost"`
+	Found       int    `json:"found"`
+}
@@ -11,6 +11,44 @@ type Profile struct {
 	AvatarURL *string    `json:"avatar_url,omitempty"`
 	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 	CreatedAt time.Time  `json:"created_at"`
+	// DeactivatedAt is set while an administrator has disabled the account
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+	// NotificationsEnabled is whether the user wants system notifications
+	NotificationsEnabled *bool `json:"notifications_enabled,omitempty"`
+}
+
+// ProfileDetails is the caller's own profile (GET /profile) with their
+// login email, the sales team they are on, the teams they manage and
+// their manager
+type ProfileDetails struct {
+	Profile
+	Email        string          `json:"email,omitempty"`
+	Team         *TeamSummary    `json:"team"`
+	ManagedTeams []TeamSummary   `json:"managed_teams,omitempty"`
+	Manager      *ProfileSummary `json:"manager"`
+}
+
+// TeamSummary names a sales team
+type TeamSummary struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ProfileSummary is what a user sees of another user
+type ProfileSummary struct {
+	ID        string  `json:"id"`
+	FullName  *string `json:"full_name"`
+	Role      string  `json:"role"`
+	Phone     *string `json:"phone"`
+	AvatarURL *string `json:"avatar_url"`
+}
+
+// UpdateProfileRequest changes the given fields of the caller's profile.
+// An empty phone clears it.
+type UpdateProfileRequest struct {
+	FullName             *string `json:"full_name"`
+	Phone                *string `json:"phone"`
+	NotificationsEnabled *bool   `json:"notifications_enabled"`
 }
 
 type LoginRequest struct {
@@ -21,5 +59,109 @@ type LoginRequest struct {
 type LoginResponse struct {
 	AccessToken  string  `json:"access_token"`
 	RefreshToken string  `json:"refresh_token"`
+	TokenType    string  `json:"token_type"`
+	ExpiresIn    int     `json:"expires_in"`
+	ExpiresAt    int64   `json:"expires_at"`
 	User         Profile `json:"user"`
 }
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// PhoneCodeRequest asks for a sign-in code texted to Phone
+type PhoneCodeRequest struct {
+	Phone string `json:"phone"`
+}
+
+// PhoneCodeVerifyRequest signs in with the code texted to Phone
+type PhoneCodeVerifyRequest struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+}
+
+// LogoutRequest selects which sessions to end: local (default), global or
+// others
+type LogoutRequest struct {
+	Scope string `json:"scope"`
+}
+
+// UserAccount is a user as administrators see it: the profile with the
+// login email, active sales team and account state
+type UserAccount struct {
+	ID            string     `json:"id"`
+	Email         *string    `json:"email"`
+	FullName      *string    `json:"full_name"`
+	Role          string     `json:"role"`
+	Phone         *string    `json:"phone"`
+	ManagerID     *string    `json:"manager_id"`
+	TeamID        *string    `json:"team_id"`
+	DeactivatedAt *time.Time `json:"deactivated_at"`
+	LastSignInAt  *time.Time `json:"last_sign_in_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// CreateUserRequest creates an account with a password (POST
+// /admin/users). TeamID only applies to sales.
+type CreateUserRequest struct {
+	Email     string  `json:"email"`
+	Password  string  `json:"password"`
+	FullName  string  `json:"full_name"`
+	Role      string  `json:"role"`
+	Phone     *string `json:"phone"`
+	ManagerID *string `json:"manager_id"`
+	TeamID    *string `json:"team_id"`
+}
+
+// UpdateUserRequest changes the given fields. An empty manager_id or
+// team_id clears it.
+type UpdateUserRequest struct {
+	FullName  *string `json:"full_name"`
+	Phone     *string `json:"phone"`
+	Role      *string `json:"role"`
+	ManagerID *string `json:"manager_id"`
+	TeamID    *string `json:"team_id"`
+}
+
+// Invitation is an invite to create an account with a pre-assigned role,
+// manager and team. Status is pending, accepted, revoked or expired.
+type Invitation struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	FullName   *string    `json:"full_name"`
+	Phone      *string    `json:"phone"`
+	Role       string     `json:"role"`
+	ManagerID  *string    `json:"manager_id"`
+	TeamID     *string    `json:"team_id"`
+	Locale     string     `json:"locale"`
+	Status     string     `json:"status"`
+	InvitedBy  *string    `json:"invited_by"`
+	UserID     *string    `json:"user_id"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	SentCount  int        `json:"sent_count"`
+	LastSentAt time.Time  `json:"last_sent_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateInvitationRequest invites email to join with role. Locale selects
+// the email's language (vi or en).
+type CreateInvitationRequest struct {
+	Email     string  `json:"email"`
+	FullName  string  `json:"full_name"`
+	Phone     *string `json:"phone"`
+	Role      string  `json:"role"`
+	ManagerID *string `json:"manager_id"`
+	TeamID    *string `json:"team_id"`
+	Locale    string  `json:"locale"`
+}
+
+// AcceptInviteRequest sets the invitee's password. FullName and Phone
+// override what the invitation was created with.
+type AcceptInviteRequest struct {
+	Token    string  `json:"token"`
+	Password string  `json:"password"`
+	FullName *string `json:"full_name"`
+	Phone    *string `json:"phone"`
+}
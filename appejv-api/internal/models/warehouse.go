@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+type Warehouse struct {
+	ID        string    `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	Address   *string   `json:"address,omitempty"`
+	Latitude  *float64  `json:"latitude,omitempty"`
+	Longitude *float64  `json:"longitude,omitempty"`
+	IsDefault bool      `json:"is_default"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WarehouseStock struct {
+	WarehouseID string    `json:"warehouse_id"`
+	ProductID   int       `json:"product_id"`
+	Quantity    int       `json:"quantity"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type CreateWarehouseRequest struct {
+	Code      string   `json:"code" binding:"required"`
+	Name      string   `json:"name" binding:"required"`
+	Address   *string  `json:"address"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+type UpdateWarehouseRequest struct {
+	Name      *string  `json:"name"`
+	Address   *string  `json:"address"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Status    *string  `json:"status"`
+}
+
+type AssignWarehouseUsersRequest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+type StockTransfer struct {
+	ID              string              `json:"id"`
+	FromWarehouseID string              `json:"from_warehouse_id"`
+	ToWarehouseID   string              `json:"to_warehouse_id"`
+	Status          string              `json:"status"`
+	Note            *string             `json:"note,omitempty"`
+	CreatedBy       *string             `json:"created_by,omitempty"`
+	ShippedBy       *string             `json:"shipped_by,omitempty"`
+	ReceivedBy      *string             `json:"received_by,omitempty"`
+	ShippedAt       *time.Time          `json:"shipped_at,omitempty"`
+	ReceivedAt      *time.Time          `json:"received_at,omitempty"`
+	CreatedAt       time.Time           `json:"created_at"`
+	Items           []StockTransferItem `json:"items,omitempty"`
+}
+
+type StockTransferItem struct {
+	ID         string `json:"id,omitempty"`
+	TransferID string `json:"transfer_id,omitempty"`
+	ProductID  int    `json:"product_id"`
+	Quantity   int    `json:"quantity"`
+}
+
+type CreateStockTransferRequest struct {
+	FromWarehouseID string              `json:"from_warehouse_id" binding:"required"`
+	ToWarehouseID   string              `json:"to_warehouse_id" binding:"required"`
+	Note            *string             `json:"note"`
+	Items           []StockTransferItem `json:"items" binding:"required,min=1"`
+}
+
+type AssignOrderWarehouseRequest struct {
+	// WarehouseID picks a specific warehouse; when empty the nearest
+	// warehouse that can fulfil the whole order is chosen
+	WarehouseID string `json:"warehouse_id"`
+}
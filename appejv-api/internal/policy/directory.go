@@ -0,0 +1,162 @@
+package policy
+
+import (
+	"github.com/appejv/appejv-api/pkg/database"
+)
+
+// ProfileFacts are the parts of a profile the rules look at
+type ProfileFacts struct {
+	ID        string  `json:"id"`
+	Role      string  `json:"role"`
+	ManagerID *string `json:"manager_id"`
+}
+
+// CustomerFacts say who a customer belongs to
+type CustomerFacts struct {
+	ID         string  `json:"id"`
+	AssignedTo *string `json:"assigned_to"`
+	TeamID     *string `json:"team_id"`
+	// UserID is the customer's own login, if they have one
+	UserID *string `json:"user_id"`
+}
+
+// OrderFacts say who an order belongs to and where it stands
+type OrderFacts struct {
+	ID         string  `json:"id"`
+	SaleID     *string `json:"sale_id"`
+	CreatedBy  *string `json:"created_by"`
+	CustomerID *string `json:"customer_id"`
+	TeamID     *string `json:"team_id"`
+	Status     string  `json:"status"`
+}
+
+// Team is what a sale_admin manages: the sales whose manager they are plus
+// the active members of their active sales teams, and those teams
+type Team struct {
+	SaleIDs map[string]bool
+	TeamIDs map[string]bool
+}
+
+// Directory looks up the facts the rules depend on. Lookups of a missing
+// row return ErrNotFound.
+type Directory interface {
+	Profile(id string) (ProfileFacts, error)
+	Customer(id string) (CustomerFacts, error)
+	Order(id string) (OrderFacts, error)
+	Team(managerID string) (Team, error)
+}
+
+// dbDirectory reads the facts from the database. It needs the service-role
+// handle: a sale cannot see the profiles table rows the checks rely on.
+type dbDirectory struct {
+	db *database.Database
+}
+
+// NewDirectory returns a Directory backed by the database
+func NewDirectory(db *database.Database) Directory {
+	return &dbDirectory{db: db}
+}
+
+func (d *dbDirectory) Profile(id string) (ProfileFacts, error) {
+	var rows []ProfileFacts
+	_, err := d.db.Client.From("profiles").
+		Select("id, role, manager_id", "", false).
+		Eq("id", id).
+		Limit(1, "").
+		ExecuteTo(&rows)
+	if err != nil {
+		return ProfileFacts{}, err
+	}
+	if len(rows) == 0 {
+		return ProfileFacts{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (d *dbDirectory) Customer(id string) (CustomerFacts, error) {
+	var rows []CustomerFacts
+	_, err := d.db.Client.From("customers").
+		Select("id, assigned_to, team_id, user_id", "", false).
+		Eq("id", id).
+		Limit(1, "").
+		ExecuteTo(&rows)
+	if err != nil {
+		return CustomerFacts{}, err
+	}
+	if len(rows) == 0 {
+		return CustomerFacts{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (d *dbDirectory) Order(id string) (OrderFacts, error) {
+	var rows []OrderFacts
+	_, err := d.db.Client.From("orders").
+		Select("id, sale_id, created_by, customer_id, team_id, status", "", false).
+		Eq("id", id).
+		Is("deleted_at", "null").
+		Limit(1, "").
+		ExecuteTo(&rows)
+	if err != nil {
+		return OrderFacts{}, err
+	}
+	if len(rows) == 0 {
+		return OrderFacts{}, ErrNotFound
+	}
+	return rows[0], nil
+}
+
+func (d *dbDirectory) Team(managerID string) (Team, error) {
+	team := Team{SaleIDs: make(map[string]bool), TeamIDs: make(map[string]bool)}
+
+	var reports []struct {
+		ID string `json:"id"`
+	}
+	_, err := d.db.Client.From("profiles").
+		Select("id", "", false).
+		Eq("manager_id", managerID).
+		ExecuteTo(&reports)
+	if err != nil {
+		return Team{}, err
+	}
+	for _, r := range reports {
+		team.SaleIDs[r.ID] = true
+	}
+
+	var teams []struct {
+		ID string `json:"id"`
+	}
+	_, err = d.db.Client.From("sales_teams").
+		Select("id", "", false).
+		Eq("manager_id", managerID).
+		Eq("status", "active").
+		ExecuteTo(&teams)
+	if err != nil {
+		return Team{}, err
+	}
+	if len(teams) == 0 {
+		return team, nil
+	}
+
+	teamIDs := make([]string, 0, len(teams))
+	for _, t := range teams {
+		team.TeamIDs[t.ID] = true
+		teamIDs = append(teamIDs, t.ID)
+	}
+
+	var members []struct {
+		SaleID string `json:"sale_id"`
+	}
+	_, err = d.db.Client.From("team_members").
+		Select("sale_id", "", false).
+		In("team_id", teamIDs).
+		Eq("status", "active").
+		ExecuteTo(&members)
+	if err != nil {
+		return Team{}, err
+	}
+	for _, m := range members {
+		team.SaleIDs[m.SaleID] = true
+	}
+	return team, nil
+}
@@ -0,0 +1,337 @@
+package policy
+
+// rule decides one (kind, action, role) cell of the matrix
+type rule func(dir Directory, s Subject, r Resource) (bool, error)
+
+// matrix is the permission matrix: kind → action → role → rule. A role
+// missing from a cell is denied. Keep it in step with the RLS policies in
+// migrations/ and the table in PERMISSION-SYSTEM.md.
+var matrix = map[Kind]map[Action]map[string]rule{
+	// The catalogue is public; admins maintain it
+	Product: {
+		Read:   allow(RoleAdmin, RoleSaleAdmin, RoleSale, RoleWarehouse, RoleCustomer),
+		Create: allow(RoleAdmin, RoleSaleAdmin),
+		Update: allow(RoleAdmin, RoleSaleAdmin),
+		Delete: allow(RoleAdmin, RoleSaleAdmin),
+	},
+
+	// Sales see their assigned customers, sale_admins their team's,
+	// warehouse all of them read-only, and a customer their own record
+	Customer: {
+		Read: {
+			RoleAdmin:     always,
+			RoleWarehouse: always,
+			RoleSaleAdmin: onCustomer(teamCustomer),
+			RoleSale:      onCustomer(assignedCustomer),
+			RoleCustomer:  onCustomer(ownCustomer),
+		},
+		Create: allow(RoleAdmin, RoleSaleAdmin, RoleSale),
+		Update: {
+			RoleAdmin:     always,
+			RoleSaleAdmin: onCustomer(teamCustomer),
+			RoleSale:      onCustomer(assignedCustomer),
+			RoleCustomer:  onCustomer(ownCustomer),
+		},
+		Delete: {
+			RoleAdmin:     always,
+			RoleSaleAdmin: onCustomer(teamCustomer),
+		},
+		Assign: {
+			RoleAdmin:     always,
+			RoleSaleAdmin: onCustomer(teamCustomer),
+		},
+	},
+
+	// Orders follow their customer and their sale. Warehouse sees every
+	// order but may only move it from ordered to shipping.
+	Order: {
+		Read: {
+			RoleAdmin:     always,
+			RoleWarehouse: always,
+			RoleSaleAdmin: onOrder(teamOrder),
+			RoleSale:      onOrder(assignedOrder),
+			RoleCustomer:  onOrder(ownOrder),
+		},
+		Create: {
+			RoleAdmin:     always,
+			RoleSaleAdmin: forCustomer(teamCustomer, false),
+			RoleSale:      forCustomer(assignedCustomer, false),
+			RoleCustomer:  forCustomer(ownCustomer, true),
+		},
+		Update: {
+			RoleAdmin:     always,
+			RoleSaleAdmin: onOrder(teamOrder),
+			RoleSale:      onOrder(inStatus(assignedOrder, "draft")),
+			RoleCustomer:  onOrder(inStatus(ownOrder, "draft")),
+		},
+		Delete: {
+			RoleAdmin:     always,
+			RoleSaleAdmin: onOrder(teamOrder),
+		},
+		ChangeStatus: {
+			RoleAdmin:     onOrder(moves(anyOrder, orderTransitions)),
+			RoleSaleAdmin: onOrder(moves(teamOrder, orderTransitions)),
+			RoleSale:      onOrder(moves(assignedOrder, placing)),
+			RoleCustomer:  onOrder(moves(ownOrder, placing)),
+			RoleWarehouse: onOrder(moves(anyOrder, dispatching)),
+		},
+		Fulfil: allow(RoleAdmin, RoleSaleAdmin, RoleWarehouse),
+	},
+
+	// Everyone reads and edits their own profile. Sale_admins read and
+	// manage their team's and add users; only admins change roles,
+	// managers and teams, and deactivate accounts.
+	Profile: {
+		Read: {
+			RoleAdmin:     always,
+			RoleWarehouse: always,
+			RoleSaleAdmin: teamProfile,
+			RoleSale:      self,
+			RoleCustomer:  self,
+		},
+		Create: allow(RoleAdmin, RoleSaleAdmin),
+		Update: {
+			RoleAdmin:     always,
+			RoleSaleAdmin: self,
+			RoleSale:      self,
+			RoleWarehouse: self,
+			RoleCustomer:  self,
+		},
+		Delete: allow(RoleAdmin),
+		Assign: allow(RoleAdmin),
+		Manage: {
+			RoleAdmin:     always,
+			RoleSaleAdmin: teamProfile,
+		},
+	},
+
+	// Stock movements, lots, transfers, stocktakes and scanning. Which
+	// warehouses a user may touch is checked by the inventory services.
+	Inventory: {
+		Read:    allow(RoleAdmin, RoleSaleAdmin, RoleWarehouse),
+		Create:  allow(RoleAdmin, RoleSaleAdmin, RoleWarehouse),
+		Update:  allow(RoleAdmin, RoleSaleAdmin, RoleWarehouse),
+		Approve: allow(RoleAdmin, RoleSaleAdmin),
+	},
+
+	Report: {
+		Read: allow(RoleAdmin, RoleSaleAdmin),
+	},
+
+	Warehouse: {
+		Read:   allow(RoleAdmin, RoleSaleAdmin, RoleWarehouse),
+		Create: allow(RoleAdmin, RoleSaleAdmin),
+		Update: allow(RoleAdmin, RoleSaleAdmin),
+		Assign: allow(RoleAdmin, RoleSaleAdmin),
+	},
+
+	System: {
+		Read:   allow(RoleAdmin),
+		Manage: allow(RoleAdmin),
+	},
+}
+
+// orderTransitions are the only status changes an order may make, whoever
+// makes them. Stock is consumed on the way to shipping and returned on
+// cancellation, so an order never moves back: a shipped order shipped
+// again would consume its stock twice, a cancelled one reopened would ship
+// stock it already gave back. The database enforces the same table
+// (migration 45); the role tables below are subsets of it.
+var orderTransitions = map[string][]string{
+	"draft":     {"ordered", "cancelled"},
+	"ordered":   {"shipping", "cancelled"},
+	"shipping":  {"delivered", "cancelled"},
+	"delivered": {"completed", "cancelled"},
+}
+
+// Status changes a sale or customer may make on an order they own: place
+// a draft, or cancel it before it ships
+var placing = map[string][]string{
+	"draft":   {"ordered", "cancelled"},
+	"ordered": {"cancelled"},
+}
+
+// Status changes the warehouse may make
+var dispatching = map[string][]string{
+	"ordered": {"shipping"},
+}
+
+func always(Directory, Subject, Resource) (bool, error) { return true, nil }
+
+// allow grants the action to roles unconditionally
+func allow(roles ...string) map[string]rule {
+	cell := make(map[string]rule, len(roles))
+	for _, role := range roles {
+		cell[role] = always
+	}
+	return cell
+}
+
+func self(_ Directory, s Subject, r Resource) (bool, error) {
+	return r.ID == "" || r.ID == s.ID, nil
+}
+
+// teamProfile is the sale_admin's own profile or one of their sales'
+func teamProfile(dir Directory, s Subject, r Resource) (bool, error) {
+	if r.ID == "" || r.ID == s.ID {
+		return true, nil
+	}
+	team, err := dir.Team(s.ID)
+	if err != nil {
+		return false, err
+	}
+	return team.SaleIDs[r.ID], nil
+}
+
+// ---------------------------------------------------------------------------
+// Customers
+// ---------------------------------------------------------------------------
+
+type customerRule func(dir Directory, s Subject, c CustomerFacts) (bool, error)
+
+// onCustomer applies check to the customer the resource names
+func onCustomer(check customerRule) rule {
+	return func(dir Directory, s Subject, r Resource) (bool, error) {
+		if r.ID == "" {
+			return true, nil
+		}
+		customer, err := dir.Customer(r.ID)
+		if err != nil {
+			return false, err
+		}
+		return check(dir, s, customer)
+	}
+}
+
+func assignedCustomer(_ Directory, s Subject, c CustomerFacts) (bool, error) {
+	return is(c.AssignedTo, s.ID), nil
+}
+
+func ownCustomer(_ Directory, s Subject, c CustomerFacts) (bool, error) {
+	return is(c.UserID, s.ID), nil
+}
+
+// teamCustomer is a customer of the sale_admin, of one of their sales or
+// of one of their teams
+func teamCustomer(dir Directory, s Subject, c CustomerFacts) (bool, error) {
+	if is(c.AssignedTo, s.ID) {
+		return true, nil
+	}
+	team, err := dir.Team(s.ID)
+	if err != nil {
+		return false, err
+	}
+	return inSet(c.AssignedTo, team.SaleIDs) || inSet(c.TeamID, team.TeamIDs), nil
+}
+
+// ---------------------------------------------------------------------------
+// Orders
+// ---------------------------------------------------------------------------
+
+type orderRule func(dir Directory, s Subject, r Resource, o OrderFacts) (bool, error)
+
+// onOrder applies check to the order the resource names
+func onOrder(check orderRule) rule {
+	return func(dir Directory, s Subject, r Resource) (bool, error) {
+		if r.ID == "" {
+			return true, nil
+		}
+		order, err := dir.Order(r.ID)
+		if err != nil {
+			return false, err
+		}
+		return check(dir, s, r, order)
+	}
+}
+
+// forCustomer checks a new order against the customer it is for. Staff may
+// create an order without a customer; a customer must name their own.
+func forCustomer(check customerRule, required bool) rule {
+	return func(dir Directory, s Subject, r Resource) (bool, error) {
+		if r.CustomerID == "" {
+			return !required, nil
+		}
+		customer, err := dir.Customer(r.CustomerID)
+		if err != nil {
+			return false, err
+		}
+		return check(dir, s, customer)
+	}
+}
+
+func anyOrder(Directory, Subject, Resource, OrderFacts) (bool, error) { return true, nil }
+
+// assignedOrder is an order the sale took or whose customer is theirs
+func assignedOrder(dir Directory, s Subject, _ Resource, o OrderFacts) (bool, error) {
+	if is(o.SaleID, s.ID) || is(o.CreatedBy, s.ID) {
+		return true, nil
+	}
+	return orderCustomer(dir, s, o, assignedCustomer)
+}
+
+// ownOrder is an order for the customer the user logs in as
+func ownOrder(dir Directory, s Subject, _ Resource, o OrderFacts) (bool, error) {
+	return orderCustomer(dir, s, o, ownCustomer)
+}
+
+// teamOrder is an order taken by the sale_admin or their sales, booked to
+// their team, or for one of their team's customers
+func teamOrder(dir Directory, s Subject, _ Resource, o OrderFacts) (bool, error) {
+	if is(o.SaleID, s.ID) || is(o.CreatedBy, s.ID) {
+		return true, nil
+	}
+	team, err := dir.Team(s.ID)
+	if err != nil {
+		return false, err
+	}
+	if inSet(o.SaleID, team.SaleIDs) || inSet(o.CreatedBy, team.SaleIDs) || inSet(o.TeamID, team.TeamIDs) {
+		return true, nil
+	}
+	return orderCustomer(dir, s, o, teamCustomer)
+}
+
+func orderCustomer(dir Directory, s Subject, o OrderFacts, check customerRule) (bool, error) {
+	if o.CustomerID == nil {
+		return false, nil
+	}
+	customer, err := dir.Customer(*o.CustomerID)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return check(dir, s, customer)
+}
+
+// inStatus restricts check to orders in one of statuses
+func inStatus(check orderRule, statuses ...string) orderRule {
+	return func(dir Directory, s Subject, r Resource, o OrderFacts) (bool, error) {
+		for _, status := range statuses {
+			if o.Status == status {
+				return check(dir, s, r, o)
+			}
+		}
+		return false, nil
+	}
+}
+
+// moves restricts check to the status changes in transitions
+func moves(check orderRule, transitions map[string][]string) orderRule {
+	return func(dir Directory, s Subject, r Resource, o OrderFacts) (bool, error) {
+		for _, to := range transitions[o.Status] {
+			if to == r.Status {
+				return check(dir, s, r, o)
+			}
+		}
+		return false, nil
+	}
+}
+
+func is(id *string, want string) bool {
+	return id != nil && *id == want
+}
+
+func inSet(id *string, set map[string]bool) bool {
+	return id != nil && set[*id]
+}
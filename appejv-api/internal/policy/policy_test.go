@@ -0,0 +1,322 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeDirectory holds the fixture every test case runs against:
+//
+//   - sale_admin u-sa manages sale u-sale and sales team team-1
+//   - customer c-own is assigned to u-sale, booked to team-1 and logs in
+//     as u-cust; c-other belongs to u-sale2, outside the team
+//   - orders o-draft, o-ordered, o-shipped and o-dropped (cancelled) were
+//     taken by u-sale for c-own; o-other is a draft of u-sale2 for c-other
+type fakeDirectory struct {
+	profiles  map[string]ProfileFacts
+	customers map[string]CustomerFacts
+	orders    map[string]OrderFacts
+	teams     map[string]Team
+}
+
+func (d *fakeDirectory) Profile(id string) (ProfileFacts, error) {
+	p, ok := d.profiles[id]
+	if !ok {
+		return ProfileFacts{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (d *fakeDirectory) Customer(id string) (CustomerFacts, error) {
+	c, ok := d.customers[id]
+	if !ok {
+		return CustomerFacts{}, ErrNotFound
+	}
+	return c, nil
+}
+
+func (d *fakeDirectory) Order(id string) (OrderFacts, error) {
+	o, ok := d.orders[id]
+	if !ok {
+		return OrderFacts{}, ErrNotFound
+	}
+	return o, nil
+}
+
+func (d *fakeDirectory) Team(managerID string) (Team, error) {
+	t, ok := d.teams[managerID]
+	if !ok {
+		return Team{SaleIDs: map[string]bool{}, TeamIDs: map[string]bool{}}, nil
+	}
+	return t, nil
+}
+
+func ptr(s string) *string { return &s }
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		profiles: map[string]ProfileFacts{
+			"u-admin": {ID: "u-admin", Role: RoleAdmin},
+			"u-sa":    {ID: "u-sa", Role: RoleSaleAdmin},
+			"u-sale":  {ID: "u-sale", Role: RoleSale, ManagerID: ptr("u-sa")},
+			"u-sale2": {ID: "u-sale2", Role: RoleSale},
+			"u-wh":    {ID: "u-wh", Role: RoleWarehouse},
+			"u-cust":  {ID: "u-cust", Role: RoleCustomer},
+		},
+		customers: map[string]CustomerFacts{
+			"c-own":   {ID: "c-own", AssignedTo: ptr("u-sale"), TeamID: ptr("team-1"), UserID: ptr("u-cust")},
+			"c-other": {ID: "c-other", AssignedTo: ptr("u-sale2"), UserID: ptr("u-cust2")},
+		},
+		orders: map[string]OrderFacts{
+			"o-draft":   {ID: "o-draft", SaleID: ptr("u-sale"), CustomerID: ptr("c-own"), Status: "draft"},
+			"o-ordered": {ID: "o-ordered", SaleID: ptr("u-sale"), CustomerID: ptr("c-own"), Status: "ordered"},
+			"o-other":   {ID: "o-other", SaleID: ptr("u-sale2"), CustomerID: ptr("c-other"), Status: "draft"},
+			"o-shipped": {ID: "o-shipped", SaleID: ptr("u-sale"), CustomerID: ptr("c-own"), Status: "shipping"},
+			"o-dropped": {ID: "o-dropped", SaleID: ptr("u-sale"), CustomerID: ptr("c-own"), Status: "cancelled"},
+		},
+		teams: map[string]Team{
+			"u-sa": {
+				SaleIDs: map[string]bool{"u-sale": true},
+				TeamIDs: map[string]bool{"team-1": true},
+			},
+		},
+	}
+}
+
+// subjects is one user of each role
+var subjects = map[string]Subject{
+	RoleAdmin:     {ID: "u-admin", Role: RoleAdmin},
+	RoleSaleAdmin: {ID: "u-sa", Role: RoleSaleAdmin},
+	RoleSale:      {ID: "u-sale", Role: RoleSale},
+	RoleWarehouse: {ID: "u-wh", Role: RoleWarehouse},
+	RoleCustomer:  {ID: "u-cust", Role: RoleCustomer},
+}
+
+// matrixCase is one action on one resource; allowed lists the roles that
+// may perform it and every other role must be denied
+type matrixCase struct {
+	name     string
+	action   Action
+	resource Resource
+	allowed  []string
+}
+
+// all is every role
+var all = []string{RoleAdmin, RoleSaleAdmin, RoleSale, RoleWarehouse, RoleCustomer}
+
+// matrixCases encode the permission matrix of PERMISSION-SYSTEM.md. Every
+// cell of matrix has at least one case.
+var matrixCases = []matrixCase{
+	// Products
+	{"read products", Read, Resource{Kind: Product}, all},
+	{"create product", Create, Resource{Kind: Product}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"update product", Update, Resource{Kind: Product, ID: "1"}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"delete product", Delete, Resource{Kind: Product, ID: "1"}, []string{RoleAdmin, RoleSaleAdmin}},
+
+	// Customers
+	{"list customers", Read, Resource{Kind: Customer}, all},
+	{"read team customer", Read, Resource{Kind: Customer, ID: "c-own"}, all},
+	{"read other customer", Read, Resource{Kind: Customer, ID: "c-other"}, []string{RoleAdmin, RoleWarehouse}},
+	{"create customer", Create, Resource{Kind: Customer}, []string{RoleAdmin, RoleSaleAdmin, RoleSale}},
+	{"update team customer", Update, Resource{Kind: Customer, ID: "c-own"}, []string{RoleAdmin, RoleSaleAdmin, RoleSale, RoleCustomer}},
+	{"update other customer", Update, Resource{Kind: Customer, ID: "c-other"}, []string{RoleAdmin}},
+	{"delete team customer", Delete, Resource{Kind: Customer, ID: "c-own"}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"delete other customer", Delete, Resource{Kind: Customer, ID: "c-other"}, []string{RoleAdmin}},
+	{"assign team customer", Assign, Resource{Kind: Customer, ID: "c-own"}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"assign other customer", Assign, Resource{Kind: Customer, ID: "c-other"}, []string{RoleAdmin}},
+
+	// Orders
+	{"list orders", Read, Resource{Kind: Order}, all},
+	{"read team order", Read, Resource{Kind: Order, ID: "o-draft"}, all},
+	{"read other order", Read, Resource{Kind: Order, ID: "o-other"}, []string{RoleAdmin, RoleWarehouse}},
+	{"create order for team customer", Create, Resource{Kind: Order, CustomerID: "c-own"}, []string{RoleAdmin, RoleSaleAdmin, RoleSale, RoleCustomer}},
+	{"create order for other customer", Create, Resource{Kind: Order, CustomerID: "c-other"}, []string{RoleAdmin}},
+	{"create order without customer", Create, Resource{Kind: Order}, []string{RoleAdmin, RoleSaleAdmin, RoleSale}},
+	{"update team draft", Update, Resource{Kind: Order, ID: "o-draft"}, []string{RoleAdmin, RoleSaleAdmin, RoleSale, RoleCustomer}},
+	{"update team placed order", Update, Resource{Kind: Order, ID: "o-ordered"}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"update other order", Update, Resource{Kind: Order, ID: "o-other"}, []string{RoleAdmin}},
+	{"delete team order", Delete, Resource{Kind: Order, ID: "o-draft"}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"delete other order", Delete, Resource{Kind: Order, ID: "o-other"}, []string{RoleAdmin}},
+	{"place team draft", ChangeStatus, Resource{Kind: Order, ID: "o-draft", Status: "ordered"}, []string{RoleAdmin, RoleSaleAdmin, RoleSale, RoleCustomer}},
+	{"ship team order", ChangeStatus, Resource{Kind: Order, ID: "o-ordered", Status: "shipping"}, []string{RoleAdmin, RoleSaleAdmin, RoleWarehouse}},
+	{"cancel team placed order", ChangeStatus, Resource{Kind: Order, ID: "o-ordered", Status: "cancelled"}, []string{RoleAdmin, RoleSaleAdmin, RoleSale, RoleCustomer}},
+	{"place other draft", ChangeStatus, Resource{Kind: Order, ID: "o-other", Status: "ordered"}, []string{RoleAdmin}},
+	{"deliver team shipped order", ChangeStatus, Resource{Kind: Order, ID: "o-shipped", Status: "delivered"}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"cancel team shipped order", ChangeStatus, Resource{Kind: Order, ID: "o-shipped", Status: "cancelled"}, []string{RoleAdmin, RoleSaleAdmin}},
+
+	// Illegal transitions are refused to every role
+	{"ship team draft", ChangeStatus, Resource{Kind: Order, ID: "o-draft", Status: "shipping"}, nil},
+	{"unship team order", ChangeStatus, Resource{Kind: Order, ID: "o-shipped", Status: "ordered"}, nil},
+	{"ship team order again", ChangeStatus, Resource{Kind: Order, ID: "o-shipped", Status: "shipping"}, nil},
+	{"reopen cancelled order", ChangeStatus, Resource{Kind: Order, ID: "o-dropped", Status: "ordered"}, nil},
+	{"redraft cancelled order", ChangeStatus, Resource{Kind: Order, ID: "o-dropped", Status: "draft"}, nil},
+	{"redraft placed order", ChangeStatus, Resource{Kind: Order, ID: "o-ordered", Status: "draft"}, nil},
+	{"fulfil order", Fulfil, Resource{Kind: Order, ID: "o-ordered"}, []string{RoleAdmin, RoleSaleAdmin, RoleWarehouse}},
+
+	// Profiles; without an id the check is on the caller's own profile
+	{"read own profile", Read, Resource{Kind: Profile}, all},
+	{"read team sale profile", Read, Resource{Kind: Profile, ID: "u-sale"}, []string{RoleAdmin, RoleSaleAdmin, RoleSale, RoleWarehouse}},
+	{"read other sale profile", Read, Resource{Kind: Profile, ID: "u-sale2"}, []string{RoleAdmin, RoleWarehouse}},
+	{"create user", Create, Resource{Kind: Profile}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"update own profile", Update, Resource{Kind: Profile}, all},
+	{"update team sale profile", Update, Resource{Kind: Profile, ID: "u-sale"}, []string{RoleAdmin, RoleSale}},
+	{"deactivate user", Delete, Resource{Kind: Profile, ID: "u-sale"}, []string{RoleAdmin}},
+	{"assign user", Assign, Resource{Kind: Profile, ID: "u-sale"}, []string{RoleAdmin}},
+	{"manage team sale", Manage, Resource{Kind: Profile, ID: "u-sale"}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"manage other sale", Manage, Resource{Kind: Profile, ID: "u-sale2"}, []string{RoleAdmin}},
+
+	// Inventory
+	{"read inventory", Read, Resource{Kind: Inventory}, []string{RoleAdmin, RoleSaleAdmin, RoleWarehouse}},
+	{"move stock", Create, Resource{Kind: Inventory}, []string{RoleAdmin, RoleSaleAdmin, RoleWarehouse}},
+	{"update transfer", Update, Resource{Kind: Inventory}, []string{RoleAdmin, RoleSaleAdmin, RoleWarehouse}},
+	{"approve stocktake", Approve, Resource{Kind: Inventory}, []string{RoleAdmin, RoleSaleAdmin}},
+
+	// Reports
+	{"read report", Read, Resource{Kind: Report}, []string{RoleAdmin, RoleSaleAdmin}},
+
+	// Warehouses
+	{"read warehouses", Read, Resource{Kind: Warehouse}, []string{RoleAdmin, RoleSaleAdmin, RoleWarehouse}},
+	{"create warehouse", Create, Resource{Kind: Warehouse}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"update warehouse", Update, Resource{Kind: Warehouse}, []string{RoleAdmin, RoleSaleAdmin}},
+	{"assign warehouse staff", Assign, Resource{Kind: Warehouse}, []string{RoleAdmin, RoleSaleAdmin}},
+
+	// System
+	{"read system", Read, Resource{Kind: System}, []string{RoleAdmin}},
+	{"manage system", Manage, Resource{Kind: System}, []string{RoleAdmin}},
+}
+
+func TestMatrix(t *testing.T) {
+	engine := NewEngine(newFakeDirectory())
+	for _, tc := range matrixCases {
+		allowed := make(map[string]bool, len(tc.allowed))
+		for _, role := range tc.allowed {
+			allowed[role] = true
+		}
+		for _, role := range all {
+			t.Run(tc.name+"/"+role, func(t *testing.T) {
+				got, err := engine.Can(subjects[role], tc.action, tc.resource)
+				if err != nil {
+					t.Fatalf("Can: %v", err)
+				}
+				if got != allowed[role] {
+					t.Errorf("Can(%s, %s, %+v) = %v, want %v", role, tc.action, tc.resource, got, allowed[role])
+				}
+			})
+		}
+	}
+}
+
+// TestMatrixCovered fails when a cell is added to the matrix without a case
+func TestMatrixCovered(t *testing.T) {
+	covered := make(map[Kind]map[Action]bool)
+	for _, tc := range matrixCases {
+		if covered[tc.resource.Kind] == nil {
+			covered[tc.resource.Kind] = make(map[Action]bool)
+		}
+		covered[tc.resource.Kind][tc.action] = true
+	}
+	for kind, actions := range matrix {
+		for action := range actions {
+			if !covered[kind][action] {
+				t.Errorf("no test case for %s %s", kind, action)
+			}
+		}
+	}
+}
+
+func TestUnknownCallerDenied(t *testing.T) {
+	engine := NewEngine(newFakeDirectory())
+	for _, subject := range []Subject{{}, {ID: "u-x", Role: "guest"}} {
+		got, err := engine.Can(subject, Read, Resource{Kind: Product})
+		if err != nil || got {
+			t.Errorf("Can(%+v) = %v, %v; want false, nil", subject, got, err)
+		}
+	}
+}
+
+func TestMissingResource(t *testing.T) {
+	engine := NewEngine(newFakeDirectory())
+	err := engine.Authorize(subjects[RoleSale], Read, Resource{Kind: Order, ID: "o-missing"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Authorize on a missing order = %v, want ErrNotFound", err)
+	}
+	err = engine.Authorize(subjects[RoleSale], Update, Resource{Kind: Customer, ID: "c-other"})
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("Authorize on another sale's customer = %v, want ErrForbidden", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	engine := NewEngine(newFakeDirectory())
+	key := func(limits Limits) Subject {
+		s := subjects[RoleAdmin]
+		s.Limits = &limits
+		return s
+	}
+
+	tests := []struct {
+		name     string
+		subject  Subject
+		action   Action
+		resource Resource
+		want     bool
+	}{
+		{"scope granted", key(Limits{Scopes: []string{"order:read"}}), Read, Resource{Kind: Order, ID: "o-other"}, true},
+		{"scope missing", key(Limits{Scopes: []string{"order:read"}}), Update, Resource{Kind: Order, ID: "o-other"}, false},
+		{"role still applies", Subject{ID: "u-sale", Role: RoleSale, Limits: &Limits{Scopes: []string{"order:read"}}}, Read, Resource{Kind: Order, ID: "o-other"}, false},
+		{"own customer", key(Limits{Scopes: []string{"customer:read"}, CustomerID: "c-own"}), Read, Resource{Kind: Customer, ID: "c-own"}, true},
+		{"other customer", key(Limits{Scopes: []string{"customer:read"}, CustomerID: "c-own"}), Read, Resource{Kind: Customer, ID: "c-other"}, false},
+		{"new customer", key(Limits{Scopes: []string{"customer:create"}, CustomerID: "c-own"}), Create, Resource{Kind: Customer}, false},
+		{"own customer's order", key(Limits{Scopes: []string{"order:read"}, CustomerID: "c-own"}), Read, Resource{Kind: Order, ID: "o-draft"}, true},
+		{"other customer's order", key(Limits{Scopes: []string{"order:read"}, CustomerID: "c-own"}), Read, Resource{Kind: Order, ID: "o-other"}, false},
+		{"new order for own customer", key(Limits{Scopes: []string{"order:create"}, CustomerID: "c-own"}), Create, Resource{Kind: Order, CustomerID: "c-own"}, true},
+		{"new order for other customer", key(Limits{Scopes: []string{"order:create"}, CustomerID: "c-own"}), Create, Resource{Kind: Order, CustomerID: "c-other"}, false},
+		{"own profile", key(Limits{Scopes: []string{"order:read"}}), Read, Resource{Kind: Profile}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := engine.Can(tt.subject, tt.action, tt.resource)
+			if err != nil {
+				t.Fatalf("Can: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Can = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	tests := map[string]bool{
+		"order:read":       true,
+		"inventory:create": true,
+		"order:fulfil":     true,
+		"order:fly":        false,
+		"profile:read":     false,
+		"system:manage":    false,
+		"orders":           false,
+	}
+	for scope, want := range tests {
+		if got := ValidScope(scope); got != want {
+			t.Errorf("ValidScope(%q) = %v, want %v", scope, got, want)
+		}
+	}
+}
+
+// TestRoleTransitionsAreLegal checks that no role's status changes go
+// beyond the order transition table
+func TestRoleTransitionsAreLegal(t *testing.T) {
+	for name, table := range map[string]map[string][]string{"placing": placing, "dispatching": dispatching} {
+		for from, tos := range table {
+			for _, to := range tos {
+				legal := false
+				for _, allowed := range orderTransitions[from] {
+					legal = legal || allowed == to
+				}
+				if !legal {
+					t.Errorf("%s allows %s -> %s, which orderTransitions does not", name, from, to)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pruneAt is the number of buckets above which full ones are dropped
+const pruneAt = 10000
+
+// MemoryStore keeps buckets in memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket is back to Burst tokens; a full bucket is no
+	// different from a new one and can be dropped
+	full time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.buckets) >= pruneAt {
+		s.prune(now)
+	}
+
+	burst := float64(limit.Burst)
+	interval := limit.interval()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		s.buckets[key] = b
+	} else {
+		b.tokens += float64(now.Sub(b.updated)) / float64(interval)
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.updated = now
+	}
+
+	result := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(interval))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((burst - b.tokens) * float64(interval))
+	b.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// prune drops full buckets. Callers must hold s.mu.
+func (s *MemoryStore) prune(now time.Time) {
+	for key, b := range s.buckets {
+		if !b.full.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
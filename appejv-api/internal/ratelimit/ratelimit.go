@@ -0,0 +1,53 @@
+// Package ratelimit throttles requests with token buckets
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit is a token bucket: it holds Burst tokens, a request takes one, and
+// an empty bucket refills evenly over Per. A zero Limit allows everything.
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// PerMinute allows n requests a minute, all at once if need be
+func PerMinute(n int) Limit {
+	return Limit{Burst: n, Per: time.Minute}
+}
+
+// PerHour allows n requests an hour, all at once if need be
+func PerHour(n int) Limit {
+	return Limit{Burst: n, Per: time.Hour}
+}
+
+// Enabled reports whether the limit restricts anything
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Per > 0
+}
+
+// interval is how long one token takes to come back
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Burst)
+}
+
+// Result is the state of a bucket after a request took from it
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next request would be allowed; zero
+	// when this one was
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps the buckets. MemoryStore counts on each API instance on its
+// own; a store shared by all instances (Redis, Postgres) makes limits hold
+// across them.
+type Store interface {
+	// Take takes a token from key's bucket, which has limit
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
@@ -0,0 +1,19 @@
+package sms
+
+import (
+	"context"
+	"log"
+)
+
+// ConsoleSender writes messages to the server log instead of sending them.
+// For development only: sign-in codes end up in the log.
+type ConsoleSender struct{}
+
+func NewConsoleSender() *ConsoleSender {
+	return &ConsoleSender{}
+}
+
+func (s *ConsoleSender) Send(ctx context.Context, to, text string) error {
+	log.Printf("📱 To: %s | %s", to, text)
+	return nil
+}
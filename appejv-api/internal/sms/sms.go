@@ -0,0 +1,27 @@
+// Package sms sends text messages through a pluggable provider. Only a
+// console provider for development ships for now; a gateway plugs in by
+// implementing Sender and adding a case to New.
+package sms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/appejv/appejv-api/internal/config"
+)
+
+// Sender sends a text message to a phone number in international form,
+// e.g. +84912345678
+type Sender interface {
+	Send(ctx context.Context, to, text string) error
+}
+
+// New returns the sender selected by cfg.SMSProvider
+func New(cfg *config.Config) (Sender, error) {
+	switch cfg.SMSProvider {
+	case "console", "":
+		return NewConsoleSender(), nil
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q", cfg.SMSProvider)
+	}
+}
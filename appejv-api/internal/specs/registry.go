@@ -0,0 +1,71 @@
+package specs
+
+import "sort"
+
+func float(v float64) *float64 {
+	return &v
+}
+
+// TargetAnimals lists the animals a feed can be formulated for
+var TargetAnimals = []string{"pig", "poultry", "duck", "fish", "shrimp", "cattle"}
+
+// GrowthStages lists the growth stages a feed can target
+var GrowthStages = []string{"starter", "grower", "finisher", "breeder", "layer", "broiler", "all"}
+
+var registry = map[string]Schema{
+	"feed": {
+		Name:  "feed",
+		Label: "Thức ăn hỗn hợp",
+		Fields: []Field{
+			{Key: "protein", Label: "Đạm thô", Type: Number, Unit: "%", Min: float(0), Max: float(100), Required: true},
+			{Key: "fat", Label: "Béo thô", Type: Number, Unit: "%", Min: float(0), Max: float(100)},
+			{Key: "fiber", Label: "Xơ thô", Type: Number, Unit: "%", Min: float(0), Max: float(100)},
+			{Key: "moisture", Label: "Độ ẩm", Type: Number, Unit: "%", Min: float(0), Max: float(100)},
+			{Key: "ash", Label: "Khoáng tổng số", Type: Number, Unit: "%", Min: float(0), Max: float(100)},
+			{Key: "calcium", Label: "Canxi", Type: Number, Unit: "%", Min: float(0), Max: float(100)},
+			{Key: "phosphorus", Label: "Phốt pho tổng số", Type: Number, Unit: "%", Min: float(0), Max: float(100)},
+			{Key: "energy", Label: "Năng lượng trao đổi", Type: Number, Unit: "kcal/kg", Min: float(0)},
+			{Key: "target_animal", Label: "Vật nuôi", Type: Enum, Options: TargetAnimals, Required: true},
+			{Key: "growth_stage", Label: "Giai đoạn", Type: Enum, Options: GrowthStages, Required: true},
+			{Key: "weight_range", Label: "Khối lượng vật nuôi", Type: Text},
+		},
+	},
+	"premix": {
+		Name:  "premix",
+		Label: "Premix, phụ gia",
+		Fields: []Field{
+			{Key: "target_animal", Label: "Vật nuôi", Type: Enum, Options: TargetAnimals, Required: true},
+			{Key: "moisture", Label: "Độ ẩm", Type: Number, Unit: "%", Min: float(0), Max: float(100)},
+			{Key: "active_ingredients", Label: "Thành phần chính", Type: Text},
+			{Key: "dosage", Label: "Liều dùng", Type: Text},
+		},
+	},
+}
+
+// Lookup returns the schema registered under name
+func Lookup(name string) (Schema, bool) {
+	s, ok := registry[name]
+	return s, ok
+}
+
+// All returns every registered schema ordered by name
+func All() []Schema {
+	out := make([]Schema, 0, len(registry))
+	for _, s := range registry {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// FilterableFields returns the union of fields across all schemas, keyed by
+// field key. Fields sharing a key across schemas have the same type.
+func FilterableFields() map[string]Field {
+	out := make(map[string]Field)
+	for _, s := range registry {
+		for _, f := range s.Fields {
+			out[f.Key] = f
+		}
+	}
+	return out
+}
@@ -0,0 +1,124 @@
+package specs
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FieldType is the value type of a specification field
+type FieldType string
+
+const (
+	Number FieldType = "number"
+	Text   FieldType = "text"
+	Enum   FieldType = "enum"
+)
+
+// Field describes one structured specification value
+type Field struct {
+	Key      string    `json:"key"`
+	Label    string    `json:"label"`
+	Type     FieldType `json:"type"`
+	Unit     string    `json:"unit,omitempty"`
+	Min      *float64  `json:"min,omitempty"`
+	Max      *float64  `json:"max,omitempty"`
+	Options  []string  `json:"options,omitempty"`
+	Required bool      `json:"required"`
+}
+
+// Schema is the set of specification fields for a category.
+// Categories reference a schema by name (categories.spec_schema).
+type Schema struct {
+	Name   string  `json:"name"`
+	Label  string  `json:"label"`
+	Fields []Field `json:"fields"`
+}
+
+// FieldError reports an invalid specification value
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Field returns the field with the given key
+func (s Schema) Field(key string) (Field, bool) {
+	for _, f := range s.Fields {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Validate checks values against the schema and returns them normalised
+// (numbers as float64, unknown keys rejected).
+func (s Schema) Validate(values map[string]interface{}) (map[string]interface{}, []FieldError) {
+	var errs []FieldError
+	out := make(map[string]interface{}, len(values))
+
+	for key := range values {
+		if _, ok := s.Field(key); !ok {
+			errs = append(errs, FieldError{Field: key, Message: "unknown field"})
+		}
+	}
+
+	for _, f := range s.Fields {
+		raw, ok := values[f.Key]
+		if !ok || raw == nil {
+			if f.Required {
+				errs = append(errs, FieldError{Field: f.Key, Message: "is required"})
+			}
+			continue
+		}
+
+		v, err := f.normalize(raw)
+		if err != nil {
+			errs = append(errs, FieldError{Field: f.Key, Message: err.Error()})
+			continue
+		}
+		out[f.Key] = v
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return out, errs
+}
+
+func (f Field) normalize(raw interface{}) (interface{}, error) {
+	switch f.Type {
+	case Number:
+		n, ok := raw.(float64)
+		if !ok {
+			return nil, fmt.Errorf("must be a number")
+		}
+		if f.Min != nil && n < *f.Min {
+			return nil, fmt.Errorf("must be at least %g", *f.Min)
+		}
+		if f.Max != nil && n > *f.Max {
+			return nil, fmt.Errorf("must be at most %g", *f.Max)
+		}
+		return n, nil
+
+	case Enum:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string")
+		}
+		for _, o := range f.Options {
+			if s == o {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("must be one of %v", f.Options)
+
+	default:
+		s, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string")
+		}
+		return s, nil
+	}
+}
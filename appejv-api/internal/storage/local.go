@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage stores files on the local filesystem (development and tests)
+type LocalStorage struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocalStorage creates a filesystem backend rooted at dir. Files are
+// expected to be served by the HTTP server under baseURL.
+func NewLocalStorage(dir, baseURL string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create storage dir: %w", err)
+	}
+
+	return &LocalStorage{
+		dir:     dir,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}, nil
+}
+
+// Dir returns the root directory of the store
+func (s *LocalStorage) Dir() string {
+	return s.dir
+}
+
+func (s *LocalStorage) Put(path string, data []byte, contentType string) error {
+	full, err := s.resolve(path)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(full, data, 0o644)
+}
+
+func (s *LocalStorage) Delete(paths ...string) error {
+	for _, p := range paths {
+		full, err := s.resolve(p)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(full); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *LocalStorage) PublicURL(path string) string {
+	return s.baseURL + "/" + strings.TrimLeft(path, "/")
+}
+
+// resolve maps an object path to a file inside the storage dir,
+// rejecting paths that would escape it
+func (s *LocalStorage) resolve(path string) (string, error) {
+	clean := filepath.Clean("/" + path)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid storage path %q", path)
+	}
+	return filepath.Join(s.dir, clean), nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStorage(filepath.Join(dir, "uploads"), "/uploads/")
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+
+	if err := store.Put("products/1/a.jpg", []byte("jpeg"), "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "uploads", "products", "1", "a.jpg"))
+	if err != nil || string(data) != "jpeg" {
+		t.Fatalf("stored file = %q, %v", data, err)
+	}
+
+	if url := store.PublicURL("/products/1/a.jpg"); url != "/uploads/products/1/a.jpg" {
+		t.Errorf("PublicURL = %q", url)
+	}
+
+	// Deleting a missing file is not an error
+	if err := store.Delete("products/1/a.jpg", "products/1/missing.jpg"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "uploads", "products", "1", "a.jpg")); !os.IsNotExist(err) {
+		t.Errorf("file still exists after Delete: %v", err)
+	}
+}
+
+func TestLocalStorageStaysInDir(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStorage(filepath.Join(dir, "uploads"), "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put("../../escaped.txt", []byte("x"), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Error("a path with .. escaped the storage dir")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "uploads", "escaped.txt")); err != nil {
+		t.Errorf("file was not kept inside the storage dir: %v", err)
+	}
+
+	if err := store.Put("/", []byte("x"), "text/plain"); err == nil {
+		t.Error("Put to the storage root succeeded")
+	}
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/appejv/appejv-api/internal/config"
+)
+
+// Storage stores uploaded files and resolves their public URLs
+type Storage interface {
+	// Put writes data to path, replacing any existing object
+	Put(path string, data []byte, contentType string) error
+	// Delete removes the objects at the given paths
+	Delete(paths ...string) error
+	// PublicURL returns the URL clients use to fetch the object at path
+	PublicURL(path string) string
+}
+
+// New returns the storage backend selected by cfg.StorageBackend
+func New(cfg *config.Config) (Storage, error) {
+	switch cfg.StorageBackend {
+	case "supabase", "":
+		return NewSupabaseStorage(cfg), nil
+	case "local":
+		return NewLocalStorage(cfg.StorageLocalDir, cfg.StorageBaseURL)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
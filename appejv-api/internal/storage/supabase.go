@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+
+	"github.com/appejv/appejv-api/internal/config"
+	storage_go "github.com/supabase-community/storage-go"
+)
+
+// SupabaseStorage stores files in a Supabase Storage bucket
+type SupabaseStorage struct {
+	client *storage_go.Client
+	bucket string
+
+	// storage-go keeps upload options in shared client headers,
+	// so uploads must not run concurrently on the same client
+	mu sync.Mutex
+}
+
+// NewSupabaseStorage creates a Supabase Storage backend. The service key is
+// used when available so uploads are not subject to storage RLS policies.
+func NewSupabaseStorage(cfg *config.Config) *SupabaseStorage {
+	key := cfg.SupabaseServiceKey
+	if key == "" {
+		key = cfg.SupabaseAnonKey
+	}
+
+	headers := map[string]string{
+		"Authorization": "Bearer " + key,
+		"apikey":        key,
+	}
+
+	return &SupabaseStorage{
+		client: storage_go.NewClient(strings.TrimRight(cfg.SupabaseURL, "/")+"/storage/v1", key, headers),
+		bucket: cfg.StorageBucket,
+	}
+}
+
+func (s *SupabaseStorage) Put(path string, data []byte, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upsert := true
+	cacheControl := "31536000"
+	_, err := s.client.UploadFile(s.bucket, path, bytes.NewReader(data), storage_go.FileOptions{
+		ContentType:  &contentType,
+		CacheControl: &cacheControl,
+		Upsert:       &upsert,
+	})
+	return err
+}
+
+func (s *SupabaseStorage) Delete(paths ...string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.client.RemoveFile(s.bucket, paths)
+	return err
+}
+
+func (s *SupabaseStorage) PublicURL(path string) string {
+	return s.client.GetPublicUrl(s.bucket, path).SignedURL
+}
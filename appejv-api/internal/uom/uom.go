@@ -0,0 +1,90 @@
+package uom
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/appejv/appejv-api/internal/models"
+)
+
+// DefaultBaseUnit is used for products without a unit
+const DefaultBaseUnit = "kg"
+
+var (
+	ErrUnknownUnit     = errors.New("unit is not allowed for this product")
+	ErrFractionalStock = errors.New("quantity does not convert to a whole number of base units")
+	ErrInvalidQuantity = errors.New("quantity must be greater than zero")
+)
+
+// BaseUnit returns the implicit base unit of a product, used when the
+// product has no product_units rows yet
+func BaseUnit(p models.Product) models.ProductUnit {
+	unit := DefaultBaseUnit
+	if p.Unit != nil && *p.Unit != "" {
+		unit = *p.Unit
+	}
+	return models.ProductUnit{
+		ProductID: p.ID,
+		Unit:      unit,
+		Factor:    1,
+		IsBase:    true,
+	}
+}
+
+// Units returns the sellable units of p with their effective prices filled in.
+// The base unit is always included.
+func Units(p models.Product, units []models.ProductUnit) []models.ProductUnit {
+	out := make([]models.ProductUnit, 0, len(units)+1)
+	hasBase := false
+	for _, u := range units {
+		hasBase = hasBase || u.IsBase
+		u.UnitPrice = UnitPrice(u, p.Price)
+		out = append(out, u)
+	}
+	if !hasBase {
+		base := BaseUnit(p)
+		base.UnitPrice = p.Price
+		out = append([]models.ProductUnit{base}, out...)
+	}
+	return out
+}
+
+// Resolve finds the unit named unit among the product's units. An empty
+// name resolves to the base unit.
+func Resolve(p models.Product, units []models.ProductUnit, unit string) (models.ProductUnit, error) {
+	for _, u := range Units(p, units) {
+		if (unit == "" && u.IsBase) || u.Unit == unit {
+			return u, nil
+		}
+	}
+	return models.ProductUnit{}, fmt.Errorf("%w: %s", ErrUnknownUnit, unit)
+}
+
+// UnitPrice returns the explicit unit price, or the base price scaled by
+// the conversion factor
+func UnitPrice(u models.ProductUnit, basePrice float64) float64 {
+	if u.Price != nil {
+		return *u.Price
+	}
+	return round(basePrice*u.Factor, 2)
+}
+
+// BaseQuantity converts qty in unit u to base units. Stock is an integer
+// count of base units, so the result must be whole.
+func BaseQuantity(u models.ProductUnit, qty float64) (int, error) {
+	if qty <= 0 {
+		return 0, ErrInvalidQuantity
+	}
+
+	base := round(qty*u.Factor, 4)
+	if base != math.Trunc(base) {
+		return 0, ErrFractionalStock
+	}
+	return int(base), nil
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
@@ -0,0 +1,95 @@
+-- Migration 22: Product image gallery
+-- Products can have several uploaded images with an explicit order and one
+-- primary image. Files live in Supabase Storage (bucket: product-images);
+-- this table stores their paths and the URLs of the generated variants.
+-- products.image_url is kept in sync with the primary image for older clients.
+
+BEGIN;
+
+-- ============================================================================
+-- PRODUCT IMAGES TABLE
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS product_images (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  storage_path TEXT NOT NULL,          -- original file, e.g. products/12/<id>.jpg
+  url TEXT NOT NULL,                   -- public URL of the original
+  thumbnail_url TEXT NOT NULL,         -- 300px JPEG
+  web_url TEXT NOT NULL,               -- 1200px JPEG
+  content_type VARCHAR(50) NOT NULL,
+  size_bytes BIGINT NOT NULL,
+  width INTEGER NOT NULL,
+  height INTEGER NOT NULL,
+  sort_order INTEGER NOT NULL DEFAULT 0,
+  is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+  created_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_images_product ON product_images(product_id, sort_order);
+
+-- At most one primary image per product
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_images_one_primary
+  ON product_images(product_id) WHERE is_primary;
+
+-- ============================================================================
+-- KEEP products.image_url IN SYNC WITH THE PRIMARY IMAGE
+-- ============================================================================
+CREATE OR REPLACE FUNCTION sync_product_primary_image()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  target_product BIGINT;
+BEGIN
+  target_product := COALESCE(NEW.product_id, OLD.product_id);
+
+  UPDATE products
+  SET image_url = (
+    SELECT web_url FROM product_images
+    WHERE product_id = target_product AND is_primary
+    LIMIT 1
+  )
+  WHERE id = target_product;
+
+  RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS product_images_sync_primary ON product_images;
+CREATE TRIGGER product_images_sync_primary
+  AFTER INSERT OR UPDATE OF is_primary OR DELETE ON product_images
+  FOR EACH ROW
+  EXECUTE FUNCTION sync_product_primary_image();
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+ALTER TABLE product_images ENABLE ROW LEVEL SECURITY;
+
+-- Product images are public like products
+DROP POLICY IF EXISTS "product_images_public_select" ON product_images;
+CREATE POLICY "product_images_public_select" ON product_images
+  FOR SELECT
+  USING (true);
+
+-- Only admin and sale_admin manage images
+DROP POLICY IF EXISTS "product_images_admin_write" ON product_images;
+CREATE POLICY "product_images_admin_write" ON product_images
+  FOR ALL
+  TO authenticated
+  USING (is_admin_or_sale_admin())
+  WITH CHECK (is_admin_or_sale_admin());
+
+-- ============================================================================
+-- STORAGE BUCKET
+-- ============================================================================
+INSERT INTO storage.buckets (id, name, public)
+VALUES ('product-images', 'product-images', true)
+ON CONFLICT (id) DO NOTHING;
+
+COMMENT ON TABLE product_images IS 'Uploaded product images with ordering and a primary image';
+
+COMMIT;
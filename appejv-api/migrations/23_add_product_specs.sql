@@ -0,0 +1,27 @@
+-- Migration 23: Structured product specifications
+-- Products get a typed specification document (products.specs) validated by
+-- the API against the schema of their category (categories.spec_schema).
+-- Schemas are defined in the API (internal/specs): 'feed', 'premix'.
+-- The free-form products.specifications text column is kept for descriptions.
+
+BEGIN;
+
+ALTER TABLE categories
+  ADD COLUMN IF NOT EXISTS spec_schema VARCHAR(50);
+
+ALTER TABLE products
+  ADD COLUMN IF NOT EXISTS specs JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+-- Existing feed categories use the compound feed schema
+UPDATE categories
+SET spec_schema = 'feed'
+WHERE spec_schema IS NULL;
+
+-- Speeds up filtering on spec values
+CREATE INDEX IF NOT EXISTS idx_products_specs ON products USING GIN (specs jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_products_specs_target_animal ON products ((specs->>'target_animal'));
+
+COMMENT ON COLUMN categories.spec_schema IS 'Name of the specification schema products in this category use (feed, premix)';
+COMMENT ON COLUMN products.specs IS 'Structured specification values, validated against the category spec schema';
+
+COMMIT;
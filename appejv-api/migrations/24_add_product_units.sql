@@ -0,0 +1,87 @@
+-- Migration 24: Units of measure per product
+-- A product is stocked in its base unit (products.unit, e.g. 'kg') and can be
+-- sold in other units (bag, tonne) with a conversion factor to the base unit.
+-- Stock is always tracked in the base unit.
+
+BEGIN;
+
+-- ============================================================================
+-- PRODUCT UNITS TABLE
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS product_units (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  unit VARCHAR(20) NOT NULL,                   -- e.g. 'bao', 'kg', 'tấn'
+  factor NUMERIC(14, 4) NOT NULL CHECK (factor > 0), -- base units per one of this unit
+  price NUMERIC(14, 2) CHECK (price >= 0),     -- explicit price; NULL = products.price * factor
+  is_base BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (product_id, unit),
+  CHECK (NOT is_base OR factor = 1)
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_units_product ON product_units(product_id);
+
+-- Exactly one base unit per product
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_units_one_base
+  ON product_units(product_id) WHERE is_base;
+
+-- Seed the base unit of every existing product from products.unit
+INSERT INTO product_units (product_id, unit, factor, is_base)
+SELECT id, COALESCE(NULLIF(unit, ''), 'kg'), 1, TRUE
+FROM products
+ON CONFLICT (product_id, unit) DO NOTHING;
+
+-- ============================================================================
+-- ORDER ITEMS: ORDERED UNIT AND BASE QUANTITY
+-- ============================================================================
+-- quantity stays the quantity in the ordered unit (fractional for e.g.
+-- 1.5 tonnes); base_quantity is what stock movements use.
+ALTER TABLE order_items
+  ALTER COLUMN quantity TYPE NUMERIC(14, 3);
+
+ALTER TABLE order_items
+  ADD COLUMN IF NOT EXISTS unit VARCHAR(20),
+  ADD COLUMN IF NOT EXISTS unit_factor NUMERIC(14, 4) NOT NULL DEFAULT 1,
+  ADD COLUMN IF NOT EXISTS base_quantity INTEGER;
+
+UPDATE order_items
+SET base_quantity = quantity
+WHERE base_quantity IS NULL;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+ALTER TABLE product_units ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS "product_units_public_select" ON product_units;
+CREATE POLICY "product_units_public_select" ON product_units
+  FOR SELECT
+  USING (true);
+
+DROP POLICY IF EXISTS "product_units_admin_write" ON product_units;
+CREATE POLICY "product_units_admin_write" ON product_units
+  FOR ALL
+  TO authenticated
+  USING (is_admin_or_sale_admin())
+  WITH CHECK (is_admin_or_sale_admin());
+
+CREATE OR REPLACE FUNCTION update_product_units_updated_at()
+RETURNS TRIGGER AS $$
+BEGIN
+  NEW.updated_at = NOW();
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS product_units_updated_at ON product_units;
+CREATE TRIGGER product_units_updated_at
+  BEFORE UPDATE ON product_units
+  FOR EACH ROW
+  EXECUTE FUNCTION update_product_units_updated_at();
+
+COMMENT ON TABLE product_units IS 'Sellable units of measure per product with conversion factor to the base unit';
+COMMENT ON COLUMN product_units.price IS 'Explicit unit price; NULL means derived as products.price * factor';
+
+COMMIT;
@@ -0,0 +1,213 @@
+-- Migration 25: Stock movement ledger
+-- Every stock change is an append-only row in stock_movements. products.stock
+-- becomes a synchronised balance that can only change through
+-- post_stock_movement(); direct writes (including the warehouse policy from
+-- migration 09) are rejected.
+-- Quantities are signed deltas in the product's base unit.
+
+BEGIN;
+
+-- ============================================================================
+-- STOCK MOVEMENTS TABLE
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS stock_movements (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+  movement_type VARCHAR(30) NOT NULL CHECK (movement_type IN (
+    'receipt',            -- goods received from production/supplier
+    'order_consumption',  -- goods shipped for an order
+    'return',             -- goods returned by a customer
+    'adjustment',         -- manual correction with a reason code
+    'transfer_out',       -- goods leaving a warehouse
+    'transfer_in'         -- goods arriving at a warehouse
+  )),
+  quantity INTEGER NOT NULL CHECK (quantity <> 0),
+  balance_after INTEGER NOT NULL,
+  reason_code VARCHAR(50),
+  reference_type VARCHAR(50),  -- 'order', 'purchase', 'transfer', ...
+  reference_id TEXT,
+  note TEXT,
+  created_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CHECK (movement_type <> 'adjustment' OR reason_code IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_product ON stock_movements(product_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_type ON stock_movements(movement_type);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_reference ON stock_movements(reference_type, reference_id);
+
+-- Ledger rows are never changed or removed
+CREATE OR REPLACE FUNCTION reject_stock_movement_change()
+RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'stock_movements is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS stock_movements_append_only ON stock_movements;
+CREATE TRIGGER stock_movements_append_only
+  BEFORE UPDATE OR DELETE ON stock_movements
+  FOR EACH ROW
+  EXECUTE FUNCTION reject_stock_movement_change();
+
+-- ============================================================================
+-- OPENING BALANCES
+-- ============================================================================
+-- Record current stock as an opening adjustment so the ledger sums to it
+INSERT INTO stock_movements (product_id, movement_type, quantity, balance_after, reason_code, note)
+SELECT id, 'adjustment', stock, stock, 'opening_balance', 'Opening balance from products.stock'
+FROM products
+WHERE stock <> 0
+  AND NOT EXISTS (SELECT 1 FROM stock_movements sm WHERE sm.product_id = products.id);
+
+-- ============================================================================
+-- POSTING FUNCTION
+-- ============================================================================
+-- Locks the product row, appends the movement and updates products.stock.
+-- Raises if the resulting balance would be negative.
+CREATE OR REPLACE FUNCTION post_stock_movement(
+  p_product_id BIGINT,
+  p_movement_type VARCHAR,
+  p_quantity INTEGER,
+  p_reason_code VARCHAR DEFAULT NULL,
+  p_reference_type VARCHAR DEFAULT NULL,
+  p_reference_id TEXT DEFAULT NULL,
+  p_note TEXT DEFAULT NULL,
+  p_user_id UUID DEFAULT NULL
+)
+RETURNS stock_movements
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  current_stock INTEGER;
+  movement stock_movements;
+BEGIN
+  SELECT stock INTO current_stock
+  FROM products
+  WHERE id = p_product_id
+  FOR UPDATE;
+
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'product % not found', p_product_id;
+  END IF;
+
+  IF current_stock + p_quantity < 0 THEN
+    RAISE EXCEPTION 'insufficient stock for product %: have %, need %',
+      p_product_id, current_stock, -p_quantity;
+  END IF;
+
+  INSERT INTO stock_movements (
+    product_id, movement_type, quantity, balance_after,
+    reason_code, reference_type, reference_id, note, created_by
+  ) VALUES (
+    p_product_id, p_movement_type, p_quantity, current_stock + p_quantity,
+    p_reason_code, p_reference_type, p_reference_id, p_note,
+    COALESCE(p_user_id, auth.uid())
+  )
+  RETURNING * INTO movement;
+
+  PERFORM set_config('app.stock_posting', 'on', true);
+  UPDATE products SET stock = movement.balance_after WHERE id = p_product_id;
+  PERFORM set_config('app.stock_posting', 'off', true);
+
+  RETURN movement;
+END;
+$$;
+
+-- ============================================================================
+-- products.stock IS A SYNCHRONISED BALANCE
+-- ============================================================================
+CREATE OR REPLACE FUNCTION guard_products_stock()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    IF COALESCE(NEW.stock, 0) <> 0 THEN
+      RAISE EXCEPTION 'products.stock must start at 0; post a receipt to add stock';
+    END IF;
+  ELSIF NEW.stock IS DISTINCT FROM OLD.stock
+        AND current_setting('app.stock_posting', true) IS DISTINCT FROM 'on' THEN
+    RAISE EXCEPTION 'products.stock can only change through post_stock_movement()';
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS guard_products_stock_trigger ON products;
+CREATE TRIGGER guard_products_stock_trigger
+  BEFORE INSERT OR UPDATE ON products
+  FOR EACH ROW
+  EXECUTE FUNCTION guard_products_stock();
+
+-- Warehouse users no longer update products directly (migration 09)
+DROP POLICY IF EXISTS "warehouse_update_product_stock" ON products;
+
+-- ============================================================================
+-- ORDER CONSUMPTION AND RETURNS
+-- ============================================================================
+-- Shipping an order consumes its items; cancelling a shipped order returns them.
+CREATE OR REPLACE FUNCTION post_order_stock_movements()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  item RECORD;
+BEGIN
+  IF NEW.status IS NOT DISTINCT FROM OLD.status THEN
+    RETURN NEW;
+  END IF;
+
+  IF OLD.status IN ('draft', 'ordered') AND NEW.status = 'shipping' THEN
+    FOR item IN
+      SELECT product_id, COALESCE(base_quantity, quantity::INTEGER) AS qty
+      FROM order_items WHERE order_id = NEW.id
+    LOOP
+      PERFORM post_stock_movement(
+        item.product_id, 'order_consumption', -item.qty,
+        NULL, 'order', NEW.id::TEXT, NULL, auth.uid()
+      );
+    END LOOP;
+  ELSIF OLD.status IN ('shipping', 'delivered', 'completed') AND NEW.status = 'cancelled' THEN
+    FOR item IN
+      SELECT product_id, COALESCE(base_quantity, quantity::INTEGER) AS qty
+      FROM order_items WHERE order_id = NEW.id
+    LOOP
+      PERFORM post_stock_movement(
+        item.product_id, 'return', item.qty,
+        'order_cancelled', 'order', NEW.id::TEXT, NULL, auth.uid()
+      );
+    END LOOP;
+  END IF;
+
+  RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS orders_stock_movements ON orders;
+CREATE TRIGGER orders_stock_movements
+  AFTER UPDATE OF status ON orders
+  FOR EACH ROW
+  EXECUTE FUNCTION post_order_stock_movements();
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+ALTER TABLE stock_movements ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS "stock_movements_staff_select" ON stock_movements;
+CREATE POLICY "stock_movements_staff_select" ON stock_movements
+  FOR SELECT
+  TO authenticated
+  USING (is_admin_or_sale_admin() OR is_warehouse());
+
+-- Rows are only written by post_stock_movement() (SECURITY DEFINER)
+REVOKE INSERT, UPDATE, DELETE ON stock_movements FROM authenticated, anon;
+GRANT EXECUTE ON FUNCTION post_stock_movement TO authenticated;
+
+COMMENT ON TABLE stock_movements IS 'Append-only stock ledger; products.stock is the running balance';
+COMMENT ON FUNCTION post_stock_movement IS 'Appends a stock movement and updates products.stock atomically';
+
+COMMIT;
@@ -0,0 +1,376 @@
+-- Migration 26: Multiple warehouses, stock per warehouse and transfers
+-- Stock is held per (warehouse, product) in warehouse_stock. products.stock
+-- stays the synchronised total on hand across all warehouses. Goods on a
+-- shipped transfer are in transit and not counted in any warehouse.
+-- Warehouse-role users are assigned to the depots they work in.
+
+BEGIN;
+
+-- ============================================================================
+-- WAREHOUSES
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS warehouses (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  code VARCHAR(20) UNIQUE NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  address TEXT,
+  latitude NUMERIC(9, 6),
+  longitude NUMERIC(9, 6),
+  is_default BOOLEAN NOT NULL DEFAULT FALSE,
+  status VARCHAR(20) NOT NULL DEFAULT 'active', -- 'active', 'inactive'
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_warehouses_one_default ON warehouses(is_default) WHERE is_default;
+
+-- Existing stock lives in the main depot
+INSERT INTO warehouses (code, name, is_default)
+SELECT 'MAIN', 'Kho chính', TRUE
+WHERE NOT EXISTS (SELECT 1 FROM warehouses);
+
+-- Warehouse users and the depots they are assigned to
+CREATE TABLE IF NOT EXISTS warehouse_users (
+  warehouse_id UUID NOT NULL REFERENCES warehouses(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+  assigned_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (warehouse_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_warehouse_users_user ON warehouse_users(user_id);
+
+-- ============================================================================
+-- STOCK PER WAREHOUSE
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS warehouse_stock (
+  warehouse_id UUID NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+  product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  quantity INTEGER NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (warehouse_id, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_warehouse_stock_product ON warehouse_stock(product_id);
+
+INSERT INTO warehouse_stock (warehouse_id, product_id, quantity)
+SELECT w.id, p.id, p.stock
+FROM products p
+CROSS JOIN warehouses w
+WHERE w.is_default AND p.stock > 0
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE stock_movements
+  ADD COLUMN IF NOT EXISTS warehouse_id UUID REFERENCES warehouses(id) ON DELETE RESTRICT;
+
+UPDATE stock_movements
+SET warehouse_id = (SELECT id FROM warehouses WHERE is_default)
+WHERE warehouse_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_warehouse ON stock_movements(warehouse_id, created_at DESC);
+
+-- ============================================================================
+-- ORDER FULFILMENT WAREHOUSE AND CUSTOMER LOCATION
+-- ============================================================================
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS warehouse_id UUID REFERENCES warehouses(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_orders_warehouse ON orders(warehouse_id);
+
+ALTER TABLE customers
+  ADD COLUMN IF NOT EXISTS latitude NUMERIC(9, 6),
+  ADD COLUMN IF NOT EXISTS longitude NUMERIC(9, 6);
+
+-- ============================================================================
+-- TRANSFERS
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS stock_transfers (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  from_warehouse_id UUID NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+  to_warehouse_id UUID NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+  status VARCHAR(20) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'in_transit', 'received', 'cancelled')),
+  note TEXT,
+  created_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  shipped_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  received_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  shipped_at TIMESTAMPTZ,
+  received_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CHECK (from_warehouse_id <> to_warehouse_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_transfers_status ON stock_transfers(status);
+
+CREATE TABLE IF NOT EXISTS stock_transfer_items (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  transfer_id UUID NOT NULL REFERENCES stock_transfers(id) ON DELETE CASCADE,
+  product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+  quantity INTEGER NOT NULL CHECK (quantity > 0),
+  UNIQUE (transfer_id, product_id)
+);
+
+-- ============================================================================
+-- POSTING FUNCTION WITH WAREHOUSE
+-- ============================================================================
+-- Replaces the single-balance version from migration 25. The movement is
+-- applied to warehouse_stock of p_warehouse_id (default warehouse when NULL)
+-- and products.stock is set to the total across warehouses.
+DROP FUNCTION IF EXISTS post_stock_movement(BIGINT, VARCHAR, INTEGER, VARCHAR, VARCHAR, TEXT, TEXT, UUID);
+
+CREATE OR REPLACE FUNCTION post_stock_movement(
+  p_product_id BIGINT,
+  p_movement_type VARCHAR,
+  p_quantity INTEGER,
+  p_reason_code VARCHAR DEFAULT NULL,
+  p_reference_type VARCHAR DEFAULT NULL,
+  p_reference_id TEXT DEFAULT NULL,
+  p_note TEXT DEFAULT NULL,
+  p_user_id UUID DEFAULT NULL,
+  p_warehouse_id UUID DEFAULT NULL
+)
+RETURNS stock_movements
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  target_warehouse UUID;
+  warehouse_qty INTEGER;
+  total_stock INTEGER;
+  movement stock_movements;
+BEGIN
+  target_warehouse := COALESCE(p_warehouse_id, (SELECT id FROM warehouses WHERE is_default));
+  IF target_warehouse IS NULL THEN
+    RAISE EXCEPTION 'warehouse not found';
+  END IF;
+
+  -- Lock the product first so concurrent postings serialise per product
+  PERFORM 1 FROM products WHERE id = p_product_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'product % not found', p_product_id;
+  END IF;
+
+  INSERT INTO warehouse_stock (warehouse_id, product_id, quantity)
+  VALUES (target_warehouse, p_product_id, 0)
+  ON CONFLICT DO NOTHING;
+
+  SELECT quantity INTO warehouse_qty
+  FROM warehouse_stock
+  WHERE warehouse_id = target_warehouse AND product_id = p_product_id
+  FOR UPDATE;
+
+  IF warehouse_qty + p_quantity < 0 THEN
+    RAISE EXCEPTION 'insufficient stock for product % in warehouse %: have %, need %',
+      p_product_id, target_warehouse, warehouse_qty, -p_quantity;
+  END IF;
+
+  UPDATE warehouse_stock
+  SET quantity = quantity + p_quantity, updated_at = NOW()
+  WHERE warehouse_id = target_warehouse AND product_id = p_product_id;
+
+  SELECT COALESCE(SUM(quantity), 0) INTO total_stock
+  FROM warehouse_stock
+  WHERE product_id = p_product_id;
+
+  INSERT INTO stock_movements (
+    product_id, warehouse_id, movement_type, quantity, balance_after,
+    reason_code, reference_type, reference_id, note, created_by
+  ) VALUES (
+    p_product_id, target_warehouse, p_movement_type, p_quantity, warehouse_qty + p_quantity,
+    p_reason_code, p_reference_type, p_reference_id, p_note,
+    COALESCE(p_user_id, auth.uid())
+  )
+  RETURNING * INTO movement;
+
+  PERFORM set_config('app.stock_posting', 'on', true);
+  UPDATE products SET stock = total_stock WHERE id = p_product_id;
+  PERFORM set_config('app.stock_posting', 'off', true);
+
+  RETURN movement;
+END;
+$$;
+
+COMMENT ON COLUMN stock_movements.balance_after IS 'Balance of the product in the movement''s warehouse after the movement';
+
+-- Orders consume from their fulfilment warehouse
+CREATE OR REPLACE FUNCTION post_order_stock_movements()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  item RECORD;
+BEGIN
+  IF NEW.status IS NOT DISTINCT FROM OLD.status THEN
+    RETURN NEW;
+  END IF;
+
+  IF OLD.status IN ('draft', 'ordered') AND NEW.status = 'shipping' THEN
+    FOR item IN
+      SELECT product_id, COALESCE(base_quantity, quantity::INTEGER) AS qty
+      FROM order_items WHERE order_id = NEW.id
+    LOOP
+      PERFORM post_stock_movement(
+        item.product_id, 'order_consumption', -item.qty,
+        NULL, 'order', NEW.id::TEXT, NULL, auth.uid(), NEW.warehouse_id
+      );
+    END LOOP;
+  ELSIF OLD.status IN ('shipping', 'delivered', 'completed') AND NEW.status = 'cancelled' THEN
+    FOR item IN
+      SELECT product_id, COALESCE(base_quantity, quantity::INTEGER) AS qty
+      FROM order_items WHERE order_id = NEW.id
+    LOOP
+      PERFORM post_stock_movement(
+        item.product_id, 'return', item.qty,
+        'order_cancelled', 'order', NEW.id::TEXT, NULL, auth.uid(), NEW.warehouse_id
+      );
+    END LOOP;
+  END IF;
+
+  RETURN NEW;
+END;
+$$;
+
+-- ============================================================================
+-- TRANSFER WORKFLOW
+-- ============================================================================
+-- draft -> in_transit: stock leaves the source warehouse
+CREATE OR REPLACE FUNCTION ship_stock_transfer(p_transfer_id UUID, p_user_id UUID DEFAULT NULL)
+RETURNS stock_transfers
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  t stock_transfers;
+  item RECORD;
+BEGIN
+  SELECT * INTO t FROM stock_transfers WHERE id = p_transfer_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'transfer % not found', p_transfer_id;
+  END IF;
+  IF t.status <> 'draft' THEN
+    RAISE EXCEPTION 'invalid transfer status: % cannot be shipped', t.status;
+  END IF;
+
+  FOR item IN SELECT product_id, quantity FROM stock_transfer_items WHERE transfer_id = t.id LOOP
+    PERFORM post_stock_movement(
+      item.product_id, 'transfer_out', -item.quantity,
+      NULL, 'transfer', t.id::TEXT, NULL, p_user_id, t.from_warehouse_id
+    );
+  END LOOP;
+
+  UPDATE stock_transfers
+  SET status = 'in_transit', shipped_at = NOW(), shipped_by = COALESCE(p_user_id, auth.uid())
+  WHERE id = t.id
+  RETURNING * INTO t;
+
+  RETURN t;
+END;
+$$;
+
+-- in_transit -> received: stock arrives at the destination warehouse
+CREATE OR REPLACE FUNCTION receive_stock_transfer(p_transfer_id UUID, p_user_id UUID DEFAULT NULL)
+RETURNS stock_transfers
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  t stock_transfers;
+  item RECORD;
+BEGIN
+  SELECT * INTO t FROM stock_transfers WHERE id = p_transfer_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'transfer % not found', p_transfer_id;
+  END IF;
+  IF t.status <> 'in_transit' THEN
+    RAISE EXCEPTION 'invalid transfer status: % cannot be received', t.status;
+  END IF;
+
+  FOR item IN SELECT product_id, quantity FROM stock_transfer_items WHERE transfer_id = t.id LOOP
+    PERFORM post_stock_movement(
+      item.product_id, 'transfer_in', item.quantity,
+      NULL, 'transfer', t.id::TEXT, NULL, p_user_id, t.to_warehouse_id
+    );
+  END LOOP;
+
+  UPDATE stock_transfers
+  SET status = 'received', received_at = NOW(), received_by = COALESCE(p_user_id, auth.uid())
+  WHERE id = t.id
+  RETURNING * INTO t;
+
+  RETURN t;
+END;
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+CREATE OR REPLACE FUNCTION public.is_assigned_warehouse(p_warehouse_id UUID)
+RETURNS boolean
+LANGUAGE sql
+SECURITY DEFINER
+SET search_path = public
+STABLE
+AS $$
+  SELECT EXISTS (
+    SELECT 1 FROM warehouse_users
+    WHERE warehouse_id = p_warehouse_id AND user_id = auth.uid()
+  );
+$$;
+
+ALTER TABLE warehouses ENABLE ROW LEVEL SECURITY;
+ALTER TABLE warehouse_users ENABLE ROW LEVEL SECURITY;
+ALTER TABLE warehouse_stock ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stock_transfers ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stock_transfer_items ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY "warehouses_staff_select" ON warehouses
+  FOR SELECT TO authenticated
+  USING (is_admin_or_sale_admin() OR is_sale() OR is_assigned_warehouse(id));
+
+CREATE POLICY "warehouses_admin_write" ON warehouses
+  FOR ALL TO authenticated
+  USING (is_admin()) WITH CHECK (is_admin());
+
+CREATE POLICY "warehouse_users_select" ON warehouse_users
+  FOR SELECT TO authenticated
+  USING (is_admin_or_sale_admin() OR user_id = auth.uid());
+
+CREATE POLICY "warehouse_users_admin_write" ON warehouse_users
+  FOR ALL TO authenticated
+  USING (is_admin()) WITH CHECK (is_admin());
+
+CREATE POLICY "warehouse_stock_select" ON warehouse_stock
+  FOR SELECT TO authenticated
+  USING (is_admin_or_sale_admin() OR is_sale() OR is_assigned_warehouse(warehouse_id));
+
+CREATE POLICY "stock_transfers_select" ON stock_transfers
+  FOR SELECT TO authenticated
+  USING (
+    is_admin_or_sale_admin()
+    OR is_assigned_warehouse(from_warehouse_id)
+    OR is_assigned_warehouse(to_warehouse_id)
+  );
+
+CREATE POLICY "stock_transfer_items_select" ON stock_transfer_items
+  FOR SELECT TO authenticated
+  USING (EXISTS (SELECT 1 FROM stock_transfers t WHERE t.id = transfer_id));
+
+-- Warehouse users only see movements of their depots
+DROP POLICY IF EXISTS "stock_movements_staff_select" ON stock_movements;
+CREATE POLICY "stock_movements_staff_select" ON stock_movements
+  FOR SELECT TO authenticated
+  USING (is_admin_or_sale_admin() OR is_assigned_warehouse(warehouse_id));
+
+REVOKE INSERT, UPDATE, DELETE ON warehouse_stock FROM authenticated, anon;
+GRANT EXECUTE ON FUNCTION post_stock_movement TO authenticated;
+GRANT EXECUTE ON FUNCTION ship_stock_transfer TO authenticated;
+GRANT EXECUTE ON FUNCTION receive_stock_transfer TO authenticated;
+
+COMMENT ON TABLE warehouses IS 'Depots holding stock';
+COMMENT ON TABLE warehouse_stock IS 'On-hand stock per warehouse and product (base units)';
+COMMENT ON TABLE stock_transfers IS 'Transfers of stock between warehouses (draft -> in_transit -> received)';
+
+COMMIT;
@@ -0,0 +1,428 @@
+-- Migration 27: Batch/lot and expiry tracking
+-- Stock received with a lot number is held in stock_lots, a sub-balance of
+-- warehouse_stock. Stock received without a lot (and stock from before this
+-- migration) is "unlotted": warehouse_stock.quantity minus the lot balances.
+-- Outgoing stock (orders, transfers, write-offs) is allocated first-expiry-
+-- first-out across lots, then from unlotted stock. Every movement that
+-- touches a lot records lot_id, so a lot can be traced to the orders and
+-- customers that received it.
+
+BEGIN;
+
+-- ============================================================================
+-- LOTS
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS stock_lots (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+  warehouse_id UUID NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+  lot_number VARCHAR(50) NOT NULL,
+  manufactured_at DATE,
+  expires_at DATE,
+  quantity INTEGER NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+  received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (product_id, warehouse_id, lot_number),
+  CHECK (expires_at IS NULL OR manufactured_at IS NULL OR expires_at >= manufactured_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_lots_fefo ON stock_lots(product_id, warehouse_id, expires_at NULLS LAST, received_at);
+CREATE INDEX IF NOT EXISTS idx_stock_lots_expiry ON stock_lots(expires_at) WHERE quantity > 0;
+CREATE INDEX IF NOT EXISTS idx_stock_lots_number ON stock_lots(product_id, lot_number);
+
+ALTER TABLE stock_movements
+  ADD COLUMN IF NOT EXISTS lot_id UUID REFERENCES stock_lots(id) ON DELETE RESTRICT;
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_lot ON stock_movements(lot_id) WHERE lot_id IS NOT NULL;
+
+-- ============================================================================
+-- POSTING FUNCTION WITH LOT
+-- ============================================================================
+-- Replaces the version from migration 26. With p_lot_id the lot balance moves
+-- together with the warehouse balance. Without it the movement may only take
+-- unlotted stock, so lot balances never exceed the warehouse balance.
+DROP FUNCTION IF EXISTS post_stock_movement(BIGINT, VARCHAR, INTEGER, VARCHAR, VARCHAR, TEXT, TEXT, UUID, UUID);
+
+CREATE OR REPLACE FUNCTION post_stock_movement(
+  p_product_id BIGINT,
+  p_movement_type VARCHAR,
+  p_quantity INTEGER,
+  p_reason_code VARCHAR DEFAULT NULL,
+  p_reference_type VARCHAR DEFAULT NULL,
+  p_reference_id TEXT DEFAULT NULL,
+  p_note TEXT DEFAULT NULL,
+  p_user_id UUID DEFAULT NULL,
+  p_warehouse_id UUID DEFAULT NULL,
+  p_lot_id UUID DEFAULT NULL
+)
+RETURNS stock_movements
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  target_warehouse UUID;
+  warehouse_qty INTEGER;
+  lotted_qty INTEGER;
+  total_stock INTEGER;
+  lot stock_lots;
+  movement stock_movements;
+BEGIN
+  target_warehouse := COALESCE(p_warehouse_id, (SELECT id FROM warehouses WHERE is_default));
+  IF target_warehouse IS NULL THEN
+    RAISE EXCEPTION 'warehouse not found';
+  END IF;
+
+  -- Lock the product first so concurrent postings serialise per product
+  PERFORM 1 FROM products WHERE id = p_product_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'product % not found', p_product_id;
+  END IF;
+
+  INSERT INTO warehouse_stock (warehouse_id, product_id, quantity)
+  VALUES (target_warehouse, p_product_id, 0)
+  ON CONFLICT DO NOTHING;
+
+  SELECT quantity INTO warehouse_qty
+  FROM warehouse_stock
+  WHERE warehouse_id = target_warehouse AND product_id = p_product_id
+  FOR UPDATE;
+
+  IF warehouse_qty + p_quantity < 0 THEN
+    RAISE EXCEPTION 'insufficient stock for product % in warehouse %: have %, need %',
+      p_product_id, target_warehouse, warehouse_qty, -p_quantity;
+  END IF;
+
+  IF p_lot_id IS NOT NULL THEN
+    SELECT * INTO lot FROM stock_lots WHERE id = p_lot_id FOR UPDATE;
+    IF NOT FOUND OR lot.product_id <> p_product_id OR lot.warehouse_id <> target_warehouse THEN
+      RAISE EXCEPTION 'lot % not found for product % in warehouse %',
+        p_lot_id, p_product_id, target_warehouse;
+    END IF;
+    IF lot.quantity + p_quantity < 0 THEN
+      RAISE EXCEPTION 'insufficient stock in lot %: have %, need %',
+        lot.lot_number, lot.quantity, -p_quantity;
+    END IF;
+
+    UPDATE stock_lots SET quantity = quantity + p_quantity WHERE id = p_lot_id;
+  ELSIF p_quantity < 0 THEN
+    SELECT COALESCE(SUM(quantity), 0) INTO lotted_qty
+    FROM stock_lots
+    WHERE product_id = p_product_id AND warehouse_id = target_warehouse;
+
+    IF warehouse_qty - lotted_qty + p_quantity < 0 THEN
+      RAISE EXCEPTION 'insufficient stock for product % in warehouse % outside lots: have %, need %',
+        p_product_id, target_warehouse, warehouse_qty - lotted_qty, -p_quantity;
+    END IF;
+  END IF;
+
+  UPDATE warehouse_stock
+  SET quantity = quantity + p_quantity, updated_at = NOW()
+  WHERE warehouse_id = target_warehouse AND product_id = p_product_id;
+
+  SELECT COALESCE(SUM(quantity), 0) INTO total_stock
+  FROM warehouse_stock
+  WHERE product_id = p_product_id;
+
+  INSERT INTO stock_movements (
+    product_id, warehouse_id, lot_id, movement_type, quantity, balance_after,
+    reason_code, reference_type, reference_id, note, created_by
+  ) VALUES (
+    p_product_id, target_warehouse, p_lot_id, p_movement_type, p_quantity, warehouse_qty + p_quantity,
+    p_reason_code, p_reference_type, p_reference_id, p_note,
+    COALESCE(p_user_id, auth.uid())
+  )
+  RETURNING * INTO movement;
+
+  PERFORM set_config('app.stock_posting', 'on', true);
+  UPDATE products SET stock = total_stock WHERE id = p_product_id;
+  PERFORM set_config('app.stock_posting', 'off', true);
+
+  RETURN movement;
+END;
+$$;
+
+-- ============================================================================
+-- RECEIPT INTO A LOT
+-- ============================================================================
+-- Creates the lot on first receipt; later receipts of the same lot number must
+-- carry the same dates.
+CREATE OR REPLACE FUNCTION receive_stock_lot(
+  p_product_id BIGINT,
+  p_quantity INTEGER,
+  p_lot_number VARCHAR,
+  p_manufactured_at DATE DEFAULT NULL,
+  p_expires_at DATE DEFAULT NULL,
+  p_movement_type VARCHAR DEFAULT 'receipt',
+  p_reason_code VARCHAR DEFAULT NULL,
+  p_reference_type VARCHAR DEFAULT NULL,
+  p_reference_id TEXT DEFAULT NULL,
+  p_note TEXT DEFAULT NULL,
+  p_user_id UUID DEFAULT NULL,
+  p_warehouse_id UUID DEFAULT NULL
+)
+RETURNS stock_movements
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  target_warehouse UUID;
+  lot stock_lots;
+BEGIN
+  IF p_quantity <= 0 THEN
+    RAISE EXCEPTION 'lot receipts must be positive';
+  END IF;
+
+  target_warehouse := COALESCE(p_warehouse_id, (SELECT id FROM warehouses WHERE is_default));
+  IF target_warehouse IS NULL OR NOT EXISTS (SELECT 1 FROM warehouses WHERE id = target_warehouse) THEN
+    RAISE EXCEPTION 'warehouse not found';
+  END IF;
+
+  INSERT INTO stock_lots (product_id, warehouse_id, lot_number, manufactured_at, expires_at)
+  VALUES (p_product_id, target_warehouse, p_lot_number, p_manufactured_at, p_expires_at)
+  ON CONFLICT (product_id, warehouse_id, lot_number) DO NOTHING;
+
+  SELECT * INTO lot
+  FROM stock_lots
+  WHERE product_id = p_product_id AND warehouse_id = target_warehouse AND lot_number = p_lot_number;
+
+  IF lot.manufactured_at IS DISTINCT FROM COALESCE(p_manufactured_at, lot.manufactured_at)
+     OR lot.expires_at IS DISTINCT FROM COALESCE(p_expires_at, lot.expires_at) THEN
+    RAISE EXCEPTION 'lot % already exists with different dates', p_lot_number;
+  END IF;
+
+  RETURN post_stock_movement(
+    p_product_id, p_movement_type, p_quantity, p_reason_code,
+    p_reference_type, p_reference_id, p_note, p_user_id, target_warehouse, lot.id
+  );
+END;
+$$;
+
+-- ============================================================================
+-- FEFO ALLOCATION
+-- ============================================================================
+-- Takes p_quantity (positive) out of a warehouse: earliest-expiring lots
+-- first (lots without an expiry date last), then unlotted stock. Expired lots
+-- are skipped unless p_include_expired, so write-offs can still clear them.
+CREATE OR REPLACE FUNCTION consume_stock_fefo(
+  p_product_id BIGINT,
+  p_movement_type VARCHAR,
+  p_quantity INTEGER,
+  p_reason_code VARCHAR DEFAULT NULL,
+  p_reference_type VARCHAR DEFAULT NULL,
+  p_reference_id TEXT DEFAULT NULL,
+  p_note TEXT DEFAULT NULL,
+  p_user_id UUID DEFAULT NULL,
+  p_warehouse_id UUID DEFAULT NULL,
+  p_include_expired BOOLEAN DEFAULT FALSE
+)
+RETURNS SETOF stock_movements
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  target_warehouse UUID;
+  remaining INTEGER := p_quantity;
+  take INTEGER;
+  lot RECORD;
+BEGIN
+  IF p_quantity <= 0 THEN
+    RAISE EXCEPTION 'quantity to consume must be positive';
+  END IF;
+
+  target_warehouse := COALESCE(p_warehouse_id, (SELECT id FROM warehouses WHERE is_default));
+  IF target_warehouse IS NULL THEN
+    RAISE EXCEPTION 'warehouse not found';
+  END IF;
+
+  PERFORM 1 FROM products WHERE id = p_product_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'product % not found', p_product_id;
+  END IF;
+
+  FOR lot IN
+    SELECT id, quantity
+    FROM stock_lots
+    WHERE product_id = p_product_id
+      AND warehouse_id = target_warehouse
+      AND quantity > 0
+      AND (p_include_expired OR expires_at IS NULL OR expires_at >= CURRENT_DATE)
+    ORDER BY expires_at NULLS LAST, received_at, lot_number
+    FOR UPDATE
+  LOOP
+    EXIT WHEN remaining = 0;
+    take := LEAST(remaining, lot.quantity);
+    RETURN NEXT post_stock_movement(
+      p_product_id, p_movement_type, -take, p_reason_code,
+      p_reference_type, p_reference_id, p_note, p_user_id, target_warehouse, lot.id
+    );
+    remaining := remaining - take;
+  END LOOP;
+
+  IF remaining > 0 THEN
+    RETURN NEXT post_stock_movement(
+      p_product_id, p_movement_type, -remaining, p_reason_code,
+      p_reference_type, p_reference_id, p_note, p_user_id, target_warehouse, NULL
+    );
+  END IF;
+END;
+$$;
+
+-- ============================================================================
+-- ORDERS: FEFO ON SHIPPING, SAME LOTS BACK ON CANCELLATION
+-- ============================================================================
+CREATE OR REPLACE FUNCTION post_order_stock_movements()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  item RECORD;
+  consumed RECORD;
+BEGIN
+  IF NEW.status IS NOT DISTINCT FROM OLD.status THEN
+    RETURN NEW;
+  END IF;
+
+  IF OLD.status IN ('draft', 'ordered') AND NEW.status = 'shipping' THEN
+    FOR item IN
+      SELECT product_id, SUM(COALESCE(base_quantity, quantity::INTEGER))::INTEGER AS qty
+      FROM order_items WHERE order_id = NEW.id
+      GROUP BY product_id
+    LOOP
+      PERFORM consume_stock_fefo(
+        item.product_id, 'order_consumption', item.qty,
+        NULL, 'order', NEW.id::TEXT, NULL, auth.uid(), NEW.warehouse_id
+      );
+    END LOOP;
+  ELSIF OLD.status IN ('shipping', 'delivered', 'completed') AND NEW.status = 'cancelled' THEN
+    FOR consumed IN
+      SELECT product_id, warehouse_id, lot_id, -SUM(quantity)::INTEGER AS qty
+      FROM stock_movements
+      WHERE reference_type = 'order' AND reference_id = NEW.id::TEXT
+      GROUP BY product_id, warehouse_id, lot_id
+      HAVING SUM(quantity) < 0
+    LOOP
+      PERFORM post_stock_movement(
+        consumed.product_id, 'return', consumed.qty,
+        'order_cancelled', 'order', NEW.id::TEXT, NULL, auth.uid(),
+        consumed.warehouse_id, consumed.lot_id
+      );
+    END LOOP;
+  END IF;
+
+  RETURN NEW;
+END;
+$$;
+
+-- ============================================================================
+-- TRANSFERS KEEP LOT IDENTITY
+-- ============================================================================
+CREATE OR REPLACE FUNCTION ship_stock_transfer(p_transfer_id UUID, p_user_id UUID DEFAULT NULL)
+RETURNS stock_transfers
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  t stock_transfers;
+  item RECORD;
+BEGIN
+  SELECT * INTO t FROM stock_transfers WHERE id = p_transfer_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'transfer % not found', p_transfer_id;
+  END IF;
+  IF t.status <> 'draft' THEN
+    RAISE EXCEPTION 'invalid transfer status: % cannot be shipped', t.status;
+  END IF;
+
+  FOR item IN SELECT product_id, quantity FROM stock_transfer_items WHERE transfer_id = t.id LOOP
+    PERFORM consume_stock_fefo(
+      item.product_id, 'transfer_out', item.quantity,
+      NULL, 'transfer', t.id::TEXT, NULL, p_user_id, t.from_warehouse_id
+    );
+  END LOOP;
+
+  UPDATE stock_transfers
+  SET status = 'in_transit', shipped_at = NOW(), shipped_by = COALESCE(p_user_id, auth.uid())
+  WHERE id = t.id
+  RETURNING * INTO t;
+
+  RETURN t;
+END;
+$$;
+
+-- Goods arrive in lots with the same number and dates as they left
+CREATE OR REPLACE FUNCTION receive_stock_transfer(p_transfer_id UUID, p_user_id UUID DEFAULT NULL)
+RETURNS stock_transfers
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  t stock_transfers;
+  shipped RECORD;
+BEGIN
+  SELECT * INTO t FROM stock_transfers WHERE id = p_transfer_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'transfer % not found', p_transfer_id;
+  END IF;
+  IF t.status <> 'in_transit' THEN
+    RAISE EXCEPTION 'invalid transfer status: % cannot be received', t.status;
+  END IF;
+
+  FOR shipped IN
+    SELECT m.product_id, -SUM(m.quantity)::INTEGER AS qty,
+           l.lot_number, l.manufactured_at, l.expires_at
+    FROM stock_movements m
+    LEFT JOIN stock_lots l ON l.id = m.lot_id
+    WHERE m.reference_type = 'transfer'
+      AND m.reference_id = t.id::TEXT
+      AND m.movement_type = 'transfer_out'
+    GROUP BY m.product_id, l.lot_number, l.manufactured_at, l.expires_at
+  LOOP
+    IF shipped.lot_number IS NULL THEN
+      PERFORM post_stock_movement(
+        shipped.product_id, 'transfer_in', shipped.qty,
+        NULL, 'transfer', t.id::TEXT, NULL, p_user_id, t.to_warehouse_id
+      );
+    ELSE
+      PERFORM receive_stock_lot(
+        shipped.product_id, shipped.qty, shipped.lot_number,
+        shipped.manufactured_at, shipped.expires_at, 'transfer_in',
+        NULL, 'transfer', t.id::TEXT, NULL, p_user_id, t.to_warehouse_id
+      );
+    END IF;
+  END LOOP;
+
+  UPDATE stock_transfers
+  SET status = 'received', received_at = NOW(), received_by = COALESCE(p_user_id, auth.uid())
+  WHERE id = t.id
+  RETURNING * INTO t;
+
+  RETURN t;
+END;
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+ALTER TABLE stock_lots ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY "stock_lots_select" ON stock_lots
+  FOR SELECT TO authenticated
+  USING (is_admin_or_sale_admin() OR is_sale() OR is_assigned_warehouse(warehouse_id));
+
+-- Lots are only written by the posting functions (SECURITY DEFINER)
+REVOKE INSERT, UPDATE, DELETE ON stock_lots FROM authenticated, anon;
+GRANT EXECUTE ON FUNCTION post_stock_movement TO authenticated;
+GRANT EXECUTE ON FUNCTION receive_stock_lot TO authenticated;
+GRANT EXECUTE ON FUNCTION consume_stock_fefo TO authenticated;
+
+COMMENT ON TABLE stock_lots IS 'Production lots per warehouse with expiry; a sub-balance of warehouse_stock';
+COMMENT ON FUNCTION consume_stock_fefo IS 'Takes stock out of a warehouse first-expiry-first-out across lots, then unlotted stock';
+
+COMMIT;
@@ -0,0 +1,134 @@
+-- Migration 28: Reorder points and low-stock alerts
+-- A product with a reorder point is low when its total stock is at or below
+-- it. evaluate_low_stock() opens one alert per dip and notifies admin and
+-- warehouse users; the alert is resolved once stock recovers, so the next
+-- dip raises a new one. The API runs it after stock changes and on a schedule.
+
+BEGIN;
+
+ALTER TABLE products
+  ADD COLUMN IF NOT EXISTS reorder_point INTEGER CHECK (reorder_point >= 0);
+
+COMMENT ON COLUMN products.reorder_point IS 'Alert when stock falls to or below this many base units; NULL disables alerts';
+
+-- ============================================================================
+-- ALERTS
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS low_stock_alerts (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  stock INTEGER NOT NULL,
+  reorder_point INTEGER NOT NULL,
+  raised_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  resolved_at TIMESTAMPTZ
+);
+
+-- At most one open alert per product
+CREATE UNIQUE INDEX IF NOT EXISTS idx_low_stock_alerts_open
+  ON low_stock_alerts(product_id) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_low_stock_alerts_raised ON low_stock_alerts(raised_at DESC);
+
+-- ============================================================================
+-- EVALUATOR
+-- ============================================================================
+-- Evaluates the given products (all when NULL) and returns the alerts it
+-- raised. Safe to run concurrently: the open-alert index makes a second
+-- raise for the same dip a no-op.
+CREATE OR REPLACE FUNCTION evaluate_low_stock(p_product_ids BIGINT[] DEFAULT NULL)
+RETURNS SETOF low_stock_alerts
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  p RECORD;
+  alert low_stock_alerts;
+BEGIN
+  -- Resolve alerts whose product recovered, was deleted or stopped alerting
+  UPDATE low_stock_alerts a
+  SET resolved_at = NOW()
+  FROM products pr
+  WHERE a.product_id = pr.id
+    AND a.resolved_at IS NULL
+    AND (p_product_ids IS NULL OR pr.id = ANY(p_product_ids))
+    AND (pr.reorder_point IS NULL OR pr.stock > pr.reorder_point OR pr.deleted_at IS NOT NULL);
+
+  FOR p IN
+    SELECT pr.id, pr.code, pr.name, pr.stock, pr.reorder_point, pr.unit
+    FROM products pr
+    WHERE pr.reorder_point IS NOT NULL
+      AND pr.stock <= pr.reorder_point
+      AND pr.deleted_at IS NULL
+      AND (p_product_ids IS NULL OR pr.id = ANY(p_product_ids))
+      AND NOT EXISTS (
+        SELECT 1 FROM low_stock_alerts a
+        WHERE a.product_id = pr.id AND a.resolved_at IS NULL
+      )
+  LOOP
+    INSERT INTO low_stock_alerts (product_id, stock, reorder_point)
+    VALUES (p.id, p.stock, p.reorder_point)
+    ON CONFLICT DO NOTHING
+    RETURNING * INTO alert;
+
+    CONTINUE WHEN alert.id IS NULL;
+
+    INSERT INTO notifications (user_id, type, title, message, data)
+    SELECT pf.id,
+           'low_stock',
+           'Sắp hết hàng: ' || p.name,
+           format('Sản phẩm %s (%s) còn %s %s, dưới điểm đặt hàng lại %s.',
+                  p.name, p.code, p.stock, COALESCE(p.unit, 'kg'), p.reorder_point),
+           jsonb_build_object(
+             'product_id', p.id,
+             'alert_id', alert.id,
+             'stock', p.stock,
+             'reorder_point', p.reorder_point
+           )
+    FROM profiles pf
+    WHERE pf.role IN ('admin', 'warehouse')
+      AND pf.notifications_enabled IS DISTINCT FROM FALSE;
+
+    RETURN NEXT alert;
+  END LOOP;
+END;
+$$;
+
+-- ============================================================================
+-- LOW-STOCK VIEW
+-- ============================================================================
+CREATE OR REPLACE VIEW low_stock_products
+WITH (security_invoker = true)
+AS
+SELECT
+  p.id,
+  p.code,
+  p.name,
+  p.unit,
+  p.stock,
+  p.reorder_point,
+  p.reorder_point - p.stock AS shortfall,
+  a.raised_at AS alert_raised_at
+FROM products p
+LEFT JOIN low_stock_alerts a ON a.product_id = p.id AND a.resolved_at IS NULL
+WHERE p.reorder_point IS NOT NULL
+  AND p.stock <= p.reorder_point
+  AND p.deleted_at IS NULL;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+ALTER TABLE low_stock_alerts ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY "low_stock_alerts_staff_select" ON low_stock_alerts
+  FOR SELECT TO authenticated
+  USING (is_admin_or_sale_admin() OR is_warehouse());
+
+-- Alerts are only written by evaluate_low_stock() (SECURITY DEFINER)
+REVOKE INSERT, UPDATE, DELETE ON low_stock_alerts FROM authenticated, anon;
+GRANT SELECT ON low_stock_products TO authenticated;
+GRANT EXECUTE ON FUNCTION evaluate_low_stock TO authenticated;
+
+COMMENT ON TABLE low_stock_alerts IS 'One row per low-stock dip; open while resolved_at IS NULL';
+COMMENT ON FUNCTION evaluate_low_stock IS 'Raises and resolves low-stock alerts and notifies admin and warehouse users';
+
+COMMIT;
@@ -0,0 +1,298 @@
+-- Migration 29: Physical stock counts (stocktakes)
+-- A stocktake freezes the expected quantity of every product in a warehouse.
+-- Counters submit quantities per product and location (a shelf, an aisle);
+-- a counter's later count for the same product and location replaces the
+-- earlier one and the line total is the sum over counters and locations.
+-- Approval posts 'count_correction' adjustments for the difference between
+-- the counted quantity and the expected quantity, corrected for stock that
+-- moved after the snapshot.
+
+BEGIN;
+
+-- Scannable product barcode (EAN-13 or any code printed on the bag)
+ALTER TABLE products
+  ADD COLUMN IF NOT EXISTS barcode VARCHAR(32);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_barcode ON products(barcode) WHERE barcode IS NOT NULL;
+
+-- ============================================================================
+-- STOCKTAKES
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS stocktakes (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  warehouse_id UUID NOT NULL REFERENCES warehouses(id) ON DELETE RESTRICT,
+  status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'approved', 'cancelled')),
+  note TEXT,
+  snapshot_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  created_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  approved_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  approved_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One open stocktake per warehouse
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stocktakes_open ON stocktakes(warehouse_id) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS idx_stocktakes_created ON stocktakes(created_at DESC);
+
+CREATE TABLE IF NOT EXISTS stocktake_lines (
+  stocktake_id UUID NOT NULL REFERENCES stocktakes(id) ON DELETE CASCADE,
+  product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+  expected_quantity INTEGER NOT NULL,
+  counted_quantity INTEGER, -- NULL until counted
+  adjusted_quantity INTEGER, -- posted on approval
+  PRIMARY KEY (stocktake_id, product_id)
+);
+
+CREATE TABLE IF NOT EXISTS stocktake_counts (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  stocktake_id UUID NOT NULL REFERENCES stocktakes(id) ON DELETE CASCADE,
+  product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+  location VARCHAR(50) NOT NULL DEFAULT '',
+  quantity INTEGER NOT NULL CHECK (quantity >= 0),
+  source VARCHAR(20) NOT NULL DEFAULT 'manual' CHECK (source IN ('manual', 'barcode')),
+  counted_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  counted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (stocktake_id, product_id, counted_by, location)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stocktake_counts_line ON stocktake_counts(stocktake_id, product_id);
+
+-- ============================================================================
+-- WORKFLOW
+-- ============================================================================
+-- Opens a stocktake with the expected quantity of every active product
+CREATE OR REPLACE FUNCTION start_stocktake(
+  p_warehouse_id UUID,
+  p_note TEXT DEFAULT NULL,
+  p_user_id UUID DEFAULT NULL
+)
+RETURNS stocktakes
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  s stocktakes;
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM warehouses WHERE id = p_warehouse_id) THEN
+    RAISE EXCEPTION 'warehouse not found';
+  END IF;
+  IF EXISTS (SELECT 1 FROM stocktakes WHERE warehouse_id = p_warehouse_id AND status = 'open') THEN
+    RAISE EXCEPTION 'stocktake already open for warehouse %', p_warehouse_id;
+  END IF;
+
+  -- Block postings to the warehouse while the snapshot is taken
+  PERFORM 1 FROM warehouse_stock WHERE warehouse_id = p_warehouse_id FOR SHARE;
+
+  INSERT INTO stocktakes (warehouse_id, note, created_by)
+  VALUES (p_warehouse_id, p_note, COALESCE(p_user_id, auth.uid()))
+  RETURNING * INTO s;
+
+  INSERT INTO stocktake_lines (stocktake_id, product_id, expected_quantity)
+  SELECT s.id, p.id, COALESCE(ws.quantity, 0)
+  FROM products p
+  LEFT JOIN warehouse_stock ws ON ws.product_id = p.id AND ws.warehouse_id = p_warehouse_id
+  WHERE p.deleted_at IS NULL OR COALESCE(ws.quantity, 0) <> 0;
+
+  RETURN s;
+END;
+$$;
+
+-- Records one counter's quantity for a product and location and returns the
+-- updated line. Products created after the snapshot are added with an
+-- expected quantity of 0.
+CREATE OR REPLACE FUNCTION record_stocktake_count(
+  p_stocktake_id UUID,
+  p_product_id BIGINT,
+  p_quantity INTEGER,
+  p_location VARCHAR DEFAULT '',
+  p_source VARCHAR DEFAULT 'manual',
+  p_user_id UUID DEFAULT NULL
+)
+RETURNS stocktake_lines
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  s stocktakes;
+  counter UUID := COALESCE(p_user_id, auth.uid());
+  line stocktake_lines;
+BEGIN
+  SELECT * INTO s FROM stocktakes WHERE id = p_stocktake_id;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'stocktake % not found', p_stocktake_id;
+  END IF;
+  IF s.status <> 'open' THEN
+    RAISE EXCEPTION 'invalid stocktake status: % is not open', s.status;
+  END IF;
+  IF NOT EXISTS (SELECT 1 FROM products WHERE id = p_product_id) THEN
+    RAISE EXCEPTION 'product % not found', p_product_id;
+  END IF;
+
+  INSERT INTO stocktake_lines (stocktake_id, product_id, expected_quantity)
+  VALUES (p_stocktake_id, p_product_id, 0)
+  ON CONFLICT DO NOTHING;
+
+  INSERT INTO stocktake_counts (stocktake_id, product_id, location, quantity, source, counted_by)
+  VALUES (p_stocktake_id, p_product_id, COALESCE(p_location, ''), p_quantity, p_source, counter)
+  ON CONFLICT (stocktake_id, product_id, counted_by, location)
+  DO UPDATE SET quantity = EXCLUDED.quantity, source = EXCLUDED.source, counted_at = NOW();
+
+  UPDATE stocktake_lines
+  SET counted_quantity = (
+    SELECT SUM(quantity) FROM stocktake_counts
+    WHERE stocktake_id = p_stocktake_id AND product_id = p_product_id
+  )
+  WHERE stocktake_id = p_stocktake_id AND product_id = p_product_id
+  RETURNING * INTO line;
+
+  RETURN line;
+END;
+$$;
+
+-- Posts the variances of every counted line and closes the stocktake.
+-- Uncounted lines are left untouched.
+CREATE OR REPLACE FUNCTION approve_stocktake(p_stocktake_id UUID, p_user_id UUID DEFAULT NULL)
+RETURNS stocktakes
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  s stocktakes;
+  line RECORD;
+  moved INTEGER;
+  delta INTEGER;
+BEGIN
+  -- Direct callers must be managers; the API calls with the service role
+  IF auth.uid() IS NOT NULL AND NOT is_admin_or_sale_admin() THEN
+    RAISE EXCEPTION 'permission denied: only admin or sale_admin can approve a stocktake';
+  END IF;
+
+  SELECT * INTO s FROM stocktakes WHERE id = p_stocktake_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'stocktake % not found', p_stocktake_id;
+  END IF;
+  IF s.status <> 'open' THEN
+    RAISE EXCEPTION 'invalid stocktake status: % cannot be approved', s.status;
+  END IF;
+
+  FOR line IN
+    SELECT product_id, expected_quantity, counted_quantity
+    FROM stocktake_lines
+    WHERE stocktake_id = s.id AND counted_quantity IS NOT NULL
+  LOOP
+    SELECT COALESCE(SUM(quantity), 0) INTO moved
+    FROM stock_movements
+    WHERE product_id = line.product_id
+      AND warehouse_id = s.warehouse_id
+      AND created_at > s.snapshot_at;
+
+    delta := line.counted_quantity - (line.expected_quantity + moved);
+
+    IF delta > 0 THEN
+      PERFORM post_stock_movement(
+        line.product_id, 'adjustment', delta, 'count_correction',
+        'stocktake', s.id::TEXT, NULL, p_user_id, s.warehouse_id
+      );
+    ELSIF delta < 0 THEN
+      PERFORM consume_stock_fefo(
+        line.product_id, 'adjustment', -delta, 'count_correction',
+        'stocktake', s.id::TEXT, NULL, p_user_id, s.warehouse_id, TRUE
+      );
+    END IF;
+
+    UPDATE stocktake_lines
+    SET adjusted_quantity = delta
+    WHERE stocktake_id = s.id AND product_id = line.product_id;
+  END LOOP;
+
+  UPDATE stocktakes
+  SET status = 'approved', approved_at = NOW(), approved_by = COALESCE(p_user_id, auth.uid())
+  WHERE id = s.id
+  RETURNING * INTO s;
+
+  RETURN s;
+END;
+$$;
+
+-- ============================================================================
+-- SHRINKAGE REPORT
+-- ============================================================================
+-- Losses and gains from count corrections, damage, expiry and loss, per
+-- period ('day', 'week', 'month', 'quarter', 'year'), product and reason
+CREATE OR REPLACE FUNCTION shrinkage_report(
+  p_from TIMESTAMPTZ,
+  p_to TIMESTAMPTZ,
+  p_period TEXT DEFAULT 'month',
+  p_warehouse_ids UUID[] DEFAULT NULL
+)
+RETURNS TABLE (
+  period DATE,
+  product_id BIGINT,
+  product_code TEXT,
+  product_name TEXT,
+  reason_code TEXT,
+  lost BIGINT,
+  found BIGINT
+)
+LANGUAGE sql
+STABLE
+SET search_path = public
+AS $$
+  SELECT
+    date_trunc(p_period, m.created_at)::DATE,
+    m.product_id,
+    p.code::TEXT,
+    p.name::TEXT,
+    m.reason_code::TEXT,
+    SUM(GREATEST(-m.quantity, 0)),
+    SUM(GREATEST(m.quantity, 0))
+  FROM stock_movements m
+  JOIN products p ON p.id = m.product_id
+  WHERE m.movement_type = 'adjustment'
+    AND m.reason_code IN ('count_correction', 'damaged', 'expired', 'lost')
+    AND m.created_at >= p_from
+    AND m.created_at < p_to
+    AND (p_warehouse_ids IS NULL OR m.warehouse_id = ANY(p_warehouse_ids))
+  GROUP BY 1, 2, 3, 4, 5
+  ORDER BY 1, 2, 5;
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+ALTER TABLE stocktakes ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stocktake_lines ENABLE ROW LEVEL SECURITY;
+ALTER TABLE stocktake_counts ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY "stocktakes_select" ON stocktakes
+  FOR SELECT TO authenticated
+  USING (is_admin_or_sale_admin() OR is_assigned_warehouse(warehouse_id));
+
+CREATE POLICY "stocktake_lines_select" ON stocktake_lines
+  FOR SELECT TO authenticated
+  USING (EXISTS (SELECT 1 FROM stocktakes s WHERE s.id = stocktake_id));
+
+CREATE POLICY "stocktake_counts_select" ON stocktake_counts
+  FOR SELECT TO authenticated
+  USING (EXISTS (SELECT 1 FROM stocktakes s WHERE s.id = stocktake_id));
+
+-- Cancelling an open stocktake is the only direct write
+CREATE POLICY "stocktakes_cancel" ON stocktakes
+  FOR UPDATE TO authenticated
+  USING (status = 'open' AND (is_admin_or_sale_admin() OR is_assigned_warehouse(warehouse_id)))
+  WITH CHECK (status = 'cancelled');
+
+REVOKE INSERT, DELETE ON stocktakes FROM authenticated, anon;
+REVOKE INSERT, UPDATE, DELETE ON stocktake_lines, stocktake_counts FROM authenticated, anon;
+GRANT EXECUTE ON FUNCTION start_stocktake TO authenticated;
+GRANT EXECUTE ON FUNCTION record_stocktake_count TO authenticated;
+GRANT EXECUTE ON FUNCTION approve_stocktake TO authenticated;
+GRANT EXECUTE ON FUNCTION shrinkage_report TO authenticated;
+
+COMMENT ON TABLE stocktakes IS 'Physical stock counts of a warehouse (open -> approved | cancelled)';
+COMMENT ON COLUMN stocktake_lines.adjusted_quantity IS 'Adjustment posted on approval: counted - (expected + movements after the snapshot)';
+
+COMMIT;
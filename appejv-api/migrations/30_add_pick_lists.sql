@@ -0,0 +1,175 @@
+-- Migration 30: Pick lists, packing slips and pick confirmation
+-- Orders gain a delivery route and date so the warehouse can group the day's
+-- picking. Before an order leaves 'ordered' for 'shipping' the warehouse
+-- confirms the base quantities actually picked for every line; a short pick
+-- blocks shipping until the order is re-picked or its lines are changed.
+-- Changing the lines or the fulfilment warehouse discards the confirmation.
+
+BEGIN;
+
+ALTER TABLE orders
+  ADD COLUMN IF NOT EXISTS route VARCHAR(50),
+  ADD COLUMN IF NOT EXISTS delivery_date DATE;
+
+CREATE INDEX IF NOT EXISTS idx_orders_picking ON orders(status, warehouse_id, delivery_date)
+  WHERE deleted_at IS NULL;
+
+-- ============================================================================
+-- PICK CONFIRMATIONS
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS order_picks (
+  order_id UUID PRIMARY KEY REFERENCES orders(id) ON DELETE CASCADE,
+  status VARCHAR(20) NOT NULL CHECK (status IN ('picked', 'short')),
+  note TEXT,
+  picked_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  picked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS order_pick_items (
+  order_id UUID NOT NULL REFERENCES order_picks(order_id) ON DELETE CASCADE,
+  order_item_id UUID NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+  product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+  ordered_quantity INTEGER NOT NULL,
+  picked_quantity INTEGER NOT NULL CHECK (picked_quantity >= 0),
+  PRIMARY KEY (order_id, order_item_id)
+);
+
+-- Records the picked base quantity of every line of an 'ordered' order.
+-- p_lines is a JSON array of {order_item_id, picked_quantity}; every line
+-- must be present and no line may be over-picked.
+CREATE OR REPLACE FUNCTION confirm_order_pick(
+  p_order_id UUID,
+  p_lines JSONB,
+  p_note TEXT DEFAULT NULL,
+  p_user_id UUID DEFAULT NULL
+)
+RETURNS order_picks
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  o orders;
+  pick order_picks;
+  item RECORD;
+  picked INTEGER;
+  short BOOLEAN := FALSE;
+BEGIN
+  SELECT * INTO o FROM orders WHERE id = p_order_id AND deleted_at IS NULL FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'order % not found', p_order_id;
+  END IF;
+  IF o.status <> 'ordered' THEN
+    RAISE EXCEPTION 'invalid order status: % orders cannot be picked', o.status;
+  END IF;
+  IF auth.uid() IS NOT NULL AND NOT is_admin_or_sale_admin() AND NOT is_assigned_warehouse(
+    COALESCE(o.warehouse_id, (SELECT id FROM warehouses WHERE is_default))
+  ) THEN
+    RAISE EXCEPTION 'permission denied: not assigned to the fulfilment warehouse';
+  END IF;
+
+  DELETE FROM order_picks WHERE order_id = p_order_id;
+  INSERT INTO order_picks (order_id, status, note, picked_by)
+  VALUES (p_order_id, 'picked', p_note, COALESCE(p_user_id, auth.uid()));
+
+  FOR item IN
+    SELECT id, product_id, COALESCE(base_quantity, quantity::INTEGER) AS qty
+    FROM order_items WHERE order_id = p_order_id
+  LOOP
+    SELECT (l->>'picked_quantity')::INTEGER INTO picked
+    FROM jsonb_array_elements(p_lines) l
+    WHERE l->>'order_item_id' = item.id::TEXT
+    LIMIT 1;
+
+    IF picked IS NULL THEN
+      RAISE EXCEPTION 'invalid pick: line % is missing', item.id;
+    END IF;
+    IF picked < 0 OR picked > item.qty THEN
+      RAISE EXCEPTION 'invalid pick: line % picked % of %', item.id, picked, item.qty;
+    END IF;
+
+    INSERT INTO order_pick_items (order_id, order_item_id, product_id, ordered_quantity, picked_quantity)
+    VALUES (p_order_id, item.id, item.product_id, item.qty, picked);
+    short := short OR picked < item.qty;
+  END LOOP;
+
+  UPDATE order_picks
+  SET status = CASE WHEN short THEN 'short' ELSE 'picked' END
+  WHERE order_id = p_order_id
+  RETURNING * INTO pick;
+
+  RETURN pick;
+END;
+$$;
+
+-- ============================================================================
+-- SHIPPING REQUIRES A COMPLETE PICK
+-- ============================================================================
+CREATE OR REPLACE FUNCTION require_order_pick()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+BEGIN
+  IF OLD.status IN ('draft', 'ordered') AND NEW.status = 'shipping'
+     AND NOT EXISTS (SELECT 1 FROM order_picks WHERE order_id = NEW.id AND status = 'picked') THEN
+    RAISE EXCEPTION 'order % has not been picked in full', NEW.id;
+  END IF;
+
+  IF NEW.warehouse_id IS DISTINCT FROM OLD.warehouse_id THEN
+    DELETE FROM order_picks WHERE order_id = NEW.id;
+  END IF;
+
+  RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS order_require_pick_trigger ON orders;
+CREATE TRIGGER order_require_pick_trigger
+  BEFORE UPDATE OF status, warehouse_id ON orders
+  FOR EACH ROW
+  EXECUTE FUNCTION require_order_pick();
+
+CREATE OR REPLACE FUNCTION discard_order_pick()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+BEGIN
+  DELETE FROM order_picks
+  WHERE order_id = COALESCE(NEW.order_id, OLD.order_id)
+    AND EXISTS (SELECT 1 FROM orders WHERE id = order_picks.order_id AND status = 'ordered');
+  RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS order_items_discard_pick_trigger ON order_items;
+CREATE TRIGGER order_items_discard_pick_trigger
+  AFTER INSERT OR UPDATE OF product_id, quantity, base_quantity OR DELETE ON order_items
+  FOR EACH ROW
+  EXECUTE FUNCTION discard_order_pick();
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+ALTER TABLE order_picks ENABLE ROW LEVEL SECURITY;
+ALTER TABLE order_pick_items ENABLE ROW LEVEL SECURITY;
+
+-- Visible to whoever can see the order
+CREATE POLICY "order_picks_select" ON order_picks
+  FOR SELECT TO authenticated
+  USING (EXISTS (SELECT 1 FROM orders o WHERE o.id = order_id));
+
+CREATE POLICY "order_pick_items_select" ON order_pick_items
+  FOR SELECT TO authenticated
+  USING (EXISTS (SELECT 1 FROM orders o WHERE o.id = order_id));
+
+REVOKE INSERT, UPDATE, DELETE ON order_picks, order_pick_items FROM authenticated, anon;
+GRANT EXECUTE ON FUNCTION confirm_order_pick TO authenticated;
+
+COMMENT ON TABLE order_picks IS 'Warehouse pick confirmation of an order; status picked is required to ship';
+COMMENT ON COLUMN order_pick_items.picked_quantity IS 'Base-unit quantity actually picked';
+
+COMMIT;
@@ -0,0 +1,100 @@
+-- Migration 31: Token-based password reset
+-- Replaces temporary passwords returned by the API. A reset request stores
+-- the SHA-256 hash of a random token; the token itself only travels in the
+-- emailed link. Tokens expire, are single use, and a new request voids the
+-- user's earlier ones. Only the service role may touch the table.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+  token_hash TEXT NOT NULL UNIQUE,
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ,
+  requested_ip TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user
+  ON password_reset_tokens(user_id) WHERE used_at IS NULL;
+
+-- Stores a token for the account with p_email and returns who it is for.
+-- Returns no row when no account has that email, so the caller can answer
+-- the same either way.
+CREATE OR REPLACE FUNCTION create_password_reset(
+  p_email TEXT,
+  p_token_hash TEXT,
+  p_ttl_seconds INTEGER,
+  p_ip TEXT DEFAULT NULL
+)
+RETURNS TABLE (user_id UUID, email TEXT, full_name TEXT)
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  v_user auth.users%ROWTYPE;
+BEGIN
+  SELECT * INTO v_user
+  FROM auth.users u
+  WHERE lower(u.email) = lower(trim(p_email))
+    AND u.deleted_at IS NULL
+  LIMIT 1;
+
+  IF NOT FOUND THEN
+    RETURN;
+  END IF;
+
+  -- Void earlier links
+  UPDATE password_reset_tokens t
+  SET used_at = NOW()
+  WHERE t.user_id = v_user.id AND t.used_at IS NULL;
+
+  INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, requested_ip)
+  VALUES (v_user.id, p_token_hash, NOW() + make_interval(secs => p_ttl_seconds), p_ip);
+
+  RETURN QUERY
+  SELECT v_user.id, v_user.email::TEXT, p.full_name::TEXT
+  FROM (SELECT 1) one
+  LEFT JOIN profiles p ON p.id = v_user.id;
+END;
+$$;
+
+-- Marks the token used and returns its user. Returns no row for unknown,
+-- expired or already used tokens.
+CREATE OR REPLACE FUNCTION consume_password_reset(p_token_hash TEXT)
+RETURNS UUID
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  v_user_id UUID;
+BEGIN
+  UPDATE password_reset_tokens
+  SET used_at = NOW()
+  WHERE token_hash = p_token_hash
+    AND used_at IS NULL
+    AND expires_at > NOW()
+  RETURNING user_id INTO v_user_id;
+
+  RETURN v_user_id;
+END;
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+-- No policies: only the service role (which bypasses RLS) reads the table
+ALTER TABLE password_reset_tokens ENABLE ROW LEVEL SECURITY;
+
+REVOKE ALL ON password_reset_tokens FROM authenticated, anon;
+REVOKE EXECUTE ON FUNCTION create_password_reset FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION consume_password_reset FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION create_password_reset TO service_role;
+GRANT EXECUTE ON FUNCTION consume_password_reset TO service_role;
+
+COMMENT ON TABLE password_reset_tokens IS 'Hashed single-use password reset tokens';
+
+COMMIT;
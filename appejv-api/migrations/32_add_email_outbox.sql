@@ -0,0 +1,194 @@
+-- Migration 32: Transactional email outbox
+-- Emails are queued as rows naming a template, a locale and the template's
+-- data; the API's worker renders and sends them, retrying with backoff, so
+-- a mail server outage never fails the request or transaction that queued
+-- the email. Order status changes queue the order confirmation (-> ordered)
+-- and shipment dispatched (-> shipping) emails to the customer.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS email_outbox (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  to_address TEXT NOT NULL,
+  template VARCHAR(50) NOT NULL,
+  locale VARCHAR(5) NOT NULL DEFAULT 'vi',
+  data JSONB NOT NULL DEFAULT '{}'::jsonb,
+  -- Sensitive data (reset links) is erased once the email is sent or given up
+  sensitive BOOLEAN NOT NULL DEFAULT FALSE,
+  status VARCHAR(20) NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'sending', 'sent', 'failed')),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  max_attempts INTEGER NOT NULL DEFAULT 8,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_error TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_outbox_due
+  ON email_outbox(next_attempt_at) WHERE status IN ('pending', 'sending');
+CREATE INDEX IF NOT EXISTS idx_email_outbox_created ON email_outbox(created_at DESC);
+
+-- ============================================================================
+-- QUEUE FUNCTIONS (service role)
+-- ============================================================================
+CREATE OR REPLACE FUNCTION enqueue_email(
+  p_to TEXT,
+  p_template TEXT,
+  p_locale TEXT DEFAULT 'vi',
+  p_data JSONB DEFAULT '{}'::jsonb,
+  p_sensitive BOOLEAN DEFAULT FALSE
+)
+RETURNS UUID
+LANGUAGE sql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+  INSERT INTO email_outbox (to_address, template, locale, data, sensitive)
+  VALUES (p_to, p_template, COALESCE(p_locale, 'vi'), COALESCE(p_data, '{}'::jsonb), p_sensitive)
+  RETURNING id;
+$$;
+
+-- Leases up to p_limit due emails to a worker. An email whose lease ran out
+-- (the worker died mid-send) becomes due again.
+CREATE OR REPLACE FUNCTION claim_email_outbox(p_limit INTEGER, p_lease_seconds INTEGER)
+RETURNS SETOF email_outbox
+LANGUAGE sql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+  UPDATE email_outbox e
+  SET status = 'sending',
+      attempts = e.attempts + 1,
+      next_attempt_at = NOW() + make_interval(secs => p_lease_seconds)
+  WHERE e.id IN (
+    SELECT id FROM email_outbox
+    WHERE status IN ('pending', 'sending')
+      AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT p_limit
+    FOR UPDATE SKIP LOCKED
+  )
+  RETURNING e.*;
+$$;
+
+-- Records the outcome of a send. With an error the email is retried after
+-- p_retry_seconds until it runs out of attempts.
+CREATE OR REPLACE FUNCTION complete_email(p_id UUID, p_error TEXT DEFAULT NULL, p_retry_seconds INTEGER DEFAULT 60)
+RETURNS VOID
+LANGUAGE sql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+  UPDATE email_outbox
+  SET status = CASE
+        WHEN p_error IS NULL THEN 'sent'
+        WHEN attempts >= max_attempts THEN 'failed'
+        ELSE 'pending'
+      END,
+      sent_at = CASE WHEN p_error IS NULL THEN NOW() END,
+      last_error = p_error,
+      next_attempt_at = NOW() + make_interval(secs => p_retry_seconds),
+      data = CASE
+        WHEN sensitive AND (p_error IS NULL OR attempts >= max_attempts) THEN '{}'::jsonb
+        ELSE data
+      END
+  WHERE id = p_id AND status = 'sending';
+$$;
+
+-- ============================================================================
+-- ORDER EMAILS
+-- ============================================================================
+CREATE OR REPLACE FUNCTION order_email_data(p_order_id UUID)
+RETURNS JSONB
+LANGUAGE sql
+STABLE
+SECURITY DEFINER
+SET search_path = public
+AS $$
+  SELECT jsonb_build_object(
+    'order_id', o.id,
+    'reference', upper(left(o.id::text, 8)),
+    'customer_name', c.full_name,
+    'total_amount', o.total_amount,
+    'route', o.route,
+    'delivery_date', o.delivery_date,
+    'notes', o.notes,
+    'items', COALESCE((
+      SELECT jsonb_agg(jsonb_build_object(
+        'product_code', p.code,
+        'product_name', p.name,
+        'quantity', oi.quantity,
+        'unit', COALESCE(oi.unit, p.unit),
+        'price', oi.price_at_order
+      ) ORDER BY p.name)
+      FROM order_items oi
+      JOIN products p ON p.id = oi.product_id
+      WHERE oi.order_id = o.id
+    ), '[]'::jsonb)
+  )
+  FROM orders o
+  LEFT JOIN customers c ON c.id = o.customer_id
+  WHERE o.id = p_order_id;
+$$;
+
+CREATE OR REPLACE FUNCTION queue_order_status_email()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  v_email TEXT;
+  v_template TEXT;
+BEGIN
+  IF NEW.status IS NOT DISTINCT FROM OLD.status THEN
+    RETURN NEW;
+  END IF;
+
+  v_template := CASE NEW.status
+    WHEN 'ordered' THEN 'order_confirmation'
+    WHEN 'shipping' THEN 'shipment_dispatched'
+  END;
+  IF v_template IS NULL OR NEW.customer_id IS NULL THEN
+    RETURN NEW;
+  END IF;
+
+  SELECT email INTO v_email FROM customers WHERE id = NEW.customer_id;
+  -- Placeholder addresses from migration 10 have no mailbox
+  IF v_email IS NULL OR v_email LIKE '%@customer.local' THEN
+    RETURN NEW;
+  END IF;
+
+  INSERT INTO email_outbox (to_address, template, data)
+  VALUES (v_email, v_template, order_email_data(NEW.id));
+
+  RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS trigger_queue_order_status_email ON orders;
+CREATE TRIGGER trigger_queue_order_status_email
+  AFTER UPDATE OF status ON orders
+  FOR EACH ROW
+  EXECUTE FUNCTION queue_order_status_email();
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+-- No policies: only the service role (which bypasses RLS) reads the outbox
+ALTER TABLE email_outbox ENABLE ROW LEVEL SECURITY;
+
+REVOKE ALL ON email_outbox FROM authenticated, anon;
+REVOKE EXECUTE ON FUNCTION enqueue_email FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION claim_email_outbox FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION complete_email FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION order_email_data FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION enqueue_email TO service_role;
+GRANT EXECUTE ON FUNCTION claim_email_outbox TO service_role;
+GRANT EXECUTE ON FUNCTION complete_email TO service_role;
+
+COMMENT ON TABLE email_outbox IS 'Queued transactional emails, rendered and sent by the API worker';
+COMMENT ON COLUMN email_outbox.template IS 'password_reset, order_confirmation or shipment_dispatched';
+
+COMMIT;
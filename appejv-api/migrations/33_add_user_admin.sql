@@ -0,0 +1,143 @@
+-- Migration 33: User administration
+-- Staff and customer accounts are created, invited, re-roled, deactivated
+-- and reactivated through /api/v1/admin/users (or create-user.sh) instead
+-- of the Supabase dashboard and hand-written SQL. A deactivated profile
+-- keeps its data and history; the API bans its auth account and refuses
+-- its tokens.
+-- Every change is recorded in audit_logs, which gains the columns the API
+-- writes.
+
+BEGIN;
+
+ALTER TABLE profiles
+  ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMPTZ;
+
+-- ============================================================================
+-- AUDIT LOG
+-- ============================================================================
+-- The table predates the migrations on existing projects; create it where it
+-- is missing and add the columns the API records
+CREATE TABLE IF NOT EXISTS audit_logs (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  action VARCHAR(50) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE audit_logs
+  ADD COLUMN IF NOT EXISTS actor_id UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  ADD COLUMN IF NOT EXISTS actor_role VARCHAR(20),
+  ADD COLUMN IF NOT EXISTS entity_type VARCHAR(50),
+  ADD COLUMN IF NOT EXISTS entity_id TEXT,
+  ADD COLUMN IF NOT EXISTS changes JSONB,
+  ADD COLUMN IF NOT EXISTS ip_address TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created ON audit_logs(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_entity ON audit_logs(entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor ON audit_logs(actor_id);
+
+-- ============================================================================
+-- USER DIRECTORY (service role)
+-- ============================================================================
+-- Profiles with their login email, active team and last sign-in. p_manager_id
+-- limits the list to the manager and the users reporting to them; p_id
+-- returns a single user.
+CREATE OR REPLACE FUNCTION admin_list_users(
+  p_id UUID DEFAULT NULL,
+  p_role TEXT DEFAULT NULL,
+  p_search TEXT DEFAULT NULL,
+  p_manager_id UUID DEFAULT NULL,
+  p_include_deactivated BOOLEAN DEFAULT FALSE,
+  p_limit INTEGER DEFAULT 50,
+  p_offset INTEGER DEFAULT 0
+)
+RETURNS TABLE (
+  id UUID,
+  email TEXT,
+  full_name TEXT,
+  role TEXT,
+  phone TEXT,
+  manager_id UUID,
+  team_id UUID,
+  deactivated_at TIMESTAMPTZ,
+  last_sign_in_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ
+)
+LANGUAGE sql
+STABLE
+SECURITY DEFINER
+SET search_path = public
+AS $$
+  SELECT
+    p.id,
+    u.email::TEXT,
+    p.full_name::TEXT,
+    p.role::TEXT,
+    p.phone::TEXT,
+    p.manager_id,
+    (
+      SELECT tm.team_id FROM team_members tm
+      WHERE tm.sale_id = p.id AND tm.status = 'active'
+      ORDER BY tm.joined_at DESC
+      LIMIT 1
+    ),
+    p.deactivated_at,
+    u.last_sign_in_at,
+    p.created_at
+  FROM profiles p
+  LEFT JOIN auth.users u ON u.id = p.id
+  WHERE p.deleted_at IS NULL
+    AND (p_id IS NULL OR p.id = p_id)
+    AND (p_role IS NULL OR p.role::TEXT = p_role)
+    AND (p_manager_id IS NULL OR p.id = p_manager_id OR p.manager_id = p_manager_id)
+    AND (p_include_deactivated OR p.deactivated_at IS NULL)
+    AND (
+      p_search IS NULL
+      OR p.full_name ILIKE '%' || p_search || '%'
+      OR u.email ILIKE '%' || p_search || '%'
+      OR p.phone ILIKE '%' || p_search || '%'
+    )
+  ORDER BY p.created_at DESC
+  LIMIT p_limit OFFSET p_offset;
+$$;
+
+-- Makes p_team_id the sale's only active team, or removes them from every
+-- team when it is NULL
+CREATE OR REPLACE FUNCTION set_team_membership(p_sale_id UUID, p_team_id UUID)
+RETURNS VOID
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+BEGIN
+  UPDATE team_members
+  SET status = 'removed'
+  WHERE sale_id = p_sale_id
+    AND status = 'active'
+    AND team_id IS DISTINCT FROM p_team_id;
+
+  IF p_team_id IS NOT NULL THEN
+    INSERT INTO team_members (team_id, sale_id, status)
+    VALUES (p_team_id, p_sale_id, 'active')
+    ON CONFLICT (team_id, sale_id)
+    DO UPDATE SET
+      status = 'active',
+      joined_at = CASE WHEN team_members.status = 'active' THEN team_members.joined_at ELSE NOW() END;
+  END IF;
+END;
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+-- The API writes audit entries with the service role
+ALTER TABLE audit_logs ENABLE ROW LEVEL SECURITY;
+
+REVOKE EXECUTE ON FUNCTION admin_list_users FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION set_team_membership FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION admin_list_users TO service_role;
+GRANT EXECUTE ON FUNCTION set_team_membership TO service_role;
+
+COMMENT ON COLUMN profiles.deactivated_at IS 'Set while the account is deactivated; its tokens are refused';
+COMMENT ON COLUMN audit_logs.changes IS 'Changed fields as {"field": {"from": ..., "to": ...}}';
+
+COMMIT;
@@ -0,0 +1,68 @@
+-- Migration 34: Email invitations
+-- Admins and sale_admins invite staff and dealers instead of setting their
+-- passwords. An invitation carries the role, manager and sales team the
+-- user will get; the invitee receives a single-use, expiring link and sets
+-- their password through POST /auth/accept-invite, which creates the
+-- account and profile. Like password reset tokens, only the SHA-256 hash of
+-- the token is stored. Only the service role touches the table.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS invitations (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  -- Stored lowercased
+  email TEXT NOT NULL,
+  full_name TEXT,
+  phone TEXT,
+  role VARCHAR(20) NOT NULL
+    CHECK (role IN ('admin', 'sale_admin', 'sale', 'warehouse', 'customer')),
+  manager_id UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  team_id UUID REFERENCES sales_teams(id) ON DELETE SET NULL,
+  locale VARCHAR(5) NOT NULL DEFAULT 'vi',
+  token_hash TEXT NOT NULL UNIQUE,
+  -- accepting: the account is being created; back to pending if that fails
+  status VARCHAR(20) NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'accepting', 'accepted', 'revoked')),
+  invited_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  user_id UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  sent_count INTEGER NOT NULL DEFAULT 1,
+  last_sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  accepted_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One open invitation per email
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invitations_open_email
+  ON invitations(email) WHERE status IN ('pending', 'accepting');
+CREATE INDEX IF NOT EXISTS idx_invitations_invited_by ON invitations(invited_by);
+CREATE INDEX IF NOT EXISTS idx_invitations_created ON invitations(created_at DESC);
+
+-- Whether an account already uses the email
+CREATE OR REPLACE FUNCTION email_has_account(p_email TEXT)
+RETURNS BOOLEAN
+LANGUAGE sql
+STABLE
+SECURITY DEFINER
+SET search_path = public
+AS $$
+  SELECT EXISTS (
+    SELECT 1 FROM auth.users
+    WHERE lower(email) = lower(trim(p_email))
+  );
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+-- No policies: only the service role (which bypasses RLS) reads the table
+ALTER TABLE invitations ENABLE ROW LEVEL SECURITY;
+
+REVOKE ALL ON invitations FROM authenticated, anon;
+REVOKE EXECUTE ON FUNCTION email_has_account FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION email_has_account TO service_role;
+
+COMMENT ON TABLE invitations IS 'Pending and past invitations; tokens are stored hashed';
+
+COMMIT;
@@ -0,0 +1,200 @@
+-- Migration 35: Phone number sign-in with one-time codes
+-- Dealers and field staff sign in with the phone number on their profile
+-- (or on the customer record linked to their login) and a 6-digit code sent
+-- by SMS. Only a keyed hash of each code is stored. Codes expire, work once
+-- and are voided after too many wrong guesses; requests are limited per
+-- phone and per IP, counted from this table so every API instance sees the
+-- same limits. Only the service role may touch the table.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS phone_otp_codes (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  phone TEXT NOT NULL,
+  -- NULL when no account has the phone; the request still counts
+  user_id UUID REFERENCES auth.users(id) ON DELETE CASCADE,
+  code_hash TEXT,
+  expires_at TIMESTAMPTZ NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  used_at TIMESTAMPTZ,
+  requested_ip TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_phone_otp_codes_phone ON phone_otp_codes(phone, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_phone_otp_codes_ip ON phone_otp_codes(requested_ip, created_at DESC);
+
+-- Digits only, with a leading 0 replaced by the 84 country code, so
+-- "0912 345 678" and "+84912345678" compare equal
+CREATE OR REPLACE FUNCTION normalize_phone(p_phone TEXT)
+RETURNS TEXT
+LANGUAGE sql
+IMMUTABLE
+AS $$
+  SELECT CASE
+    WHEN digits LIKE '0%' THEN '84' || substr(digits, 2)
+    ELSE digits
+  END
+  FROM (SELECT regexp_replace(coalesce(p_phone, ''), '\D', '', 'g') AS digits) d;
+$$;
+
+-- Stores a code for the active account with p_phone (normalized) unless a
+-- limit is hit. Returns the user and the seconds to wait: retry_after > 0
+-- means the request was refused; a NULL user_id with retry_after 0 means no
+-- account has the phone. Within an hour a phone may ask p_phone_limit
+-- times and an IP p_ip_limit times, and a phone must wait p_cooldown_seconds
+-- between codes. A new code voids the phone's earlier ones.
+CREATE OR REPLACE FUNCTION create_phone_otp(
+  p_phone TEXT,
+  p_code_hash TEXT,
+  p_ttl_seconds INTEGER,
+  p_ip TEXT,
+  p_phone_limit INTEGER,
+  p_ip_limit INTEGER,
+  p_cooldown_seconds INTEGER
+)
+RETURNS TABLE (user_id UUID, retry_after INTEGER)
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  v_user_id UUID;
+  v_wait INTEGER;
+BEGIN
+  -- Serialize requests for the same phone so limits hold under concurrency
+  PERFORM pg_advisory_xact_lock(hashtext('phone_otp:' || p_phone));
+
+  SELECT ceil(extract(EPOCH FROM (min(c.created_at) + INTERVAL '1 hour' - NOW())))::INTEGER
+  INTO v_wait
+  FROM (
+    SELECT t.created_at FROM phone_otp_codes t
+    WHERE t.phone = p_phone AND t.created_at > NOW() - INTERVAL '1 hour'
+    ORDER BY t.created_at DESC
+    LIMIT p_phone_limit
+  ) c
+  HAVING count(*) >= p_phone_limit;
+  IF v_wait > 0 THEN
+    RETURN QUERY SELECT NULL::UUID, v_wait;
+    RETURN;
+  END IF;
+
+  IF p_ip IS NOT NULL THEN
+    SELECT ceil(extract(EPOCH FROM (min(c.created_at) + INTERVAL '1 hour' - NOW())))::INTEGER
+    INTO v_wait
+    FROM (
+      SELECT t.created_at FROM phone_otp_codes t
+      WHERE t.requested_ip = p_ip AND t.created_at > NOW() - INTERVAL '1 hour'
+      ORDER BY t.created_at DESC
+      LIMIT p_ip_limit
+    ) c
+    HAVING count(*) >= p_ip_limit;
+    IF v_wait > 0 THEN
+      RETURN QUERY SELECT NULL::UUID, v_wait;
+      RETURN;
+    END IF;
+  END IF;
+
+  SELECT ceil(extract(EPOCH FROM (max(t.created_at) + make_interval(secs => p_cooldown_seconds) - NOW())))::INTEGER
+  INTO v_wait
+  FROM phone_otp_codes t
+  WHERE t.phone = p_phone AND t.code_hash IS NOT NULL;
+  IF v_wait > 0 THEN
+    RETURN QUERY SELECT NULL::UUID, v_wait;
+    RETURN;
+  END IF;
+
+  -- Staff and customers who log in, by the phone on their profile or on
+  -- their customer record
+  SELECT u.id INTO v_user_id
+  FROM auth.users u
+  JOIN profiles p ON p.id = u.id
+  WHERE u.deleted_at IS NULL
+    AND p.deleted_at IS NULL
+    AND p.deactivated_at IS NULL
+    AND (
+      normalize_phone(p.phone) = p_phone
+      OR EXISTS (
+        SELECT 1 FROM customers cu
+        WHERE cu.user_id = u.id AND normalize_phone(cu.phone) = p_phone
+      )
+    )
+  ORDER BY u.last_sign_in_at DESC NULLS LAST
+  LIMIT 1;
+
+  UPDATE phone_otp_codes t
+  SET used_at = NOW()
+  WHERE t.phone = p_phone AND t.used_at IS NULL;
+
+  INSERT INTO phone_otp_codes (phone, user_id, code_hash, expires_at, used_at, requested_ip)
+  VALUES (
+    p_phone,
+    v_user_id,
+    CASE WHEN v_user_id IS NULL THEN NULL ELSE p_code_hash END,
+    NOW() + make_interval(secs => p_ttl_seconds),
+    CASE WHEN v_user_id IS NULL THEN NOW() END,
+    p_ip
+  );
+
+  RETURN QUERY SELECT v_user_id, 0;
+END;
+$$;
+
+-- Checks p_code_hash against the phone's live code. On a match the code is
+-- used up and its user returned; a wrong guess counts against the code,
+-- which is voided after p_max_attempts. Returns NULL when there is no
+-- match.
+CREATE OR REPLACE FUNCTION consume_phone_otp(
+  p_phone TEXT,
+  p_code_hash TEXT,
+  p_max_attempts INTEGER
+)
+RETURNS UUID
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  v_code phone_otp_codes%ROWTYPE;
+BEGIN
+  SELECT * INTO v_code
+  FROM phone_otp_codes t
+  WHERE t.phone = p_phone
+    AND t.used_at IS NULL
+    AND t.expires_at > NOW()
+  ORDER BY t.created_at DESC
+  LIMIT 1
+  FOR UPDATE;
+
+  IF NOT FOUND THEN
+    RETURN NULL;
+  END IF;
+
+  IF v_code.code_hash = p_code_hash THEN
+    UPDATE phone_otp_codes SET used_at = NOW() WHERE id = v_code.id;
+    RETURN v_code.user_id;
+  END IF;
+
+  UPDATE phone_otp_codes
+  SET attempts = attempts + 1,
+      used_at = CASE WHEN attempts + 1 >= p_max_attempts THEN NOW() END
+  WHERE id = v_code.id;
+  RETURN NULL;
+END;
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+-- No policies: only the service role (which bypasses RLS) reads the table
+ALTER TABLE phone_otp_codes ENABLE ROW LEVEL SECURITY;
+
+REVOKE ALL ON phone_otp_codes FROM authenticated, anon;
+REVOKE EXECUTE ON FUNCTION create_phone_otp FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION consume_phone_otp FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION create_phone_otp TO service_role;
+GRANT EXECUTE ON FUNCTION consume_phone_otp TO service_role;
+
+COMMENT ON TABLE phone_otp_codes IS 'Hashed one-time sign-in codes sent by SMS, and the requests counted for rate limits';
+
+COMMIT;
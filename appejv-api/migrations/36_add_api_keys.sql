@@ -0,0 +1,85 @@
+-- Migration 36: API keys for integrations
+-- The ERP and distributors' systems call the API with an X-API-Key header
+-- instead of a user's token. A key acts for the admin who created it,
+-- narrowed to its scopes ("order:read", "inventory:create", ...) and
+-- optionally to one customer or one warehouse. Only the SHA-256 hash of a
+-- key is stored; the key itself is shown once, when it is created or
+-- rotated. Rotating may keep the previous key working for a grace period
+-- so the integration can switch over. Only the service role may touch the
+-- table.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  name VARCHAR(100) NOT NULL,
+  -- The start of the key, to tell keys apart in lists and logs
+  prefix VARCHAR(16) NOT NULL,
+  key_hash TEXT NOT NULL UNIQUE,
+  previous_key_hash TEXT UNIQUE,
+  previous_expires_at TIMESTAMPTZ,
+  scopes TEXT[] NOT NULL DEFAULT '{}',
+  customer_id UUID REFERENCES customers(id) ON DELETE CASCADE,
+  warehouse_id UUID REFERENCES warehouses(id) ON DELETE CASCADE,
+  created_by UUID NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+  last_used_ip TEXT,
+  rotated_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_created ON api_keys(created_at DESC);
+
+-- Returns the live key whose current hash, or previous hash within its
+-- grace period, is p_key_hash, and records the use. last_used_at is
+-- written at most once a minute per key.
+CREATE OR REPLACE FUNCTION authenticate_api_key(p_key_hash TEXT, p_ip TEXT DEFAULT NULL)
+RETURNS SETOF api_keys
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  v_key api_keys%ROWTYPE;
+BEGIN
+  SELECT * INTO v_key
+  FROM api_keys k
+  WHERE k.revoked_at IS NULL
+    AND (k.expires_at IS NULL OR k.expires_at > NOW())
+    AND (
+      k.key_hash = p_key_hash
+      OR (k.previous_key_hash = p_key_hash AND k.previous_expires_at > NOW())
+    )
+  LIMIT 1;
+
+  IF NOT FOUND THEN
+    RETURN;
+  END IF;
+
+  IF v_key.last_used_at IS NULL OR v_key.last_used_at < NOW() - INTERVAL '1 minute' THEN
+    UPDATE api_keys
+    SET last_used_at = NOW(), last_used_ip = p_ip
+    WHERE id = v_key.id
+    RETURNING * INTO v_key;
+  END IF;
+
+  RETURN NEXT v_key;
+END;
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+-- No policies: only the service role (which bypasses RLS) reads the table
+ALTER TABLE api_keys ENABLE ROW LEVEL SECURITY;
+
+REVOKE ALL ON api_keys FROM authenticated, anon;
+REVOKE EXECUTE ON FUNCTION authenticate_api_key FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION authenticate_api_key TO service_role;
+
+COMMENT ON TABLE api_keys IS 'Hashed API keys for integrations, with their scopes and limits';
+COMMENT ON COLUMN api_keys.scopes IS 'Allowed "kind:action" pairs of the permission matrix, e.g. order:read';
+
+COMMIT;
@@ -0,0 +1,23 @@
+-- Migration 37: Audit every mutation
+-- Every POST, PUT, PATCH and DELETE the API accepts is now recorded in
+-- audit_logs, not only user administration. Entries gain the request they
+-- came from (method, route pattern and response status) and the API key
+-- that made them, if any. The API writes entries in batches with the
+-- service role; admins search them through /api/v1/admin/audit-logs.
+
+BEGIN;
+
+ALTER TABLE audit_logs
+  ADD COLUMN IF NOT EXISTS method VARCHAR(10),
+  ADD COLUMN IF NOT EXISTS route TEXT,
+  ADD COLUMN IF NOT EXISTS status_code INTEGER,
+  ADD COLUMN IF NOT EXISTS api_key_id UUID REFERENCES api_keys(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_action ON audit_logs(action, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_route ON audit_logs(route, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_api_key ON audit_logs(api_key_id) WHERE api_key_id IS NOT NULL;
+
+COMMENT ON COLUMN audit_logs.route IS 'Route pattern of the request, e.g. /api/v1/orders/:id';
+COMMENT ON COLUMN audit_logs.api_key_id IS 'API key the change was made with, when an integration made it';
+
+COMMIT;
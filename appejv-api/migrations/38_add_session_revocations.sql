@@ -0,0 +1,99 @@
+-- Migration 38: Session management
+-- Users list their sign-ins (auth.sessions) and end the ones they do not
+-- recognise; admins end every session of a user, e.g. a sales rep who
+-- left. Ending a session deletes it, which voids its refresh tokens, but
+-- access tokens already issued stay valid until they expire. The API
+-- therefore keeps revoked_sessions, polled by every instance, and refuses
+-- tokens of a revoked session. An entry is only needed until the last
+-- access token of the session has expired.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS revoked_sessions (
+  session_id UUID PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+  revoked_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+  revoked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_sessions_revoked ON revoked_sessions(revoked_at);
+
+-- The user's live sessions, most recently used first
+CREATE OR REPLACE FUNCTION list_user_sessions(p_user_id UUID)
+RETURNS TABLE (
+  id UUID,
+  created_at TIMESTAMPTZ,
+  refreshed_at TIMESTAMPTZ,
+  user_agent TEXT,
+  ip TEXT,
+  not_after TIMESTAMPTZ
+)
+LANGUAGE sql
+STABLE
+SECURITY DEFINER
+SET search_path = public
+AS $$
+  SELECT
+    s.id,
+    s.created_at,
+    coalesce(s.refreshed_at::TIMESTAMPTZ, s.updated_at, s.created_at),
+    s.user_agent,
+    host(s.ip),
+    s.not_after
+  FROM auth.sessions s
+  WHERE s.user_id = p_user_id
+    AND (s.not_after IS NULL OR s.not_after > NOW())
+  ORDER BY coalesce(s.refreshed_at::TIMESTAMPTZ, s.updated_at, s.created_at) DESC;
+$$;
+
+-- Ends p_session_id, or every session when it is NULL, of p_user_id and
+-- returns the sessions ended. Their access tokens are refused for
+-- p_token_ttl_seconds, the longest an access token lives.
+CREATE OR REPLACE FUNCTION revoke_user_sessions(
+  p_user_id UUID,
+  p_session_id UUID,
+  p_revoked_by UUID,
+  p_token_ttl_seconds INTEGER
+)
+RETURNS SETOF UUID
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+BEGIN
+  DELETE FROM revoked_sessions WHERE expires_at < NOW();
+
+  RETURN QUERY
+  WITH ended AS (
+    DELETE FROM auth.sessions s
+    WHERE s.user_id = p_user_id
+      AND (p_session_id IS NULL OR s.id = p_session_id)
+    RETURNING s.id
+  ), recorded AS (
+    INSERT INTO revoked_sessions (session_id, user_id, revoked_by, expires_at)
+    SELECT e.id, p_user_id, p_revoked_by, NOW() + make_interval(secs => p_token_ttl_seconds)
+    FROM ended e
+    ON CONFLICT (session_id) DO UPDATE
+      SET revoked_at = EXCLUDED.revoked_at, expires_at = EXCLUDED.expires_at
+    RETURNING session_id
+  )
+  SELECT r.session_id FROM recorded r;
+END;
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+-- No policies: only the service role (which bypasses RLS) reads the table
+ALTER TABLE revoked_sessions ENABLE ROW LEVEL SECURITY;
+
+REVOKE ALL ON revoked_sessions FROM authenticated, anon;
+REVOKE EXECUTE ON FUNCTION list_user_sessions FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION revoke_user_sessions FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION list_user_sessions TO service_role;
+GRANT EXECUTE ON FUNCTION revoke_user_sessions TO service_role;
+
+COMMENT ON TABLE revoked_sessions IS 'Ended sessions whose access tokens the API refuses until they expire';
+
+COMMIT;
@@ -0,0 +1,37 @@
+-- Migration 39: Profile self-service
+-- Users edit their own name, phone and notification setting and upload an
+-- avatar through /api/v1/profile. Phone sign-in finds the account by its
+-- phone number, so a user may not take a number another account already
+-- signs in with.
+
+BEGIN;
+
+-- Whether an account other than p_user_id has p_phone (normalized) on its
+-- profile or on its customer record
+CREATE OR REPLACE FUNCTION phone_in_use(p_phone TEXT, p_user_id UUID)
+RETURNS BOOLEAN
+LANGUAGE sql
+STABLE
+SECURITY DEFINER
+SET search_path = public
+AS $$
+  SELECT EXISTS (
+    SELECT 1 FROM profiles p
+    WHERE p.id <> p_user_id
+      AND p.deleted_at IS NULL
+      AND normalize_phone(p.phone) = normalize_phone(p_phone)
+  ) OR EXISTS (
+    SELECT 1 FROM customers cu
+    WHERE cu.user_id IS NOT NULL
+      AND cu.user_id <> p_user_id
+      AND normalize_phone(cu.phone) = normalize_phone(p_phone)
+  );
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+REVOKE EXECUTE ON FUNCTION phone_in_use FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION phone_in_use TO service_role;
+
+COMMIT;
@@ -0,0 +1,91 @@
+-- Migration 40: Pick confirmation through the API only
+-- confirm_order_pick was granted to authenticated and trusted the caller's
+-- p_user_id, so any user could record a pick in someone else's name, and
+-- called with the service role it skipped the warehouse check because
+-- auth.uid() is NULL there. Only the service role may call it now. The
+-- API passes the signed-in picker and the warehouses they may pick from,
+-- which the function checks while it holds the order lock.
+
+BEGIN;
+
+DROP FUNCTION IF EXISTS confirm_order_pick(UUID, JSONB, TEXT, UUID);
+
+-- Records the picked base quantity of every line of an 'ordered' order by
+-- p_user_id. p_lines is a JSON array of {order_item_id, picked_quantity};
+-- every line must be present and no line may be over-picked. With
+-- p_warehouse_ids the order must ship from one of those warehouses.
+CREATE OR REPLACE FUNCTION confirm_order_pick(
+  p_order_id UUID,
+  p_lines JSONB,
+  p_user_id UUID,
+  p_note TEXT DEFAULT NULL,
+  p_warehouse_ids UUID[] DEFAULT NULL
+)
+RETURNS order_picks
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  o orders;
+  pick order_picks;
+  item RECORD;
+  picked INTEGER;
+  short BOOLEAN := FALSE;
+BEGIN
+  SELECT * INTO o FROM orders WHERE id = p_order_id AND deleted_at IS NULL FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'order % not found', p_order_id;
+  END IF;
+  IF o.status <> 'ordered' THEN
+    RAISE EXCEPTION 'invalid order status: % orders cannot be picked', o.status;
+  END IF;
+  -- Checked under the order lock, so the order cannot move to another
+  -- warehouse between the API's check and the pick
+  IF p_warehouse_ids IS NOT NULL AND (
+    COALESCE(o.warehouse_id, (SELECT id FROM warehouses WHERE is_default)) = ANY (p_warehouse_ids)
+  ) IS NOT TRUE THEN
+    RAISE EXCEPTION 'permission denied: not assigned to the fulfilment warehouse';
+  END IF;
+
+  DELETE FROM order_picks WHERE order_id = p_order_id;
+  INSERT INTO order_picks (order_id, status, note, picked_by)
+  VALUES (p_order_id, 'picked', p_note, p_user_id);
+
+  FOR item IN
+    SELECT id, product_id, COALESCE(base_quantity, quantity::INTEGER) AS qty
+    FROM order_items WHERE order_id = p_order_id
+  LOOP
+    SELECT (l->>'picked_quantity')::INTEGER INTO picked
+    FROM jsonb_array_elements(p_lines) l
+    WHERE l->>'order_item_id' = item.id::TEXT
+    LIMIT 1;
+
+    IF picked IS NULL THEN
+      RAISE EXCEPTION 'invalid pick: line % is missing', item.id;
+    END IF;
+    IF picked < 0 OR picked > item.qty THEN
+      RAISE EXCEPTION 'invalid pick: line % picked % of %', item.id, picked, item.qty;
+    END IF;
+
+    INSERT INTO order_pick_items (order_id, order_item_id, product_id, ordered_quantity, picked_quantity)
+    VALUES (p_order_id, item.id, item.product_id, item.qty, picked);
+    short := short OR picked < item.qty;
+  END LOOP;
+
+  UPDATE order_picks
+  SET status = CASE WHEN short THEN 'short' ELSE 'picked' END
+  WHERE order_id = p_order_id
+  RETURNING * INTO pick;
+
+  RETURN pick;
+END;
+$$;
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+REVOKE EXECUTE ON FUNCTION confirm_order_pick FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION confirm_order_pick TO service_role;
+
+COMMIT;
@@ -0,0 +1,157 @@
+-- Migration 41: Low-stock alerts per warehouse
+-- Reorder points were checked against products.stock, the total across
+-- warehouses, so a depot could run out while another held enough to keep
+-- the total above the reorder point. A product's reorder point now applies
+-- to each active warehouse that stocks it: alerts, the low-stock view and
+-- notifications are per (product, warehouse). Warehouse users are notified
+-- about their own depots only. Open alerts on the total are resolved; the
+-- next evaluation raises the per-warehouse ones.
+
+BEGIN;
+
+ALTER TABLE low_stock_alerts
+  ADD COLUMN IF NOT EXISTS warehouse_id UUID REFERENCES warehouses(id) ON DELETE CASCADE;
+
+COMMENT ON COLUMN low_stock_alerts.warehouse_id IS 'Warehouse whose stock dipped; NULL on alerts raised on the total before migration 41';
+
+UPDATE low_stock_alerts
+SET resolved_at = NOW()
+WHERE resolved_at IS NULL AND warehouse_id IS NULL;
+
+-- At most one open alert per product and warehouse
+DROP INDEX IF EXISTS idx_low_stock_alerts_open;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_low_stock_alerts_open
+  ON low_stock_alerts(product_id, warehouse_id) WHERE resolved_at IS NULL;
+
+-- ============================================================================
+-- EVALUATOR
+-- ============================================================================
+-- Evaluates the given products (all when NULL) in every active warehouse
+-- that has a stock row for them and returns the alerts it raised.
+CREATE OR REPLACE FUNCTION evaluate_low_stock(p_product_ids BIGINT[] DEFAULT NULL)
+RETURNS SETOF low_stock_alerts
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  p RECORD;
+  alert low_stock_alerts;
+BEGIN
+  -- Resolve alerts whose stock recovered, or whose product or warehouse
+  -- was deleted, deactivated or stopped alerting
+  UPDATE low_stock_alerts a
+  SET resolved_at = NOW()
+  FROM products pr
+  WHERE a.product_id = pr.id
+    AND a.resolved_at IS NULL
+    AND (p_product_ids IS NULL OR pr.id = ANY(p_product_ids))
+    AND (
+      pr.reorder_point IS NULL
+      OR pr.deleted_at IS NOT NULL
+      OR NOT EXISTS (
+        SELECT 1
+        FROM warehouse_stock ws
+        JOIN warehouses w ON w.id = ws.warehouse_id
+        WHERE ws.product_id = pr.id
+          AND ws.warehouse_id = a.warehouse_id
+          AND w.status = 'active'
+          AND ws.quantity <= pr.reorder_point
+      )
+    );
+
+  FOR p IN
+    SELECT pr.id, pr.code, pr.name, pr.reorder_point, pr.unit,
+           w.id AS warehouse_id, w.name AS warehouse_name, ws.quantity AS stock
+    FROM products pr
+    JOIN warehouse_stock ws ON ws.product_id = pr.id
+    JOIN warehouses w ON w.id = ws.warehouse_id
+    WHERE pr.reorder_point IS NOT NULL
+      AND ws.quantity <= pr.reorder_point
+      AND pr.deleted_at IS NULL
+      AND w.status = 'active'
+      AND (p_product_ids IS NULL OR pr.id = ANY(p_product_ids))
+      AND NOT EXISTS (
+        SELECT 1 FROM low_stock_alerts a
+        WHERE a.product_id = pr.id AND a.warehouse_id = w.id AND a.resolved_at IS NULL
+      )
+  LOOP
+    INSERT INTO low_stock_alerts (product_id, warehouse_id, stock, reorder_point)
+    VALUES (p.id, p.warehouse_id, p.stock, p.reorder_point)
+    ON CONFLICT DO NOTHING
+    RETURNING * INTO alert;
+
+    CONTINUE WHEN alert.id IS NULL;
+
+    INSERT INTO notifications (user_id, type, title, message, data)
+    SELECT pf.id,
+           'low_stock',
+           'Sắp hết hàng: ' || p.name || ' (' || p.warehouse_name || ')',
+           format('Sản phẩm %s (%s) tại kho %s còn %s %s, dưới điểm đặt hàng lại %s.',
+                  p.name, p.code, p.warehouse_name, p.stock, COALESCE(p.unit, 'kg'), p.reorder_point),
+           jsonb_build_object(
+             'product_id', p.id,
+             'warehouse_id', p.warehouse_id,
+             'alert_id', alert.id,
+             'stock', p.stock,
+             'reorder_point', p.reorder_point
+           )
+    FROM profiles pf
+    WHERE (
+        pf.role = 'admin'
+        OR (pf.role = 'warehouse' AND EXISTS (
+          SELECT 1 FROM warehouse_users wu
+          WHERE wu.user_id = pf.id AND wu.warehouse_id = p.warehouse_id
+        ))
+      )
+      AND pf.notifications_enabled IS DISTINCT FROM FALSE;
+
+    RETURN NEXT alert;
+  END LOOP;
+END;
+$$;
+
+-- ============================================================================
+-- LOW-STOCK VIEW
+-- ============================================================================
+DROP VIEW IF EXISTS low_stock_products;
+
+CREATE VIEW low_stock_products
+WITH (security_invoker = true)
+AS
+SELECT
+  p.id,
+  p.code,
+  p.name,
+  p.unit,
+  w.id AS warehouse_id,
+  w.code AS warehouse_code,
+  w.name AS warehouse_name,
+  ws.quantity AS stock,
+  p.reorder_point,
+  p.reorder_point - ws.quantity AS shortfall,
+  a.raised_at AS alert_raised_at
+FROM products p
+JOIN warehouse_stock ws ON ws.product_id = p.id
+JOIN warehouses w ON w.id = ws.warehouse_id
+LEFT JOIN low_stock_alerts a
+  ON a.product_id = p.id AND a.warehouse_id = w.id AND a.resolved_at IS NULL
+WHERE p.reorder_point IS NOT NULL
+  AND ws.quantity <= p.reorder_point
+  AND p.deleted_at IS NULL
+  AND w.status = 'active';
+
+-- ============================================================================
+-- RLS
+-- ============================================================================
+DROP POLICY IF EXISTS "low_stock_alerts_staff_select" ON low_stock_alerts;
+
+CREATE POLICY "low_stock_alerts_staff_select" ON low_stock_alerts
+  FOR SELECT TO authenticated
+  USING (is_admin_or_sale_admin() OR (is_warehouse() AND is_assigned_warehouse(warehouse_id)));
+
+GRANT SELECT ON low_stock_products TO authenticated;
+
+COMMENT ON FUNCTION evaluate_low_stock IS 'Raises and resolves low-stock alerts per warehouse and notifies admins and the warehouse''s users';
+
+COMMIT;
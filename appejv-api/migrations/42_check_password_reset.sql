@@ -0,0 +1,28 @@
+-- Migration 42: Check a reset token before using it up
+-- consume_password_reset marked the token used before the API changed the
+-- password, so a failed update left the user with a dead link. The API now
+-- checks the token, changes the password and ends the user's sessions, and
+-- only then consumes it.
+
+BEGIN;
+
+-- Returns the user of a valid token without using it up. Returns no row
+-- for unknown, expired or already used tokens.
+CREATE OR REPLACE FUNCTION check_password_reset(p_token_hash TEXT)
+RETURNS UUID
+LANGUAGE sql
+STABLE
+SECURITY DEFINER
+SET search_path = public
+AS $$
+  SELECT user_id
+  FROM password_reset_tokens
+  WHERE token_hash = p_token_hash
+    AND used_at IS NULL
+    AND expires_at > NOW();
+$$;
+
+REVOKE EXECUTE ON FUNCTION check_password_reset FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION check_password_reset TO service_role;
+
+COMMIT;
@@ -0,0 +1,26 @@
+-- Migration 43: Stock posting through the API only
+-- The stock functions are SECURITY DEFINER, trust the caller's p_user_id
+-- and check no role, yet were granted to authenticated and never revoked
+-- from PUBLIC, so any signed-in user, or anon through /rest/v1/rpc, could
+-- post receipts and adjustments and rewrite warehouse stock and lots. The
+-- API calls them with the service role, which checks the caller's role and
+-- warehouses first; the order trigger runs as the function owner. Only the
+-- service role may execute them now.
+
+BEGIN;
+
+REVOKE EXECUTE ON FUNCTION post_stock_movement FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION ship_stock_transfer FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION receive_stock_transfer FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION receive_stock_lot FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION consume_stock_fefo FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION evaluate_low_stock FROM PUBLIC, authenticated, anon;
+
+GRANT EXECUTE ON FUNCTION post_stock_movement TO service_role;
+GRANT EXECUTE ON FUNCTION ship_stock_transfer TO service_role;
+GRANT EXECUTE ON FUNCTION receive_stock_transfer TO service_role;
+GRANT EXECUTE ON FUNCTION receive_stock_lot TO service_role;
+GRANT EXECUTE ON FUNCTION consume_stock_fefo TO service_role;
+GRANT EXECUTE ON FUNCTION evaluate_low_stock TO service_role;
+
+COMMIT;
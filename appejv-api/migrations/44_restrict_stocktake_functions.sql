@@ -0,0 +1,201 @@
+-- Migration 44: Stocktakes through the API only
+-- The stocktake functions were executable by PUBLIC and trusted the
+-- caller's p_user_id, and approve_stocktake only checked the role when
+-- auth.uid() was set, so anon could approve a stocktake and post its
+-- variances and counts could be recorded in anyone's name. Only the
+-- service role may call them now. The API checks the caller's role and
+-- passes the signed-in user and, for counts, the warehouses they may count
+-- in, which the function checks while it holds the stocktake.
+
+BEGIN;
+
+-- ============================================================================
+-- WORKFLOW
+-- ============================================================================
+DROP FUNCTION IF EXISTS start_stocktake(UUID, TEXT, UUID);
+DROP FUNCTION IF EXISTS record_stocktake_count(UUID, BIGINT, INTEGER, VARCHAR, VARCHAR, UUID);
+DROP FUNCTION IF EXISTS approve_stocktake(UUID, UUID);
+
+-- Opens a stocktake by p_user_id with the expected quantity of every
+-- active product
+CREATE OR REPLACE FUNCTION start_stocktake(
+  p_warehouse_id UUID,
+  p_user_id UUID,
+  p_note TEXT DEFAULT NULL
+)
+RETURNS stocktakes
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  s stocktakes;
+BEGIN
+  IF p_user_id IS NULL THEN
+    RAISE EXCEPTION 'permission denied: no user';
+  END IF;
+  IF NOT EXISTS (SELECT 1 FROM warehouses WHERE id = p_warehouse_id) THEN
+    RAISE EXCEPTION 'warehouse not found';
+  END IF;
+  IF EXISTS (SELECT 1 FROM stocktakes WHERE warehouse_id = p_warehouse_id AND status = 'open') THEN
+    RAISE EXCEPTION 'stocktake already open for warehouse %', p_warehouse_id;
+  END IF;
+
+  -- Block postings to the warehouse while the snapshot is taken
+  PERFORM 1 FROM warehouse_stock WHERE warehouse_id = p_warehouse_id FOR SHARE;
+
+  INSERT INTO stocktakes (warehouse_id, note, created_by)
+  VALUES (p_warehouse_id, p_note, p_user_id)
+  RETURNING * INTO s;
+
+  INSERT INTO stocktake_lines (stocktake_id, product_id, expected_quantity)
+  SELECT s.id, p.id, COALESCE(ws.quantity, 0)
+  FROM products p
+  LEFT JOIN warehouse_stock ws ON ws.product_id = p.id AND ws.warehouse_id = p_warehouse_id
+  WHERE p.deleted_at IS NULL OR COALESCE(ws.quantity, 0) <> 0;
+
+  RETURN s;
+END;
+$$;
+
+-- Records p_user_id's quantity for a product and location and returns the
+-- updated line. Products created after the snapshot are added with an
+-- expected quantity of 0. With p_warehouse_ids the stocktake must be of one
+-- of those warehouses.
+CREATE OR REPLACE FUNCTION record_stocktake_count(
+  p_stocktake_id UUID,
+  p_product_id BIGINT,
+  p_quantity INTEGER,
+  p_user_id UUID,
+  p_location VARCHAR DEFAULT '',
+  p_source VARCHAR DEFAULT 'manual',
+  p_warehouse_ids UUID[] DEFAULT NULL
+)
+RETURNS stocktake_lines
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  s stocktakes;
+  line stocktake_lines;
+BEGIN
+  IF p_user_id IS NULL THEN
+    RAISE EXCEPTION 'permission denied: no user';
+  END IF;
+
+  -- Held until commit, so a count cannot land while the stocktake is approved
+  SELECT * INTO s FROM stocktakes WHERE id = p_stocktake_id FOR SHARE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'stocktake % not found', p_stocktake_id;
+  END IF;
+  IF p_warehouse_ids IS NOT NULL AND NOT (s.warehouse_id = ANY(p_warehouse_ids)) THEN
+    RAISE EXCEPTION 'permission denied: stocktake is not of the caller''s warehouses';
+  END IF;
+  IF s.status <> 'open' THEN
+    RAISE EXCEPTION 'invalid stocktake status: % is not open', s.status;
+  END IF;
+  IF NOT EXISTS (SELECT 1 FROM products WHERE id = p_product_id) THEN
+    RAISE EXCEPTION 'product % not found', p_product_id;
+  END IF;
+
+  INSERT INTO stocktake_lines (stocktake_id, product_id, expected_quantity)
+  VALUES (p_stocktake_id, p_product_id, 0)
+  ON CONFLICT DO NOTHING;
+
+  INSERT INTO stocktake_counts (stocktake_id, product_id, location, quantity, source, counted_by)
+  VALUES (p_stocktake_id, p_product_id, COALESCE(p_location, ''), p_quantity, p_source, p_user_id)
+  ON CONFLICT (stocktake_id, product_id, counted_by, location)
+  DO UPDATE SET quantity = EXCLUDED.quantity, source = EXCLUDED.source, counted_at = NOW();
+
+  UPDATE stocktake_lines
+  SET counted_quantity = (
+    SELECT SUM(quantity) FROM stocktake_counts
+    WHERE stocktake_id = p_stocktake_id AND product_id = p_product_id
+  )
+  WHERE stocktake_id = p_stocktake_id AND product_id = p_product_id
+  RETURNING * INTO line;
+
+  RETURN line;
+END;
+$$;
+
+-- Posts the variances of every counted line and closes the stocktake,
+-- approved by p_user_id. Uncounted lines are left untouched. The API only
+-- calls it for admin and sale_admin users.
+CREATE OR REPLACE FUNCTION approve_stocktake(p_stocktake_id UUID, p_user_id UUID)
+RETURNS stocktakes
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  s stocktakes;
+  line RECORD;
+  moved INTEGER;
+  delta INTEGER;
+BEGIN
+  IF p_user_id IS NULL THEN
+    RAISE EXCEPTION 'permission denied: no user';
+  END IF;
+
+  SELECT * INTO s FROM stocktakes WHERE id = p_stocktake_id FOR UPDATE;
+  IF NOT FOUND THEN
+    RAISE EXCEPTION 'stocktake % not found', p_stocktake_id;
+  END IF;
+  IF s.status <> 'open' THEN
+    RAISE EXCEPTION 'invalid stocktake status: % cannot be approved', s.status;
+  END IF;
+
+  FOR line IN
+    SELECT product_id, expected_quantity, counted_quantity
+    FROM stocktake_lines
+    WHERE stocktake_id = s.id AND counted_quantity IS NOT NULL
+  LOOP
+    SELECT COALESCE(SUM(quantity), 0) INTO moved
+    FROM stock_movements
+    WHERE product_id = line.product_id
+      AND warehouse_id = s.warehouse_id
+      AND created_at > s.snapshot_at;
+
+    delta := line.counted_quantity - (line.expected_quantity + moved);
+
+    IF delta > 0 THEN
+      PERFORM post_stock_movement(
+        line.product_id, 'adjustment', delta, 'count_correction',
+        'stocktake', s.id::TEXT, NULL, p_user_id, s.warehouse_id
+      );
+    ELSIF delta < 0 THEN
+      PERFORM consume_stock_fefo(
+        line.product_id, 'adjustment', -delta, 'count_correction',
+        'stocktake', s.id::TEXT, NULL, p_user_id, s.warehouse_id, TRUE
+      );
+    END IF;
+
+    UPDATE stocktake_lines
+    SET adjusted_quantity = delta
+    WHERE stocktake_id = s.id AND product_id = line.product_id;
+  END LOOP;
+
+  UPDATE stocktakes
+  SET status = 'approved', approved_at = NOW(), approved_by = p_user_id
+  WHERE id = s.id
+  RETURNING * INTO s;
+
+  RETURN s;
+END;
+$$;
+
+-- ============================================================================
+-- PERMISSIONS
+-- ============================================================================
+REVOKE EXECUTE ON FUNCTION start_stocktake FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION record_stocktake_count FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION approve_stocktake FROM PUBLIC, authenticated, anon;
+REVOKE EXECUTE ON FUNCTION shrinkage_report FROM PUBLIC, authenticated, anon;
+GRANT EXECUTE ON FUNCTION start_stocktake TO service_role;
+GRANT EXECUTE ON FUNCTION record_stocktake_count TO service_role;
+GRANT EXECUTE ON FUNCTION approve_stocktake TO service_role;
+GRANT EXECUTE ON FUNCTION shrinkage_report TO service_role;
+
+COMMIT;
@@ -0,0 +1,47 @@
+-- Migration 45: Order status transitions
+-- Any status change was accepted from admins and managers, so an order
+-- could go shipping -> ordered -> shipping and consume its stock twice (the
+-- old pick still satisfied require_order_pick), or be reopened after its
+-- stock was returned on cancellation. Orders now only move forward:
+--
+--   draft     -> ordered, cancelled
+--   ordered   -> shipping, cancelled
+--   shipping  -> delivered, cancelled
+--   delivered -> completed, cancelled
+--
+-- completed and cancelled are final. internal/policy holds the same table.
+
+BEGIN;
+
+CREATE OR REPLACE FUNCTION guard_order_status()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+SET search_path = public
+AS $$
+BEGIN
+  IF NEW.status IS NOT DISTINCT FROM OLD.status THEN
+    RETURN NEW;
+  END IF;
+
+  IF NOT (
+    (OLD.status = 'draft' AND NEW.status IN ('ordered', 'cancelled'))
+    OR (OLD.status = 'ordered' AND NEW.status IN ('shipping', 'cancelled'))
+    OR (OLD.status = 'shipping' AND NEW.status IN ('delivered', 'cancelled'))
+    OR (OLD.status = 'delivered' AND NEW.status IN ('completed', 'cancelled'))
+  ) THEN
+    RAISE EXCEPTION 'invalid order status change: % -> %', OLD.status, NEW.status;
+  END IF;
+
+  RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS order_guard_status_trigger ON orders;
+CREATE TRIGGER order_guard_status_trigger
+  BEFORE UPDATE OF status ON orders
+  FOR EACH ROW
+  EXECUTE FUNCTION guard_order_status();
+
+COMMENT ON FUNCTION guard_order_status IS 'Refuses order status changes outside draft -> ordered -> shipping -> delivered -> completed, with cancellation from any of them';
+
+COMMIT;
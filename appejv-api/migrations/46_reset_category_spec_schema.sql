@@ -0,0 +1,20 @@
+-- Migration 46: Undo the blanket feed spec schema
+-- Migration 23 set every existing category to the 'feed' schema, so
+-- premix and non-feed categories accepted and filtered on feed
+-- specifications. Categories whose products already carry specs keep
+-- 'feed', since those specs were validated against it. The others go back
+-- to no schema; products in them can still be saved without specs, and an
+-- admin sets spec_schema ('feed', 'premix') on the categories that need one.
+
+BEGIN;
+
+UPDATE categories c
+SET spec_schema = NULL
+WHERE c.spec_schema = 'feed'
+  AND NOT EXISTS (
+    SELECT 1 FROM products p
+    WHERE p.category_id = c.id
+      AND p.specs <> '{}'::jsonb
+  );
+
+COMMIT;
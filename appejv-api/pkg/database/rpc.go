@@ -0,0 +1,70 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var rpcClient = &http.Client{Transport: transport, Timeout: 15 * time.Second}
+
+// RPCError is an error returned by a Postgres function called through PostgREST
+type RPCError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details string `json:"details"`
+	Hint    string `json:"hint"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// RPC calls a Postgres function and decodes its result into out (which may
+// be nil). Unlike Client.Rpc, errors raised by the function are returned
+// as *RPCError.
+func (d *Database) RPC(ctx context.Context, name string, params interface{}, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimRight(d.url, "/") + "/rest/v1/rpc/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apikey", d.apiKey)
+	req.Header.Set("Authorization", "Bearer "+d.bearer)
+
+	resp, err := rpcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		rpcErr := &RPCError{Status: resp.StatusCode}
+		if json.Unmarshal(data, rpcErr) != nil || rpcErr.Message == "" {
+			rpcErr.Message = fmt.Sprintf("rpc %s failed: status %d", name, resp.StatusCode)
+		}
+		return rpcErr
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
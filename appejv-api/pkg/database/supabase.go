@@ -1,21 +1,86 @@
 package database
 
 import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
 	"github.com/appejv/appejv-api/internal/config"
-	"github.com/supabase-community/supabase-go"
+	"github.com/supabase-community/postgrest-go"
 )
 
+// transport pools the connections to Supabase for every handle, REST and RPC
+var transport = http.DefaultTransport.(*http.Transport).Clone()
+
+// Database is a handle on the Supabase REST API. Its role decides which RLS
+// policies apply: the anon handle sees what anonymous visitors may see, a
+// handle from ForUser runs as the caller so auth.uid() works in policies,
+// and the Service handle bypasses RLS.
 type Database struct {
-	Client *supabase.Client
+	Client *postgrest.Client
+
+	url string
+	// apiKey identifies the project; bearer authorizes requests as the
+	// handle's role
+	apiKey string
+	bearer string
+	// serviceKey is kept on the anon handle to build the service handle
+	serviceKey string
 }
 
-func NewSupabaseClient(cfg *config.Config) *Database {
-	client, err := supabase.NewClient(cfg.SupabaseURL, cfg.SupabaseAnonKey, &supabase.ClientOptions{})
-	if err != nil {
-		panic(err)
+// NewSupabaseClient returns the anon handle
+func NewSupabaseClient(cfg *config.Config) (*Database, error) {
+	if cfg.SupabaseURL == "" || cfg.SupabaseAnonKey == "" {
+		return nil, errors.New("SUPABASE_URL and SUPABASE_ANON_KEY are required")
+	}
+	if _, err := url.Parse(cfg.SupabaseURL); err != nil {
+		return nil, err
+	}
+
+	d := &Database{
+		url:        strings.TrimRight(cfg.SupabaseURL, "/"),
+		serviceKey: cfg.SupabaseServiceKey,
 	}
+	return d.withBearer(cfg.SupabaseAnonKey, cfg.SupabaseAnonKey), nil
+}
+
+// ForUser returns a handle that runs queries and RPC calls with the
+// caller's access token
+func (d *Database) ForUser(accessToken string) *Database {
+	return d.withBearer(d.apiKey, accessToken)
+}
+
+// Service returns the service-role handle, which bypasses RLS. Only use it
+// for operations the API authorizes itself. Without a service key it is
+// the anon handle.
+func (d *Database) Service() *Database {
+	if d.serviceKey == "" {
+		return d
+	}
+	return d.withBearer(d.serviceKey, d.serviceKey)
+}
+
+// HasServiceRole reports whether Service really bypasses RLS
+func (d *Database) HasServiceRole() bool {
+	return d.serviceKey != ""
+}
+
+// withBearer returns a handle authorized with bearer. Handles only differ in
+// their headers: they share the connections of transport, so one is cheap
+// to make per request.
+func (d *Database) withBearer(apiKey, bearer string) *Database {
+	client := postgrest.NewClient(d.url+"/rest/v1", "public", map[string]string{
+		"apikey":        apiKey,
+		"Authorization": "Bearer " + bearer,
+	})
+	client.Transport.Parent = transport
 
 	return &Database{
-		Client: client,
+		Client:     client,
+		url:        d.url,
+		apiKey:     apiKey,
+		bearer:     bearer,
+		serviceKey: d.serviceKey,
 	}
 }
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/appejv/appejv-api/internal/config"
+)
+
+// TestForUser checks that handles built per request send their own token
+// and share the connections of the anon handle
+func TestForUser(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path] = r.Header.Get("Authorization") + " " + r.Header.Get("apikey")
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	db, err := NewSupabaseClient(&config.Config{SupabaseURL: server.URL + "/", SupabaseAnonKey: "anon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := db.ForUser("token-1")
+	if user.Client.Transport.Parent != transport || db.Client.Transport.Parent != transport {
+		t.Error("handles do not share the connection pool")
+	}
+
+	var rows []interface{}
+	if _, err := db.Client.From("anon").Select("*", "", false).ExecuteTo(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user.Client.From("user").Select("*", "", false).ExecuteTo(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := user.RPC(context.Background(), "user_rpc", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"/rest/v1/anon":         "Bearer anon anon",
+		"/rest/v1/user":         "Bearer token-1 anon",
+		"/rest/v1/rpc/user_rpc": "Bearer token-1 anon",
+	}
+	for path, header := range want {
+		if seen[path] != header {
+			t.Errorf("%s sent %q, want %q", path, seen[path], header)
+		}
+	}
+}
+
+func TestNewSupabaseClientRequiresConfig(t *testing.T) {
+	if _, err := NewSupabaseClient(&config.Config{SupabaseURL: "http://localhost"}); err == nil {
+		t.Error("NewSupabaseClient accepted a config without an anon key")
+	}
+}